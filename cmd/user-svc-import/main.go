@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/communication/client"
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+var args = types.ImportArgs{}
+
+func main() {
+	flag.StringVar(&args.UserSvcAddr, "user-svc-addr", "http://localhost:8080", "")
+	flag.StringVar(&args.AccessToken, "access-token", "", "")
+	flag.StringVar(&args.File, "file", "-", "")
+	flag.StringVar(&args.Format, "format", "", "")
+	flag.BoolVar(&args.DryRun, "dry-run", false, "")
+	flag.BoolVar(&args.HashedPasswords, "hashed-passwords", false, "")
+	flag.IntVar(&args.BatchSize, "batch-size", 100, "")
+	flag.Parse()
+
+	ctx := context.Background()
+
+	var rsp types.ImportUsersResponse
+	err := func() (err error) {
+		format := args.Format
+		if format == "" {
+			switch strings.ToLower(filepath.Ext(args.File)) {
+			case ".csv":
+				format = types.ImportFormatCsv
+			case ".jsonl", ".ndjson":
+				format = types.ImportFormatJsonl
+			default:
+				err = errors.Errorf("failed to detect format of %s, set --format", args.File)
+
+				return
+			}
+		}
+
+		if args.BatchSize < 1 {
+			err = errors.New("failed as --batch-size must be at least 1")
+
+			return
+		}
+
+		var r io.Reader = os.Stdin
+		if args.File != "-" {
+			var f *os.File
+			f, err = os.Open(args.File)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to open %s", args.File)
+
+				return
+			}
+			defer f.Close()
+
+			r = f
+		}
+
+		var chunks [][]byte
+		switch format {
+		case types.ImportFormatCsv:
+			chunks, err = splitCsv(r, args.BatchSize)
+		case types.ImportFormatJsonl:
+			chunks, err = splitJsonl(r, args.BatchSize)
+		default:
+			err = errors.Errorf("unsupported format %s", format)
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "failed to split %s", args.File)
+
+			return
+		}
+
+		c := &http.Client{Timeout: 5 * time.Minute}
+
+		rsp.DryRun = args.DryRun
+		for i, chunk := range chunks {
+			var httpRsp *http.Response
+			var chunkRsp types.ImportUsersResponse
+			httpRsp, chunkRsp, err = client.ImportUsers(ctx, c, args.UserSvcAddr, args.AccessToken, types.ImportUsersRequest{
+				Format:          format,
+				DryRun:          args.DryRun,
+				HashedPasswords: args.HashedPasswords,
+				Body:            bytes.NewReader(chunk),
+			})
+			if err != nil {
+				err = errors.Wrapf(err, "failed to import batch %v", i+1)
+
+				return
+			}
+
+			offset := i * args.BatchSize
+			for _, e := range chunkRsp.Errors {
+				e.Row += offset
+				rsp.Errors = append(rsp.Errors, e)
+			}
+			rsp.Total += chunkRsp.Total
+			rsp.Imported += chunkRsp.Imported
+			rsp.Failed += chunkRsp.Failed
+
+			if httpRsp.StatusCode != http.StatusOK {
+				rsp.Error = chunkRsp.Error
+				err = errors.Errorf("failed to import batch %v: status code %v: %s", i+1, httpRsp.StatusCode, chunkRsp.Error)
+
+				return
+			}
+		}
+
+		return
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	encErr := enc.Encode(rsp)
+	if encErr != nil {
+		log.Print(encErr)
+	}
+
+	if err != nil {
+		log.Fatal("failed to import users: ", err)
+	}
+
+	if rsp.Failed > 0 {
+		os.Exit(1)
+	}
+
+	return
+}
+
+func splitCsv(r io.Reader, batchSize int) (chunks [][]byte, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		err = nil
+
+		return
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to read csv header")
+
+		return
+	}
+
+	var buf bytes.Buffer
+	var w *csv.Writer
+	n := 0
+	flush := func() error {
+		w.Flush()
+		err := w.Error()
+		if err != nil {
+			return err
+		}
+
+		chunks = append(chunks, append([]byte(nil), buf.Bytes()...))
+		n = 0
+
+		return nil
+	}
+
+	for {
+		var rec []string
+		rec, err = cr.Read()
+		if err == io.EOF {
+			err = nil
+
+			break
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to read csv row")
+
+			return
+		}
+
+		if n == 0 {
+			buf.Reset()
+			w = csv.NewWriter(&buf)
+
+			err = w.Write(header)
+			if err != nil {
+				err = errors.Wrap(err, "failed to write csv header")
+
+				return
+			}
+		}
+
+		err = w.Write(rec)
+		if err != nil {
+			err = errors.Wrap(err, "failed to write csv row")
+
+			return
+		}
+		n++
+
+		if n == batchSize {
+			err = flush()
+			if err != nil {
+				err = errors.Wrap(err, "failed to flush csv batch")
+
+				return
+			}
+		}
+	}
+
+	if n > 0 {
+		err = flush()
+		if err != nil {
+			err = errors.Wrap(err, "failed to flush csv batch")
+
+			return
+		}
+	}
+
+	return
+}
+
+func splitJsonl(r io.Reader, batchSize int) (chunks [][]byte, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var buf bytes.Buffer
+	n := 0
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+
+		buf.Write(s.Bytes())
+		buf.WriteByte('\n')
+		n++
+
+		if n == batchSize {
+			chunks = append(chunks, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+			n = 0
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		err = errors.Wrap(err, "failed to read jsonl")
+
+		return
+	}
+
+	if n > 0 {
+		chunks = append(chunks, buf.Bytes())
+	}
+
+	return
+}
@@ -7,6 +7,10 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	monitoring "cloud.google.com/go/monitoring/apiv3"
@@ -17,8 +21,11 @@ import (
 
 	"github.com/ppwfx/user-svc/pkg/business"
 	"github.com/ppwfx/user-svc/pkg/communication"
+	"github.com/ppwfx/user-svc/pkg/mailing"
 	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/publishing"
 	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
 	"github.com/ppwfx/user-svc/pkg/utils/loggingutil"
 	"github.com/ppwfx/user-svc/pkg/utils/metricsutil"
 )
@@ -35,6 +42,44 @@ func main() {
 	flag.StringVar(&args.Migrate, "migrate", "", "")
 	flag.BoolVar(&args.ExposePprof, "expose-pprof", false, "")
 	flag.IntVar(&args.HttpReadTimeoutSeconds, "http-read-timeout-seconds", 5, "")
+	flag.IntVar(&args.HttpWriteTimeoutSeconds, "http-write-timeout-seconds", 5, "")
+	flag.IntVar(&args.UserExportTimeoutSeconds, "user-export-timeout-seconds", 10*60, "")
+	flag.IntVar(&args.EventStreamSeconds, "event-stream-seconds", 5*60, "")
+	flag.IntVar(&args.DeletionGracePeriodSeconds, "deletion-grace-period-seconds", 30*24*60*60, "")
+	flag.IntVar(&args.PurgeIntervalSeconds, "purge-interval-seconds", 60*60, "")
+	flag.IntVar(&args.DataExportTtlSeconds, "data-export-ttl-seconds", 24*60*60, "")
+	flag.IntVar(&args.DataExportIntervalSeconds, "data-export-interval-seconds", 5, "")
+	flag.IntVar(&args.WebhookIntervalSeconds, "webhook-interval-seconds", 1, "")
+	flag.IntVar(&args.WebhookMaxAttempts, "webhook-max-attempts", business.DefaultWebhookOpts.MaxAttempts, "")
+	flag.IntVar(&args.WebhookConcurrency, "webhook-concurrency", business.DefaultWebhookOpts.Concurrency, "")
+	flag.BoolVar(&args.WebhookAllowPrivate, "webhook-allow-private-networks", false, "")
+	flag.StringVar(&args.Publisher, "publisher", "", "")
+	flag.IntVar(&args.PublishIntervalSeconds, "publish-interval-seconds", 1, "")
+	flag.StringVar(&args.PubSubProject, "pubsub-project", "", "")
+	flag.StringVar(&args.PubSubTopic, "pubsub-topic", "user-events", "")
+	flag.StringVar(&args.NatsUrl, "nats-url", "nats://127.0.0.1:4222", "")
+	flag.StringVar(&args.NatsSubject, "nats-subject", "user-events", "")
+	flag.StringVar(&args.NatsStream, "nats-stream", "user-events", "")
+	flag.StringVar(&args.ScimBearerToken, "scim-bearer-token", "", "")
+	flag.StringVar(&args.OidcProvidersFile, "oidc-providers-file", "", "")
+	flag.StringVar(&args.Authenticators, "authenticators", types.AuthenticatorLocal, "")
+	flag.StringVar(&args.LdapConfigFile, "ldap-config-file", "", "")
+	flag.StringVar(&args.SamlProvidersFile, "saml-providers-file", "", "")
+	flag.StringVar(&args.Mailer, "mailer", "", "")
+	flag.StringVar(&args.MailFrom, "mail-from", "", "")
+	flag.StringVar(&args.SmtpAddr, "smtp-addr", "", "")
+	flag.StringVar(&args.SmtpUsername, "smtp-username", "", "")
+	flag.StringVar(&args.SmtpPassword, "smtp-password", "", "")
+	flag.StringVar(&args.MagicLinkURL, "magic-link-url", "", "")
+	flag.IntVar(&args.MagicLinkTtlSeconds, "magic-link-ttl-seconds", int(business.DefaultMagicLinkOpts.Ttl.Seconds()), "")
+	flag.IntVar(&args.MagicLinkRateLimit, "magic-link-rate-limit", business.DefaultMagicLinkOpts.RateLimit, "")
+	flag.IntVar(&args.MagicLinkRateLimitSeconds, "magic-link-rate-limit-seconds", int(business.DefaultMagicLinkOpts.RateLimitWindow.Seconds()), "")
+	flag.StringVar(&args.InvitationURL, "invitation-url", "", "")
+	flag.IntVar(&args.LockoutThreshold, "lockout-threshold", 0, "")
+	flag.IntVar(&args.TokenStateTtlSeconds, "token-state-ttl-seconds", int(business.DefaultTokenStateTtl.Seconds()), "")
+	flag.StringVar(&args.NetworkPoliciesFile, "network-policies-file", "", "")
+	flag.StringVar(&args.TrustedProxies, "trusted-proxies", "", "")
+	flag.IntVar(&args.TrustedProxyHops, "trusted-proxy-hops", 0, "")
 	flag.Parse()
 
 	ctx := context.Background()
@@ -122,10 +167,218 @@ func main() {
 			}
 		}
 
+		deletionGracePeriod := time.Duration(args.DeletionGracePeriodSeconds) * time.Second
+
+		dataExportTtl := time.Duration(args.DataExportTtlSeconds) * time.Second
+
+		go business.PurgeDeletedUsersPeriodically(ctxutil.WithContextLogger(ctx, logger), metricSink, db, time.Duration(args.PurgeIntervalSeconds)*time.Second, deletionGracePeriod)
+
+		go business.ProcessDataExportsPeriodically(ctxutil.WithContextLogger(ctx, logger), metricSink, db, time.Duration(args.DataExportIntervalSeconds)*time.Second)
+
+		webhookOpts := business.DefaultWebhookOpts
+		webhookOpts.MaxAttempts = args.WebhookMaxAttempts
+		webhookOpts.Concurrency = args.WebhookConcurrency
+
+		webhookClient := business.NewWebhookClient(args.WebhookAllowPrivate)
+
+		go business.DeliverWebhooksPeriodically(ctxutil.WithContextLogger(ctx, logger), metricSink, db, webhookClient, time.Duration(args.WebhookIntervalSeconds)*time.Second, webhookOpts)
+
+		var publisher publishing.Publisher
+		switch args.Publisher {
+		case types.PublisherPubSub:
+			publisher, err = publishing.NewPubSubPublisher(ctx, args.PubSubProject, args.PubSubTopic)
+			if err != nil {
+				err = errors.Wrap(err, "failed to create pubsub publisher")
+
+				return
+			}
+		case types.PublisherNats:
+			publisher, err = publishing.NewNatsPublisher(args.NatsUrl, args.NatsStream, args.NatsSubject)
+			if err != nil {
+				err = errors.Wrap(err, "failed to create nats publisher")
+
+				return
+			}
+		}
+		if publisher != nil {
+			defer func() {
+				err := publisher.Close()
+				if err != nil {
+					err = errors.Wrap(err, "failed to close publisher")
+
+					log.Print(err)
+
+					return
+				}
+			}()
+
+			go business.PublishEventsPeriodically(ctxutil.WithContextLogger(ctx, logger), metricSink, db, publisher, time.Duration(args.PublishIntervalSeconds)*time.Second)
+		}
+
+		listener, err := persistence.NewOutboxEventsListener(logger, args.PostgresUrl)
+		if err != nil {
+			err = errors.Wrap(err, "failed to listen for outbox events")
+
+			return
+		}
+		defer func() {
+			err := listener.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close outbox events listener")
+
+				log.Print(err)
+
+				return
+			}
+		}()
+
+		eventBroadcaster := business.NewEventBroadcaster()
+
+		go eventBroadcaster.Listen(ctxutil.WithContextLogger(ctx, logger), metricSink, db, listener)
+
+		writeTimeout := time.Duration(args.HttpWriteTimeoutSeconds) * time.Second
+
 		validate := validator.New()
 
+		var oidcProviders *business.OidcProviders
+		if args.OidcProvidersFile != "" {
+			var cs []types.OidcProvider
+			cs, err = business.LoadOidcProviders(args.OidcProvidersFile)
+			if err != nil {
+				err = errors.Wrap(err, "failed to load oidc providers")
+
+				return
+			}
+
+			oidcProviders, err = business.NewOidcProviders(ctx, validate, &http.Client{Timeout: 10 * time.Second}, cs)
+			if err != nil {
+				err = errors.Wrap(err, "failed to create oidc providers")
+
+				return
+			}
+		}
+
+		var samlProviders *business.SamlProviders
+		if args.SamlProvidersFile != "" {
+			var cs []types.SamlProvider
+			cs, err = business.LoadSamlProviders(args.SamlProvidersFile)
+			if err != nil {
+				err = errors.Wrap(err, "failed to load saml providers")
+
+				return
+			}
+
+			samlProviders, err = business.NewSamlProviders(validate, cs)
+			if err != nil {
+				err = errors.Wrap(err, "failed to create saml providers")
+
+				return
+			}
+
+			go business.DeleteExpiredSamlAssertionsPeriodically(ctxutil.WithContextLogger(ctx, logger), metricSink, db, time.Duration(args.PurgeIntervalSeconds)*time.Second)
+		}
+
+		var mailer mailing.Mailer
+		switch args.Mailer {
+		case types.MailerSmtp:
+			mailer, err = mailing.NewSmtpMailer(args.SmtpAddr, args.MailFrom, args.SmtpUsername, args.SmtpPassword)
+			if err != nil {
+				err = errors.Wrap(err, "failed to create smtp mailer")
+
+				return
+			}
+		case types.MailerLog:
+			mailer = mailing.NewLogMailer(logger)
+		}
+
+		magicLinkOpts := business.MagicLinkOpts{
+			URL:             args.MagicLinkURL,
+			Ttl:             time.Duration(args.MagicLinkTtlSeconds) * time.Second,
+			RateLimit:       args.MagicLinkRateLimit,
+			RateLimitWindow: time.Duration(args.MagicLinkRateLimitSeconds) * time.Second,
+		}
+		if mailer != nil {
+			if magicLinkOpts.URL == "" {
+				err = errors.New("failed as --magic-link-url is required by the mailer")
+
+				return
+			}
+
+			go business.DeleteStaleMagicLinksPeriodically(ctxutil.WithContextLogger(ctx, logger), metricSink, db, time.Duration(args.PurgeIntervalSeconds)*time.Second, magicLinkOpts)
+		}
+
+		var authenticators business.AuthenticatorChain
+		for _, name := range strings.Split(args.Authenticators, ",") {
+			switch strings.TrimSpace(name) {
+			case types.AuthenticatorLocal:
+				authenticators = append(authenticators, business.LocalAuthenticator{})
+			case types.AuthenticatorLdap:
+				var cfg types.LdapConfig
+				cfg, err = business.LoadLdapConfig(args.LdapConfigFile)
+				if err != nil {
+					err = errors.Wrap(err, "failed to load ldap config")
+
+					return
+				}
+
+				var ldapAuthenticator *business.LdapAuthenticator
+				ldapAuthenticator, err = business.NewLdapAuthenticator(validate, cfg, args.AllowedSubjectSuffix, business.DefaultArgon2IdOpts)
+				if err != nil {
+					err = errors.Wrap(err, "failed to create ldap authenticator")
+
+					return
+				}
+				defer ldapAuthenticator.Close()
+
+				authenticators = append(authenticators, ldapAuthenticator)
+			default:
+				err = errors.Errorf("failed as authenticator %s is unknown", name)
+
+				return
+			}
+		}
+
+		var networkPolicies *business.NetworkPolicies
+		if args.NetworkPoliciesFile != "" {
+			var cs types.NetworkPolicies
+			cs, err = business.LoadNetworkPolicies(args.NetworkPoliciesFile)
+			if err != nil {
+				err = errors.Wrap(err, "failed to load network policies")
+
+				return
+			}
+
+			networkPolicies, err = business.NewNetworkPolicies(validate, cs)
+			if err != nil {
+				err = errors.Wrap(err, "failed to create network policies")
+
+				return
+			}
+
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGHUP)
+			defer signal.Stop(signals)
+
+			go business.ReloadNetworkPoliciesOnSignal(ctxutil.WithContextLogger(ctx, logger), validate, networkPolicies, args.NetworkPoliciesFile, signals)
+		}
+
+		var trustedProxies *business.TrustedProxies
+		if args.TrustedProxies != "" || args.TrustedProxyHops > 0 {
+			var cidrs []string
+			if args.TrustedProxies != "" {
+				cidrs = strings.Split(args.TrustedProxies, ",")
+			}
+
+			trustedProxies, err = business.NewTrustedProxies(cidrs, args.TrustedProxyHops)
+			if err != nil {
+				err = errors.Wrap(err, "failed to create trusted proxies")
+
+				return
+			}
+		}
+
 		mux := http.NewServeMux()
-		mux = communication.AddSvcRoutes(mux, validate, logger, metricSink, db, args.HmacSecret, args.AllowedSubjectSuffix, business.DefaultArgon2IdOpts)
+		mux = communication.AddSvcRoutes(mux, validate, logger, metricSink, db, args.HmacSecret, args.AllowedSubjectSuffix, business.DefaultArgon2IdOpts, deletionGracePeriod, dataExportTtl, webhookClient, eventBroadcaster, time.Duration(args.EventStreamSeconds)*time.Second, time.Duration(args.UserExportTimeoutSeconds)*time.Second, args.ScimBearerToken, oidcProviders, authenticators, samlProviders, mailer, magicLinkOpts, business.InvitationOpts{URL: args.InvitationURL}, args.LockoutThreshold, business.NewTokenStates(time.Duration(args.TokenStateTtlSeconds)*time.Second), networkPolicies, trustedProxies)
 
 		if args.ExposePprof {
 			mux = communication.AddPprofRoutes(mux)
@@ -141,7 +394,7 @@ func main() {
 
 		s := &http.Server{
 			ReadHeaderTimeout: 5 * time.Second,
-			WriteTimeout:      5 * time.Second,
+			WriteTimeout:      writeTimeout,
 			ReadTimeout:       time.Duration(args.HttpReadTimeoutSeconds) * time.Second,
 			IdleTimeout:       30 * time.Second,
 			Handler:           mux,
@@ -4,17 +4,32 @@ go 1.13
 
 require (
 	cloud.google.com/go v0.61.0
+	cloud.google.com/go/pubsub v1.5.0
 	github.com/armon/go-metrics v0.3.0
+	github.com/beevik/etree v1.1.0
+	github.com/coreos/go-oidc v2.2.1+incompatible
+	github.com/crewjam/saml v0.4.9
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/go-asn1-ber/asn1-ber v1.5.1
+	github.com/go-ldap/ldap/v3 v3.2.4
 	github.com/go-playground/validator/v10 v10.3.0
 	github.com/golang-migrate/migrate/v4 v4.12.2
 	github.com/google/go-metrics-stackdriver v0.2.0
 	github.com/google/uuid v1.1.1
 	github.com/jmoiron/sqlx v1.2.0
 	github.com/lib/pq v1.3.0
+	github.com/nats-io/jwt v0.3.2 // indirect
+	github.com/nats-io/nats.go v1.11.0
 	github.com/pkg/errors v0.9.1
-	github.com/stretchr/testify v1.6.1
+	github.com/pquerna/cachecontrol v0.2.0 // indirect
+	github.com/russellhaering/goxmldsig v1.1.1
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	github.com/stretchr/testify v1.7.0
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	go.uber.org/zap v1.15.0
-	golang.org/x/crypto v0.0.0-20200709230013-948cd5f35899
-	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	golang.org/x/crypto v0.0.0-20220128200615-198e4374d7ed
+	golang.org/x/oauth2 v0.0.0-20200107190931-bf48bf16ab8d
+	google.golang.org/api v0.29.0
+	gopkg.in/square/go-jose.v2 v2.6.0
 )
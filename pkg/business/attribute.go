@@ -0,0 +1,411 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+var attributeNamespaceRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+var (
+	errInvalidAttributeNamespace   = errors.New("invalid attribute namespace")
+	errAttributeSchemaDoesNotExist = errors.New("attribute schema does not exist")
+	errAttributeSchemaInUse        = errors.New("attribute schema is in use")
+	errInvalidAttributes           = errors.New("invalid attributes")
+)
+
+// PutAttributeSchema creates or replaces the json schema of an attribute namespace. Replacing a schema
+// does not revalidate the attributes that users already hold, it only applies to subsequent writes.
+func PutAttributeSchema(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.PutAttributeSchemaRequest) (rsp types.PutAttributeSchemaResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"namespace", req.Namespace,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to put attribute schema")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	if !attributeNamespaceRegexp.MatchString(req.Namespace) {
+		err = errors.Errorf("failed as attribute namespace %v is invalid", req.Namespace)
+
+		rsp.Error = types.ErrorInvalidAttributeNamespace
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	_, err = compileAttributeSchema(req.Namespace, req.Schema)
+	if err != nil {
+		err = errors.Wrap(err, "failed to compile attribute schema")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var s types.AttributeSchemaModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		var before interface{}
+		existing, err := persistence.GetAttributeSchemaForUpdate(ctx, m, tx, req.Namespace)
+		switch {
+		case errors.Cause(err) == sql.ErrNoRows:
+		case err != nil:
+			err = errors.Wrap(err, "failed to get attribute schema")
+
+			return
+		default:
+			before = json.RawMessage(existing.Schema)
+		}
+
+		s, err = persistence.UpsertAttributeSchema(ctx, m, tx, types.AttributeSchemaModel{
+			Namespace: req.Namespace,
+			Schema:    req.Schema,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to upsert attribute schema")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionAttributeSchemaUpdated, s.Namespace, map[string]types.AuditChange{
+			"schema": {Before: before, After: json.RawMessage(s.Schema)},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.Schema = toAttributeSchema(s)
+
+	return
+}
+
+func ListAttributeSchemas(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ListAttributeSchemasRequest) (rsp types.ListAttributeSchemasResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_schemas_count", len(rsp.Schemas),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list attribute schemas")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	ss, err := persistence.SelectAttributeSchemas(ctx, m, db)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select attribute schemas")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.Schemas = []types.AttributeSchema{}
+	for _, s := range ss {
+		rsp.Schemas = append(rsp.Schemas, *toAttributeSchema(s))
+	}
+
+	return
+}
+
+// DeleteAttributeSchema deletes the schema of an attribute namespace, as long as no user holds attributes of it.
+func DeleteAttributeSchema(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.DeleteAttributeSchemaRequest) (rsp types.DeleteAttributeSchemaResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"namespace", req.Namespace,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to delete attribute schema")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		s, err := persistence.GetAttributeSchemaForUpdate(ctx, m, tx, req.Namespace)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get attribute schema")
+
+			return
+		}
+
+		count, err := persistence.CountUsersWithAttributeNamespace(ctx, m, tx, s.Namespace)
+		if err != nil {
+			err = errors.Wrap(err, "failed to count users with attribute namespace")
+
+			return
+		}
+
+		if count > 0 {
+			err = errors.Wrapf(errAttributeSchemaInUse, "failed as %d users hold attributes of the namespace", count)
+
+			return
+		}
+
+		err = persistence.DeleteAttributeSchema(ctx, m, tx, s.Namespace)
+		if err != nil {
+			err = errors.Wrap(err, "failed to delete attribute schema")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionAttributeSchemaDeleted, s.Namespace, map[string]types.AuditChange{
+			"schema": {Before: json.RawMessage(s.Schema), After: nil},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		rsp.Error = types.ErrorAttributeSchemaDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	case errors.Cause(err) == errAttributeSchemaInUse:
+		rsp.Error = types.ErrorAttributeSchemaInUse
+		statusCode = http.StatusConflict
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	return
+}
+
+// applyAttributes validates the given namespaces against their schemas, and merges them into current.
+// A namespace set to null is removed, namespaces that are not given are left untouched.
+// It has to be called within a transaction, as it locks the schemas until the attributes are written.
+func applyAttributes(ctx context.Context, m metrics.MetricSink, tx sqlx.ExtContext, current []byte, patch map[string]json.RawMessage) (merged []byte, err error) {
+	attributes := map[string]json.RawMessage{}
+	if len(current) > 0 {
+		err = json.Unmarshal(current, &attributes)
+		if err != nil {
+			err = errors.Wrap(err, "failed to unmarshal current attributes")
+
+			return
+		}
+	}
+
+	var namespaces []string
+	for namespace := range patch {
+		if !attributeNamespaceRegexp.MatchString(namespace) {
+			err = errors.Wrapf(errInvalidAttributeNamespace, "failed as attribute namespace %v is invalid", namespace)
+
+			return
+		}
+
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	ss, err := persistence.SelectAttributeSchemasByNamespacesForShare(ctx, m, tx, namespaces)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select attribute schemas")
+
+		return
+	}
+
+	schemas := map[string]types.AttributeSchemaModel{}
+	for _, s := range ss {
+		schemas[s.Namespace] = s
+	}
+
+	for _, namespace := range namespaces {
+		value := patch[namespace]
+		if isJsonNull(value) {
+			delete(attributes, namespace)
+
+			continue
+		}
+
+		s, ok := schemas[namespace]
+		if !ok {
+			err = errors.Wrapf(errAttributeSchemaDoesNotExist, "failed as attribute namespace %v has no schema", namespace)
+
+			return
+		}
+
+		err = validateAttributes(s, value)
+		if err != nil {
+			err = errors.Wrapf(errInvalidAttributes, "failed to validate attributes of namespace %v: %v", namespace, err)
+
+			return
+		}
+
+		attributes[namespace] = value
+	}
+
+	merged, err = json.Marshal(attributes)
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal attributes")
+
+		return
+	}
+
+	return
+}
+
+func validateAttributes(s types.AttributeSchemaModel, value json.RawMessage) (err error) {
+	schema, err := compileAttributeSchema(s.Namespace, s.Schema)
+	if err != nil {
+		err = errors.Wrap(err, "failed to compile attribute schema")
+
+		return
+	}
+
+	d := json.NewDecoder(bytes.NewReader(value))
+	d.UseNumber()
+
+	var doc interface{}
+	err = d.Decode(&doc)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode attributes")
+
+		return
+	}
+
+	return schema.Validate(doc)
+}
+
+// compileAttributeSchema compiles a json schema, and refuses to load remote references,
+// as schemas are supplied by admins and must not make the service issue requests.
+func compileAttributeSchema(namespace string, schema []byte) (s *jsonschema.Schema, err error) {
+	c := jsonschema.NewCompiler()
+	c.AssertFormat = true
+	c.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, errors.Errorf("failed to load %v as remote references are not supported", s)
+	}
+
+	u := "attributes:///" + namespace + ".json"
+	err = c.AddResource(u, bytes.NewReader(schema))
+	if err != nil {
+		err = errors.Wrap(err, "failed to add schema resource")
+
+		return
+	}
+
+	s, err = c.Compile(u)
+	if err != nil {
+		err = errors.Wrap(err, "failed to compile schema")
+
+		return
+	}
+
+	return
+}
+
+// parseAttributesFilter checks that the filter of listUsers is a json object, so that it can be used for a containment query.
+func parseAttributesFilter(filter json.RawMessage) (b []byte, err error) {
+	if len(filter) == 0 || isJsonNull(filter) {
+		return
+	}
+
+	var attributes map[string]json.RawMessage
+	err = json.Unmarshal(filter, &attributes)
+	if err != nil {
+		err = errors.Wrap(err, "failed as attributes filter is not a json object")
+
+		return
+	}
+
+	b = filter
+
+	return
+}
+
+func isJsonNull(b json.RawMessage) bool {
+	return string(bytes.TrimSpace(b)) == "null"
+}
+
+func toAttributeSchema(s types.AttributeSchemaModel) *types.AttributeSchema {
+	return &types.AttributeSchema{
+		Namespace: s.Namespace,
+		Schema:    s.Schema,
+		CreatedAt: s.CreatedAt,
+		UpdatedAt: s.UpdatedAt,
+	}
+}
@@ -0,0 +1,81 @@
+// +build unit
+
+package business
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestValidateAttributes(t *testing.T) {
+	s := types.AttributeSchemaModel{
+		Namespace: "crm",
+		Schema: []byte(`{
+			"type": "object",
+			"properties": {
+				"plan": {"enum": ["free", "pro"]},
+				"seats": {"type": "integer", "minimum": 1}
+			},
+			"required": ["plan"],
+			"additionalProperties": false
+		}`),
+	}
+
+	for _, tc := range []struct {
+		name  string
+		value string
+		valid bool
+	}{
+		{name: "valid", value: `{"plan": "pro", "seats": 10}`, valid: true},
+		{name: "missing required", value: `{"seats": 10}`, valid: false},
+		{name: "unknown enum value", value: `{"plan": "enterprise"}`, valid: false},
+		{name: "fractional integer", value: `{"plan": "pro", "seats": 1.5}`, valid: false},
+		{name: "additional property", value: `{"plan": "pro", "region": "eu"}`, valid: false},
+		{name: "not an object", value: `"pro"`, valid: false},
+	} {
+		err := validateAttributes(s, json.RawMessage(tc.value))
+		if tc.valid {
+			assert.NoError(t, err, tc.name)
+		} else {
+			assert.Error(t, err, tc.name)
+		}
+	}
+}
+
+func TestCompileAttributeSchema(t *testing.T) {
+	_, err := compileAttributeSchema("crm", []byte(`{"type": "object"}`))
+	assert.NoError(t, err)
+
+	_, err = compileAttributeSchema("crm", []byte(`{"type": "unknown"}`))
+	assert.Error(t, err)
+
+	_, err = compileAttributeSchema("crm", []byte(`{"$ref": "http://127.0.0.1/schema.json"}`))
+	assert.Error(t, err)
+}
+
+func TestParseAttributesFilter(t *testing.T) {
+	b, err := parseAttributesFilter(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, b)
+
+	b, err = parseAttributesFilter(json.RawMessage(`null`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Nil(t, b)
+
+	b, err = parseAttributesFilter(json.RawMessage(`{"crm": {"plan": "pro"}}`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, `{"crm": {"plan": "pro"}}`, string(b))
+
+	_, err = parseAttributesFilter(json.RawMessage(`["crm"]`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,409 @@
+package business
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	auditEventsSortBy      = "id"
+	auditEventsVerifyBatch = 1000
+	auditRedacted          = "[redacted]"
+)
+
+type auditEventHashInput struct {
+	PrevHash   string          `json:"prev_hash"`
+	OccurredAt string          `json:"occurred_at"`
+	ActorID    string          `json:"actor_id"`
+	Action     string          `json:"action"`
+	TargetID   string          `json:"target_id"`
+	RequestID  string          `json:"request_id"`
+	IP         string          `json:"ip"`
+	Diff       json.RawMessage `json:"diff"`
+}
+
+func recordAuditEvent(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, action string, targetID string, diff map[string]types.AuditChange) (err error) {
+	prevHash, err := persistence.GetLastAuditEventHashForUpdate(ctx, m, db)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get last audit event hash")
+
+		return
+	}
+
+	e := types.AuditEventModel{
+		OccurredAt: time.Now().UTC().Truncate(time.Microsecond),
+		ActorID:    ctxutil.GetSubject(ctx),
+		Action:     action,
+		TargetID:   targetID,
+		RequestID:  ctxutil.GetRequestId(ctx),
+		IP:         ctxutil.GetRemoteIp(ctx),
+		PrevHash:   prevHash,
+	}
+
+	if diff != nil {
+		e.Diff, err = json.Marshal(diff)
+		if err != nil {
+			err = errors.Wrap(err, "failed to marshal audit event diff")
+
+			return
+		}
+	}
+
+	e.Hash, err = computeAuditEventHash(e)
+	if err != nil {
+		err = errors.Wrap(err, "failed to compute audit event hash")
+
+		return
+	}
+
+	err = persistence.InsertAuditEvent(ctx, m, db, e)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert audit event")
+
+		return
+	}
+
+	return
+}
+
+func computeAuditEventHash(e types.AuditEventModel) (hash string, err error) {
+	diff := json.RawMessage("null")
+	if len(e.Diff) > 0 {
+		diff = e.Diff
+	}
+
+	b, err := json.Marshal(auditEventHashInput{
+		PrevHash:   e.PrevHash,
+		OccurredAt: e.OccurredAt.UTC().Format(time.RFC3339Nano),
+		ActorID:    e.ActorID,
+		Action:     e.Action,
+		TargetID:   e.TargetID,
+		RequestID:  e.RequestID,
+		IP:         e.IP,
+		Diff:       diff,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal audit event")
+
+		return
+	}
+
+	h := sha256.Sum256(b)
+	hash = hex.EncodeToString(h[:])
+
+	return
+}
+
+func diffUsers(before *types.UserModel, after *types.UserModel) (diff map[string]types.AuditChange) {
+	snapshot := func(u *types.UserModel) map[string]interface{} {
+		if u == nil {
+			return map[string]interface{}{}
+		}
+
+		var deletedAt interface{}
+		if u.DeletedAt != nil {
+			deletedAt = u.DeletedAt.UTC()
+		}
+
+		var status interface{}
+		if u.Status != "" {
+			status = u.Status
+		}
+
+		var externalID interface{}
+		if u.ExternalID != "" {
+			externalID = u.ExternalID
+		}
+
+		var attributes interface{}
+		if len(u.Attributes) > 0 && string(u.Attributes) != "{}" {
+			attributes = string(u.Attributes)
+		}
+
+		return map[string]interface{}{
+			"email":       u.Email,
+			"fullname":    u.FullName,
+			"user_group":  u.UserGroup,
+			"external_id": externalID,
+			"status":      status,
+			"attributes":  attributes,
+			"deleted_at":  deletedAt,
+		}
+	}
+
+	b, a := snapshot(before), snapshot(after)
+
+	diff = map[string]types.AuditChange{}
+	for _, k := range []string{"email", "fullname", "user_group", "external_id", "status", "attributes", "deleted_at"} {
+		if b[k] == a[k] {
+			continue
+		}
+
+		diff[k] = types.AuditChange{Before: b[k], After: a[k]}
+	}
+
+	return
+}
+
+func diffWebhooks(before *types.WebhookEndpointModel, after *types.WebhookEndpointModel) (diff map[string]types.AuditChange) {
+	snapshot := func(w *types.WebhookEndpointModel) map[string]interface{} {
+		if w == nil {
+			return map[string]interface{}{}
+		}
+
+		var pausedAt interface{}
+		if w.PausedAt != nil {
+			pausedAt = w.PausedAt.UTC()
+		}
+
+		return map[string]interface{}{
+			"url":         w.URL,
+			"event_types": append([]string{}, w.EventTypes...),
+			"paused_at":   pausedAt,
+		}
+	}
+
+	b, a := snapshot(before), snapshot(after)
+
+	diff = map[string]types.AuditChange{}
+	for _, k := range []string{"url", "event_types", "paused_at"} {
+		if reflect.DeepEqual(b[k], a[k]) {
+			continue
+		}
+
+		diff[k] = types.AuditChange{Before: b[k], After: a[k]}
+	}
+
+	return
+}
+
+func toAuditEvent(e types.AuditEventModel) (ae types.AuditEvent, err error) {
+	ae = types.AuditEvent{
+		ID:         e.ID,
+		OccurredAt: e.OccurredAt,
+		ActorID:    e.ActorID,
+		Action:     e.Action,
+		TargetID:   e.TargetID,
+		RequestID:  e.RequestID,
+		IP:         e.IP,
+		PrevHash:   e.PrevHash,
+		Hash:       e.Hash,
+	}
+
+	if len(e.Diff) > 0 {
+		err = json.Unmarshal(e.Diff, &ae.Diff)
+		if err != nil {
+			err = errors.Wrap(err, "failed to unmarshal audit event diff")
+
+			return
+		}
+	}
+
+	return
+}
+
+func ListAuditEvents(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ListAuditEventsRequest) (rsp types.ListAuditEventsResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_events_count", len(rsp.Events),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list audit events")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	q := types.AuditEventsQuery{
+		Limit:          req.PageSize,
+		ActorID:        req.ActorID,
+		TargetID:       req.TargetID,
+		Action:         req.Action,
+		OccurredAfter:  req.OccurredAfter,
+		OccurredBefore: req.OccurredBefore,
+	}
+	if q.Limit == 0 {
+		q.Limit = types.DefaultPageSize
+	}
+
+	if req.Cursor != "" {
+		var c cursor
+		c, err = decodeCursor(req.Cursor)
+		if err == nil && c.SortBy != auditEventsSortBy {
+			err = errors.New("failed as cursor does not match the requested sort")
+		}
+		if err == nil {
+			q.BeforeID, err = strconv.ParseInt(c.Value, 10, 64)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to decode cursor")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+	}
+
+	pageSize := q.Limit
+	q.Limit = pageSize + 1
+
+	es, err := persistence.SelectAuditEvents(ctx, m, db, q)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get audit events from database")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	if len(es) > pageSize {
+		es = es[:pageSize]
+
+		rsp.NextCursor, err = encodeCursor(cursor{
+			SortBy:    auditEventsSortBy,
+			SortOrder: types.SortOrderDesc,
+			Value:     strconv.FormatInt(es[len(es)-1].ID, 10),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to encode next cursor")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	for _, e := range es {
+		var ae types.AuditEvent
+		ae, err = toAuditEvent(e)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to convert audit event %v", e.ID)
+
+			rsp.Error = types.ErrorInternalError
+			rsp.Events = nil
+			rsp.NextCursor = ""
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+
+		rsp.Events = append(rsp.Events, ae)
+	}
+
+	return
+}
+
+func VerifyAuditEvents(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.VerifyAuditEventsRequest) (rsp types.VerifyAuditEventsResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"verified_count", rsp.VerifiedCount,
+			"first_invalid_id", rsp.FirstInvalidID,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to verify audit events")
+
+			l.Warn(err)
+		} else if !rsp.Valid {
+			l.Error("audit event hash chain is broken")
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var prevHash string
+	var afterID int64
+	for {
+		var es []types.AuditEventModel
+		es, err = persistence.SelectAuditEventsAfterId(ctx, m, db, afterID, auditEventsVerifyBatch)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get audit events from database")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+
+		for _, e := range es {
+			var hash string
+			hash, err = computeAuditEventHash(e)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to compute hash of audit event %v", e.ID)
+
+				rsp.Error = types.ErrorInternalError
+				statusCode = http.StatusInternalServerError
+
+				return
+			}
+
+			if e.PrevHash != prevHash || e.Hash != hash {
+				rsp.FirstInvalidID = e.ID
+
+				return
+			}
+
+			prevHash = e.Hash
+			afterID = e.ID
+			rsp.VerifiedCount++
+		}
+
+		if len(es) < auditEventsVerifyBatch {
+			break
+		}
+	}
+
+	rsp.Valid = true
+
+	return
+}
@@ -0,0 +1,136 @@
+// +build unit
+
+package business
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestComputeAuditEventHash(t *testing.T) {
+	e := types.AuditEventModel{
+		OccurredAt: time.Date(2020, 7, 1, 12, 0, 0, 123456000, time.UTC),
+		ActorID:    "7c2f4e0c-8d2b-4a36-9a4e-6a0f5f3f6b7a",
+		Action:     types.AuditActionUserUpdated,
+		TargetID:   "0b8e3f4e-3c3e-4b0e-8a5e-1c9b2f1e4d6a",
+		RequestID:  "5d1f6c8e-2a4b-4c6d-8e0f-1a2b3c4d5e6f",
+		IP:         "127.0.0.1",
+		Diff:       []byte(`{"fullname":{"before":"jonhdoe","after":"johndoe"}}`),
+	}
+
+	first, err := computeAuditEventHash(e)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	second, err := computeAuditEventHash(e)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, first, second)
+	assert.Len(t, first, 64)
+
+	tampered := e
+	tampered.Diff = []byte(`{"fullname":{"before":"jonhdoe","after":"janedoe"}}`)
+
+	h, err := computeAuditEventHash(tampered)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEqual(t, first, h)
+
+	chained := e
+	chained.PrevHash = first
+
+	h, err = computeAuditEventHash(chained)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NotEqual(t, first, h)
+
+	inOtherZone := e
+	inOtherZone.OccurredAt = e.OccurredAt.In(time.FixedZone("UTC+2", 2*60*60))
+
+	h, err = computeAuditEventHash(inOtherZone)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, first, h)
+}
+
+func TestDiffUsers(t *testing.T) {
+	deletedAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	before := types.UserModel{
+		Email:     "john@example.com",
+		FullName:  "jonhdoe",
+		UserGroup: types.UserGroupUser,
+	}
+
+	after := before
+	after.FullName = "johndoe"
+	after.DeletedAt = &deletedAt
+
+	assert.Equal(t, map[string]types.AuditChange{
+		"fullname":   {Before: "jonhdoe", After: "johndoe"},
+		"deleted_at": {Before: nil, After: deletedAt},
+	}, diffUsers(&before, &after))
+
+	assert.Equal(t, map[string]types.AuditChange{
+		"email":      {Before: nil, After: "john@example.com"},
+		"fullname":   {Before: nil, After: "jonhdoe"},
+		"user_group": {Before: nil, After: types.UserGroupUser},
+	}, diffUsers(nil, &before))
+
+	before.Attributes = []byte(`{}`)
+	after = before
+	after.Attributes = []byte(`{"crm": {"plan": "pro"}}`)
+
+	assert.Equal(t, map[string]types.AuditChange{
+		"attributes": {Before: nil, After: `{"crm": {"plan": "pro"}}`},
+	}, diffUsers(&before, &after))
+
+	before.Status = types.UserStatusActive
+	after = before
+	after.Status = types.UserStatusSuspended
+
+	assert.Equal(t, map[string]types.AuditChange{
+		"status": {Before: types.UserStatusActive, After: types.UserStatusSuspended},
+	}, diffUsers(&before, &after))
+}
+
+func TestDiffWebhooks(t *testing.T) {
+	pausedAt := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	before := types.WebhookEndpointModel{
+		URL:        "https://example.com/hook",
+		Secret:     "secret",
+		EventTypes: []string{types.EventTypeUserCreated},
+	}
+
+	assert.Equal(t, map[string]types.AuditChange{
+		"url":         {Before: nil, After: "https://example.com/hook"},
+		"event_types": {Before: nil, After: []string{types.EventTypeUserCreated}},
+	}, diffWebhooks(nil, &before))
+
+	after := before
+	after.Secret = "rotated"
+	after.EventTypes = []string{types.EventTypeUserCreated, types.EventTypeUserDeleted}
+	after.PausedAt = &pausedAt
+
+	assert.Equal(t, map[string]types.AuditChange{
+		"event_types": {Before: []string{types.EventTypeUserCreated}, After: []string{types.EventTypeUserCreated, types.EventTypeUserDeleted}},
+		"paused_at":   {Before: nil, After: pausedAt},
+	}, diffWebhooks(&before, &after))
+
+	assert.Equal(t, map[string]types.AuditChange{
+		"url":         {Before: "https://example.com/hook", After: nil},
+		"event_types": {Before: []string{types.EventTypeUserCreated, types.EventTypeUserDeleted}, After: nil},
+		"paused_at":   {Before: pausedAt, After: nil},
+	}, diffWebhooks(&after, nil))
+}
@@ -0,0 +1,271 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+var (
+	errInvalidCredentials  = errors.New("invalid credentials")
+	errIdentityNotLinkable = errors.New("identity is not linkable to the existing user")
+)
+
+// Authenticator verifies the credentials of a user, and returns the user they sign in as. It returns an error
+// caused by errInvalidCredentials if it doesn't know the user, or the password doesn't match.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, email string, password string) (u types.UserModel, err error)
+}
+
+// AuthenticatorChain tries the authenticators in order, and signs in with the first one accepting the credentials.
+type AuthenticatorChain []Authenticator
+
+// authenticate returns an error caused by errInvalidCredentials if all authenticators rejected the credentials,
+// or signed in a user that isn't active, and any other error if one of them failed.
+func (c AuthenticatorChain) authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, email string, password string) (u types.UserModel, name string, err error) {
+	var failed bool
+	var errs []string
+	for _, a := range c {
+		u, err = a.Authenticate(ctx, m, db, email, password)
+		if err == nil {
+			name = a.Name()
+
+			return
+		}
+
+		if errors.Cause(err) != errInvalidCredentials && errors.Cause(err) != errUserNotActive {
+			failed = true
+		}
+
+		errs = append(errs, fmt.Sprintf("%s: %v", a.Name(), err))
+	}
+
+	if failed {
+		err = errors.Errorf("failed to authenticate with any authenticator: %s", strings.Join(errs, "; "))
+
+		return
+	}
+
+	err = errors.Wrapf(errInvalidCredentials, "failed to authenticate with any authenticator: %s", strings.Join(errs, "; "))
+
+	return
+}
+
+// LocalAuthenticator verifies passwords against the argon2id hashes of the users table.
+type LocalAuthenticator struct{}
+
+func (LocalAuthenticator) Name() string {
+	return types.AuthenticatorLocal
+}
+
+func (LocalAuthenticator) Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, email string, password string) (u types.UserModel, err error) {
+	u, err = persistence.GetUserByEmail(ctx, m, db, email)
+	if errors.Cause(err) == sql.ErrNoRows {
+		err = errors.Wrap(errInvalidCredentials, "failed as user doesn't exist")
+
+		return
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user from database")
+
+		return
+	}
+
+	match, err := compareSecretAndHash(password, u.Password)
+	if err != nil {
+		err = errors.Wrap(err, "failed to compare password and hash")
+
+		return
+	}
+
+	if !match {
+		err = errors.Wrap(errInvalidCredentials, "failed as password and hash don't match")
+
+		return
+	}
+
+	return
+}
+
+// externalIdentity is a user as asserted by an identity provider or directory. A UserGroup overrides the user
+// group of the user on every sign in.
+type externalIdentity struct {
+	Provider  string
+	Subject   string
+	Email     string
+	FullName  string
+	UserGroup string
+	// LinkExisting allows linking the identity to an existing user with the same email, which lets the
+	// provider sign in as that user, so it's only set for providers that are trusted for the email
+	LinkExisting bool
+}
+
+// signInExternalIdentity returns the user linked to the identity, and links the identity to a user with the same
+// email if the provider is trusted for it, or to a user created just in time, on its first sign in.
+func signInExternalIdentity(ctx context.Context, m metrics.MetricSink, tx *sqlx.Tx, ei externalIdentity, allowedSubjectSuffix string, argonOpts Argon2IdOpts) (u types.UserModel, err error) {
+	ui, err := persistence.GetUserIdentity(ctx, m, tx, ei.Provider, ei.Subject)
+	switch {
+	case err == nil:
+		u, err = persistence.GetUserById(ctx, m, tx, ui.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get linked user")
+
+			return
+		}
+
+		err = persistence.TouchUserIdentity(ctx, m, tx, ei.Provider, ei.Subject, ei.Email)
+		if err != nil {
+			err = errors.Wrap(err, "failed to update user identity")
+
+			return
+		}
+	case errors.Cause(err) == sql.ErrNoRows:
+		u, err = linkExternalIdentity(ctx, m, tx, ei, allowedSubjectSuffix, argonOpts)
+		if err != nil {
+			err = errors.Wrap(err, "failed to link user identity")
+
+			return
+		}
+	default:
+		err = errors.Wrap(err, "failed to get user identity")
+
+		return
+	}
+
+	err = checkUserActive(u)
+	if err != nil {
+		return
+	}
+
+	if ei.UserGroup == "" || ei.UserGroup == u.UserGroup {
+		return
+	}
+
+	before := u
+	u.UserGroup = ei.UserGroup
+
+	u, err = persistence.UpdateAnyUserById(ctx, m, tx, u)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user group")
+
+		return
+	}
+
+	err = recordAuditEvent(ctx, m, tx, types.AuditActionUserUpdated, u.ID, diffUsers(&before, &u))
+	if err != nil {
+		err = errors.Wrap(err, "failed to record audit event")
+
+		return
+	}
+
+	err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserGroupChanged, u)
+	if err != nil {
+		err = errors.Wrap(err, "failed to enqueue user event")
+
+		return
+	}
+
+	return
+}
+
+func linkExternalIdentity(ctx context.Context, m metrics.MetricSink, tx *sqlx.Tx, ei externalIdentity, allowedSubjectSuffix string, argonOpts Argon2IdOpts) (u types.UserModel, err error) {
+	u, err = persistence.GetUserByEmail(ctx, m, tx, ei.Email)
+	if err == nil && !ei.LinkExisting {
+		err = errors.Wrapf(errIdentityNotLinkable, "failed as provider %s isn't trusted to sign in existing user %s", ei.Provider, u.ID)
+
+		return
+	}
+	if errors.Cause(err) == sql.ErrNoRows {
+		var password, hash string
+		password, _, err = generateToken()
+		if err != nil {
+			err = errors.Wrap(err, "failed to generate random password")
+
+			return
+		}
+
+		hash, err = hashPassword(argonOpts, password)
+		if err != nil {
+			err = errors.Wrap(err, "failed to hash password")
+
+			return
+		}
+
+		fullName := ei.FullName
+		if fullName == "" {
+			fullName = ei.Email
+		}
+
+		group := ei.UserGroup
+		if group == "" {
+			group = types.UserGroupUser
+			if strings.HasSuffix(ei.Email, allowedSubjectSuffix) {
+				group = types.UserGroupAdmin
+			}
+		}
+
+		u, err = persistence.InsertUser(ctx, m, tx, types.UserModel{
+			Email:     ei.Email,
+			Password:  hash,
+			FullName:  fullName,
+			UserGroup: group,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert user")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserCreated, u.ID, diffUsers(nil, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserCreated, u)
+		if err != nil {
+			err = errors.Wrap(err, "failed to enqueue user event")
+
+			return
+		}
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user by email")
+
+		return
+	}
+
+	_, err = persistence.InsertUserIdentity(ctx, m, tx, types.UserIdentityModel{
+		Provider: ei.Provider,
+		Subject:  ei.Subject,
+		UserID:   u.ID,
+		Email:    ei.Email,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert user identity")
+
+		return
+	}
+
+	err = recordAuditEvent(ctx, m, tx, types.AuditActionUserIdentityLinked, u.ID, map[string]types.AuditChange{
+		"provider": {After: ei.Provider},
+		"subject":  {After: ei.Subject},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to record audit event")
+
+		return
+	}
+
+	return
+}
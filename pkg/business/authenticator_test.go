@@ -0,0 +1,53 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"testing"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+type fakeAuthenticator struct {
+	name string
+	u    types.UserModel
+	err  error
+}
+
+func (a fakeAuthenticator) Name() string {
+	return a.name
+}
+
+func (a fakeAuthenticator) Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, email string, password string) (types.UserModel, error) {
+	return a.u, a.err
+}
+
+func TestAuthenticatorChain(t *testing.T) {
+	rejecting := fakeAuthenticator{name: "rejecting", err: errors.Wrap(errInvalidCredentials, "failed as password doesn't match")}
+	failing := fakeAuthenticator{name: "failing", err: errors.New("failed to connect")}
+	accepting := fakeAuthenticator{name: "accepting", u: types.UserModel{ID: "1"}}
+
+	u, name, err := AuthenticatorChain{rejecting, accepting, failing}.authenticate(context.Background(), nil, nil, "john@example.com", "password")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "accepting", name)
+	assert.Equal(t, "1", u.ID)
+
+	_, _, err = AuthenticatorChain{rejecting, rejecting}.authenticate(context.Background(), nil, nil, "john@example.com", "password")
+	assert.Equal(t, errInvalidCredentials, errors.Cause(err))
+
+	_, _, err = AuthenticatorChain{}.authenticate(context.Background(), nil, nil, "john@example.com", "password")
+	assert.Equal(t, errInvalidCredentials, errors.Cause(err))
+
+	_, _, err = AuthenticatorChain{failing, rejecting}.authenticate(context.Background(), nil, nil, "john@example.com", "password")
+	if assert.Error(t, err) {
+		assert.NotEqual(t, errInvalidCredentials, errors.Cause(err))
+	}
+}
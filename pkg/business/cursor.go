@@ -0,0 +1,46 @@
+package business
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+type cursor struct {
+	SortBy    string `json:"s"`
+	SortOrder string `json:"o"`
+	Value     string `json:"v"`
+	ID        string `json:"i"`
+}
+
+func encodeCursor(c cursor) (s string, err error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal cursor")
+
+		return
+	}
+
+	s = base64.RawURLEncoding.EncodeToString(b)
+
+	return
+}
+
+func decodeCursor(s string) (c cursor, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode cursor")
+
+		return
+	}
+
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal cursor")
+
+		return
+	}
+
+	return
+}
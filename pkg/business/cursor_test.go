@@ -0,0 +1,38 @@
+// +build unit
+
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeCursor(t *testing.T) {
+	c := cursor{
+		SortBy:    "email",
+		SortOrder: "asc",
+		Value:     "john@example.com",
+		ID:        "7c2f4e0c-8d2b-4a36-9a4e-6a0f5f3f6b7a",
+	}
+
+	s, err := encodeCursor(c)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	decoded, err := decodeCursor(s)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, c, decoded)
+}
+
+func TestDecodeInvalidCursor(t *testing.T) {
+	_, err := decodeCursor("not a cursor")
+	assert.Error(t, err)
+
+	_, err = decodeCursor("bm90IGpzb24")
+	assert.Error(t, err)
+}
@@ -0,0 +1,373 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const dataExportClaimTimeout = 5 * time.Minute
+
+func ExportMyData(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, ttl time.Duration, sub string, req types.ExportMyDataRequest) (rsp types.ExportMyDataResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to export my data")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusAccepted
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	rsp.Export, rsp.Error, statusCode, err = requestDataExport(ctx, m, db, ttl, sub, sub)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ExportUserData(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, ttl time.Duration, sub string, req types.ExportUserDataRequest) (rsp types.ExportUserDataResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to export user data")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusAccepted
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	rsp.Export, rsp.Error, statusCode, err = requestDataExport(ctx, m, db, ttl, req.ID, sub)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func requestDataExport(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, ttl time.Duration, userID string, requestedBy string) (export *types.DataExport, rspErr string, statusCode int, err error) {
+	statusCode = http.StatusAccepted
+
+	_, err = persistence.GetAnyUserById(ctx, m, db, userID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		rspErr = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate download token")
+
+		rspErr = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	var e types.DataExportModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		e, err = persistence.InsertDataExport(ctx, m, tx, types.DataExportModel{
+			UserID:      userID,
+			RequestedBy: requestedBy,
+			TokenHash:   tokenHash,
+			ExpiresAt:   time.Now().Add(ttl),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert data export")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionDataExportRequested, userID, nil)
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to request data export")
+
+		rspErr = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	export = &types.DataExport{
+		ID:          e.ID,
+		Status:      e.Status,
+		DownloadURL: types.RouteDownloadDataExport + "?" + url.Values{types.QueryToken: []string{token}}.Encode(),
+		ExpiresAt:   e.ExpiresAt,
+	}
+
+	return
+}
+
+func DownloadDataExport(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.DownloadDataExportRequest) (rsp types.DownloadDataExportResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"data_export_id", rsp.ID,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to download data export")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	e, err := persistence.GetDataExportByTokenHash(ctx, m, db, hashToken(req.Token))
+	if err != nil {
+		err = errors.Wrap(err, "failed to get data export")
+
+		rsp.Error = types.ErrorDataExportDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	rsp.ID = e.ID
+
+	switch e.Status {
+	case types.DataExportStatusCompleted:
+		rsp.Archive = e.Archive
+	case types.DataExportStatusFailed:
+		rsp.Error = types.ErrorDataExportFailed
+		statusCode = http.StatusInternalServerError
+	default:
+		rsp.Error = types.ErrorDataExportNotReady
+		statusCode = http.StatusAccepted
+	}
+
+	return
+}
+
+func ProcessDataExport(ctx context.Context, m metrics.MetricSink, db *sqlx.DB) (processed bool, err error) {
+	var e types.DataExportModel
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"data_export_id", e.ID,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to process data export")
+
+			l.Error(err)
+		} else if processed {
+			l.Info("processed data export")
+		}
+	}(time.Now())
+
+	e, err = persistence.ClaimPendingDataExport(ctx, m, db, dataExportClaimTimeout)
+	if errors.Cause(err) == sql.ErrNoRows {
+		err = nil
+
+		return
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to claim pending data export")
+
+		return
+	}
+
+	processed = true
+
+	archive, err := buildDataExportArchive(ctx, m, db, e.UserID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to build data export archive")
+
+		failErr := persistence.FailDataExport(ctx, m, db, e.ID)
+		if failErr != nil {
+			err = errors.Wrapf(err, "failed to mark data export as failed: %v", failErr)
+		}
+
+		return
+	}
+
+	err = persistence.CompleteDataExport(ctx, m, db, e.ID, archive)
+	if err != nil {
+		err = errors.Wrap(err, "failed to complete data export")
+
+		return
+	}
+
+	return
+}
+
+func buildDataExportArchive(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, userID string) (b []byte, err error) {
+	u, err := persistence.GetAnyUserById(ctx, m, db, userID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		return
+	}
+
+	archive := types.DataExportArchive{
+		GeneratedAt: time.Now().UTC(),
+		Profile: types.DataExportProfile{
+			ID:         u.ID,
+			Email:      u.Email,
+			FullName:   u.FullName,
+			UserGroup:  u.UserGroup,
+			Attributes: u.Attributes,
+			CreatedAt:  u.CreatedAt,
+			UpdatedAt:  u.UpdatedAt,
+			DeletedAt:  u.DeletedAt,
+		},
+	}
+
+	es, err := persistence.SelectAuditEvents(ctx, m, db, types.AuditEventsQuery{SubjectID: userID})
+	if err != nil {
+		err = errors.Wrap(err, "failed to get audit events")
+
+		return
+	}
+
+	for _, e := range es {
+		var ae types.AuditEvent
+		ae, err = toAuditEvent(e)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to convert audit event %v", e.ID)
+
+			return
+		}
+
+		archive.AuditEvents = append(archive.AuditEvents, ae)
+	}
+
+	as, err := persistence.SelectLoginAttempts(ctx, m, db, types.LoginAttemptsQuery{UserID: userID})
+	if err != nil {
+		err = errors.Wrap(err, "failed to get login attempts")
+
+		return
+	}
+
+	for _, a := range as {
+		archive.LoginAttempts = append(archive.LoginAttempts, toLoginAttempt(a))
+	}
+
+	uis, err := persistence.SelectUserIdentitiesByUserId(ctx, m, db, userID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user identities")
+
+		return
+	}
+
+	for _, ui := range uis {
+		archive.Identities = append(archive.Identities, types.DataExportIdentity{
+			Provider:    ui.Provider,
+			Subject:     ui.Subject,
+			Email:       ui.Email,
+			CreatedAt:   ui.CreatedAt,
+			LastLoginAt: ui.LastLoginAt,
+		})
+	}
+
+	b, err = json.Marshal(archive)
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal archive")
+
+		return
+	}
+
+	return
+}
+
+func ProcessDataExportsPeriodically(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		n, err := persistence.DeleteExpiredDataExports(ctx, m, db)
+		if err != nil {
+			ctxutil.GetContextLogger(ctx).Error(errors.Wrap(err, "failed to delete expired data exports"))
+		} else if n > 0 {
+			ctxutil.GetContextLogger(ctx).With("deleted_data_exports_count", n).Info("deleted expired data exports")
+		}
+
+		for {
+			processed, err := ProcessDataExport(ctx, m, db)
+			if err != nil || !processed {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
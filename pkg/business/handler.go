@@ -2,6 +2,7 @@ package business
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"github.com/armon/go-metrics"
 	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
@@ -12,6 +13,7 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
+	"github.com/ppwfx/user-svc/pkg/mailing"
 	"github.com/ppwfx/user-svc/pkg/persistence"
 	"github.com/ppwfx/user-svc/pkg/types"
 )
@@ -60,18 +62,45 @@ func CreateUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, argonOpt
 		group = types.UserGroupUser
 	}
 
-	err = persistence.InsertUser(ctx, m, db, types.UserModel{
-		Email:     req.Email,
-		Password:  string(hashSecret(salt, req.Password, argonOpts)),
-		FullName:  req.FullName,
-		UserGroup: group,
-	})
-	if err != nil {
-		err = errors.Wrap(err, "failed to insert user into database")
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err := persistence.InsertUser(ctx, m, tx, types.UserModel{
+			Email:     req.Email,
+			Password:  string(hashSecret(salt, req.Password, argonOpts)),
+			FullName:  req.FullName,
+			UserGroup: group,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert user into database")
 
-		rsp.Error = err.Error()
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserCreated, u.ID, diffUsers(nil, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserCreated, u)
+		if err != nil {
+			err = errors.Wrap(err, "failed to enqueue user event")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case persistence.IsUniqueViolation(err):
+		rsp.Error = types.ErrorEmailAlreadyExists
 		statusCode = http.StatusUnprocessableEntity
 
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
 		return
 	}
 
@@ -107,7 +136,87 @@ func ListUsers(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *valida
 		return
 	}
 
-	us, err := persistence.SelectUsersOrderByIdDesc(ctx, m, db)
+	q := types.UsersQuery{
+		Limit:         req.PageSize,
+		SortBy:        req.SortBy,
+		SortOrder:     req.SortOrder,
+		UserGroup:     req.UserGroup,
+		CreatedAfter:  req.CreatedAfter,
+		CreatedBefore: req.CreatedBefore,
+		EmailDomain:   req.EmailDomain,
+		Deleted:       req.Deleted,
+		Status:        req.Status,
+	}
+	if q.Limit == 0 {
+		q.Limit = types.DefaultPageSize
+	}
+
+	q.Attributes, err = parseAttributesFilter(req.Attributes)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse attributes filter")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+	if q.SortBy == "" {
+		q.SortBy = types.SortByCreatedAt
+	}
+	if q.SortOrder == "" {
+		q.SortOrder = types.SortOrderDesc
+	}
+
+	if req.Cursor != "" {
+		var c cursor
+		c, err = decodeCursor(req.Cursor)
+		if err != nil {
+			err = errors.Wrap(err, "failed to decode cursor")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+
+		if c.SortBy != q.SortBy || c.SortOrder != q.SortOrder {
+			err = errors.New("failed as cursor does not match the requested sort")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+
+		err = v.Var(c.ID, "uuid")
+		if err != nil {
+			err = errors.Wrap(err, "failed to validate cursor id")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+
+		q.AfterID = c.ID
+		q.AfterValue = c.Value
+		if q.SortBy == types.SortByCreatedAt {
+			q.AfterValue, err = time.Parse(time.RFC3339Nano, c.Value)
+			if err != nil {
+				err = errors.Wrap(err, "failed to parse cursor value")
+
+				rsp.Error = types.ErrorInvalidCursor
+				statusCode = http.StatusUnprocessableEntity
+
+				return
+			}
+		}
+	}
+
+	pageSize := q.Limit
+	q.Limit = pageSize + 1
+
+	us, err := persistence.SelectUsers(ctx, m, db, q)
 	if err != nil {
 		err = errors.Wrap(err, "failed to get users from database")
 
@@ -117,14 +226,60 @@ func ListUsers(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *valida
 		return
 	}
 
+	if len(us) > pageSize {
+		us = us[:pageSize]
+
+		last := us[len(us)-1]
+		c := cursor{
+			SortBy:    q.SortBy,
+			SortOrder: q.SortOrder,
+			ID:        last.ID,
+		}
+		switch q.SortBy {
+		case types.SortByCreatedAt:
+			c.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+		case types.SortByEmail:
+			c.Value = last.Email
+		case types.SortByFullName:
+			c.Value = last.FullName
+		}
+
+		rsp.NextCursor, err = encodeCursor(c)
+		if err != nil {
+			err = errors.Wrap(err, "failed to encode next cursor")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
 	for _, u := range us {
 		rsp.Users = append(rsp.Users, types.ListUser{
-			ID:       u.ID,
-			Email:    u.Email,
-			FullName: u.FullName,
+			ID:         u.ID,
+			Email:      u.Email,
+			FullName:   u.FullName,
+			UserGroup:  u.UserGroup,
+			Status:     u.Status,
+			Attributes: u.Attributes,
+			CreatedAt:  u.CreatedAt,
+			DeletedAt:  u.DeletedAt,
 		})
 	}
 
+	err = applyLastLogins(ctx, m, db, rsp.Users)
+	if err != nil {
+		err = errors.Wrap(err, "failed to apply last logins")
+
+		rsp.Error = types.ErrorInternalError
+		rsp.Users = nil
+		rsp.NextCursor = ""
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
 	return
 }
 
@@ -156,7 +311,7 @@ func DeleteUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *valid
 		return
 	}
 
-	_, err = persistence.GetUserByEmail(ctx, m, db, req.Email)
+	before, err := persistence.GetUserByEmail(ctx, m, db, req.Email)
 	if err != nil {
 		err = errors.Wrap(err, "failed to get user")
 
@@ -166,7 +321,30 @@ func DeleteUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *valid
 		return
 	}
 
-	err = persistence.DeleteUserByEmail(ctx, m, db, req.Email)
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err := persistence.SoftDeleteUserByEmail(ctx, m, tx, req.Email)
+		if err != nil {
+			err = errors.Wrap(err, "failed to soft delete user")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserDeleted, u.ID, diffUsers(&before, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserDeleted, u)
+		if err != nil {
+			err = errors.Wrap(err, "failed to enqueue user event")
+
+			return
+		}
+
+		return
+	})
 	if err != nil {
 		err = errors.Wrap(err, "failed to delete user")
 
@@ -179,7 +357,9 @@ func DeleteUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *valid
 	return
 }
 
-func Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, hmacSecret string, req types.AuthenticateRequest) (rsp types.AuthenticateResponse, statusCode int) {
+// Authenticate signs a user in with the authenticator chain. Users that aren't active are rejected, and active users
+// are locked after lockoutThreshold consecutive failed authentications, unless lockoutThreshold is 0.
+func Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, hmacSecret string, oidcProviders *OidcProviders, authenticators AuthenticatorChain, lockoutThreshold int, mailer mailing.Mailer, req types.AuthenticateRequest) (rsp types.AuthenticateResponse, statusCode int) {
 	var err error
 	defer func(begin time.Time) {
 		l := ctxutil.GetContextLogger(ctx).With(
@@ -207,9 +387,47 @@ func Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *val
 		return
 	}
 
-	u, err := persistence.GetUserByEmail(ctx, m, db, req.Email)
+	if oidcProviders.RequiresFederatedLogin(req.Email) {
+		err = errors.New("failed as the email domain is routed to an identity provider")
+
+		rsp.Error = types.ErrorFederatedLoginRequired
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	u, authenticator, err := authenticators.authenticate(ctx, m, db, req.Email, req.Password)
+	if err != nil && errors.Cause(err) != errInvalidCredentials {
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
 	if err != nil {
-		err = errors.Wrap(err, "failed to get user from database")
+		fu, getErr := persistence.GetUserByEmail(ctx, m, db, req.Email)
+		if getErr != nil {
+			recordFailedLoginAttempt(ctx, m, db, "", req.Email, types.LoginMethodPassword, types.ErrorInvalidCredentials)
+		}
+		if getErr == nil {
+			// failed authentications are recorded in login_attempts instead of the audit log, so that guessing
+			// passwords doesn't contend for the audit log lock, only locking the user appends an audit event
+			recordErr := persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+				_, err = recordLoginAttempt(ctx, m, tx, fu.ID, req.Email, types.LoginMethodPassword, types.ErrorInvalidCredentials)
+				if err != nil {
+					return
+				}
+
+				return countFailedAuthentication(ctx, m, tx, fu, lockoutThreshold)
+			})
+			if recordErr != nil {
+				err = errors.Wrapf(err, "failed to record failed authentication: %v", recordErr)
+
+				rsp.Error = types.ErrorInternalError
+				statusCode = http.StatusInternalServerError
+
+				return
+			}
+		}
 
 		rsp.Error = types.ErrorInvalidCredentials
 		statusCode = http.StatusUnprocessableEntity
@@ -217,7 +435,221 @@ func Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *val
 		return
 	}
 
-	match, err := compareSecretAndHash(req.Password, u.Password)
+	// users that aren't active get the same response as a wrong password, so that the response doesn't
+	// reveal whether a guessed password of a locked, or suspended user matches
+	if u.Status != types.UserStatusActive {
+		err = checkUserActive(u)
+
+		_, recordErr := recordLoginAttempt(ctx, m, db, u.ID, req.Email, types.LoginMethodPassword, types.ErrorUserNotActive)
+		if recordErr != nil {
+			err = errors.Wrapf(err, "failed to record failed authentication: %v", recordErr)
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+
+		rsp.Error = types.ErrorInvalidCredentials
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var d types.LoginDeviceModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		err = recordAuditEvent(ctxutil.WithSubject(ctx, u.ID), m, tx, types.AuditActionUserAuthenticated, u.ID, map[string]types.AuditChange{
+			"authenticator": {After: authenticator},
+		})
+		if err != nil {
+			return
+		}
+
+		d, err = recordLoginAttempt(ctx, m, tx, u.ID, req.Email, types.LoginMethodPassword, "")
+		if err != nil {
+			return
+		}
+
+		return persistence.ResetFailedAuthenticationsById(ctx, m, tx, u.ID)
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to record audit event")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	accessToken, err := GenerateAccessToken(hmacSecret, u.UserGroup, u.ID, u.TokenEpoch)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate access token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.AccessToken = accessToken
+
+	notifyLoginDevice(ctx, mailer, u, d)
+
+	return
+}
+
+func GetMe(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, sub string, req types.GetMeRequest) (rsp types.GetMeResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to get me")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	u, err := persistence.GetUserById(ctx, m, db, sub)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	rsp.User = toUser(u)
+
+	return
+}
+
+func UpdateProfile(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, sub string, req types.UpdateProfileRequest) (rsp types.UpdateProfileResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to update profile")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	before, err := persistence.GetUserById(ctx, m, db, sub)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = persistence.UpdateUserFullNameById(ctx, m, tx, sub, req.FullName)
+		if err != nil {
+			err = errors.Wrap(err, "failed to update user fullname")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserUpdated, u.ID, diffUsers(&before, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user fullname")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.User = toUser(u)
+
+	return
+}
+
+func ChangePassword(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, hmacSecret string, argonOpts Argon2IdOpts, v *validator.Validate, sub string, req types.ChangePasswordRequest) (rsp types.ChangePasswordResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to change password")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	u, err := persistence.GetUserById(ctx, m, db, sub)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	match, err := compareSecretAndHash(req.CurrentPassword, u.Password)
 	if err != nil {
 		err = errors.Wrap(err, "failed to compare password and hash")
 
@@ -235,7 +667,46 @@ func Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *val
 		return
 	}
 
-	accessToken, err := GenerateAccessToken(hmacSecret, u.UserGroup, u.ID)
+	salt, err := generateRandomBytes(argonOpts.SaltLength)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate random salt")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = persistence.UpdateUserPasswordById(ctx, m, tx, sub, hashSecret(salt, req.NewPassword, argonOpts))
+		if err != nil {
+			err = errors.Wrap(err, "failed to update user password")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserPasswordChanged, sub, map[string]types.AuditChange{
+			"password": {Before: auditRedacted, After: auditRedacted},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user password")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	// the password change bumped the token epoch, which revoked the token of the request as well
+	rsp.AccessToken, err = GenerateAccessToken(hmacSecret, u.UserGroup, u.ID, u.TokenEpoch)
 	if err != nil {
 		err = errors.Wrap(err, "failed to generate access token")
 
@@ -245,7 +716,251 @@ func Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *val
 		return
 	}
 
-	rsp.AccessToken = accessToken
+	return
+}
+
+func DeleteMyAccount(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, sub string, req types.DeleteMyAccountRequest) (rsp types.DeleteMyAccountResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to delete my account")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	before, err := persistence.GetUserById(ctx, m, db, sub)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err := persistence.SoftDeleteUserById(ctx, m, tx, sub)
+		if err != nil {
+			err = errors.Wrap(err, "failed to soft delete user")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserDeleted, u.ID, diffUsers(&before, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserDeleted, u)
+		if err != nil {
+			err = errors.Wrap(err, "failed to enqueue user event")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete user")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
 
 	return
 }
+
+func UpdateUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.UpdateUserRequest) (rsp types.UpdateUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to update user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	if req.Version == "" {
+		err = errors.New("failed as no version was provided")
+
+		rsp.Error = types.ErrorVersionRequired
+		statusCode = http.StatusPreconditionRequired
+
+		return
+	}
+
+	updatedAt, err := parseVersion(req.Version)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse version")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	before, err := persistence.GetUserById(ctx, m, db, req.ID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		attributes, err := applyAttributes(ctx, m, tx, before.Attributes, req.Attributes)
+		if err != nil {
+			err = errors.Wrap(err, "failed to apply attributes")
+
+			return
+		}
+
+		u, err = persistence.UpdateUserByIdAndUpdatedAt(ctx, m, tx, types.UserModel{
+			ID:         req.ID,
+			Email:      req.Email,
+			FullName:   req.FullName,
+			UserGroup:  req.UserGroup,
+			Attributes: attributes,
+			UpdatedAt:  updatedAt,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to update user")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserUpdated, u.ID, diffUsers(&before, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		if u.UserGroup != before.UserGroup {
+			err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserGroupChanged, u)
+			if err != nil {
+				err = errors.Wrap(err, "failed to enqueue user event")
+
+				return
+			}
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		err = errors.Wrap(err, "failed to update user")
+
+		rsp.Error = types.ErrorVersionMismatch
+		statusCode = http.StatusPreconditionFailed
+
+		return
+	case errors.Cause(err) == errInvalidAttributeNamespace:
+		err = errors.Wrap(err, "failed to update user")
+
+		rsp.Error = types.ErrorInvalidAttributeNamespace
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case errors.Cause(err) == errAttributeSchemaDoesNotExist:
+		err = errors.Wrap(err, "failed to update user")
+
+		rsp.Error = types.ErrorAttributeSchemaDoesNotExist
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case errors.Cause(err) == errInvalidAttributes:
+		err = errors.Wrap(err, "failed to update user")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case persistence.IsUniqueViolation(err):
+		err = errors.Wrap(err, "failed to update user")
+
+		rsp.Error = types.ErrorEmailAlreadyExists
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case err != nil:
+		err = errors.Wrap(err, "failed to update user")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.User = toUser(u)
+
+	return
+}
+
+func toUser(u types.UserModel) *types.User {
+	return &types.User{
+		ID:              u.ID,
+		Email:           u.Email,
+		FullName:        u.FullName,
+		UserGroup:       u.UserGroup,
+		Status:          u.Status,
+		StatusReason:    u.StatusReason,
+		StatusChangedAt: u.StatusChangedAt,
+		Attributes:      u.Attributes,
+		CreatedAt:       u.CreatedAt,
+		UpdatedAt:       u.UpdatedAt,
+		Version:         formatVersion(u.UpdatedAt),
+	}
+}
+
+func formatVersion(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseVersion(v string) (t time.Time, err error) {
+	return time.Parse(time.RFC3339Nano, v)
+}
@@ -0,0 +1,459 @@
+package business
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	importBatchSize    = 500
+	importHashWorkers  = 4
+	importMaxLineBytes = 1024 * 1024
+	importColumnEmail  = "email"
+	importColumnPass   = "password"
+	importColumnName   = "fullname"
+)
+
+var errTooManyImportRows = errors.New(types.ErrorTooManyImportRows)
+
+type importRow struct {
+	row int
+	req types.CreateUserRequest
+	err string
+}
+
+func parseImportRows(format string, r io.Reader) (rows []importRow, err error) {
+	switch format {
+	case types.ImportFormatCsv:
+		return parseCsvImportRows(r)
+	case types.ImportFormatJsonl:
+		return parseJsonlImportRows(r)
+	}
+
+	err = errors.Errorf("unsupported import format %s", format)
+
+	return
+}
+
+func parseCsvImportRows(r io.Reader) (rows []importRow, err error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		err = nil
+
+		return
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to read csv header")
+
+		return
+	}
+
+	columns := map[string]int{}
+	for i, h := range header {
+		if i == 0 {
+			h = strings.TrimPrefix(h, "\ufeff")
+		}
+
+		columns[strings.ToLower(strings.TrimSpace(h))] = i
+	}
+
+	for _, c := range []string{importColumnEmail, importColumnPass, importColumnName} {
+		_, ok := columns[c]
+		if !ok {
+			err = errors.Errorf("csv header is missing column %s", c)
+
+			return
+		}
+	}
+
+	for n := 1; ; n++ {
+		var rec []string
+		rec, err = cr.Read()
+		if err == io.EOF {
+			err = nil
+
+			return
+		}
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read csv row %v", n)
+
+			return
+		}
+
+		if n > types.MaxImportRows {
+			err = errTooManyImportRows
+
+			return
+		}
+
+		row := importRow{row: n}
+		if len(rec) != len(header) {
+			row.err = fmt.Sprintf("expected %d fields, got %d", len(header), len(rec))
+		} else {
+			row.req = types.CreateUserRequest{
+				Email:    rec[columns[importColumnEmail]],
+				Password: rec[columns[importColumnPass]],
+				FullName: rec[columns[importColumnName]],
+			}
+		}
+
+		rows = append(rows, row)
+	}
+}
+
+func parseJsonlImportRows(r io.Reader) (rows []importRow, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), importMaxLineBytes)
+
+	n := 0
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		n++
+		if n > types.MaxImportRows {
+			err = errTooManyImportRows
+
+			return
+		}
+
+		row := importRow{row: n}
+		err = json.Unmarshal([]byte(line), &row.req)
+		if err != nil {
+			row.err = errors.Wrap(err, "failed to decode row").Error()
+			row.req = types.CreateUserRequest{}
+			err = nil
+		}
+
+		rows = append(rows, row)
+	}
+
+	err = s.Err()
+	if err != nil {
+		err = errors.Wrap(err, "failed to read jsonl")
+
+		return
+	}
+
+	return
+}
+
+func validateImportRows(v *validator.Validate, rows []importRow, hashedPasswords bool) (valid []importRow, failed []types.ImportUserError) {
+	seen := map[string]bool{}
+	for _, r := range rows {
+		fail := func(msg string) {
+			failed = append(failed, types.ImportUserError{Row: r.row, Email: r.req.Email, Error: msg})
+		}
+
+		if r.err != "" {
+			fail(r.err)
+
+			continue
+		}
+
+		err := v.Struct(&r.req)
+		if err != nil {
+			fail(errors.Wrap(err, "failed to validate the row").Error())
+
+			continue
+		}
+
+		if hashedPasswords && !isArgon2IdHash(r.req.Password) {
+			fail(types.ErrorInvalidPasswordHash)
+
+			continue
+		}
+
+		if seen[r.req.Email] {
+			fail(types.ErrorDuplicateImportRow)
+
+			continue
+		}
+		seen[r.req.Email] = true
+
+		valid = append(valid, r)
+	}
+
+	return
+}
+
+func isArgon2IdHash(s string) bool {
+	if !strings.HasPrefix(s, "$argon2id$") {
+		return false
+	}
+
+	_, _, _, err := decodeHash(s)
+
+	return err == nil
+}
+
+func hashImportPasswords(ctx context.Context, argonOpts Argon2IdOpts, rows []importRow) (hashes []string, err error) {
+	hashes = make([]string, len(rows))
+
+	indexes := make(chan int)
+	errs := make(chan error, importHashWorkers)
+	wg := sync.WaitGroup{}
+	for w := 0; w < importHashWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range indexes {
+				salt, err := generateRandomBytes(argonOpts.SaltLength)
+				if err != nil {
+					errs <- errors.Wrap(err, "failed to generate random salt")
+
+					return
+				}
+
+				hashes[i] = hashSecret(salt, rows[i].req.Password, argonOpts)
+			}
+		}()
+	}
+
+	func() {
+		defer close(indexes)
+
+		for i := range rows {
+			select {
+			case indexes <- i:
+			case err = <-errs:
+				return
+			case <-ctx.Done():
+				err = ctx.Err()
+
+				return
+			}
+		}
+	}()
+
+	wg.Wait()
+
+	if err == nil {
+		select {
+		case err = <-errs:
+		default:
+		}
+	}
+
+	return
+}
+
+func ImportUsers(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, argonOpts Argon2IdOpts, v *validator.Validate, allowedSubjectSuffix string, req types.ImportUsersRequest) (rsp types.ImportUsersResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"dry_run", rsp.DryRun,
+			"total_count", rsp.Total,
+			"imported_count", rsp.Imported,
+			"failed_count", rsp.Failed,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to import users")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	rsp.DryRun = req.DryRun
+
+	rows, err := parseImportRows(req.Format, req.Body)
+	switch {
+	case err == errTooManyImportRows:
+		rsp.Error = types.ErrorTooManyImportRows
+		statusCode = http.StatusRequestEntityTooLarge
+
+		return
+	case err != nil:
+		err = errors.Wrap(err, "failed to parse rows")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	rsp.Total = len(rows)
+
+	valid, failed := validateImportRows(v, rows, req.HashedPasswords)
+	rsp.Errors = append(rsp.Errors, failed...)
+
+	defer func() {
+		sort.Slice(rsp.Errors, func(i, j int) bool {
+			return rsp.Errors[i].Row < rsp.Errors[j].Row
+		})
+
+		rsp.Failed = len(rsp.Errors)
+	}()
+
+	if len(valid) > 0 {
+		emails := make([]string, len(valid))
+		for i, r := range valid {
+			emails[i] = r.req.Email
+		}
+
+		var existing []string
+		existing, err = persistence.SelectExistingUserEmails(ctx, m, db, emails)
+		if err != nil {
+			err = errors.Wrap(err, "failed to select existing user emails")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+
+		exists := map[string]bool{}
+		for _, e := range existing {
+			exists[e] = true
+		}
+
+		available := valid[:0]
+		for _, r := range valid {
+			if exists[r.req.Email] {
+				rsp.Errors = append(rsp.Errors, types.ImportUserError{Row: r.row, Email: r.req.Email, Error: types.ErrorEmailAlreadyExists})
+
+				continue
+			}
+
+			available = append(available, r)
+		}
+		valid = available
+	}
+
+	if req.DryRun || len(valid) == 0 {
+		return
+	}
+
+	passwords := make([]string, len(valid))
+	if req.HashedPasswords {
+		for i, r := range valid {
+			passwords[i] = r.req.Password
+		}
+	} else {
+		passwords, err = hashImportPasswords(ctx, argonOpts, valid)
+		if err != nil {
+			err = errors.Wrap(err, "failed to hash passwords")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	for start := 0; start < len(valid); start += importBatchSize {
+		end := start + importBatchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+
+		var imported int
+		var conflicts []types.ImportUserError
+		err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+			imported, conflicts = 0, nil
+
+			for i := start; i < end; i++ {
+				r := valid[i]
+
+				var group string
+				if strings.HasSuffix(r.req.Email, allowedSubjectSuffix) {
+					group = types.UserGroupAdmin
+				} else {
+					group = types.UserGroupUser
+				}
+
+				var u types.UserModel
+				u, err = persistence.InsertUserIfEmailAvailable(ctx, m, tx, types.UserModel{
+					Email:     r.req.Email,
+					Password:  passwords[i],
+					FullName:  r.req.FullName,
+					UserGroup: group,
+				})
+				if errors.Cause(err) == sql.ErrNoRows {
+					conflicts = append(conflicts, types.ImportUserError{Row: r.row, Email: r.req.Email, Error: types.ErrorEmailAlreadyExists})
+					err = nil
+
+					continue
+				}
+				if err != nil {
+					err = errors.Wrapf(err, "failed to insert user of row %v into database", r.row)
+
+					return
+				}
+
+				err = recordAuditEvent(ctx, m, tx, types.AuditActionUserCreated, u.ID, diffUsers(nil, &u))
+				if err != nil {
+					err = errors.Wrap(err, "failed to record audit event")
+
+					return
+				}
+
+				err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserCreated, u)
+				if err != nil {
+					err = errors.Wrap(err, "failed to enqueue user event")
+
+					return
+				}
+
+				imported++
+			}
+
+			return
+		})
+		if err != nil {
+			err = errors.Wrapf(err, "failed to import batch starting at row %v", valid[start].row)
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+
+		rsp.Imported += imported
+		rsp.Errors = append(rsp.Errors, conflicts...)
+	}
+
+	return
+}
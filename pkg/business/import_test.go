@@ -0,0 +1,120 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestParseCsvImportRows(t *testing.T) {
+	rows, err := parseImportRows(types.ImportFormatCsv, strings.NewReader("\ufeffFullName,Email,Password\nJohn Doe,john@example.com,secret\n\"Doe, Jane\",jane@example.com\n"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []importRow{
+		{row: 1, req: types.CreateUserRequest{Email: "john@example.com", Password: "secret", FullName: "John Doe"}},
+		{row: 2, err: "expected 3 fields, got 2"},
+	}, rows)
+
+	_, err = parseImportRows(types.ImportFormatCsv, strings.NewReader("email,password\njohn@example.com,secret\n"))
+	assert.EqualError(t, err, "csv header is missing column fullname")
+
+	rows, err = parseImportRows(types.ImportFormatCsv, strings.NewReader(""))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, rows)
+}
+
+func TestParseJsonlImportRows(t *testing.T) {
+	rows, err := parseImportRows(types.ImportFormatJsonl, strings.NewReader("{\"email\":\"john@example.com\",\"password\":\"secret\",\"fullname\":\"John Doe\"}\n\n{\"email\":\n"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.Len(t, rows, 2) {
+		return
+	}
+	assert.Equal(t, importRow{row: 1, req: types.CreateUserRequest{Email: "john@example.com", Password: "secret", FullName: "John Doe"}}, rows[0])
+	assert.Equal(t, 2, rows[1].row)
+	assert.Contains(t, rows[1].err, "failed to decode row")
+}
+
+func TestParseImportRowsTooMany(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i <= types.MaxImportRows; i++ {
+		b.WriteString("{}\n")
+	}
+
+	_, err := parseImportRows(types.ImportFormatJsonl, strings.NewReader(b.String()))
+	assert.Equal(t, errTooManyImportRows, err)
+}
+
+func TestValidateImportRows(t *testing.T) {
+	salt, err := generateRandomBytes(DefaultArgon2IdOpts.SaltLength)
+	if !assert.NoError(t, err) {
+		return
+	}
+	hash := hashSecret(salt, "secret", DefaultArgon2IdOpts)
+
+	rows := []importRow{
+		{row: 1, req: types.CreateUserRequest{Email: "john@example.com", Password: hash, FullName: "John Doe"}},
+		{row: 2, req: types.CreateUserRequest{Email: "not-an-email", Password: hash, FullName: "Jane Doe"}},
+		{row: 3, req: types.CreateUserRequest{Email: "john@example.com", Password: hash, FullName: "John Doe"}},
+		{row: 4, req: types.CreateUserRequest{Email: "jane@example.com", Password: "secret", FullName: "Jane Doe"}},
+		{row: 5, err: "expected 3 fields, got 2"},
+	}
+
+	valid, failed := validateImportRows(validator.New(), rows, true)
+
+	assert.Equal(t, rows[:1], valid)
+
+	if !assert.Len(t, failed, 4) {
+		return
+	}
+	assert.Equal(t, 2, failed[0].Row)
+	assert.Contains(t, failed[0].Error, "failed to validate the row")
+	assert.Equal(t, types.ImportUserError{Row: 3, Email: "john@example.com", Error: types.ErrorDuplicateImportRow}, failed[1])
+	assert.Equal(t, types.ImportUserError{Row: 4, Email: "jane@example.com", Error: types.ErrorInvalidPasswordHash}, failed[2])
+	assert.Equal(t, types.ImportUserError{Row: 5, Error: "expected 3 fields, got 2"}, failed[3])
+
+	valid, failed = validateImportRows(validator.New(), rows[3:4], false)
+	assert.Len(t, valid, 1)
+	assert.Empty(t, failed)
+}
+
+func TestHashImportPasswords(t *testing.T) {
+	opts := Argon2IdOpts{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+	rows := []importRow{
+		{req: types.CreateUserRequest{Password: "a"}},
+		{req: types.CreateUserRequest{Password: "b"}},
+		{req: types.CreateUserRequest{Password: "c"}},
+		{req: types.CreateUserRequest{Password: "d"}},
+		{req: types.CreateUserRequest{Password: "e"}},
+	}
+
+	hashes, err := hashImportPasswords(context.Background(), opts, rows)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	if !assert.Len(t, hashes, len(rows)) {
+		return
+	}
+	for i, h := range hashes {
+		match, err := compareSecretAndHash(rows[i].req.Password, h)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.True(t, match)
+	}
+}
@@ -0,0 +1,585 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/mailing"
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	DefaultInvitationTtl    = 7 * 24 * time.Hour
+	invitationsSortBy       = "invitation_created_at"
+	invitationAuthenticator = "invitation"
+)
+
+var (
+	errInvalidInvitation  = errors.New("invitation is invalid")
+	errEmailAlreadyExists = errors.New("email already exists")
+)
+
+type InvitationOpts struct {
+	URL string
+}
+
+// InviteUser creates an invitation, and revokes open invitations for the same email. The token is only returned
+// here, and mailed if a mailer and an invitation url are configured.
+func InviteUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, mailer mailing.Mailer, oidcProviders *OidcProviders, opts InvitationOpts, sub string, req types.InviteUserRequest) (rsp types.InviteUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to invite user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	if oidcProviders.RequiresFederatedLogin(req.Email) {
+		err = errors.New("failed as the email domain is routed to an identity provider")
+
+		rsp.Error = types.ErrorFederatedLoginRequired
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	ttl := DefaultInvitationTtl
+	if req.ExpiresInSeconds != 0 {
+		ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	var i types.InvitationModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		_, err = persistence.GetUserByEmail(ctx, m, tx, req.Email)
+		switch {
+		case err == nil:
+			err = errors.Wrap(errEmailAlreadyExists, "failed as a user with the email exists")
+
+			return
+		case errors.Cause(err) != sql.ErrNoRows:
+			err = errors.Wrap(err, "failed to get user")
+
+			return
+		}
+
+		open, err := persistence.SelectOpenInvitationsByEmailForUpdate(ctx, m, tx, req.Email)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get open invitations")
+
+			return
+		}
+
+		for _, o := range open {
+			_, err = persistence.RevokeInvitationById(ctx, m, tx, o.ID, sub)
+			if err != nil {
+				err = errors.Wrap(err, "failed to revoke invitation")
+
+				return
+			}
+
+			err = recordAuditEvent(ctx, m, tx, types.AuditActionInvitationRevoked, o.ID, map[string]types.AuditChange{
+				"status": {Before: invitationStatus(o), After: types.InvitationStatusRevoked},
+			})
+			if err != nil {
+				err = errors.Wrap(err, "failed to record audit event")
+
+				return
+			}
+		}
+
+		i, err = persistence.InsertInvitation(ctx, m, tx, types.InvitationModel{
+			Email:     req.Email,
+			UserGroup: req.UserGroup,
+			InvitedBy: sub,
+			TokenHash: tokenHash,
+			ExpiresAt: time.Now().Add(ttl),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert invitation")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionInvitationCreated, i.ID, map[string]types.AuditChange{
+			"email":      {After: i.Email},
+			"user_group": {After: i.UserGroup},
+			"expires_at": {After: i.ExpiresAt.UTC()},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == errEmailAlreadyExists:
+		rsp.Error = types.ErrorEmailAlreadyExists
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case persistence.IsUniqueViolation(err):
+		rsp.Error = types.ErrorInvitationAlreadyExists
+		statusCode = http.StatusConflict
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.Invitation = toInvitation(i)
+	rsp.Token = token
+
+	if mailer == nil || opts.URL == "" {
+		return
+	}
+
+	link, err := url.Parse(opts.URL)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse invitation url")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	q := link.Query()
+	q.Set(types.QueryToken, token)
+	link.RawQuery = q.Encode()
+
+	err = mailer.Send(ctx, mailing.Message{
+		To:      i.Email,
+		Subject: "You're invited",
+		Body: fmt.Sprintf("You were invited to create an account. Open the link below to choose your password, it expires on %s.\r\n\r\n%s\r\n",
+			i.ExpiresAt.UTC().Format(time.RFC1123), link.String()),
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to send invitation")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	return
+}
+
+func ListInvitations(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ListInvitationsRequest) (rsp types.ListInvitationsResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_invitations_count", len(rsp.Invitations),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list invitations")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	q := types.InvitationsQuery{
+		Limit:  req.PageSize,
+		Status: req.Status,
+		Email:  req.Email,
+	}
+	if q.Limit == 0 {
+		q.Limit = types.DefaultPageSize
+	}
+
+	if req.Cursor != "" {
+		var c cursor
+		c, err = decodeCursor(req.Cursor)
+		if err == nil && c.SortBy != invitationsSortBy {
+			err = errors.New("failed as cursor does not match the requested sort")
+		}
+		if err == nil {
+			err = v.Var(c.ID, "uuid")
+		}
+		if err == nil {
+			var createdAt time.Time
+			createdAt, err = time.Parse(time.RFC3339Nano, c.Value)
+			q.BeforeCreatedAt, q.BeforeID = &createdAt, c.ID
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to decode cursor")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+	}
+
+	pageSize := q.Limit
+	q.Limit = pageSize + 1
+
+	is, err := persistence.SelectInvitations(ctx, m, db, q)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get invitations from database")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	if len(is) > pageSize {
+		is = is[:pageSize]
+
+		last := is[len(is)-1]
+		rsp.NextCursor, err = encodeCursor(cursor{
+			SortBy:    invitationsSortBy,
+			SortOrder: types.SortOrderDesc,
+			Value:     last.CreatedAt.UTC().Format(time.RFC3339Nano),
+			ID:        last.ID,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to encode cursor")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	for _, i := range is {
+		rsp.Invitations = append(rsp.Invitations, *toInvitation(i))
+	}
+
+	return
+}
+
+func RevokeInvitation(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, sub string, req types.RevokeInvitationRequest) (rsp types.RevokeInvitationResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to revoke invitation")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var i types.InvitationModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		i, err = persistence.GetInvitationByIdForUpdate(ctx, m, tx, req.ID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get invitation")
+
+			return
+		}
+
+		if i.AcceptedAt != nil || i.RevokedAt != nil {
+			err = errors.Wrap(errInvalidInvitation, "failed as invitation was accepted or revoked before")
+
+			return
+		}
+
+		before := invitationStatus(i)
+
+		i, err = persistence.RevokeInvitationById(ctx, m, tx, i.ID, sub)
+		if err != nil {
+			err = errors.Wrap(err, "failed to revoke invitation")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionInvitationRevoked, i.ID, map[string]types.AuditChange{
+			"status": {Before: before, After: types.InvitationStatusRevoked},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		rsp.Error = types.ErrorInvitationDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	case errors.Cause(err) == errInvalidInvitation:
+		rsp.Error = types.ErrorInvitationNotPending
+		statusCode = http.StatusConflict
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.Invitation = toInvitation(i)
+
+	return
+}
+
+// AcceptInvitation creates the invited user with the chosen password and fullname, and signs it in.
+func AcceptInvitation(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, hmacSecret string, argonOpts Argon2IdOpts, req types.AcceptInvitationRequest) (rsp types.AcceptInvitationResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to accept invitation")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	password, err := hashPassword(argonOpts, req.Password)
+	if err != nil {
+		err = errors.Wrap(err, "failed to hash password")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		i, err := persistence.GetInvitationByTokenHashForUpdate(ctx, m, tx, hashToken(req.Token))
+		if err != nil {
+			err = errors.Wrap(err, "failed to get invitation")
+
+			return
+		}
+
+		switch {
+		case i.AcceptedAt != nil:
+			err = errors.Wrap(errInvalidInvitation, "failed as invitation was accepted before")
+		case i.RevokedAt != nil:
+			err = errors.Wrap(errInvalidInvitation, "failed as invitation was revoked")
+		case !i.ExpiresAt.After(time.Now()):
+			err = errors.Wrap(errInvalidInvitation, "failed as invitation expired")
+		}
+		if err != nil {
+			return
+		}
+
+		u, err = persistence.InsertUser(ctx, m, tx, types.UserModel{
+			Email:     i.Email,
+			Password:  password,
+			FullName:  req.FullName,
+			UserGroup: i.UserGroup,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert user into database")
+
+			return
+		}
+
+		_, err = persistence.AcceptInvitationById(ctx, m, tx, i.ID, u.ID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to accept invitation")
+
+			return
+		}
+
+		ctx := ctxutil.WithSubject(ctx, u.ID)
+
+		diff := diffUsers(nil, &u)
+		diff["invited_by"] = types.AuditChange{After: i.InvitedBy}
+		diff["invitation_id"] = types.AuditChange{After: i.ID}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserCreated, u.ID, diff)
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionInvitationAccepted, i.ID, map[string]types.AuditChange{
+			"accepted_user_id": {After: u.ID},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserAuthenticated, u.ID, map[string]types.AuditChange{
+			"authenticator": {After: invitationAuthenticator},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		_, err = recordLoginAttempt(ctx, m, tx, u.ID, u.Email, types.LoginMethodInvitation, "")
+		if err != nil {
+			err = errors.Wrap(err, "failed to record login attempt")
+
+			return
+		}
+
+		err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserCreated, u)
+		if err != nil {
+			err = errors.Wrap(err, "failed to enqueue user event")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows, errors.Cause(err) == errInvalidInvitation:
+		rsp.Error = types.ErrorInvalidInvitation
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case persistence.IsUniqueViolation(err):
+		rsp.Error = types.ErrorEmailAlreadyExists
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.AccessToken, err = GenerateAccessToken(hmacSecret, u.UserGroup, u.ID, u.TokenEpoch)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate access token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	return
+}
+
+func invitationStatus(i types.InvitationModel) string {
+	switch {
+	case i.AcceptedAt != nil:
+		return types.InvitationStatusAccepted
+	case i.RevokedAt != nil:
+		return types.InvitationStatusRevoked
+	case !i.ExpiresAt.After(time.Now()):
+		return types.InvitationStatusExpired
+	default:
+		return types.InvitationStatusPending
+	}
+}
+
+func toInvitation(i types.InvitationModel) *types.Invitation {
+	return &types.Invitation{
+		ID:             i.ID,
+		Email:          i.Email,
+		UserGroup:      i.UserGroup,
+		Status:         invitationStatus(i),
+		InvitedBy:      i.InvitedBy,
+		CreatedAt:      i.CreatedAt,
+		ExpiresAt:      i.ExpiresAt,
+		AcceptedAt:     i.AcceptedAt,
+		AcceptedUserID: i.AcceptedUserID,
+		RevokedAt:      i.RevokedAt,
+		RevokedBy:      i.RevokedBy,
+	}
+}
@@ -0,0 +1,31 @@
+// +build unit
+
+package business
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestInvitationStatus(t *testing.T) {
+	now := time.Now()
+	future := now.Add(time.Hour)
+	past := now.Add(-time.Hour)
+
+	for _, tc := range []struct {
+		name       string
+		invitation types.InvitationModel
+		status     string
+	}{
+		{name: "pending", invitation: types.InvitationModel{ExpiresAt: future}, status: types.InvitationStatusPending},
+		{name: "expired", invitation: types.InvitationModel{ExpiresAt: past}, status: types.InvitationStatusExpired},
+		{name: "accepted", invitation: types.InvitationModel{ExpiresAt: past, AcceptedAt: &now}, status: types.InvitationStatusAccepted},
+		{name: "revoked", invitation: types.InvitationModel{ExpiresAt: past, RevokedAt: &now}, status: types.InvitationStatusRevoked},
+	} {
+		assert.Equal(t, tc.status, invitationStatus(tc.invitation), tc.name)
+	}
+}
@@ -36,12 +36,18 @@ func GetJwtClaims(hmacSecret string, accessToken string) (c map[string]interface
 	return
 }
 
-func GenerateAccessToken(hmacSecret string, group string, userID string) (t string, err error) {
+// GenerateAccessToken issues a token that is valid until it expires, or until the token epoch of the user is bumped.
+// Tokens are only issued once a user authenticated, so the auth_time claim is the time of issue.
+func GenerateAccessToken(hmacSecret string, group string, userID string, tokenEpoch int) (t string, err error) {
+	now := time.Now()
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		types.ClaimIat:       time.Now().Unix(),
-		types.ClaimExp:       time.Now().Add(24 * time.Hour).Unix(),
-		types.ClaimUserGroup: group,
-		types.ClaimSub:       userID,
+		types.ClaimIat:        now.Unix(),
+		types.ClaimAuthTime:   now.Unix(),
+		types.ClaimExp:        now.Add(24 * time.Hour).Unix(),
+		types.ClaimUserGroup:  group,
+		types.ClaimSub:        userID,
+		types.ClaimTokenEpoch: tokenEpoch,
 	})
 
 	t, err = token.SignedString([]byte(hmacSecret))
@@ -0,0 +1,396 @@
+package business
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	ldapDefaultUserFilter     = "(&(objectClass=person)(mail=%s))"
+	ldapDefaultNameAttribute  = "cn"
+	ldapDefaultGroupAttribute = "memberOf"
+	ldapDefaultPoolSize       = 4
+	ldapDefaultTimeout        = 5 * time.Second
+)
+
+// LdapAuthenticator signs in users of an LDAP directory. It searches the user by email with a service account, and
+// binds as the found entry with the password.
+type LdapAuthenticator struct {
+	cfg                  types.LdapConfig
+	allowedSubjectSuffix string
+	argonOpts            Argon2IdOpts
+	pool                 *ldapPool
+}
+
+type ldapIdentity struct {
+	DN        string
+	Subject   string
+	Email     string
+	FullName  string
+	UserGroup string
+}
+
+func LoadLdapConfig(path string) (cfg types.LdapConfig, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrap(err, "failed to read ldap config file")
+
+		return
+	}
+
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode ldap config file")
+
+		return
+	}
+
+	return
+}
+
+func NewLdapAuthenticator(v *validator.Validate, cfg types.LdapConfig, allowedSubjectSuffix string, argonOpts Argon2IdOpts) (a *LdapAuthenticator, err error) {
+	err = v.Struct(&cfg)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate ldap config")
+
+		return
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse ldap url")
+
+		return
+	}
+
+	if u.Scheme != "ldap" && u.Scheme != "ldaps" {
+		err = errors.Errorf("failed as ldap url scheme %s isn't ldap or ldaps", u.Scheme)
+
+		return
+	}
+
+	if u.Scheme == "ldaps" && cfg.StartTLS {
+		err = errors.New("failed as start_tls can't be combined with ldaps")
+
+		return
+	}
+
+	tlsConfig := &tls.Config{ServerName: u.Hostname()}
+	if cfg.CACertFile != "" {
+		var b []byte
+		b, err = ioutil.ReadFile(cfg.CACertFile)
+		if err != nil {
+			err = errors.Wrap(err, "failed to read ldap ca cert file")
+
+			return
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(b) {
+			err = errors.New("failed as ldap ca cert file contains no certificate")
+
+			return
+		}
+	}
+
+	if cfg.UserFilter == "" {
+		cfg.UserFilter = ldapDefaultUserFilter
+	}
+	if strings.Count(cfg.UserFilter, "%s") != 1 {
+		err = errors.New("failed as ldap user filter has to contain exactly one %s")
+
+		return
+	}
+
+	if cfg.NameAttribute == "" {
+		cfg.NameAttribute = ldapDefaultNameAttribute
+	}
+	if cfg.GroupAttribute == "" {
+		cfg.GroupAttribute = ldapDefaultGroupAttribute
+	}
+
+	mapping := map[string]string{}
+	for dn, group := range cfg.GroupMapping {
+		mapping[strings.ToLower(dn)] = group
+	}
+	cfg.GroupMapping = mapping
+
+	poolSize := cfg.PoolSize
+	if poolSize == 0 {
+		poolSize = ldapDefaultPoolSize
+	}
+
+	timeout := time.Duration(cfg.TimeoutSeconds) * time.Second
+	if timeout == 0 {
+		timeout = ldapDefaultTimeout
+	}
+
+	a = &LdapAuthenticator{
+		cfg:                  cfg,
+		allowedSubjectSuffix: allowedSubjectSuffix,
+		argonOpts:            argonOpts,
+		pool: &ldapPool{
+			url:       cfg.URL,
+			startTLS:  cfg.StartTLS,
+			tlsConfig: tlsConfig,
+			timeout:   timeout,
+			conns:     make(chan *ldap.Conn, poolSize),
+		},
+	}
+
+	return
+}
+
+func (a *LdapAuthenticator) Name() string {
+	return types.AuthenticatorLdap
+}
+
+func (a *LdapAuthenticator) Close() {
+	a.pool.close()
+}
+
+// Authenticate links the directory entry to a user on the first sign in like an OIDC identity, with the entry DN as
+// subject, unless a subject attribute is configured. The user group follows the group mapping on every sign in.
+func (a *LdapAuthenticator) Authenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, email string, password string) (u types.UserModel, err error) {
+	li, err := a.verify(ctx, email, password)
+	if err != nil {
+		err = errors.Wrap(err, "failed to verify credentials with ldap")
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = signInExternalIdentity(ctx, m, tx, externalIdentity{
+			Provider:  types.AuthenticatorLdap,
+			Subject:   li.Subject,
+			Email:     li.Email,
+			FullName:  li.FullName,
+			UserGroup: li.UserGroup,
+			// the directory is the operator's own source of users
+			LinkExisting: true,
+		}, a.allowedSubjectSuffix, a.argonOpts)
+
+		return
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to sign in ldap identity")
+
+		return
+	}
+
+	return
+}
+
+func (a *LdapAuthenticator) verify(ctx context.Context, email string, password string) (li ldapIdentity, err error) {
+	if password == "" {
+		err = errors.Wrap(errInvalidCredentials, "failed as password is empty")
+
+		return
+	}
+
+	c, err := a.pool.get()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get ldap connection")
+
+		return
+	}
+	defer func() {
+		a.pool.put(ctx, c)
+	}()
+
+	err = c.Bind(a.cfg.BindDN, a.cfg.BindPassword)
+	if err != nil {
+		err = errors.Wrap(err, "failed to bind service account")
+
+		c.Close()
+
+		return
+	}
+
+	attributes := []string{a.cfg.NameAttribute, a.cfg.GroupAttribute}
+	if a.cfg.SubjectAttribute != "" {
+		attributes = append(attributes, a.cfg.SubjectAttribute)
+	}
+
+	sr, err := c.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN,
+		ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases,
+		2,
+		int(a.pool.timeout.Seconds()),
+		false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(email)),
+		attributes,
+		nil,
+	))
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded):
+		err = errors.Errorf("failed as more than one ldap entry matches %s", email)
+
+		return
+	case err != nil:
+		err = errors.Wrap(err, "failed to search ldap user")
+
+		c.Close()
+
+		return
+	case len(sr.Entries) == 0:
+		err = errors.Wrap(errInvalidCredentials, "failed as ldap user doesn't exist")
+
+		return
+	case len(sr.Entries) > 1:
+		err = errors.Errorf("failed as more than one ldap entry matches %s", email)
+
+		return
+	}
+
+	e := sr.Entries[0]
+
+	err = c.Bind(e.DN, password)
+	switch {
+	case ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials):
+		err = errors.Wrap(errInvalidCredentials, "failed as ldap rejected the password")
+
+		return
+	case err != nil:
+		err = errors.Wrap(err, "failed to bind ldap user")
+
+		c.Close()
+
+		return
+	}
+
+	li = ldapIdentity{
+		DN:       e.DN,
+		Subject:  e.DN,
+		Email:    email,
+		FullName: e.GetEqualFoldAttributeValue(a.cfg.NameAttribute),
+	}
+
+	if a.cfg.SubjectAttribute != "" {
+		li.Subject = e.GetEqualFoldAttributeValue(a.cfg.SubjectAttribute)
+		if li.Subject == "" {
+			err = errors.Errorf("failed as ldap entry %s has no %s", e.DN, a.cfg.SubjectAttribute)
+
+			return
+		}
+	}
+
+	for _, dn := range e.GetEqualFoldAttributeValues(a.cfg.GroupAttribute) {
+		switch a.cfg.GroupMapping[strings.ToLower(dn)] {
+		case types.UserGroupAdmin:
+			li.UserGroup = types.UserGroupAdmin
+		case types.UserGroupUser:
+			if li.UserGroup == "" {
+				li.UserGroup = types.UserGroupUser
+			}
+		}
+	}
+
+	if li.UserGroup == "" {
+		li.UserGroup = a.cfg.DefaultUserGroup
+	}
+
+	if li.UserGroup == "" {
+		err = errors.Wrapf(errInvalidCredentials, "failed as ldap entry %s is in no mapped group", e.DN)
+
+		return
+	}
+
+	return
+}
+
+// ldapPool keeps up to cap(conns) idle connections. Connections are bound as the service account before each use,
+// as a user bind changes the identity of the connection.
+type ldapPool struct {
+	url       string
+	startTLS  bool
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	conns     chan *ldap.Conn
+}
+
+func (p *ldapPool) get() (c *ldap.Conn, err error) {
+	c = p.idle()
+	if c != nil {
+		return
+	}
+
+	c, err = ldap.DialURL(p.url, ldap.DialWithDialer(&net.Dialer{Timeout: p.timeout}), ldap.DialWithTLSConfig(p.tlsConfig))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to dial %s", p.url)
+
+		return
+	}
+
+	c.SetTimeout(p.timeout)
+
+	if p.startTLS {
+		err = c.StartTLS(p.tlsConfig)
+		if err != nil {
+			err = errors.Wrap(err, "failed to start tls")
+
+			c.Close()
+
+			return
+		}
+	}
+
+	return
+}
+
+func (p *ldapPool) idle() *ldap.Conn {
+	for {
+		select {
+		case c := <-p.conns:
+			if !c.IsClosing() {
+				return c
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *ldapPool) put(ctx context.Context, c *ldap.Conn) {
+	if c.IsClosing() {
+		return
+	}
+
+	select {
+	case p.conns <- c:
+	default:
+		ctxutil.GetContextLogger(ctx).Debug("closing ldap connection as the pool is full")
+
+		c.Close()
+	}
+}
+
+func (p *ldapPool) close() {
+	for {
+		select {
+		case c := <-p.conns:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
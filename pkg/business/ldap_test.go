@@ -0,0 +1,172 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+	"github.com/ppwfx/user-svc/pkg/utils/ldaputil"
+)
+
+func newLdapTestServer(t *testing.T, implicitTLS bool) (s *ldaputil.MockServer, cfg types.LdapConfig, ok bool) {
+	s, err := ldaputil.NewMockServer(implicitTLS)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	f, err := ioutil.TempFile("", "ldap-ca-*.pem")
+	if !assert.NoError(t, err) {
+		s.Close()
+
+		return
+	}
+	defer f.Close()
+
+	_, err = f.Write(s.CACert)
+	if !assert.NoError(t, err) {
+		s.Close()
+
+		return
+	}
+
+	s.SetEntry(ldaputil.MockEntry{DN: "cn=svc,dc=example,dc=com", Password: "svc-password"})
+	s.SetEntry(ldaputil.MockEntry{DN: "uid=john,ou=people,dc=example,dc=com", Password: "john-password", Attributes: map[string][]string{
+		"objectClass": {"person"},
+		"mail":        {"john@example.com"},
+		"cn":          {"John Doe"},
+		"entryUUID":   {"6f1f6c48-3c1b-4c2b-8d1c-6c0b8f0f4a01"},
+		"memberOf":    {"cn=staff,ou=groups,dc=example,dc=com", "CN=Admins,ou=groups,dc=example,dc=com"},
+	}})
+	s.SetEntry(ldaputil.MockEntry{DN: "uid=jane,ou=people,dc=example,dc=com", Password: "jane-password", Attributes: map[string][]string{
+		"objectClass": {"person"},
+		"mail":        {"jane@example.com"},
+		"cn":          {"Jane Doe"},
+	}})
+
+	cfg = types.LdapConfig{
+		URL:          s.URL,
+		StartTLS:     !implicitTLS,
+		CACertFile:   f.Name(),
+		BindDN:       "cn=svc,dc=example,dc=com",
+		BindPassword: "svc-password",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		GroupMapping: map[string]string{
+			"cn=staff,ou=groups,dc=example,dc=com":  types.UserGroupUser,
+			"cn=admins,ou=groups,dc=example,dc=com": types.UserGroupAdmin,
+		},
+		PoolSize: 1,
+	}
+	ok = true
+
+	return
+}
+
+func TestLdapAuthenticatorVerify(t *testing.T) {
+	ctx := ctxutil.WithContextLogger(context.Background(), zap.NewNop().Sugar())
+
+	for _, implicitTLS := range []bool{false, true} {
+		s, cfg, ok := newLdapTestServer(t, implicitTLS)
+		if !ok {
+			return
+		}
+		defer os.Remove(cfg.CACertFile)
+		defer s.Close()
+
+		a, err := NewLdapAuthenticator(validator.New(), cfg, "@test.com", DefaultArgon2IdOpts)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer a.Close()
+
+		li, err := a.verify(ctx, "John@example.com", "john-password")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, ldapIdentity{DN: "uid=john,ou=people,dc=example,dc=com", Subject: "uid=john,ou=people,dc=example,dc=com", Email: "John@example.com", FullName: "John Doe", UserGroup: types.UserGroupAdmin}, li)
+
+		for _, tc := range []struct {
+			email    string
+			password string
+		}{
+			{email: "john@example.com", password: "wrong"},
+			{email: "john@example.com", password: ""},
+			{email: "unknown@example.com", password: "john-password"},
+			{email: "*", password: "john-password"},
+			{email: "jane@example.com", password: "jane-password"},
+		} {
+			_, err = a.verify(ctx, tc.email, tc.password)
+			assert.Equal(t, errInvalidCredentials, errors.Cause(err), tc.email)
+		}
+
+		assert.Equal(t, 1, s.Connections(), "connections are reused")
+
+		cfg.DefaultUserGroup = types.UserGroupUser
+		cfg.SubjectAttribute = "entryUUID"
+		cfg.PoolSize = 0
+
+		a, err = NewLdapAuthenticator(validator.New(), cfg, "@test.com", DefaultArgon2IdOpts)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer a.Close()
+
+		li, err = a.verify(ctx, "john@example.com", "john-password")
+		if assert.NoError(t, err) {
+			assert.Equal(t, "6f1f6c48-3c1b-4c2b-8d1c-6c0b8f0f4a01", li.Subject)
+		}
+
+		_, err = a.verify(ctx, "jane@example.com", "jane-password")
+		if assert.Error(t, err, "jane has no subject attribute") {
+			assert.NotEqual(t, errInvalidCredentials, errors.Cause(err))
+		}
+	}
+}
+
+func TestNewLdapAuthenticator(t *testing.T) {
+	s, cfg, ok := newLdapTestServer(t, false)
+	if !ok {
+		return
+	}
+	defer os.Remove(cfg.CACertFile)
+	defer s.Close()
+
+	ctx := ctxutil.WithContextLogger(context.Background(), zap.NewNop().Sugar())
+
+	untrusted := cfg
+	untrusted.CACertFile = ""
+
+	a, err := NewLdapAuthenticator(validator.New(), untrusted, "@test.com", DefaultArgon2IdOpts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer a.Close()
+
+	_, err = a.verify(ctx, "john@example.com", "john-password")
+	if assert.Error(t, err, "the server certificate isn't trusted") {
+		assert.NotEqual(t, errInvalidCredentials, errors.Cause(err))
+	}
+
+	for name, modify := range map[string]func(cfg *types.LdapConfig){
+		"scheme":        func(cfg *types.LdapConfig) { cfg.URL = "http://127.0.0.1" },
+		"starttls":      func(cfg *types.LdapConfig) { cfg.URL = "ldaps://127.0.0.1" },
+		"filter":        func(cfg *types.LdapConfig) { cfg.UserFilter = "(mail=*)" },
+		"group mapping": func(cfg *types.LdapConfig) { cfg.GroupMapping = map[string]string{"cn=root": "root"} },
+		"base dn":       func(cfg *types.LdapConfig) { cfg.BaseDN = "" },
+	} {
+		invalid := cfg
+		modify(&invalid)
+
+		_, err = NewLdapAuthenticator(validator.New(), invalid, "@test.com", DefaultArgon2IdOpts)
+		assert.Error(t, err, name)
+	}
+}
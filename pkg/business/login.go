@@ -0,0 +1,250 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/mailing"
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const loginAttemptsSortBy = "id"
+
+// recordLoginAttempt records an authentication attempt with the ip and user agent of the request, an empty
+// failureReason records a success. For a success it reports whether the user signed in from the device before.
+func recordLoginAttempt(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, userID string, email string, method string, failureReason string) (d types.LoginDeviceModel, err error) {
+	a := types.LoginAttemptModel{
+		Email:         email,
+		Method:        method,
+		IP:            ctxutil.GetRemoteIp(ctx),
+		UserAgent:     ctxutil.GetUserAgent(ctx),
+		Outcome:       types.LoginOutcomeSuccess,
+		FailureReason: failureReason,
+	}
+	if userID != "" {
+		a.UserID = &userID
+	}
+	if failureReason != "" {
+		a.Outcome = types.LoginOutcomeFailure
+	}
+
+	if a.UserID != nil && a.Outcome == types.LoginOutcomeSuccess {
+		d, err = persistence.GetLoginDevice(ctx, m, db, userID, a.IP, a.UserAgent)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get login device")
+
+			return
+		}
+	}
+
+	_, err = persistence.InsertLoginAttempt(ctx, m, db, a)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert login attempt")
+
+		return
+	}
+
+	return
+}
+
+// recordFailedLoginAttempt records a failed authentication attempt outside of the failed transaction,
+// it only logs if that fails, as the attempt is rejected anyway.
+func recordFailedLoginAttempt(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, userID string, email string, method string, failureReason string) {
+	_, err := recordLoginAttempt(ctx, m, db, userID, email, method, failureReason)
+	if err != nil {
+		ctxutil.GetContextLogger(ctx).Warn(errors.Wrap(err, "failed to record failed login attempt"))
+	}
+}
+
+// notifyLoginDevice emails the user if it signed in from a device, and ip it didn't sign in from before.
+// The first sign in of a user isn't notified, the mail is sent after the response, and a failure to send only logs,
+// as the sign in already succeeded.
+func notifyLoginDevice(ctx context.Context, mailer mailing.Mailer, u types.UserModel, d types.LoginDeviceModel) {
+	if mailer == nil || !d.HasLogins || d.IsKnown {
+		return
+	}
+
+	sendMailInBackground(ctx, mailer, mailing.Message{
+		To:      u.Email,
+		Subject: "New sign in to your account",
+		Body: fmt.Sprintf("Your account was signed in to from a new device.\r\n\r\nTime: %s\r\nIP address: %s\r\nDevice: %s\r\n\r\nIf this wasn't you, change your password right away.\r\n",
+			time.Now().UTC().Format(time.RFC1123), ctxutil.GetRemoteIp(ctx), ctxutil.GetUserAgent(ctx)),
+	}, "new login device notification")
+}
+
+// ListLoginHistory lists the authentication attempts of the signed in user. Admins can list the attempts
+// of any user, or of an email that doesn't belong to a user, and list all attempts if they don't filter.
+func ListLoginHistory(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, sub string, group string, req types.ListLoginHistoryRequest) (rsp types.ListLoginHistoryResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_attempts_count", len(rsp.Attempts),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list login history")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	q := types.LoginAttemptsQuery{
+		Limit:   req.PageSize,
+		UserID:  req.UserID,
+		Email:   req.Email,
+		Outcome: req.Outcome,
+	}
+	if q.Limit == 0 {
+		q.Limit = types.DefaultPageSize
+	}
+
+	if group != types.UserGroupAdmin {
+		if (req.UserID != "" && req.UserID != sub) || req.Email != "" {
+			err = errors.New("failed as users can only list their own login history")
+
+			rsp.Error = types.ErrorUnauthorized
+			statusCode = http.StatusForbidden
+
+			return
+		}
+
+		q.UserID = sub
+	}
+
+	if req.Cursor != "" {
+		var c cursor
+		c, err = decodeCursor(req.Cursor)
+		if err == nil && c.SortBy != loginAttemptsSortBy {
+			err = errors.New("failed as cursor does not match the requested sort")
+		}
+		if err == nil {
+			q.BeforeID, err = strconv.ParseInt(c.Value, 10, 64)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to decode cursor")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+	}
+
+	pageSize := q.Limit
+	q.Limit = pageSize + 1
+
+	as, err := persistence.SelectLoginAttempts(ctx, m, db, q)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get login attempts from database")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	if len(as) > pageSize {
+		as = as[:pageSize]
+
+		rsp.NextCursor, err = encodeCursor(cursor{
+			SortBy:    loginAttemptsSortBy,
+			SortOrder: types.SortOrderDesc,
+			Value:     strconv.FormatInt(as[len(as)-1].ID, 10),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to encode next cursor")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	rsp.Attempts = []types.LoginAttempt{}
+	for _, a := range as {
+		rsp.Attempts = append(rsp.Attempts, toLoginAttempt(a))
+	}
+
+	return
+}
+
+// applyLastLogins sets the last successful login of each of the listed users.
+func applyLastLogins(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, us []types.ListUser) (err error) {
+	if len(us) == 0 {
+		return
+	}
+
+	var ids []string
+	for _, u := range us {
+		ids = append(ids, u.ID)
+	}
+
+	ls, err := persistence.SelectLastLoginsByUserIds(ctx, m, db, ids)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select last logins")
+
+		return
+	}
+
+	lastLogins := map[string]types.LastLoginModel{}
+	for _, l := range ls {
+		lastLogins[l.UserID] = l
+	}
+
+	for i := range us {
+		l, ok := lastLogins[us[i].ID]
+		if !ok {
+			continue
+		}
+
+		occurredAt := l.OccurredAt
+		us[i].LastLoginAt = &occurredAt
+		us[i].LastLoginIP = l.IP
+	}
+
+	return
+}
+
+func toLoginAttempt(a types.LoginAttemptModel) types.LoginAttempt {
+	la := types.LoginAttempt{
+		ID:            a.ID,
+		OccurredAt:    a.OccurredAt,
+		Email:         a.Email,
+		Method:        a.Method,
+		IP:            a.IP,
+		UserAgent:     a.UserAgent,
+		Outcome:       a.Outcome,
+		FailureReason: a.FailureReason,
+	}
+	if a.UserID != nil {
+		la.UserID = *a.UserID
+	}
+
+	return la
+}
@@ -0,0 +1,50 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+	"github.com/ppwfx/user-svc/pkg/utils/mailutil"
+)
+
+func TestNotifyLoginDevice(t *testing.T) {
+	ctx := ctxutil.WithUserAgent(ctxutil.WithRemoteIp(ctxutil.WithContextLogger(context.Background(), zap.NewNop().Sugar()), "203.0.113.7"), "other-device/1.0")
+	u := types.UserModel{Email: "john@example.com"}
+
+	for _, tc := range []struct {
+		name     string
+		device   types.LoginDeviceModel
+		expected int
+	}{
+		{name: "first sign in", device: types.LoginDeviceModel{HasLogins: false, IsKnown: false}, expected: 0},
+		{name: "known device", device: types.LoginDeviceModel{HasLogins: true, IsKnown: true}, expected: 0},
+		{name: "new device", device: types.LoginDeviceModel{HasLogins: true, IsKnown: false}, expected: 1},
+	} {
+		mailer := &mailutil.MockMailer{}
+
+		notifyLoginDevice(ctx, mailer, u, tc.device)
+
+		msgs := mailer.WaitForMessages(u.Email, 1, 100*time.Millisecond)
+		if assert.Len(t, msgs, tc.expected, tc.name) && tc.expected > 0 {
+			assert.Contains(t, msgs[0].Body, "203.0.113.7")
+			assert.Contains(t, msgs[0].Body, "other-device/1.0")
+		}
+	}
+
+	notifyLoginDevice(ctx, nil, u, types.LoginDeviceModel{HasLogins: true})
+}
+
+func TestToLoginAttempt(t *testing.T) {
+	userID := "a3c1a0a4-3f0e-4a53-9a3b-7c1f4f2b8f4e"
+
+	assert.Equal(t, userID, toLoginAttempt(types.LoginAttemptModel{UserID: &userID}).UserID)
+	assert.Empty(t, toLoginAttempt(types.LoginAttemptModel{Email: "unknown@example.com"}).UserID)
+}
@@ -0,0 +1,373 @@
+package business
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/mailing"
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	magicLinkAuthenticator = "magic_link"
+	backgroundMailTimeout  = 30 * time.Second
+)
+
+var (
+	errMagicLinkRateLimited = errors.New("too many magic link requests")
+	errInvalidMagicLink     = errors.New("magic link is invalid")
+)
+
+type MagicLinkOpts struct {
+	URL             string
+	Ttl             time.Duration
+	RateLimit       int
+	RateLimitWindow time.Duration
+}
+
+var DefaultMagicLinkOpts = MagicLinkOpts{
+	Ttl:             15 * time.Minute,
+	RateLimit:       5,
+	RateLimitWindow: time.Hour,
+}
+
+// sendMailInBackground sends msg without delaying the response, a failure is only logged, so that neither the
+// latency, nor the status code of the response depend on whether, and how fast a mail is sent.
+func sendMailInBackground(ctx context.Context, mailer mailing.Mailer, msg mailing.Message, what string) {
+	l := ctxutil.GetContextLogger(ctx)
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctxutil.WithContextLogger(context.Background(), l), backgroundMailTimeout)
+		defer cancel()
+
+		err := mailer.Send(ctx, msg)
+		if err != nil {
+			l.Warn(errors.Wrapf(err, "failed to send %s", what))
+		}
+	}()
+}
+
+// RequestMagicLink mails a login link if a user with the email exists. The response doesn't tell whether it exists,
+// requests for unknown emails are stored with a token that is never sent, so that they count towards the rate limit.
+// The link is mailed after the response, which succeeds once the link is stored.
+func RequestMagicLink(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, mailer mailing.Mailer, oidcProviders *OidcProviders, opts MagicLinkOpts, req types.RequestMagicLinkRequest) (rsp types.RequestMagicLinkResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to request magic link")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	if oidcProviders.RequiresFederatedLogin(req.Email) {
+		err = errors.New("failed as the email domain is routed to an identity provider")
+
+		rsp.Error = types.ErrorFederatedLoginRequired
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	token, tokenHash, err := generateToken()
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	nonce, nonceHash, err := generateToken()
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate nonce")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	link, err := url.Parse(opts.URL)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse magic link url")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	q := link.Query()
+	q.Set(types.QueryToken, token)
+	link.RawQuery = q.Encode()
+
+	var u *types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		email := strings.ToLower(req.Email)
+
+		err = persistence.LockMagicLinkEmail(ctx, m, tx, email)
+		if err != nil {
+			err = errors.Wrap(err, "failed to lock email")
+
+			return
+		}
+
+		n, err := persistence.CountMagicLinksSince(ctx, m, tx, email, time.Now().Add(-opts.RateLimitWindow))
+		if err != nil {
+			err = errors.Wrap(err, "failed to count magic links")
+
+			return
+		}
+
+		if n >= opts.RateLimit {
+			err = errors.Wrapf(errMagicLinkRateLimited, "failed as %v magic links were requested within %v", n, opts.RateLimitWindow)
+
+			return
+		}
+
+		ml := types.MagicLinkModel{
+			TokenHash: tokenHash,
+			Email:     email,
+			NonceHash: nonceHash,
+			ExpiresAt: time.Now().Add(opts.Ttl),
+		}
+
+		fu, err := persistence.GetUserByEmail(ctx, m, tx, req.Email)
+		switch {
+		case errors.Cause(err) == sql.ErrNoRows:
+			err = nil
+		case err != nil:
+			err = errors.Wrap(err, "failed to get user")
+
+			return
+		default:
+			u = &fu
+			ml.UserID = &fu.ID
+		}
+
+		_, err = persistence.InsertMagicLink(ctx, m, tx, ml)
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert magic link")
+
+			return
+		}
+
+		if u != nil {
+			err = recordAuditEvent(ctx, m, tx, types.AuditActionUserMagicLinkRequested, u.ID, nil)
+			if err != nil {
+				err = errors.Wrap(err, "failed to record audit event")
+
+				return
+			}
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == errMagicLinkRateLimited:
+		rsp.Error = types.ErrorTooManyMagicLinkRequests
+		statusCode = http.StatusTooManyRequests
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	if u != nil {
+		sendMailInBackground(ctx, mailer, mailing.Message{
+			To:      u.Email,
+			Subject: "Your login link",
+			Body: fmt.Sprintf("Open the link below to log in. It expires in %v, works once, and only in the browser it was requested from.\r\n\r\n%s\r\n\r\nIf you didn't request it, you can ignore this email.\r\n",
+				opts.Ttl, link.String()),
+		}, "magic link")
+	}
+
+	rsp.Nonce = nonce
+
+	return
+}
+
+// ConsumeMagicLink signs in the user of a magic link, if it's presented with the nonce of the requesting browser.
+// Signing in consumes all outstanding magic links of the user.
+func ConsumeMagicLink(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, hmacSecret string, mailer mailing.Mailer, req types.ConsumeMagicLinkRequest) (rsp types.ConsumeMagicLinkResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to consume magic link")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var u types.UserModel
+	var d types.LoginDeviceModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		ml, err := persistence.GetMagicLinkForUpdate(ctx, m, tx, hashToken(req.Token))
+		if err != nil {
+			err = errors.Wrap(err, "failed to get magic link")
+
+			return
+		}
+
+		switch {
+		case ml.UserID == nil:
+			err = errors.Wrap(errInvalidMagicLink, "failed as magic link was requested for an unknown email")
+		case ml.ConsumedAt != nil:
+			err = errors.Wrap(errInvalidMagicLink, "failed as magic link was consumed before")
+		case !ml.ExpiresAt.After(time.Now()):
+			err = errors.Wrap(errInvalidMagicLink, "failed as magic link expired")
+		case subtle.ConstantTimeCompare([]byte(hashToken(req.Nonce)), []byte(ml.NonceHash)) != 1:
+			err = errors.Wrap(errInvalidMagicLink, "failed as nonce doesn't match")
+		}
+		if err != nil {
+			return
+		}
+
+		u, err = persistence.GetUserById(ctx, m, tx, *ml.UserID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get user")
+
+			return
+		}
+
+		err = checkUserActive(u)
+		if err != nil {
+			return
+		}
+
+		err = persistence.ConsumeMagicLinksOfUser(ctx, m, tx, u.ID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to consume magic links")
+
+			return
+		}
+
+		err = recordAuditEvent(ctxutil.WithSubject(ctx, u.ID), m, tx, types.AuditActionUserAuthenticated, u.ID, map[string]types.AuditChange{
+			"authenticator": {After: magicLinkAuthenticator},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		d, err = recordLoginAttempt(ctx, m, tx, u.ID, u.Email, types.LoginMethodMagicLink, "")
+		if err != nil {
+			err = errors.Wrap(err, "failed to record login attempt")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows, errors.Cause(err) == errInvalidMagicLink:
+		recordFailedLoginAttempt(ctx, m, db, "", "", types.LoginMethodMagicLink, types.ErrorInvalidMagicLink)
+
+		rsp.Error = types.ErrorInvalidMagicLink
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case errors.Cause(err) == errUserNotActive:
+		recordFailedLoginAttempt(ctx, m, db, u.ID, u.Email, types.LoginMethodMagicLink, types.ErrorUserNotActive)
+
+		rsp.Error = types.ErrorUserNotActive
+		statusCode = http.StatusForbidden
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.AccessToken, err = GenerateAccessToken(hmacSecret, u.UserGroup, u.ID, u.TokenEpoch)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate access token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	notifyLoginDevice(ctx, mailer, u, d)
+
+	return
+}
+
+// DeleteStaleMagicLinksPeriodically deletes magic links that expired, and no longer count towards the rate limit.
+func DeleteStaleMagicLinksPeriodically(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, interval time.Duration, opts MagicLinkOpts) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		n, err := persistence.DeleteStaleMagicLinks(ctx, m, db, opts.RateLimitWindow)
+		if err != nil {
+			ctxutil.GetContextLogger(ctx).Error(errors.Wrap(err, "failed to delete stale magic links"))
+		} else if n > 0 {
+			ctxutil.GetContextLogger(ctx).With("deleted_magic_links_count", n).Info("deleted stale magic links")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
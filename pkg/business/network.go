@@ -0,0 +1,274 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+var errNetworkNotAllowed = errors.New("client network is not allowed")
+
+type networkPolicy struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// NetworkPolicies restricts per role the networks requests are accepted from, the policies can be replaced at runtime.
+type NetworkPolicies struct {
+	mu       sync.RWMutex
+	policies map[string]networkPolicy
+}
+
+func LoadNetworkPolicies(path string) (cs types.NetworkPolicies, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrap(err, "failed to read network policies file")
+
+		return
+	}
+
+	err = json.Unmarshal(b, &cs)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode network policies file")
+
+		return
+	}
+
+	return
+}
+
+func NewNetworkPolicies(v *validator.Validate, cs types.NetworkPolicies) (ps *NetworkPolicies, err error) {
+	ps = &NetworkPolicies{}
+
+	err = ps.Set(v, cs)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// Set replaces the policies of all roles, and keeps the current policies if one of the new policies is invalid.
+func (ps *NetworkPolicies) Set(v *validator.Validate, cs types.NetworkPolicies) (err error) {
+	policies := map[string]networkPolicy{}
+	for role, c := range cs {
+		switch role {
+		case types.UserGroupAdmin, types.UserGroupUser, types.RoleGuest, types.RoleScim:
+		default:
+			err = errors.Errorf("failed as role %s is unknown", role)
+
+			return
+		}
+
+		err = v.Struct(c)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to validate network policy of role %s", role)
+
+			return
+		}
+
+		var p networkPolicy
+
+		p.allow, err = parseCidrs(c.Allow)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse allowed networks of role %s", role)
+
+			return
+		}
+
+		p.deny, err = parseCidrs(c.Deny)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse denied networks of role %s", role)
+
+			return
+		}
+
+		policies[role] = p
+	}
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.policies = policies
+
+	return
+}
+
+// CheckNetwork fails if ip is denied to role, or if role has an allowlist that doesn't contain ip.
+// Denied networks take precedence over allowed networks, roles without a policy are allowed from anywhere.
+func (ps *NetworkPolicies) CheckNetwork(role string, ip string) (err error) {
+	if ps == nil {
+		return
+	}
+
+	ps.mu.RLock()
+	p, ok := ps.policies[role]
+	ps.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		err = errors.Wrapf(errNetworkNotAllowed, "failed as remote ip %s is invalid", ip)
+
+		return
+	}
+
+	for _, n := range p.deny {
+		if n.Contains(parsed) {
+			err = errors.Wrapf(errNetworkNotAllowed, "failed as remote ip %s is in denied network %s", ip, n)
+
+			return
+		}
+	}
+
+	if len(p.allow) == 0 {
+		return
+	}
+
+	for _, n := range p.allow {
+		if n.Contains(parsed) {
+			return
+		}
+	}
+
+	err = errors.Wrapf(errNetworkNotAllowed, "failed as remote ip %s is in none of the allowed networks", ip)
+
+	return
+}
+
+// ReloadNetworkPoliciesOnSignal reloads the policies from path whenever a signal is received, and keeps
+// the current policies if the file can't be loaded.
+func ReloadNetworkPoliciesOnSignal(ctx context.Context, v *validator.Validate, ps *NetworkPolicies, path string, signals <-chan os.Signal) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-signals:
+		}
+
+		l := ctxutil.GetContextLogger(ctx)
+
+		cs, err := LoadNetworkPolicies(path)
+		if err != nil {
+			l.Error(errors.Wrap(err, "failed to reload network policies"))
+
+			continue
+		}
+
+		err = ps.Set(v, cs)
+		if err != nil {
+			l.Error(errors.Wrap(err, "failed to reload network policies"))
+
+			continue
+		}
+
+		l.Infof("reloaded network policies from %s", path)
+	}
+}
+
+// TrustedProxies resolves the client ip of requests that passed through proxies, which append the address
+// they received the request from to X-Forwarded-For.
+type TrustedProxies struct {
+	networks []*net.IPNet
+	hops     int
+}
+
+func NewTrustedProxies(cidrs []string, hops int) (tp *TrustedProxies, err error) {
+	if hops < 0 {
+		err = errors.Errorf("failed as trusted proxy hops %d is negative", hops)
+
+		return
+	}
+
+	networks, err := parseCidrs(cidrs)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse trusted proxies")
+
+		return
+	}
+
+	tp = &TrustedProxies{
+		networks: networks,
+		hops:     hops,
+	}
+
+	return
+}
+
+// ClientIP walks from the remote ip of the connection leftwards through X-Forwarded-For, and returns the first
+// address that isn't a trusted proxy. The nearest hops addresses are trusted, as are addresses in the trusted
+// networks, entries left of the client are ignored, as the client can set them to anything. Without trusted
+// proxies the remote ip is returned.
+func (tp *TrustedProxies) ClientIP(remoteIP string, forwardedFor []string) (ip string) {
+	ip = remoteIP
+	if tp == nil {
+		return
+	}
+
+	var chain []string
+	for _, v := range forwardedFor {
+		for _, a := range strings.Split(v, ",") {
+			chain = append(chain, strings.TrimSpace(a))
+		}
+	}
+
+	for hop := 0; len(chain) > 0 && tp.trusts(hop, ip); hop++ {
+		next := chain[len(chain)-1]
+		chain = chain[:len(chain)-1]
+
+		if net.ParseIP(next) == nil {
+			return
+		}
+
+		ip = next
+	}
+
+	return
+}
+
+func (tp *TrustedProxies) trusts(hop int, ip string) bool {
+	if hop < tp.hops {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, n := range tp.networks {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func parseCidrs(cidrs []string) (ns []*net.IPNet, err error) {
+	for _, c := range cidrs {
+		var n *net.IPNet
+		_, n, err = net.ParseCIDR(c)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse cidr %s", c)
+
+			return
+		}
+
+		ns = append(ns, n)
+	}
+
+	return
+}
@@ -0,0 +1,150 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func TestNetworkPoliciesCheckNetwork(t *testing.T) {
+	ps, err := NewNetworkPolicies(validator.New(), types.NetworkPolicies{
+		types.UserGroupAdmin: {Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.6.0.0/16"}},
+		types.UserGroupUser:  {Deny: []string{"192.0.2.0/24"}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, tc := range []struct {
+		role     string
+		ip       string
+		expected bool
+	}{
+		{role: types.UserGroupAdmin, ip: "10.1.2.3", expected: true},
+		{role: types.UserGroupAdmin, ip: "2001:db8::1", expected: true},
+		{role: types.UserGroupAdmin, ip: "10.6.1.1", expected: false},
+		{role: types.UserGroupAdmin, ip: "203.0.113.1", expected: false},
+		{role: types.UserGroupAdmin, ip: "invalid", expected: false},
+		{role: types.UserGroupUser, ip: "203.0.113.1", expected: true},
+		{role: types.UserGroupUser, ip: "192.0.2.1", expected: false},
+		{role: types.RoleGuest, ip: "192.0.2.1", expected: true},
+	} {
+		err := ps.CheckNetwork(tc.role, tc.ip)
+		if tc.expected {
+			assert.NoError(t, err, "%s from %s", tc.role, tc.ip)
+		} else {
+			assert.Equal(t, errNetworkNotAllowed, errors.Cause(err), "%s from %s", tc.role, tc.ip)
+		}
+	}
+
+	var nilPolicies *NetworkPolicies
+	assert.NoError(t, nilPolicies.CheckNetwork(types.UserGroupAdmin, "203.0.113.1"), "without policies every network is allowed")
+}
+
+func TestNetworkPoliciesSet(t *testing.T) {
+	v := validator.New()
+
+	ps, err := NewNetworkPolicies(v, types.NetworkPolicies{types.UserGroupAdmin: {Allow: []string{"10.0.0.0/8"}}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Error(t, ps.Set(v, types.NetworkPolicies{"superuser": {}}), "unknown roles are rejected")
+	assert.Error(t, ps.Set(v, types.NetworkPolicies{types.UserGroupAdmin: {Allow: []string{"10.0.0.1"}}}), "invalid cidrs are rejected")
+	assert.Error(t, ps.CheckNetwork(types.UserGroupAdmin, "203.0.113.1"), "invalid policies keep the current policies")
+
+	assert.NoError(t, ps.Set(v, types.NetworkPolicies{types.RoleScim: {Allow: []string{"10.0.0.0/8"}}}))
+	assert.Error(t, ps.CheckNetwork(types.RoleScim, "203.0.113.1"), "the identity provider has its own role")
+
+	assert.NoError(t, ps.Set(v, types.NetworkPolicies{}))
+	assert.NoError(t, ps.CheckNetwork(types.UserGroupAdmin, "203.0.113.1"))
+}
+
+func TestReloadNetworkPoliciesOnSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(ctxutil.WithContextLogger(context.Background(), zap.NewNop().Sugar()))
+	defer cancel()
+
+	f, err := ioutil.TempFile("", "network-policies-*.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+
+	v := validator.New()
+
+	ps, err := NewNetworkPolicies(v, types.NetworkPolicies{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	signals := make(chan os.Signal)
+
+	go ReloadNetworkPoliciesOnSignal(ctx, v, ps, f.Name(), signals)
+
+	err = ioutil.WriteFile(f.Name(), []byte(`{"admin": {"deny": ["203.0.113.0/24"]}}`), 0600)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	signals <- syscall.SIGHUP
+
+	assert.Eventually(t, func() bool {
+		return ps.CheckNetwork(types.UserGroupAdmin, "203.0.113.1") != nil
+	}, time.Second, 10*time.Millisecond)
+
+	err = ioutil.WriteFile(f.Name(), []byte(`{"admin": {"deny": ["invalid"]}}`), 0600)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	signals <- syscall.SIGHUP
+	signals <- syscall.SIGHUP
+
+	assert.Error(t, ps.CheckNetwork(types.UserGroupAdmin, "203.0.113.1"), "a file that can't be loaded keeps the current policies")
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		cidrs        []string
+		hops         int
+		remoteIP     string
+		forwardedFor []string
+		expected     string
+	}{
+		{name: "untrusted remote ip", cidrs: []string{"10.0.0.0/8"}, remoteIP: "192.0.2.1", forwardedFor: []string{"203.0.113.1"}, expected: "192.0.2.1"},
+		{name: "trusted network", cidrs: []string{"10.0.0.0/8"}, remoteIP: "10.0.0.1", forwardedFor: []string{"203.0.113.1"}, expected: "203.0.113.1"},
+		{name: "spoofed entries left of the client", cidrs: []string{"10.0.0.0/8"}, remoteIP: "10.0.0.1", forwardedFor: []string{"198.51.100.1, 203.0.113.1"}, expected: "203.0.113.1"},
+		{name: "chained proxies", cidrs: []string{"10.0.0.0/8"}, remoteIP: "10.0.0.1", forwardedFor: []string{"203.0.113.1, 10.0.0.2", "10.0.0.3"}, expected: "203.0.113.1"},
+		{name: "hops", hops: 1, remoteIP: "169.254.1.1", forwardedFor: []string{"198.51.100.1, 203.0.113.1"}, expected: "203.0.113.1"},
+		{name: "hops and networks", cidrs: []string{"10.0.0.0/8"}, hops: 1, remoteIP: "169.254.1.1", forwardedFor: []string{"203.0.113.1, 10.0.0.2"}, expected: "203.0.113.1"},
+		{name: "without forwarded for", hops: 1, remoteIP: "169.254.1.1", expected: "169.254.1.1"},
+		{name: "invalid entry", hops: 2, remoteIP: "169.254.1.1", forwardedFor: []string{"203.0.113.1, invalid, 10.0.0.2"}, expected: "10.0.0.2"},
+	} {
+		tp, err := NewTrustedProxies(tc.cidrs, tc.hops)
+		if !assert.NoError(t, err, tc.name) {
+			continue
+		}
+
+		assert.Equal(t, tc.expected, tp.ClientIP(tc.remoteIP, tc.forwardedFor), tc.name)
+	}
+
+	var nilProxies *TrustedProxies
+	assert.Equal(t, "10.0.0.1", nilProxies.ClientIP("10.0.0.1", []string{"203.0.113.1"}), "without trusted proxies the remote ip is the client ip")
+
+	_, err := NewTrustedProxies([]string{"10.0.0.1"}, 0)
+	assert.Error(t, err, "invalid cidrs are rejected")
+}
@@ -0,0 +1,551 @@
+package business
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/coreos/go-oidc"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+
+	"github.com/ppwfx/user-svc/pkg/mailing"
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	OidcLoginStateTtl    = 10 * time.Minute
+	oidcLoginStateDomain = "oidc-login-state."
+)
+
+var oidcDefaultScopes = []string{oidc.ScopeOpenID, "email", "profile"}
+
+type oidcProvider struct {
+	name              string
+	domains           []string
+	linkExistingUsers bool
+	verifier          *oidc.IDTokenVerifier
+	oauth2            oauth2.Config
+}
+
+// OidcProviders holds the upstream OpenID Connect providers, and routes email domains to them.
+type OidcProviders struct {
+	client    *http.Client
+	providers map[string]*oidcProvider
+	domains   map[string]*oidcProvider
+}
+
+type oidcClaims struct {
+	Subject       string      `json:"sub"`
+	Email         string      `json:"email"`
+	EmailVerified interface{} `json:"email_verified"`
+	Name          string      `json:"name"`
+}
+
+func LoadOidcProviders(path string) (cs []types.OidcProvider, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrap(err, "failed to read oidc providers file")
+
+		return
+	}
+
+	err = json.Unmarshal(b, &cs)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode oidc providers file")
+
+		return
+	}
+
+	return
+}
+
+// NewOidcProviders discovers the configuration of each provider, and fails if one isn't reachable.
+func NewOidcProviders(ctx context.Context, v *validator.Validate, client *http.Client, cs []types.OidcProvider) (ps *OidcProviders, err error) {
+	ps = &OidcProviders{
+		client:    client,
+		providers: map[string]*oidcProvider{},
+		domains:   map[string]*oidcProvider{},
+	}
+
+	ctx = oidc.ClientContext(ctx, client)
+
+	for _, c := range cs {
+		err = v.Struct(&c)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to validate oidc provider %s", c.Name)
+
+			return
+		}
+
+		_, ok := ps.providers[c.Name]
+		if ok {
+			err = errors.Errorf("failed to add oidc provider %s: name is not unique", c.Name)
+
+			return
+		}
+
+		var provider *oidc.Provider
+		provider, err = oidc.NewProvider(ctx, c.Issuer)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to discover oidc provider %s", c.Name)
+
+			return
+		}
+
+		scopes := c.Scopes
+		if len(scopes) == 0 {
+			scopes = oidcDefaultScopes
+		}
+
+		p := &oidcProvider{
+			name:              c.Name,
+			linkExistingUsers: c.LinkExistingUsers,
+			verifier:          provider.Verifier(&oidc.Config{ClientID: c.ClientID}),
+			oauth2: oauth2.Config{
+				ClientID:     c.ClientID,
+				ClientSecret: c.ClientSecret,
+				Endpoint:     provider.Endpoint(),
+				RedirectURL:  c.RedirectURL,
+				Scopes:       scopes,
+			},
+		}
+
+		for _, d := range c.Domains {
+			d = strings.ToLower(d)
+
+			_, ok = ps.domains[d]
+			if ok {
+				err = errors.Errorf("failed to add oidc provider %s: domain %s is routed to another provider", c.Name, d)
+
+				return
+			}
+
+			ps.domains[d] = p
+			p.domains = append(p.domains, d)
+		}
+
+		ps.providers[c.Name] = p
+	}
+
+	return
+}
+
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+
+	return strings.ToLower(email[i+1:])
+}
+
+func (ps *OidcProviders) forEmail(email string) *oidcProvider {
+	if ps == nil {
+		return nil
+	}
+
+	return ps.domains[emailDomain(email)]
+}
+
+func (ps *OidcProviders) byName(name string) *oidcProvider {
+	if ps == nil {
+		return nil
+	}
+
+	return ps.providers[name]
+}
+
+// RequiresFederatedLogin reports whether the domain of email is routed to an identity provider.
+func (ps *OidcProviders) RequiresFederatedLogin(email string) bool {
+	return ps.forEmail(email) != nil
+}
+
+func signOidcLoginState(hmacSecret string, s types.OidcLoginState) (v string, err error) {
+	b, err := json.Marshal(s)
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal login state")
+
+		return
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(b)
+
+	mac := hmac.New(sha256.New, []byte(hmacSecret))
+	mac.Write([]byte(oidcLoginStateDomain + payload))
+
+	v = payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	return
+}
+
+func parseOidcLoginState(hmacSecret string, v string) (s types.OidcLoginState, err error) {
+	i := strings.LastIndex(v, ".")
+	if i < 0 {
+		err = errors.New("failed to split login state")
+
+		return
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(v[i+1:])
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode login state signature")
+
+		return
+	}
+
+	mac := hmac.New(sha256.New, []byte(hmacSecret))
+	mac.Write([]byte(oidcLoginStateDomain + v[:i]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		err = errors.New("failed to verify login state signature")
+
+		return
+	}
+
+	b, err := base64.RawURLEncoding.DecodeString(v[:i])
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode login state")
+
+		return
+	}
+
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal login state")
+
+		return
+	}
+
+	if time.Now().After(s.ExpiresAt) {
+		err = errors.New("failed as login state expired")
+
+		return
+	}
+
+	return
+}
+
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func StartOidcLogin(ctx context.Context, ps *OidcProviders, v *validator.Validate, hmacSecret string, req types.OidcLoginRequest) (rsp types.OidcLoginResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"provider", req.Provider,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to start oidc login")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusFound
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	p := ps.byName(req.Provider)
+	if req.Provider == "" {
+		p = ps.forEmail(req.Email)
+	}
+	if p == nil {
+		err = errors.New("failed to find oidc provider")
+
+		rsp.Error = types.ErrorOidcProviderDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	s := types.OidcLoginState{
+		Provider:  p.name,
+		ExpiresAt: time.Now().Add(OidcLoginStateTtl),
+	}
+	for _, f := range []*string{&s.State, &s.Nonce, &s.CodeVerifier} {
+		*f, _, err = generateToken()
+		if err != nil {
+			err = errors.Wrap(err, "failed to generate token")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	rsp.LoginState, err = signOidcLoginState(hmacSecret, s)
+	if err != nil {
+		err = errors.Wrap(err, "failed to sign login state")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	opts := []oauth2.AuthCodeOption{
+		oidc.Nonce(s.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(s.CodeVerifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	}
+	if req.Email != "" {
+		opts = append(opts, oauth2.SetAuthURLParam("login_hint", req.Email))
+	}
+
+	rsp.AuthorizationURL = p.oauth2.AuthCodeURL(s.State, opts...)
+
+	return
+}
+
+// verifyOidcCallback exchanges the authorization code of a callback, and verifies the returned id token. rspError
+// is the error to return to the client if err isn't nil.
+func (ps *OidcProviders) verifyOidcCallback(ctx context.Context, hmacSecret string, req types.OidcCallbackRequest) (p *oidcProvider, claims oidcClaims, rspError string, err error) {
+	rspError = types.ErrorInvalidOidcState
+
+	s, err := parseOidcLoginState(hmacSecret, req.LoginState)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse login state")
+
+		return
+	}
+
+	if !hmac.Equal([]byte(s.State), []byte(req.State)) {
+		err = errors.New("failed as state doesn't match the login state")
+
+		return
+	}
+
+	p = ps.byName(s.Provider)
+	if p == nil {
+		err = errors.Errorf("failed to find oidc provider %s", s.Provider)
+
+		return
+	}
+
+	rspError = types.ErrorOidcLoginFailed
+
+	if req.Error != "" {
+		err = errors.Errorf("failed as identity provider returned %s: %s", req.Error, req.ErrorDescription)
+
+		return
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, ps.client)
+
+	t, err := p.oauth2.Exchange(ctx, req.Code, oauth2.SetAuthURLParam("code_verifier", s.CodeVerifier))
+	if err != nil {
+		err = errors.Wrap(err, "failed to exchange authorization code")
+
+		return
+	}
+
+	rawIDToken, ok := t.Extra("id_token").(string)
+	if !ok {
+		err = errors.New("failed as token response contains no id token")
+
+		return
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		err = errors.Wrap(err, "failed to verify id token")
+
+		return
+	}
+
+	if !hmac.Equal([]byte(idToken.Nonce), []byte(s.Nonce)) {
+		err = errors.New("failed as id token nonce doesn't match the login state")
+
+		return
+	}
+
+	err = idToken.Claims(&claims)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode id token claims")
+
+		return
+	}
+
+	if claims.Email == "" {
+		err = errors.New("failed as id token contains no email")
+
+		return
+	}
+
+	// a missing claim doesn't verify the email, as the email of an existing user could be claimed otherwise
+	verified := false
+	switch ev := claims.EmailVerified.(type) {
+	case bool:
+		verified = ev
+	case string:
+		verified, _ = strconv.ParseBool(ev)
+	}
+	if !verified {
+		rspError = types.ErrorOidcEmailNotVerified
+		err = errors.Errorf("failed as email %s isn't verified", claims.Email)
+
+		return
+	}
+
+	if len(p.domains) > 0 && ps.forEmail(claims.Email) != p {
+		rspError = types.ErrorOidcEmailDomainNotAllowed
+		err = errors.Errorf("failed as email %s isn't routed to provider %s", claims.Email, p.name)
+
+		return
+	}
+
+	rspError = ""
+
+	return
+}
+
+// FinishOidcLogin signs in the user linked to the external subject. A subject without link is linked to the user
+// with the same email, or to a user created just in time.
+func FinishOidcLogin(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, ps *OidcProviders, hmacSecret string, allowedSubjectSuffix string, argonOpts Argon2IdOpts, mailer mailing.Mailer, req types.OidcCallbackRequest) (rsp types.OidcCallbackResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to finish oidc login")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = types.ErrorInvalidOidcState
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	p, claims, rspError, err := ps.verifyOidcCallback(ctx, hmacSecret, req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to verify callback")
+
+		recordFailedLoginAttempt(ctx, m, db, "", "", types.LoginMethodOidc, rspError)
+
+		rsp.Error = rspError
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var u types.UserModel
+	var d types.LoginDeviceModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = signInExternalIdentity(ctx, m, tx, externalIdentity{Provider: p.name, Subject: claims.Subject, Email: claims.Email, FullName: claims.Name, LinkExisting: p.linkExistingUsers || ps.forEmail(claims.Email) == p}, allowedSubjectSuffix, argonOpts)
+		if err != nil {
+			err = errors.Wrap(err, "failed to sign in user identity")
+
+			return
+		}
+
+		err = recordAuditEvent(ctxutil.WithSubject(ctx, u.ID), m, tx, types.AuditActionUserAuthenticated, u.ID, map[string]types.AuditChange{
+			"provider": {After: p.name},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		d, err = recordLoginAttempt(ctx, m, tx, u.ID, u.Email, types.LoginMethodOidc, "")
+		if err != nil {
+			err = errors.Wrap(err, "failed to record login attempt")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		recordFailedLoginAttempt(ctx, m, db, "", claims.Email, types.LoginMethodOidc, types.ErrorInvalidCredentials)
+
+		rsp.Error = types.ErrorInvalidCredentials
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case errors.Cause(err) == errUserNotActive:
+		recordFailedLoginAttempt(ctx, m, db, u.ID, u.Email, types.LoginMethodOidc, types.ErrorUserNotActive)
+
+		rsp.Error = types.ErrorUserNotActive
+		statusCode = http.StatusForbidden
+
+		return
+	case errors.Cause(err) == errIdentityNotLinkable:
+		recordFailedLoginAttempt(ctx, m, db, "", claims.Email, types.LoginMethodOidc, types.ErrorIdentityNotLinkable)
+
+		rsp.Error = types.ErrorIdentityNotLinkable
+		statusCode = http.StatusConflict
+
+		return
+	case persistence.IsUniqueViolation(err):
+		recordFailedLoginAttempt(ctx, m, db, "", claims.Email, types.LoginMethodOidc, types.ErrorEmailAlreadyExists)
+
+		rsp.Error = types.ErrorEmailAlreadyExists
+		statusCode = http.StatusConflict
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.AccessToken, err = GenerateAccessToken(hmacSecret, u.UserGroup, u.ID, u.TokenEpoch)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate access token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	notifyLoginDevice(ctx, mailer, u, d)
+
+	return
+}
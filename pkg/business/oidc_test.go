@@ -0,0 +1,226 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+	"github.com/ppwfx/user-svc/pkg/utils/oidcutil"
+)
+
+const oidcTestRedirectURL = "http://user-svc.test/api/v0/oidcCallback"
+
+func newOidcTestProviders(t *testing.T) (ctx context.Context, mock *oidcutil.MockProvider, ps *OidcProviders, ok bool) {
+	ctx = ctxutil.WithContextLogger(context.Background(), zap.NewNop().Sugar())
+
+	mock, err := oidcutil.NewMockProvider("user-svc", "client-secret")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ps, err = NewOidcProviders(ctx, validator.New(), &http.Client{}, []types.OidcProvider{{
+		Name:         "corp",
+		Issuer:       mock.URL,
+		ClientID:     mock.ClientID,
+		ClientSecret: mock.ClientSecret,
+		RedirectURL:  oidcTestRedirectURL,
+		Domains:      []string{"corp.example.com"},
+	}})
+	if !assert.NoError(t, err) {
+		mock.Close()
+
+		return
+	}
+
+	ok = true
+
+	return
+}
+
+func startOidcTestLogin(t *testing.T, ctx context.Context, ps *OidcProviders) (req types.OidcCallbackRequest, ok bool) {
+	rsp, statusCode := StartOidcLogin(ctx, ps, validator.New(), "hmac-secret", types.OidcLoginRequest{Email: "john@corp.example.com"})
+	if !assert.Equal(t, http.StatusFound, statusCode) {
+		return
+	}
+
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	httpRsp, err := c.Get(rsp.AuthorizationURL)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	if !assert.Equal(t, http.StatusFound, httpRsp.StatusCode) {
+		return
+	}
+
+	callback, err := url.Parse(httpRsp.Header.Get("Location"))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	req = types.OidcCallbackRequest{
+		Code:       callback.Query().Get(types.QueryCode),
+		State:      callback.Query().Get(types.QueryState),
+		LoginState: rsp.LoginState,
+	}
+	ok = true
+
+	return
+}
+
+func TestOidcLoginState(t *testing.T) {
+	s := types.OidcLoginState{Provider: "corp", State: "state", Nonce: "nonce", CodeVerifier: "verifier", ExpiresAt: time.Now().Add(time.Minute).UTC()}
+
+	v, err := signOidcLoginState("hmac-secret", s)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	parsed, err := parseOidcLoginState("hmac-secret", v)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, s.State, parsed.State)
+	assert.Equal(t, s.CodeVerifier, parsed.CodeVerifier)
+
+	_, err = parseOidcLoginState("other-secret", v)
+	assert.Error(t, err)
+
+	_, err = parseOidcLoginState("hmac-secret", "x"+v)
+	assert.Error(t, err)
+
+	s.ExpiresAt = time.Now().Add(-time.Second)
+	v, err = signOidcLoginState("hmac-secret", s)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = parseOidcLoginState("hmac-secret", v)
+	assert.Error(t, err)
+}
+
+func TestStartOidcLogin(t *testing.T) {
+	ctx, mock, ps, ok := newOidcTestProviders(t)
+	if !ok {
+		return
+	}
+	defer mock.Close()
+
+	v := validator.New()
+
+	rsp, statusCode := StartOidcLogin(ctx, ps, v, "hmac-secret", types.OidcLoginRequest{Email: "john@CORP.example.com"})
+	if !assert.Equal(t, http.StatusFound, statusCode) {
+		return
+	}
+
+	u, err := url.Parse(rsp.AuthorizationURL)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	q := u.Query()
+	assert.Equal(t, mock.URL+"/authorize", u.Scheme+"://"+u.Host+u.Path)
+	assert.Equal(t, mock.ClientID, q.Get("client_id"))
+	assert.Equal(t, oidcTestRedirectURL, q.Get("redirect_uri"))
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, "john@CORP.example.com", q.Get("login_hint"))
+	assert.NotEmpty(t, q.Get("nonce"))
+
+	s, err := parseOidcLoginState("hmac-secret", rsp.LoginState)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, s.State, q.Get("state"))
+	assert.Equal(t, s.Nonce, q.Get("nonce"))
+	assert.Equal(t, pkceChallenge(s.CodeVerifier), q.Get("code_challenge"))
+
+	assert.True(t, ps.RequiresFederatedLogin("jane@corp.example.com"))
+	assert.False(t, ps.RequiresFederatedLogin("jane@example.com"))
+	assert.False(t, (*OidcProviders)(nil).RequiresFederatedLogin("jane@corp.example.com"))
+
+	_, statusCode = StartOidcLogin(ctx, ps, v, "hmac-secret", types.OidcLoginRequest{Provider: "corp"})
+	assert.Equal(t, http.StatusFound, statusCode)
+
+	_, statusCode = StartOidcLogin(ctx, ps, v, "hmac-secret", types.OidcLoginRequest{Email: "john@example.com"})
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	_, statusCode = StartOidcLogin(ctx, ps, v, "hmac-secret", types.OidcLoginRequest{})
+	assert.Equal(t, http.StatusUnprocessableEntity, statusCode)
+}
+
+func TestVerifyOidcCallback(t *testing.T) {
+	ctx, mock, ps, ok := newOidcTestProviders(t)
+	if !ok {
+		return
+	}
+	defer mock.Close()
+
+	john := oidcutil.MockIdentity{Subject: "1234", Email: "john@corp.example.com", EmailVerified: true, Name: "John Doe"}
+
+	mock.SetIdentity(john)
+
+	req, ok := startOidcTestLogin(t, ctx, ps)
+	if !ok {
+		return
+	}
+
+	p, claims, _, err := ps.verifyOidcCallback(ctx, "hmac-secret", req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "corp", p.name)
+	assert.Equal(t, oidcClaims{Subject: "1234", Email: "john@corp.example.com", EmailVerified: true, Name: "John Doe"}, claims)
+
+	_, _, rspError, err := ps.verifyOidcCallback(ctx, "hmac-secret", req)
+	assert.Error(t, err, "codes can only be exchanged once")
+	assert.Equal(t, types.ErrorOidcLoginFailed, rspError)
+
+	for _, tc := range []struct {
+		name     string
+		identity oidcutil.MockIdentity
+		tamper   func(claims map[string]interface{})
+		modify   func(req *types.OidcCallbackRequest)
+		rspError string
+	}{
+		{name: "state mismatch", identity: john, modify: func(req *types.OidcCallbackRequest) { req.State = "other" }, rspError: types.ErrorInvalidOidcState},
+		{name: "missing login state", identity: john, modify: func(req *types.OidcCallbackRequest) { req.LoginState = "" }, rspError: types.ErrorInvalidOidcState},
+		{name: "idp error", identity: john, modify: func(req *types.OidcCallbackRequest) { req.Error = "access_denied" }, rspError: types.ErrorOidcLoginFailed},
+		{name: "nonce mismatch", identity: john, tamper: func(c map[string]interface{}) { c["nonce"] = "other" }, rspError: types.ErrorOidcLoginFailed},
+		{name: "audience mismatch", identity: john, tamper: func(c map[string]interface{}) { c["aud"] = "other" }, rspError: types.ErrorOidcLoginFailed},
+		{name: "expired", identity: john, tamper: func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, rspError: types.ErrorOidcLoginFailed},
+		{name: "unverified email", identity: oidcutil.MockIdentity{Subject: "1234", Email: "john@corp.example.com"}, rspError: types.ErrorOidcEmailNotVerified},
+		{name: "unverified email string", identity: john, tamper: func(c map[string]interface{}) { c["email_verified"] = "false" }, rspError: types.ErrorOidcEmailNotVerified},
+		{name: "missing email verification", identity: john, tamper: func(c map[string]interface{}) { delete(c, "email_verified") }, rspError: types.ErrorOidcEmailNotVerified},
+		{name: "foreign domain", identity: oidcutil.MockIdentity{Subject: "1234", Email: "john@example.com", EmailVerified: true}, rspError: types.ErrorOidcEmailDomainNotAllowed},
+	} {
+		mock.SetIdentity(tc.identity)
+		mock.TamperClaims(tc.tamper)
+
+		req, ok := startOidcTestLogin(t, ctx, ps)
+		if !ok {
+			return
+		}
+
+		if tc.modify != nil {
+			tc.modify(&req)
+		}
+
+		_, _, rspError, err := ps.verifyOidcCallback(ctx, "hmac-secret", req)
+		assert.Error(t, err, tc.name)
+		assert.Equal(t, tc.rspError, rspError, tc.name)
+	}
+}
@@ -0,0 +1,61 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func enqueueUserEvent(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, eventType string, u types.UserModel) (err error) {
+	b, err := json.Marshal(types.UserEventData{
+		User: types.ListUser{
+			ID:         u.ID,
+			Email:      u.Email,
+			FullName:   u.FullName,
+			UserGroup:  u.UserGroup,
+			Status:     u.Status,
+			Attributes: u.Attributes,
+			CreatedAt:  u.CreatedAt,
+			DeletedAt:  u.DeletedAt,
+		},
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal user event data")
+
+		return
+	}
+
+	_, err = persistence.InsertOutboxEvent(ctx, m, db, types.OutboxEventModel{
+		EventType:   eventType,
+		AggregateID: u.ID,
+		Payload:     b,
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert outbox event")
+
+		return
+	}
+
+	return
+}
+
+func toEvent(e types.OutboxEventModel) types.Event {
+	event := types.Event{
+		ID:         e.ID,
+		Type:       e.EventType,
+		OccurredAt: e.CreatedAt,
+		Data:       e.Payload,
+	}
+
+	if e.StreamSeq != nil {
+		event.Sequence = *e.StreamSeq
+	}
+
+	return event
+}
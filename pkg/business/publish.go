@@ -0,0 +1,183 @@
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/publishing"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	publishBatchSize     = 100
+	publishTimeout       = 10 * time.Second
+	attributeEventID     = "event_id"
+	attributeEventType   = "event_type"
+	attributeAggregateID = "user_id"
+)
+
+// publishClaimTimeout outlasts publishing a whole batch, events claimed by a publisher that stopped are published again once it expires.
+const publishClaimTimeout = publishBatchSize*publishTimeout + time.Minute
+
+// PublishEvents claims a batch of events in a short transaction, publishes them without holding a transaction,
+// and marks them as published in a second one. It stops at the first failure, so that events of a user are never
+// published out of order, the claim of the events that weren't published is released to retry them.
+func PublishEvents(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, p publishing.Publisher) (n int, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"published_events_count", n,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to publish events")
+
+			l.Error(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	var es []types.OutboxEventModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		locked, err := persistence.TryLockOutboxPublishing(ctx, m, tx)
+		if err != nil {
+			err = errors.Wrap(err, "failed to lock outbox publishing")
+
+			return
+		}
+		if !locked {
+			return
+		}
+
+		es, err = persistence.ClaimUnpublishedOutboxEvents(ctx, m, tx, publishBatchSize, publishClaimTimeout)
+		if err != nil {
+			err = errors.Wrap(err, "failed to claim unpublished outbox events")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	var published, unpublished []int64
+	var publishErr error
+	for _, e := range es {
+		if publishErr == nil {
+			var msg publishing.Message
+			msg, publishErr = toMessage(e)
+			if publishErr == nil {
+				publishErr = publishWithTimeout(ctx, p, msg)
+			}
+			if publishErr != nil {
+				publishErr = errors.Wrapf(publishErr, "failed to publish outbox event %v", e.ID)
+			}
+		}
+
+		if publishErr != nil {
+			unpublished = append(unpublished, e.ID)
+
+			continue
+		}
+
+		published = append(published, e.ID)
+	}
+
+	if len(es) == 0 {
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		if len(published) > 0 {
+			err = persistence.UpdateOutboxEventsPublished(ctx, m, tx, published)
+			if err != nil {
+				err = errors.Wrap(err, "failed to mark outbox events as published")
+
+				return
+			}
+		}
+
+		if len(unpublished) > 0 {
+			err = persistence.ReleaseOutboxEventsPublishClaim(ctx, m, tx, unpublished)
+			if err != nil {
+				err = errors.Wrap(err, "failed to release outbox events publish claim")
+
+				return
+			}
+		}
+
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	n = len(published)
+
+	err = publishErr
+
+	return
+}
+
+func publishWithTimeout(ctx context.Context, p publishing.Publisher, msg publishing.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, publishTimeout)
+	defer cancel()
+
+	return p.Publish(ctx, msg)
+}
+
+func toMessage(e types.OutboxEventModel) (msg publishing.Message, err error) {
+	b, err := json.Marshal(toEvent(e))
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal event")
+
+		return
+	}
+
+	id := strconv.FormatInt(e.ID, 10)
+
+	msg = publishing.Message{
+		ID:          id,
+		OrderingKey: e.AggregateID,
+		Data:        b,
+		Attributes: map[string]string{
+			attributeEventID:     id,
+			attributeEventType:   e.EventType,
+			attributeAggregateID: e.AggregateID,
+		},
+	}
+
+	return
+}
+
+func PublishEventsPeriodically(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, p publishing.Publisher, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		for {
+			n, err := PublishEvents(ctx, m, db, p)
+			if err != nil || n < publishBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func RestoreUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, gracePeriod time.Duration, req types.RestoreUserRequest) (rsp types.RestoreUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to restore user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = persistence.RestoreUserById(ctx, m, tx, req.ID, gracePeriod)
+		if err != nil {
+			err = errors.Wrap(err, "failed to restore user")
+
+			return
+		}
+
+		before := u
+		before.Status = types.UserStatusDeactivated
+		u.DeletedAt = nil
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserRestored, u.ID, diffUsers(&before, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserRestored, u)
+		if err != nil {
+			err = errors.Wrap(err, "failed to enqueue user event")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		err = errors.Wrap(err, "failed to restore user")
+
+		rsp.Error = types.ErrorUserCanNotBeRestored
+		statusCode = http.StatusNotFound
+
+		return
+	case persistence.IsUniqueViolation(err):
+		err = errors.Wrap(err, "failed to restore user")
+
+		rsp.Error = types.ErrorEmailAlreadyExists
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case err != nil:
+		err = errors.Wrap(err, "failed to restore user")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.User = toUser(u)
+
+	return
+}
+
+func PurgeDeletedUsers(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, gracePeriod time.Duration) (err error) {
+	var ids []string
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"purged_users_count", len(ids),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to purge deleted users")
+
+			l.Error(err)
+		} else if len(ids) > 0 {
+			l.Info("purged deleted users")
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		ids, err = persistence.PurgeDeletedUsers(ctx, m, tx, gracePeriod)
+		if err != nil {
+			err = errors.Wrap(err, "failed to purge deleted users")
+
+			return
+		}
+
+		for _, id := range ids {
+			err = recordAuditEvent(ctx, m, tx, types.AuditActionUserPurged, id, nil)
+			if err != nil {
+				err = errors.Wrap(err, "failed to record audit event")
+
+				return
+			}
+		}
+
+		return
+	})
+	if err != nil {
+		ids = nil
+
+		return
+	}
+
+	return
+}
+
+func PurgeDeletedUsersPeriodically(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, interval time.Duration, gracePeriod time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		_ = PurgeDeletedUsers(ctx, m, db, gracePeriod)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+// Reauthenticate checks the password of the signed in user again, and returns a token with a fresh auth_time,
+// as required by the routes of types.RouteMaxAuthAgeSeconds. Failed attempts count towards the lockout.
+func Reauthenticate(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, hmacSecret string, authenticators AuthenticatorChain, lockoutThreshold int, sub string, req types.ReauthenticateRequest) (rsp types.ReauthenticateResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to reauthenticate")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	u, err := persistence.GetUserById(ctx, m, db, sub)
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	case err != nil:
+		err = errors.Wrap(err, "failed to get user")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	au, authenticator, err := authenticators.authenticate(ctx, m, db, u.Email, req.Password)
+	if err == nil && au.ID != u.ID {
+		err = errors.Wrapf(errInvalidCredentials, "failed as authenticator %v resolved another user", authenticator)
+	}
+	if err != nil && errors.Cause(err) != errInvalidCredentials {
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+	if err != nil {
+		recordErr := persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+			_, err = recordLoginAttempt(ctx, m, tx, u.ID, u.Email, types.LoginMethodReauthenticate, types.ErrorInvalidCredentials)
+			if err != nil {
+				return
+			}
+
+			return countFailedAuthentication(ctx, m, tx, u, lockoutThreshold)
+		})
+		if recordErr != nil {
+			err = errors.Wrapf(err, "failed to record failed authentication: %v", recordErr)
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+
+		rsp.Error = types.ErrorInvalidCredentials
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	err = checkUserActive(au)
+	if err != nil {
+		recordFailedLoginAttempt(ctx, m, db, au.ID, au.Email, types.LoginMethodReauthenticate, types.ErrorUserNotActive)
+
+		rsp.Error = types.ErrorUserNotActive
+		statusCode = http.StatusForbidden
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserReauthenticated, au.ID, map[string]types.AuditChange{
+			"authenticator": {After: authenticator},
+		})
+		if err != nil {
+			return
+		}
+
+		_, err = recordLoginAttempt(ctx, m, tx, au.ID, au.Email, types.LoginMethodReauthenticate, "")
+		if err != nil {
+			return
+		}
+
+		return persistence.ResetFailedAuthenticationsById(ctx, m, tx, au.ID)
+	})
+	if err != nil {
+		err = errors.Wrap(err, "failed to record audit event")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.AccessToken, err = GenerateAccessToken(hmacSecret, au.UserGroup, au.ID, au.TokenEpoch)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate access token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	return
+}
+
+// RequiresStepUp reports whether the claims are too old to access route, tokens without auth_time were
+// authenticated when they were issued.
+func RequiresStepUp(claims map[string]interface{}, route string, now time.Time) bool {
+	maxAge, ok := types.RouteMaxAuthAgeSeconds[route]
+	if !ok {
+		return false
+	}
+
+	authTime, ok := claims[types.ClaimAuthTime].(float64)
+	if !ok {
+		authTime, _ = claims[types.ClaimIat].(float64)
+	}
+
+	return now.Unix()-int64(authTime) > maxAge
+}
@@ -0,0 +1,57 @@
+// +build unit
+
+package business
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestRequiresStepUp(t *testing.T) {
+	now := time.Now()
+	maxAge := types.RouteMaxAuthAgeSeconds[types.RouteDeleteUser]
+
+	for _, tc := range []struct {
+		name     string
+		claims   map[string]interface{}
+		route    string
+		expected bool
+	}{
+		{
+			name:     "fresh auth_time",
+			claims:   map[string]interface{}{types.ClaimAuthTime: float64(now.Unix())},
+			route:    types.RouteDeleteUser,
+			expected: false,
+		},
+		{
+			name:     "stale auth_time",
+			claims:   map[string]interface{}{types.ClaimAuthTime: float64(now.Unix() - maxAge - 1)},
+			route:    types.RouteDeleteUser,
+			expected: true,
+		},
+		{
+			name:     "stale auth_time on a route without requirement",
+			claims:   map[string]interface{}{types.ClaimAuthTime: float64(now.Unix() - maxAge - 1)},
+			route:    types.RouteListUsers,
+			expected: false,
+		},
+		{
+			name:     "stale iat without auth_time",
+			claims:   map[string]interface{}{types.ClaimIat: float64(now.Unix() - maxAge - 1)},
+			route:    types.RouteDeleteUser,
+			expected: true,
+		},
+		{
+			name:     "no auth_time and no iat",
+			claims:   map[string]interface{}{},
+			route:    types.RouteDeleteUser,
+			expected: true,
+		},
+	} {
+		assert.Equal(t, tc.expected, RequiresStepUp(tc.claims, tc.route, now), tc.name)
+	}
+}
@@ -0,0 +1,484 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/crewjam/saml"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/mailing"
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	samlDefaultEmailAttribute = "email"
+	samlDefaultNameAttribute  = "displayName"
+)
+
+var errSamlAssertionReplayed = errors.New("saml assertion was presented before")
+
+type samlProvider struct {
+	name              string
+	emailAttribute    string
+	nameAttribute     string
+	domains           map[string]bool
+	linkExistingUsers bool
+	sp                *saml.ServiceProvider
+}
+
+// SamlProviders holds the upstream SAML identity providers, each with its own service provider entity.
+type SamlProviders struct {
+	providers map[string]*samlProvider
+}
+
+type samlIdentity struct {
+	Subject            string
+	Email              string
+	FullName           string
+	AssertionID        string
+	AssertionExpiresAt time.Time
+}
+
+func LoadSamlProviders(path string) (cs []types.SamlProvider, err error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = errors.Wrap(err, "failed to read saml providers file")
+
+		return
+	}
+
+	err = json.Unmarshal(b, &cs)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode saml providers file")
+
+		return
+	}
+
+	return
+}
+
+// NewSamlProviders reads the metadata of each identity provider, and fails if one can't be read.
+func NewSamlProviders(v *validator.Validate, cs []types.SamlProvider) (ps *SamlProviders, err error) {
+	ps = &SamlProviders{
+		providers: map[string]*samlProvider{},
+	}
+
+	for _, c := range cs {
+		err = v.Struct(&c)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to validate saml provider %s", c.Name)
+
+			return
+		}
+
+		_, ok := ps.providers[c.Name]
+		if ok {
+			err = errors.Errorf("failed to add saml provider %s: name is not unique", c.Name)
+
+			return
+		}
+
+		var b []byte
+		b, err = ioutil.ReadFile(c.IdpMetadataFile)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to read idp metadata of saml provider %s", c.Name)
+
+			return
+		}
+
+		var idpMetadata saml.EntityDescriptor
+		err = xml.Unmarshal(b, &idpMetadata)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to decode idp metadata of saml provider %s", c.Name)
+
+			return
+		}
+
+		if len(idpMetadata.IDPSSODescriptors) == 0 {
+			err = errors.Errorf("failed to add saml provider %s: idp metadata contains no IDPSSODescriptor", c.Name)
+
+			return
+		}
+
+		var acsURL *url.URL
+		acsURL, err = url.Parse(c.AcsURL)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to parse acs url of saml provider %s", c.Name)
+
+			return
+		}
+
+		p := &samlProvider{
+			name:              c.Name,
+			emailAttribute:    c.EmailAttribute,
+			nameAttribute:     c.NameAttribute,
+			domains:           map[string]bool{},
+			linkExistingUsers: c.LinkExistingUsers,
+			sp: &saml.ServiceProvider{
+				EntityID:          c.EntityID,
+				AcsURL:            *acsURL,
+				IDPMetadata:       &idpMetadata,
+				AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+				AllowIDPInitiated: true,
+			},
+		}
+
+		if p.emailAttribute == "" {
+			p.emailAttribute = samlDefaultEmailAttribute
+		}
+		if p.nameAttribute == "" {
+			p.nameAttribute = samlDefaultNameAttribute
+		}
+
+		for _, d := range c.Domains {
+			p.domains[strings.ToLower(d)] = true
+		}
+
+		ps.providers[c.Name] = p
+	}
+
+	return
+}
+
+func GetSamlMetadata(ctx context.Context, v *validator.Validate, ps *SamlProviders, req types.SamlMetadataRequest) (rsp types.SamlMetadataResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to get saml metadata")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	p, ok := ps.providers[req.Provider]
+	if !ok {
+		err = errors.Errorf("failed as saml provider %s doesn't exist", req.Provider)
+
+		rsp.Error = types.ErrorSamlProviderDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	// only the HTTP-POST binding is supported
+	ed := p.sp.Metadata()
+	for i := range ed.SPSSODescriptors {
+		var acs []saml.IndexedEndpoint
+		for _, e := range ed.SPSSODescriptors[i].AssertionConsumerServices {
+			if e.Binding == saml.HTTPPostBinding {
+				acs = append(acs, e)
+			}
+		}
+		ed.SPSSODescriptors[i].AssertionConsumerServices = acs
+	}
+
+	rsp.Metadata, err = xml.MarshalIndent(ed, "", "  ")
+	if err != nil {
+		err = errors.Wrap(err, "failed to encode metadata")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	return
+}
+
+// verifySamlResponse verifies the signature, issuer, destination, recipient, audience, and validity period of the
+// response. Replays are rejected by consumeSamlAssertion.
+func (ps *SamlProviders) verifySamlResponse(v *validator.Validate, p *samlProvider, samlResponse string) (si samlIdentity, rspError string, err error) {
+	rspError = types.ErrorInvalidSamlResponse
+
+	b, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		err = errors.Wrap(err, "failed to decode saml response")
+
+		return
+	}
+
+	assertion, err := p.sp.ParseXMLResponse(b, nil)
+	if err != nil {
+		ire, ok := err.(*saml.InvalidResponseError)
+		if ok {
+			err = ire.PrivateErr
+		}
+
+		err = errors.Wrap(err, "failed to verify saml response")
+
+		return
+	}
+
+	if assertion.Conditions == nil || len(assertion.Conditions.AudienceRestrictions) == 0 {
+		err = errors.New("failed as saml assertion has no audience restriction")
+
+		return
+	}
+
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		err = errors.New("failed as saml assertion has no name id")
+
+		return
+	}
+
+	si.AssertionID = assertion.ID
+	si.AssertionExpiresAt = assertion.IssueInstant.Add(saml.MaxIssueDelay)
+	si.Subject = assertion.Subject.NameID.Value
+	si.Email = samlAttribute(assertion, p.emailAttribute)
+	si.FullName = samlAttribute(assertion, p.nameAttribute)
+
+	if si.Email == "" && assertion.Subject.NameID.Format == string(saml.EmailAddressNameIDFormat) {
+		si.Email = assertion.Subject.NameID.Value
+	}
+
+	if v.Var(si.Email, "required,email") != nil {
+		rspError = types.ErrorSamlEmailMissing
+		err = errors.Errorf("failed as saml assertion contains no valid email in %s", p.emailAttribute)
+
+		return
+	}
+
+	if len(p.domains) > 0 && !p.domains[strings.ToLower(si.Email[strings.LastIndex(si.Email, "@")+1:])] {
+		rspError = types.ErrorSamlEmailDomainNotAllowed
+		err = errors.Errorf("failed as email %s isn't allowed for provider %s", si.Email, p.name)
+
+		return
+	}
+
+	rspError = ""
+
+	return
+}
+
+// consumeSamlAssertion fails if the assertion was consumed before. Consumed assertions are stored until they can no
+// longer be verified, so that replays are rejected by every instance.
+func consumeSamlAssertion(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, provider string, si samlIdentity) (err error) {
+	_, err = persistence.InsertSamlAssertionIfNew(ctx, m, db, types.SamlAssertionModel{
+		Provider:  provider,
+		ID:        si.AssertionID,
+		ExpiresAt: si.AssertionExpiresAt,
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		err = errors.Wrapf(errSamlAssertionReplayed, "failed as saml assertion %s was consumed before", si.AssertionID)
+
+		return
+	case err != nil:
+		err = errors.Wrap(err, "failed to insert saml assertion")
+
+		return
+	}
+
+	return
+}
+
+// DeleteExpiredSamlAssertionsPeriodically deletes consumed assertions that can no longer be verified.
+func DeleteExpiredSamlAssertionsPeriodically(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		n, err := persistence.DeleteExpiredSamlAssertions(ctx, m, db)
+		if err != nil {
+			ctxutil.GetContextLogger(ctx).Error(errors.Wrap(err, "failed to delete expired saml assertions"))
+		} else if n > 0 {
+			ctxutil.GetContextLogger(ctx).With("deleted_saml_assertions_count", n).Info("deleted expired saml assertions")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func samlAttribute(assertion *saml.Assertion, name string) string {
+	for _, s := range assertion.AttributeStatements {
+		for _, a := range s.Attributes {
+			if (a.Name == name || a.FriendlyName == name) && len(a.Values) > 0 {
+				return strings.TrimSpace(a.Values[0].Value)
+			}
+		}
+	}
+
+	return ""
+}
+
+// FinishSamlLogin signs in the user linked to the name id of the assertion, linking users like FinishOidcLogin.
+func FinishSamlLogin(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, ps *SamlProviders, hmacSecret string, allowedSubjectSuffix string, argonOpts Argon2IdOpts, mailer mailing.Mailer, req types.SamlAcsRequest) (rsp types.SamlAcsResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to finish saml login")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	p, ok := ps.providers[req.Provider]
+	if !ok {
+		err = errors.Errorf("failed as saml provider %s doesn't exist", req.Provider)
+
+		rsp.Error = types.ErrorSamlProviderDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	}
+
+	si, rspError, err := ps.verifySamlResponse(v, p, req.SamlResponse)
+	if err != nil {
+		err = errors.Wrap(err, "failed to verify saml response")
+
+		recordFailedLoginAttempt(ctx, m, db, "", "", types.LoginMethodSaml, rspError)
+
+		rsp.Error = rspError
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	// the email domain of a provider with domains was verified to be one of them
+	var u types.UserModel
+	var d types.LoginDeviceModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		err = consumeSamlAssertion(ctx, m, tx, p.name, si)
+		if err != nil {
+			err = errors.Wrap(err, "failed to consume saml assertion")
+
+			return
+		}
+
+		u, err = signInExternalIdentity(ctx, m, tx, externalIdentity{Provider: p.name, Subject: si.Subject, Email: si.Email, FullName: si.FullName, LinkExisting: p.linkExistingUsers || len(p.domains) > 0}, allowedSubjectSuffix, argonOpts)
+		if err != nil {
+			err = errors.Wrap(err, "failed to sign in user identity")
+
+			return
+		}
+
+		err = recordAuditEvent(ctxutil.WithSubject(ctx, u.ID), m, tx, types.AuditActionUserAuthenticated, u.ID, map[string]types.AuditChange{
+			"provider": {After: p.name},
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		d, err = recordLoginAttempt(ctx, m, tx, u.ID, u.Email, types.LoginMethodSaml, "")
+		if err != nil {
+			err = errors.Wrap(err, "failed to record login attempt")
+
+			return
+		}
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == errSamlAssertionReplayed:
+		recordFailedLoginAttempt(ctx, m, db, "", si.Email, types.LoginMethodSaml, types.ErrorInvalidSamlResponse)
+
+		rsp.Error = types.ErrorInvalidSamlResponse
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case errors.Cause(err) == sql.ErrNoRows:
+		recordFailedLoginAttempt(ctx, m, db, "", si.Email, types.LoginMethodSaml, types.ErrorInvalidCredentials)
+
+		rsp.Error = types.ErrorInvalidCredentials
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	case errors.Cause(err) == errUserNotActive:
+		recordFailedLoginAttempt(ctx, m, db, u.ID, u.Email, types.LoginMethodSaml, types.ErrorUserNotActive)
+
+		rsp.Error = types.ErrorUserNotActive
+		statusCode = http.StatusForbidden
+
+		return
+	case errors.Cause(err) == errIdentityNotLinkable:
+		recordFailedLoginAttempt(ctx, m, db, "", si.Email, types.LoginMethodSaml, types.ErrorIdentityNotLinkable)
+
+		rsp.Error = types.ErrorIdentityNotLinkable
+		statusCode = http.StatusConflict
+
+		return
+	case persistence.IsUniqueViolation(err):
+		recordFailedLoginAttempt(ctx, m, db, "", si.Email, types.LoginMethodSaml, types.ErrorEmailAlreadyExists)
+
+		rsp.Error = types.ErrorEmailAlreadyExists
+		statusCode = http.StatusConflict
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.AccessToken, err = GenerateAccessToken(hmacSecret, u.UserGroup, u.ID, u.TokenEpoch)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate access token")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	notifyLoginDevice(ctx, mailer, u, d)
+
+	return
+}
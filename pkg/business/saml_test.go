@@ -0,0 +1,168 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+	"github.com/ppwfx/user-svc/pkg/utils/samlutil"
+)
+
+const (
+	samlTestEntityID = "http://user-svc.test/api/v0/samlMetadata?provider=corp"
+	samlTestAcsURL   = "http://user-svc.test/api/v0/samlAcs?provider=corp"
+)
+
+func newSamlTestProviders(t *testing.T) (ctx context.Context, mock *samlutil.MockIdentityProvider, ps *SamlProviders, ok bool) {
+	ctx = ctxutil.WithContextLogger(context.Background(), zap.NewNop().Sugar())
+
+	mock, err := samlutil.NewMockIdentityProvider("http://idp.test/metadata")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	b, err := mock.Metadata()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	f, err := ioutil.TempFile("", "saml-idp-*.xml")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	f.Close()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ps, err = NewSamlProviders(validator.New(), []types.SamlProvider{{
+		Name:            "corp",
+		IdpMetadataFile: f.Name(),
+		EntityID:        samlTestEntityID,
+		AcsURL:          samlTestAcsURL,
+		Domains:         []string{"corp.example.com"},
+	}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ok = true
+
+	return
+}
+
+func TestGetSamlMetadata(t *testing.T) {
+	ctx, _, ps, ok := newSamlTestProviders(t)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+
+	rsp, statusCode := GetSamlMetadata(ctx, v, ps, types.SamlMetadataRequest{Provider: "corp"})
+	if !assert.Equal(t, http.StatusOK, statusCode) {
+		return
+	}
+
+	metadata := string(rsp.Metadata)
+	assert.Contains(t, metadata, `entityID="`+strings.Replace(samlTestEntityID, "&", "&amp;", -1)+`"`)
+	assert.Contains(t, metadata, `Location="`+samlTestAcsURL+`"`)
+	assert.Contains(t, metadata, `Binding="`+"urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"+`"`)
+	assert.NotContains(t, metadata, "HTTP-Artifact")
+
+	_, statusCode = GetSamlMetadata(ctx, v, ps, types.SamlMetadataRequest{Provider: "other"})
+	assert.Equal(t, http.StatusNotFound, statusCode)
+
+	_, statusCode = GetSamlMetadata(ctx, v, ps, types.SamlMetadataRequest{})
+	assert.Equal(t, http.StatusUnprocessableEntity, statusCode)
+}
+
+func TestVerifySamlResponse(t *testing.T) {
+	_, mock, ps, ok := newSamlTestProviders(t)
+	if !ok {
+		return
+	}
+
+	v := validator.New()
+	p := ps.providers["corp"]
+
+	john := samlutil.MockAssertion{
+		ID:         "id-john",
+		Audience:   samlTestEntityID,
+		Recipient:  samlTestAcsURL,
+		NameID:     "1234",
+		Attributes: map[string]string{"email": "john@corp.example.com", "displayName": "John Doe"},
+	}
+
+	samlResponse, err := mock.MakeResponse(john)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	si, _, err := ps.verifySamlResponse(v, p, samlResponse)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "id-john", si.AssertionID)
+	assert.True(t, si.AssertionExpiresAt.After(time.Now()))
+	si.AssertionID, si.AssertionExpiresAt = "", time.Time{}
+	assert.Equal(t, samlIdentity{Subject: "1234", Email: "john@corp.example.com", FullName: "John Doe"}, si)
+
+	for _, tc := range []struct {
+		name     string
+		modify   func(a *samlutil.MockAssertion)
+		rspError string
+	}{
+		{name: "audience mismatch", modify: func(a *samlutil.MockAssertion) { a.Audience = "http://other.test" }, rspError: types.ErrorInvalidSamlResponse},
+		{name: "missing audience", modify: func(a *samlutil.MockAssertion) { a.Audience = "" }, rspError: types.ErrorInvalidSamlResponse},
+		{name: "recipient mismatch", modify: func(a *samlutil.MockAssertion) { a.Recipient = "http://other.test/acs" }, rspError: types.ErrorInvalidSamlResponse},
+		{name: "tampered", modify: func(a *samlutil.MockAssertion) { a.Tamper = true }, rspError: types.ErrorInvalidSamlResponse},
+		{name: "missing email", modify: func(a *samlutil.MockAssertion) { a.Attributes = map[string]string{"displayName": "John Doe"} }, rspError: types.ErrorSamlEmailMissing},
+		{name: "foreign domain", modify: func(a *samlutil.MockAssertion) { a.Attributes = map[string]string{"email": "john@example.com"} }, rspError: types.ErrorSamlEmailDomainNotAllowed},
+	} {
+		a := john
+		a.ID = ""
+		tc.modify(&a)
+
+		samlResponse, err := mock.MakeResponse(a)
+		if !assert.NoError(t, err, tc.name) {
+			return
+		}
+
+		_, rspError, err := ps.verifySamlResponse(v, p, samlResponse)
+		assert.Error(t, err, tc.name)
+		assert.Equal(t, tc.rspError, rspError, tc.name)
+	}
+
+	other, err := samlutil.NewMockIdentityProvider("http://idp.test/metadata")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	samlResponse, err = other.MakeResponse(samlutil.MockAssertion{Audience: samlTestEntityID, Recipient: samlTestAcsURL, NameID: "1234", Attributes: john.Attributes})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, _, err = ps.verifySamlResponse(v, p, samlResponse)
+	assert.Error(t, err, "responses signed by another key are rejected")
+
+	_, _, err = ps.verifySamlResponse(v, p, base64.StdEncoding.EncodeToString([]byte("<Response/>")))
+	assert.Error(t, err)
+}
@@ -0,0 +1,1339 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	scimOpAdd              = "add"
+	scimOpReplace          = "replace"
+	scimOpRemove           = "remove"
+	scimRandomPasswordSize = 32
+)
+
+var (
+	scimGroups          = []string{types.UserGroupUser, types.UserGroupAdmin}
+	scimFilterRegexp    = regexp.MustCompile(`^\s*([A-Za-z.]+)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*$`)
+	scimMemberPathRegex = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*\]$`)
+	scimEmailPathRegex  = regexp.MustCompile(`^(?i:emails)\[\s*(?i:type)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*\]\.(?i:value)$`)
+)
+
+type scimErr struct {
+	*types.ScimError
+}
+
+func (e scimErr) Error() string {
+	return e.Detail
+}
+
+func newScimError(status int, scimType string, detail string) *types.ScimError {
+	return &types.ScimError{
+		Schemas:  []string{types.ScimSchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+func scimErrorResult(err error) (serr *types.ScimError, statusCode int) {
+	se, ok := errors.Cause(err).(scimErr)
+	switch {
+	case ok:
+		serr = se.ScimError
+	case errors.Cause(err) == sql.ErrNoRows:
+		serr = newScimError(http.StatusNotFound, "", types.ErrorUserDoesNotExist)
+	case persistence.IsUniqueViolation(err):
+		serr = newScimError(http.StatusConflict, types.ScimTypeUniqueness, types.ErrorEmailAlreadyExists)
+	default:
+		serr = newScimError(http.StatusInternalServerError, "", types.ErrorInternalError)
+	}
+
+	statusCode, _ = strconv.Atoi(serr.Status)
+
+	return
+}
+
+func parseScimFilter(filter string, attribute string) (value string, err error) {
+	ms := scimFilterRegexp.FindStringSubmatch(filter)
+	if ms == nil || !strings.EqualFold(ms[1], attribute) {
+		err = scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidFilter, fmt.Sprintf("only %s eq \"value\" filters are supported", attribute))}
+
+		return
+	}
+
+	err = json.Unmarshal([]byte(ms[2]), &value)
+	if err != nil {
+		err = scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidFilter, "filter value is not a valid string")}
+
+		return
+	}
+
+	return
+}
+
+func scimFullName(su types.ScimUser) string {
+	switch {
+	case su.DisplayName != "":
+		return su.DisplayName
+	case su.Name != nil && su.Name.Formatted != "":
+		return su.Name.Formatted
+	case su.Name != nil && strings.TrimSpace(su.Name.GivenName+" "+su.Name.FamilyName) != "":
+		return strings.TrimSpace(su.Name.GivenName + " " + su.Name.FamilyName)
+	}
+
+	return su.UserName
+}
+
+// scimEmail returns the primary email, or else the work email, or else the first email.
+func scimEmail(es []types.ScimEmail) string {
+	for _, e := range es {
+		if e.Primary {
+			return e.Value
+		}
+	}
+
+	for _, e := range es {
+		if strings.EqualFold(e.Type, "work") {
+			return e.Value
+		}
+	}
+
+	if len(es) > 0 {
+		return es[0].Value
+	}
+
+	return ""
+}
+
+// splitFullName splits a fullname into the given name, its first word, and the family name, the remaining words.
+func splitFullName(fullName string) (givenName string, familyName string) {
+	fs := strings.Fields(fullName)
+	if len(fs) == 0 {
+		return
+	}
+
+	return fs[0], strings.Join(fs[1:], " ")
+}
+
+// isScimActive reports whether the user is provisioned, pending and deactivated users are provisioned inactive,
+// the other statuses are managed by admins, and don't concern the identity provider.
+func isScimActive(u types.UserModel) bool {
+	return u.DeletedAt == nil && u.Status != types.UserStatusPending && u.Status != types.UserStatusDeactivated
+}
+
+func toScimUser(u types.UserModel) types.ScimUser {
+	active := isScimActive(u)
+	createdAt := u.CreatedAt.UTC()
+	updatedAt := u.UpdatedAt.UTC()
+
+	return types.ScimUser{
+		Schemas:     []string{types.ScimSchemaUser},
+		ID:          u.ID,
+		ExternalID:  u.ExternalID,
+		UserName:    u.Email,
+		Name:        &types.ScimName{Formatted: u.FullName},
+		DisplayName: u.FullName,
+		Emails:      []types.ScimEmail{{Value: u.Email, Type: "work", Primary: true}},
+		Active:      &active,
+		Groups:      []types.ScimGroupRef{{Value: u.UserGroup, Ref: types.RouteScimGroups + "/" + u.UserGroup, Display: u.UserGroup}},
+		Meta: &types.ScimMeta{
+			ResourceType: types.ScimResourceTypeUser,
+			Created:      &createdAt,
+			LastModified: &updatedAt,
+			Location:     types.RouteScimUsers + "/" + u.ID,
+			Version:      fmt.Sprintf("W/%q", formatVersion(u.UpdatedAt)),
+		},
+	}
+}
+
+func toScimGroup(group string, members []types.UserModel, withMembers bool) types.ScimGroup {
+	g := types.ScimGroup{
+		Schemas:     []string{types.ScimSchemaGroup},
+		ID:          group,
+		DisplayName: group,
+		Meta: &types.ScimMeta{
+			ResourceType: types.ScimResourceTypeGroup,
+			Location:     types.RouteScimGroups + "/" + group,
+		},
+	}
+
+	if withMembers {
+		g.Members = []types.ScimMember{}
+		for _, u := range members {
+			g.Members = append(g.Members, types.ScimMember{Value: u.ID, Ref: types.RouteScimUsers + "/" + u.ID, Display: u.Email})
+		}
+	}
+
+	return g
+}
+
+func isScimGroup(id string) bool {
+	for _, g := range scimGroups {
+		if g == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func scimPaging(req types.ScimListRequest) (offset int, limit int) {
+	offset = req.StartIndex - 1
+	if offset < 0 {
+		offset = 0
+	}
+
+	limit = req.Count
+	if limit < 0 {
+		limit = 0
+	}
+	if limit > types.ScimMaxCount {
+		limit = types.ScimMaxCount
+	}
+
+	return
+}
+
+type scimUserState struct {
+	Email      string
+	FullName   string
+	ExternalID string
+	Active     bool
+	Password   string
+}
+
+// scimAttributePath strips the user schema from a fully qualified attribute path.
+func scimAttributePath(path string) string {
+	prefix := types.ScimSchemaUser + ":"
+	if len(path) > len(prefix) && strings.EqualFold(path[:len(prefix)], prefix) {
+		return path[len(prefix):]
+	}
+
+	return path
+}
+
+func (s *scimUserState) set(path string, raw json.RawMessage) (err error) {
+	invalid := func(err error) error {
+		return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, errors.Wrapf(err, "failed to decode %s", path).Error())}
+	}
+
+	path = scimAttributePath(path)
+
+	if ms := scimEmailPathRegex.FindStringSubmatch(path); ms != nil {
+		var typ string
+		err = json.Unmarshal([]byte(ms[1]), &typ)
+		if err != nil || !strings.EqualFold(typ, "work") {
+			return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidPath, fmt.Sprintf("unsupported path %s, only the work email can be set", path))}
+		}
+
+		err = json.Unmarshal(raw, &s.Email)
+		if err != nil {
+			return invalid(err)
+		}
+
+		return
+	}
+
+	switch strings.ToLower(path) {
+	case "active":
+		var b interface{}
+		err = json.Unmarshal(raw, &b)
+		if err != nil {
+			return invalid(err)
+		}
+
+		switch v := b.(type) {
+		case bool:
+			s.Active = v
+		case string:
+			s.Active, err = strconv.ParseBool(v)
+			if err != nil {
+				return invalid(err)
+			}
+		default:
+			return invalid(errors.New("expected a boolean"))
+		}
+	case "username":
+		err = json.Unmarshal(raw, &s.Email)
+		if err != nil {
+			return invalid(err)
+		}
+	case "displayname", "name.formatted":
+		err = json.Unmarshal(raw, &s.FullName)
+		if err != nil {
+			return invalid(err)
+		}
+	case "name":
+		var n types.ScimName
+		err = json.Unmarshal(raw, &n)
+		if err != nil {
+			return invalid(err)
+		}
+
+		s.FullName = scimFullName(types.ScimUser{Name: &n, UserName: s.FullName})
+	case "name.givenname", "name.familyname":
+		var name string
+		err = json.Unmarshal(raw, &name)
+		if err != nil {
+			return invalid(err)
+		}
+
+		givenName, familyName := splitFullName(s.FullName)
+		if strings.EqualFold(path, "name.givenName") {
+			givenName = name
+		} else {
+			familyName = name
+		}
+
+		s.FullName = strings.TrimSpace(strings.TrimSpace(givenName) + " " + strings.TrimSpace(familyName))
+	case "emails":
+		var es []types.ScimEmail
+		err = json.Unmarshal(raw, &es)
+		if err != nil {
+			return invalid(err)
+		}
+
+		s.Email = scimEmail(es)
+	case "externalid":
+		err = json.Unmarshal(raw, &s.ExternalID)
+		if err != nil {
+			return invalid(err)
+		}
+	case "password":
+		err = json.Unmarshal(raw, &s.Password)
+		if err != nil {
+			return invalid(err)
+		}
+	default:
+		return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidPath, fmt.Sprintf("unsupported path %s", path))}
+	}
+
+	return
+}
+
+func (s *scimUserState) patch(ops []types.ScimPatchOperation) (err error) {
+	for _, op := range ops {
+		switch strings.ToLower(op.Op) {
+		case scimOpAdd, scimOpReplace:
+			if op.Path != "" {
+				err = s.set(op.Path, op.Value)
+				if err != nil {
+					return
+				}
+
+				continue
+			}
+
+			var attrs map[string]json.RawMessage
+			err = json.Unmarshal(op.Value, &attrs)
+			if err != nil {
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, "value of an operation without path has to be an object")}
+			}
+
+			for k, v := range attrs {
+				err = s.set(k, v)
+				if err != nil {
+					return
+				}
+			}
+		case scimOpRemove:
+			path := scimAttributePath(op.Path)
+
+			switch {
+			case strings.EqualFold(path, "externalId"):
+				s.ExternalID = ""
+			case scimEmailPathRegex.MatchString(path):
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeMutability, fmt.Sprintf("%s can not be removed", op.Path))}
+			default:
+				switch strings.ToLower(path) {
+				case "username", "displayname", "name", "name.formatted", "name.givenname", "name.familyname", "emails", "active", "password":
+					return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeMutability, fmt.Sprintf("%s can not be removed", op.Path))}
+				default:
+					return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidPath, fmt.Sprintf("unsupported path %s", op.Path))}
+				}
+			}
+		default:
+			return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidSyntax, fmt.Sprintf("unsupported operation %s", op.Op))}
+		}
+	}
+
+	return
+}
+
+func (s scimUserState) validate(v *validator.Validate) (err error) {
+	err = v.Var(s.Email, "required,email")
+	if err != nil {
+		return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, errors.Wrap(err, "failed to validate userName").Error())}
+	}
+
+	err = v.Var(s.FullName, "required")
+	if err != nil {
+		return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, errors.Wrap(err, "failed to validate displayName").Error())}
+	}
+
+	return
+}
+
+func hashPassword(argonOpts Argon2IdOpts, password string) (hash string, err error) {
+	salt, err := generateRandomBytes(argonOpts.SaltLength)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate random salt")
+
+		return
+	}
+
+	hash = hashSecret(salt, password, argonOpts)
+
+	return
+}
+
+func ScimListUsers(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, req types.ScimListRequest) (rsp types.ScimListUsersResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"total_results", rsp.List.TotalResults,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list scim users")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	q := types.ScimUsersQuery{}
+	q.Offset, q.Limit = scimPaging(req)
+
+	if req.Filter != "" {
+		q.Email, err = parseScimFilter(req.Filter, "userName")
+		if err != nil {
+			rsp.Error, statusCode = scimErrorResult(err)
+
+			return
+		}
+	}
+
+	us, total, err := persistence.SelectAnyUsers(ctx, m, db, q)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select users")
+
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	resources := []types.ScimUser{}
+	for _, u := range us {
+		resources = append(resources, toScimUser(u))
+	}
+
+	rsp.List = types.ScimListResponse{
+		Schemas:      []string{types.ScimSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   q.Offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+
+	return
+}
+
+func ScimGetUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, id string) (rsp types.ScimUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to get scim user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	if v.Var(id, "uuid") != nil {
+		err = sql.ErrNoRows
+
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	u, err := persistence.GetAnyUserById(ctx, m, db, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	rsp.User = toScimUser(u)
+
+	return
+}
+
+func ScimCreateUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, argonOpts Argon2IdOpts, v *validator.Validate, allowedSubjectSuffix string, req types.ScimUser) (rsp types.ScimUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to create scim user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusCreated
+
+	s := scimUserState{
+		Email:      req.UserName,
+		FullName:   scimFullName(req),
+		ExternalID: req.ExternalID,
+		Active:     req.Active == nil || *req.Active,
+		Password:   req.Password,
+	}
+
+	err = s.validate(v)
+	if err != nil {
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	if s.Password == "" {
+		var b []byte
+		b, err = generateRandomBytes(scimRandomPasswordSize)
+		if err != nil {
+			err = errors.Wrap(err, "failed to generate random password")
+
+			rsp.Error, statusCode = scimErrorResult(err)
+
+			return
+		}
+
+		s.Password = base64.RawURLEncoding.EncodeToString(b)
+	}
+
+	hash, err := hashPassword(argonOpts, s.Password)
+	if err != nil {
+		err = errors.Wrap(err, "failed to hash password")
+
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	group := types.UserGroupUser
+	if strings.HasSuffix(s.Email, allowedSubjectSuffix) {
+		group = types.UserGroupAdmin
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = persistence.InsertUser(ctx, m, tx, types.UserModel{
+			Email:      s.Email,
+			Password:   hash,
+			FullName:   s.FullName,
+			UserGroup:  group,
+			ExternalID: s.ExternalID,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert user into database")
+
+			return
+		}
+
+		if !s.Active {
+			u, err = persistence.UpdateUserStatusById(ctx, m, tx, u.ID, types.UserStatusPending, "")
+			if err != nil {
+				err = errors.Wrap(err, "failed to update user status")
+
+				return
+			}
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserCreated, u.ID, diffUsers(nil, &u))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserCreated, u)
+		if err != nil {
+			err = errors.Wrap(err, "failed to enqueue user event")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	rsp.User = toScimUser(u)
+
+	return
+}
+
+func ScimReplaceUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, argonOpts Argon2IdOpts, v *validator.Validate, gracePeriod time.Duration, id string, req types.ScimUser) (rsp types.ScimUserResponse, statusCode int) {
+	return scimUpdateUser(ctx, m, db, argonOpts, v, gracePeriod, id, func(s *scimUserState) error {
+		*s = scimUserState{
+			Email:      req.UserName,
+			FullName:   scimFullName(req),
+			ExternalID: req.ExternalID,
+			Active:     req.Active == nil || *req.Active,
+			Password:   req.Password,
+		}
+
+		return nil
+	})
+}
+
+func ScimPatchUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, argonOpts Argon2IdOpts, v *validator.Validate, gracePeriod time.Duration, id string, req types.ScimPatchRequest) (rsp types.ScimUserResponse, statusCode int) {
+	err := v.Struct(&req)
+	if err != nil {
+		rsp.Error, statusCode = scimErrorResult(scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidSyntax, errors.Wrap(err, "failed to validate the request").Error())})
+
+		return
+	}
+
+	return scimUpdateUser(ctx, m, db, argonOpts, v, gracePeriod, id, func(s *scimUserState) error {
+		return s.patch(req.Operations)
+	})
+}
+
+func scimUpdateUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, argonOpts Argon2IdOpts, v *validator.Validate, gracePeriod time.Duration, id string, apply func(s *scimUserState) error) (rsp types.ScimUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to update scim user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	if v.Var(id, "uuid") != nil {
+		err = sql.ErrNoRows
+
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		before, err := persistence.GetAnyUserByIdForUpdate(ctx, m, tx, id)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get user")
+
+			return
+		}
+
+		s := scimUserState{
+			Email:      before.Email,
+			FullName:   before.FullName,
+			ExternalID: before.ExternalID,
+			Active:     isScimActive(before),
+		}
+
+		err = apply(&s)
+		if err != nil {
+			return
+		}
+
+		err = s.validate(v)
+		if err != nil {
+			return
+		}
+
+		u = before
+
+		if s.Active && u.DeletedAt != nil {
+			u, err = persistence.RestoreUserById(ctx, m, tx, id, gracePeriod)
+			if err != nil {
+				err = errors.Wrap(err, "failed to restore user")
+
+				return
+			}
+
+			restored := u
+			restored.DeletedAt = nil
+			u.Status = types.UserStatusDeactivated
+
+			err = recordAuditEvent(ctx, m, tx, types.AuditActionUserRestored, u.ID, diffUsers(&u, &restored))
+			if err != nil {
+				err = errors.Wrap(err, "failed to record audit event")
+
+				return
+			}
+
+			err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserRestored, restored)
+			if err != nil {
+				err = errors.Wrap(err, "failed to enqueue user event")
+
+				return
+			}
+
+			u = restored
+		}
+
+		if s.Email != u.Email || s.FullName != u.FullName || s.ExternalID != u.ExternalID || s.Password != "" {
+			updated := u
+			updated.Email = s.Email
+			updated.FullName = s.FullName
+			updated.ExternalID = s.ExternalID
+
+			if s.Password != "" {
+				updated.Password, err = hashPassword(argonOpts, s.Password)
+				if err != nil {
+					err = errors.Wrap(err, "failed to hash password")
+
+					return
+				}
+			}
+
+			updated, err = persistence.UpdateAnyUserById(ctx, m, tx, updated)
+			if err != nil {
+				err = errors.Wrap(err, "failed to update user")
+
+				return
+			}
+
+			if s.Email != u.Email || s.FullName != u.FullName || s.ExternalID != u.ExternalID {
+				err = recordAuditEvent(ctx, m, tx, types.AuditActionUserUpdated, u.ID, diffUsers(&u, &updated))
+				if err != nil {
+					err = errors.Wrap(err, "failed to record audit event")
+
+					return
+				}
+			}
+
+			if s.Password != "" {
+				err = recordAuditEvent(ctx, m, tx, types.AuditActionUserPasswordChanged, u.ID, map[string]types.AuditChange{
+					"password": {Before: auditRedacted, After: auditRedacted},
+				})
+				if err != nil {
+					err = errors.Wrap(err, "failed to record audit event")
+
+					return
+				}
+			}
+
+			u = updated
+		}
+
+		switch {
+		case s.Active && u.DeletedAt == nil && !isScimActive(u):
+			u, err = changeUserStatus(ctx, m, tx, u.ID, types.UserStatusActive, "", types.AuditActionUserReactivated)
+			if err != nil {
+				err = errors.Wrap(err, "failed to activate user")
+
+				return
+			}
+		case !s.Active && isScimActive(u):
+			u, err = changeUserStatus(ctx, m, tx, u.ID, types.UserStatusDeactivated, "", types.AuditActionUserDeactivated)
+			if err != nil {
+				err = errors.Wrap(err, "failed to deactivate user")
+
+				return
+			}
+		}
+
+		return
+	})
+	if err != nil {
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	rsp.User = toScimUser(u)
+
+	return
+}
+
+func ScimDeleteUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, id string) (rsp types.ScimDeleteResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to delete scim user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusNoContent
+
+	if v.Var(id, "uuid") != nil {
+		err = sql.ErrNoRows
+
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err := persistence.PurgeUserById(ctx, m, tx, id)
+		if err != nil {
+			err = errors.Wrap(err, "failed to purge user")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionUserPurged, u.ID, nil)
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		if u.DeletedAt == nil {
+			now := time.Now().UTC()
+			u.DeletedAt = &now
+
+			err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserDeleted, u)
+			if err != nil {
+				err = errors.Wrap(err, "failed to enqueue user event")
+
+				return
+			}
+		}
+
+		return
+	})
+	if err != nil {
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	return
+}
+
+func ScimListGroups(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, req types.ScimListRequest) (rsp types.ScimListGroupsResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list scim groups")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	groups := scimGroups
+	if req.Filter != "" {
+		var name string
+		name, err = parseScimFilter(req.Filter, "displayName")
+		if err != nil {
+			rsp.Error, statusCode = scimErrorResult(err)
+
+			return
+		}
+
+		groups = nil
+		if isScimGroup(name) {
+			groups = []string{name}
+		}
+	}
+
+	offset, limit := scimPaging(req)
+
+	resources := []types.ScimGroup{}
+	for i, g := range groups {
+		if i < offset || len(resources) >= limit {
+			continue
+		}
+
+		withMembers := req.ExcludedAttributes != "members"
+
+		var members []types.UserModel
+		if withMembers {
+			members, _, err = persistence.SelectAnyUsers(ctx, m, db, types.ScimUsersQuery{UserGroup: g, Active: true, Limit: -1})
+			if err != nil {
+				err = errors.Wrap(err, "failed to select group members")
+
+				rsp.Error, statusCode = scimErrorResult(err)
+
+				return
+			}
+		}
+
+		resources = append(resources, toScimGroup(g, members, withMembers))
+	}
+
+	rsp.List = types.ScimListResponse{
+		Schemas:      []string{types.ScimSchemaListResponse},
+		TotalResults: len(groups),
+		StartIndex:   offset + 1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+
+	return
+}
+
+func ScimGetGroup(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, id string, req types.ScimListRequest) (rsp types.ScimGroupResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to get scim group")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	if !isScimGroup(id) {
+		err = errors.Errorf("failed to find group %s", id)
+
+		rsp.Error, statusCode = scimErrorResult(scimErr{newScimError(http.StatusNotFound, "", types.ErrorGroupDoesNotExist)})
+
+		return
+	}
+
+	withMembers := req.ExcludedAttributes != "members"
+
+	var members []types.UserModel
+	if withMembers {
+		members, _, err = persistence.SelectAnyUsers(ctx, m, db, types.ScimUsersQuery{UserGroup: id, Active: true, Limit: -1})
+		if err != nil {
+			err = errors.Wrap(err, "failed to select group members")
+
+			rsp.Error, statusCode = scimErrorResult(err)
+
+			return
+		}
+	}
+
+	rsp.Group = toScimGroup(id, members, withMembers)
+
+	return
+}
+
+func ScimReplaceGroup(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, id string, req types.ScimGroup) (rsp types.ScimGroupResponse, statusCode int) {
+	return scimUpdateGroup(ctx, m, db, v, id, func(members map[string]bool) (err error) {
+		if req.DisplayName != "" && req.DisplayName != id {
+			return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeMutability, "displayName can not be changed")}
+		}
+
+		for k := range members {
+			delete(members, k)
+		}
+		for _, mb := range req.Members {
+			members[mb.Value] = true
+		}
+
+		return
+	})
+}
+
+func ScimPatchGroup(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, id string, req types.ScimPatchRequest) (rsp types.ScimGroupResponse, statusCode int) {
+	err := v.Struct(&req)
+	if err != nil {
+		rsp.Error, statusCode = scimErrorResult(scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidSyntax, errors.Wrap(err, "failed to validate the request").Error())})
+
+		return
+	}
+
+	return scimUpdateGroup(ctx, m, db, v, id, func(members map[string]bool) (err error) {
+		return patchScimGroupMembers(id, members, req.Operations)
+	})
+}
+
+func patchScimGroupMembers(id string, members map[string]bool, ops []types.ScimPatchOperation) (err error) {
+	decodeMembers := func(raw json.RawMessage) (ids []string, err error) {
+		var ms []types.ScimMember
+		err = json.Unmarshal(raw, &ms)
+		if err != nil {
+			err = scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, "members have to be a list of objects with a value")}
+
+			return
+		}
+
+		for _, mb := range ms {
+			ids = append(ids, mb.Value)
+		}
+
+		return
+	}
+
+	for _, op := range ops {
+		opName := strings.ToLower(op.Op)
+		path := op.Path
+
+		if path == "" && opName != scimOpRemove {
+			var attrs map[string]json.RawMessage
+			err = json.Unmarshal(op.Value, &attrs)
+			if err != nil {
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, "value of an operation without path has to be an object")}
+			}
+
+			for k, raw := range attrs {
+				err = patchScimGroupMembers(id, members, []types.ScimPatchOperation{{Op: op.Op, Path: k, Value: raw}})
+				if err != nil {
+					return
+				}
+			}
+
+			continue
+		}
+
+		switch {
+		case strings.EqualFold(path, "displayName"):
+			var name string
+			_ = json.Unmarshal(op.Value, &name)
+			if opName == scimOpRemove || name != id {
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeMutability, "displayName can not be changed")}
+			}
+		case strings.EqualFold(path, "members"):
+			var ids []string
+			if len(op.Value) > 0 {
+				ids, err = decodeMembers(op.Value)
+				if err != nil {
+					return
+				}
+			}
+
+			switch opName {
+			case scimOpAdd:
+				for _, mid := range ids {
+					members[mid] = true
+				}
+			case scimOpReplace:
+				for k := range members {
+					delete(members, k)
+				}
+				for _, mid := range ids {
+					members[mid] = true
+				}
+			case scimOpRemove:
+				if len(op.Value) == 0 {
+					for k := range members {
+						delete(members, k)
+					}
+				}
+				for _, mid := range ids {
+					delete(members, mid)
+				}
+			default:
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidSyntax, fmt.Sprintf("unsupported operation %s", op.Op))}
+			}
+		case scimMemberPathRegex.MatchString(path) && opName == scimOpRemove:
+			var mid string
+			err = json.Unmarshal([]byte(scimMemberPathRegex.FindStringSubmatch(path)[1]), &mid)
+			if err != nil {
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidPath, "member filter value is not a valid string")}
+			}
+
+			delete(members, mid)
+		default:
+			return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidPath, fmt.Sprintf("unsupported path %s", path))}
+		}
+	}
+
+	return
+}
+
+func scimUpdateGroup(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, id string, apply func(members map[string]bool) error) (rsp types.ScimGroupResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to update scim group")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	if !isScimGroup(id) {
+		err = errors.Errorf("failed to find group %s", id)
+
+		rsp.Error, statusCode = scimErrorResult(scimErr{newScimError(http.StatusNotFound, "", types.ErrorGroupDoesNotExist)})
+
+		return
+	}
+
+	var members []types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		current, _, err := persistence.SelectAnyUsers(ctx, m, tx, types.ScimUsersQuery{UserGroup: id, Active: true, Limit: -1})
+		if err != nil {
+			err = errors.Wrap(err, "failed to select group members")
+
+			return
+		}
+
+		desired := map[string]bool{}
+		for _, u := range current {
+			desired[u.ID] = true
+		}
+
+		err = apply(desired)
+		if err != nil {
+			return
+		}
+
+		for _, u := range current {
+			if desired[u.ID] {
+				continue
+			}
+
+			if id == types.UserGroupUser {
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeMutability, "members can only leave the user group by joining the admin group")}
+			}
+
+			err = scimSetUserGroup(ctx, m, tx, u.ID, types.UserGroupUser)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to remove member %s", u.ID)
+
+				return
+			}
+		}
+
+		for mid := range desired {
+			if v.Var(mid, "uuid") != nil {
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, fmt.Sprintf("member %s does not exist", mid))}
+			}
+
+			err = scimSetUserGroup(ctx, m, tx, mid, id)
+			if errors.Cause(err) == sql.ErrNoRows {
+				return scimErr{newScimError(http.StatusBadRequest, types.ScimTypeInvalidValue, fmt.Sprintf("member %s does not exist", mid))}
+			}
+			if err != nil {
+				err = errors.Wrapf(err, "failed to add member %s", mid)
+
+				return
+			}
+		}
+
+		members, _, err = persistence.SelectAnyUsers(ctx, m, tx, types.ScimUsersQuery{UserGroup: id, Active: true, Limit: -1})
+		if err != nil {
+			err = errors.Wrap(err, "failed to select group members")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		rsp.Error, statusCode = scimErrorResult(err)
+
+		return
+	}
+
+	rsp.Group = toScimGroup(id, members, true)
+
+	return
+}
+
+func scimSetUserGroup(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, group string) (err error) {
+	before, err := persistence.GetAnyUserByIdForUpdate(ctx, m, db, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		return
+	}
+
+	if before.UserGroup == group {
+		return
+	}
+
+	u := before
+	u.UserGroup = group
+
+	u, err = persistence.UpdateAnyUserById(ctx, m, db, u)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user")
+
+		return
+	}
+
+	err = recordAuditEvent(ctx, m, db, types.AuditActionUserUpdated, u.ID, diffUsers(&before, &u))
+	if err != nil {
+		err = errors.Wrap(err, "failed to record audit event")
+
+		return
+	}
+
+	err = enqueueUserEvent(ctx, m, db, types.EventTypeUserGroupChanged, u)
+	if err != nil {
+		err = errors.Wrap(err, "failed to enqueue user event")
+
+		return
+	}
+
+	return
+}
+
+func ScimServiceProviderConfig() map[string]interface{} {
+	supported := func(b bool) map[string]interface{} {
+		return map[string]interface{}{"supported": b}
+	}
+
+	return map[string]interface{}{
+		"schemas":        []string{types.ScimSchemaServiceProviderConfig},
+		"patch":          supported(true),
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": types.ScimMaxCount},
+		"changePassword": supported(true),
+		"sort":           supported(false),
+		"etag":           supported(false),
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Authentication with the SCIM bearer token",
+			"primary":     true,
+		}},
+		"meta": map[string]interface{}{
+			"resourceType": "ServiceProviderConfig",
+			"location":     types.RouteScimServiceProviderConfig,
+		},
+	}
+}
+
+func ScimResourceTypes() []map[string]interface{} {
+	resourceType := func(name string, endpoint string, schema string) map[string]interface{} {
+		return map[string]interface{}{
+			"schemas":     []string{types.ScimSchemaResourceType},
+			"id":          name,
+			"name":        name,
+			"endpoint":    strings.TrimPrefix(endpoint, "/scim/v2"),
+			"description": name,
+			"schema":      schema,
+			"meta": map[string]interface{}{
+				"resourceType": "ResourceType",
+				"location":     types.RouteScimResourceTypes + "/" + name,
+			},
+		}
+	}
+
+	return []map[string]interface{}{
+		resourceType(types.ScimResourceTypeUser, types.RouteScimUsers, types.ScimSchemaUser),
+		resourceType(types.ScimResourceTypeGroup, types.RouteScimGroups, types.ScimSchemaGroup),
+	}
+}
+
+func ScimSchemas() []map[string]interface{} {
+	attribute := func(name string, typ string, required bool, mutability string, uniqueness string) map[string]interface{} {
+		return map[string]interface{}{
+			"name":        name,
+			"type":        typ,
+			"multiValued": false,
+			"required":    required,
+			"caseExact":   false,
+			"mutability":  mutability,
+			"returned":    "default",
+			"uniqueness":  uniqueness,
+		}
+	}
+
+	password := attribute("password", "string", false, "writeOnly", "none")
+	password["returned"] = "never"
+
+	groups := attribute("groups", "complex", false, "readOnly", "none")
+	groups["multiValued"] = true
+
+	members := attribute("members", "complex", false, "readWrite", "none")
+	members["multiValued"] = true
+
+	schema := func(id string, name string, attributes ...map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"schemas":    []string{types.ScimSchemaSchema},
+			"id":         id,
+			"name":       name,
+			"attributes": attributes,
+			"meta": map[string]interface{}{
+				"resourceType": "Schema",
+				"location":     types.RouteScimSchemas + "/" + id,
+			},
+		}
+	}
+
+	return []map[string]interface{}{
+		schema(types.ScimSchemaUser, types.ScimResourceTypeUser,
+			attribute("userName", "string", true, "readWrite", "server"),
+			attribute("externalId", "string", false, "readWrite", "none"),
+			attribute("displayName", "string", false, "readWrite", "none"),
+			attribute("active", "boolean", false, "readWrite", "none"),
+			password,
+			groups,
+		),
+		schema(types.ScimSchemaGroup, types.ScimResourceTypeGroup,
+			attribute("displayName", "string", true, "immutable", "server"),
+			members,
+		),
+	}
+}
@@ -0,0 +1,185 @@
+// +build unit
+
+package business
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestParseScimFilter(t *testing.T) {
+	v, err := parseScimFilter(`userName eq "john@example.com"`, "userName")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "john@example.com", v)
+
+	v, err = parseScimFilter(`  USERNAME Eq "jo\"hn@example.com" `, "userName")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, `jo"hn@example.com`, v)
+
+	for _, f := range []string{
+		`userName co "john"`,
+		`displayName eq "john"`,
+		`userName eq john`,
+		`userName eq "a" and userName eq "b"`,
+	} {
+		_, err = parseScimFilter(f, "userName")
+		if assert.Error(t, err, f) {
+			serr, statusCode := scimErrorResult(err)
+			assert.Equal(t, 400, statusCode, f)
+			assert.Equal(t, types.ScimTypeInvalidFilter, serr.ScimType, f)
+		}
+	}
+}
+
+func TestToScimUser(t *testing.T) {
+	createdAt := time.Date(2020, 8, 1, 10, 0, 0, 0, time.UTC)
+
+	su := toScimUser(types.UserModel{ID: "1b2b3c4d-0000-0000-0000-000000000001", Email: "john@example.com", Password: "hash", FullName: "John Doe", UserGroup: types.UserGroupAdmin, CreatedAt: createdAt, UpdatedAt: createdAt, DeletedAt: &createdAt})
+
+	assert.Equal(t, "john@example.com", su.UserName)
+	assert.Equal(t, "John Doe", su.DisplayName)
+	assert.Equal(t, false, *su.Active)
+	assert.Equal(t, "", su.Password)
+	assert.Equal(t, []types.ScimGroupRef{{Value: types.UserGroupAdmin, Ref: types.RouteScimGroups + "/admin", Display: types.UserGroupAdmin}}, su.Groups)
+	assert.Equal(t, types.RouteScimUsers+"/1b2b3c4d-0000-0000-0000-000000000001", su.Meta.Location)
+
+	for status, active := range map[string]bool{
+		types.UserStatusPending:     false,
+		types.UserStatusActive:      true,
+		types.UserStatusSuspended:   true,
+		types.UserStatusLocked:      true,
+		types.UserStatusDeactivated: false,
+	} {
+		assert.Equal(t, active, *toScimUser(types.UserModel{Status: status}).Active, status)
+	}
+}
+
+func TestScimUserStatePatch(t *testing.T) {
+	var ops []types.ScimPatchOperation
+	err := json.Unmarshal([]byte(`[
+		{"op": "Replace", "path": "active", "value": "False"},
+		{"op": "replace", "path": "name.formatted", "value": "Jane Doe"},
+		{"op": "add", "value": {"userName": "jane@example.com", "password": "secret", "externalId": "00u1"}},
+		{"op": "remove", "path": "externalId"},
+		{"op": "replace", "path": "urn:ietf:params:scim:schemas:core:2.0:User:externalId", "value": "00u2"}
+	]`), &ops)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	s := scimUserState{Email: "john@example.com", FullName: "John Doe", Active: true}
+	err = s.patch(ops)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, scimUserState{Email: "jane@example.com", FullName: "Jane Doe", ExternalID: "00u2", Active: false, Password: "secret"}, s)
+
+	err = json.Unmarshal([]byte(`[
+		{"op": "replace", "path": "emails[type eq \"work\"].value", "value": "jane.roe@example.com"},
+		{"op": "replace", "path": "name.familyName", "value": "Roe"},
+		{"op": "replace", "value": {"name.givenName": "Janet"}}
+	]`), &ops)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = s.patch(ops)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "jane.roe@example.com", s.Email)
+	assert.Equal(t, "Janet Roe", s.FullName)
+
+	for _, op := range []types.ScimPatchOperation{
+		{Op: "replace", Path: "nickName", Value: json.RawMessage(`"jj"`)},
+		{Op: "replace", Path: `emails[type eq "home"].value`, Value: json.RawMessage(`"jane@home.com"`)},
+		{Op: "replace", Value: json.RawMessage(`{"title": "CEO"}`)},
+		{Op: "remove", Path: "title"},
+	} {
+		err = s.patch([]types.ScimPatchOperation{op})
+		if assert.Error(t, err) {
+			serr, statusCode := scimErrorResult(err)
+			assert.Equal(t, 400, statusCode)
+			assert.Equal(t, types.ScimTypeInvalidPath, serr.ScimType)
+		}
+	}
+
+	err = s.patch([]types.ScimPatchOperation{{Op: "remove", Path: "userName"}})
+	if assert.Error(t, err) {
+		serr, statusCode := scimErrorResult(err)
+		assert.Equal(t, 400, statusCode)
+		assert.Equal(t, types.ScimTypeMutability, serr.ScimType)
+	}
+
+	err = s.patch([]types.ScimPatchOperation{{Op: "replace", Path: "active", Value: json.RawMessage(`1`)}})
+	if assert.Error(t, err) {
+		serr, _ := scimErrorResult(err)
+		assert.Equal(t, types.ScimTypeInvalidValue, serr.ScimType)
+	}
+
+	err = s.patch([]types.ScimPatchOperation{{Op: "move", Path: "active"}})
+	if assert.Error(t, err) {
+		serr, _ := scimErrorResult(err)
+		assert.Equal(t, types.ScimTypeInvalidSyntax, serr.ScimType)
+	}
+
+	err = scimUserState{Email: "not-an-email", FullName: "John"}.validate(validator.New())
+	if assert.Error(t, err) {
+		serr, _ := scimErrorResult(err)
+		assert.Equal(t, types.ScimTypeInvalidValue, serr.ScimType)
+	}
+}
+
+func TestPatchScimGroupMembers(t *testing.T) {
+	var ops []types.ScimPatchOperation
+	err := json.Unmarshal([]byte(`[
+		{"op": "add", "path": "members", "value": [{"value": "c"}, {"value": "d"}]},
+		{"op": "remove", "path": "members[value eq \"a\"]"},
+		{"op": "remove", "path": "members", "value": [{"value": "b"}]},
+		{"op": "replace", "value": {"displayName": "admin"}}
+	]`), &ops)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	members := map[string]bool{"a": true, "b": true}
+	err = patchScimGroupMembers(types.UserGroupAdmin, members, ops)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]bool{"c": true, "d": true}, members)
+
+	err = patchScimGroupMembers(types.UserGroupAdmin, members, []types.ScimPatchOperation{{Op: "replace", Path: "members", Value: json.RawMessage(`[{"value": "e"}]`)}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]bool{"e": true}, members)
+
+	err = patchScimGroupMembers(types.UserGroupAdmin, members, []types.ScimPatchOperation{{Op: "remove", Path: "members"}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Empty(t, members)
+
+	err = patchScimGroupMembers(types.UserGroupAdmin, members, []types.ScimPatchOperation{{Op: "replace", Path: "displayName", Value: json.RawMessage(`"root"`)}})
+	if assert.Error(t, err) {
+		serr, _ := scimErrorResult(err)
+		assert.Equal(t, types.ScimTypeMutability, serr.ScimType)
+	}
+
+	err = patchScimGroupMembers(types.UserGroupAdmin, members, []types.ScimPatchOperation{{Op: "add", Path: "owners", Value: json.RawMessage(`[]`)}})
+	if assert.Error(t, err) {
+		serr, _ := scimErrorResult(err)
+		assert.Equal(t, types.ScimTypeInvalidPath, serr.ScimType)
+	}
+}
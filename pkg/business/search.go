@@ -0,0 +1,212 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const minHighlightLength = 2
+
+func SearchUsers(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.SearchUsersRequest) (rsp types.SearchUsersResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_users_count", len(rsp.Users),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to search users")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	q := types.UsersSearchQuery{
+		Query: req.Query,
+		Limit: req.PageSize,
+	}
+	if q.Limit == 0 {
+		q.Limit = types.DefaultPageSize
+	}
+
+	if req.Cursor != "" {
+		var c cursor
+		c, err = decodeCursor(req.Cursor)
+		if err != nil {
+			err = errors.Wrap(err, "failed to decode cursor")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+
+		if c.SortBy != types.SortByScore {
+			err = errors.New("failed as cursor does not match the requested sort")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+
+		err = v.Var(c.ID, "uuid")
+		if err != nil {
+			err = errors.Wrap(err, "failed to validate cursor id")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+
+		var score float64
+		score, err = strconv.ParseFloat(c.Value, 32)
+		if err != nil {
+			err = errors.Wrap(err, "failed to parse cursor value")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+
+		afterScore := float32(score)
+		q.AfterScore = &afterScore
+		q.AfterID = c.ID
+	}
+
+	pageSize := q.Limit
+	q.Limit = pageSize + 1
+
+	us, err := persistence.SearchUsers(ctx, m, db, q)
+	if err != nil {
+		err = errors.Wrap(err, "failed to search users in database")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	if len(us) > pageSize {
+		us = us[:pageSize]
+
+		last := us[len(us)-1]
+		rsp.NextCursor, err = encodeCursor(cursor{
+			SortBy:    types.SortByScore,
+			SortOrder: types.SortOrderDesc,
+			Value:     strconv.FormatFloat(float64(last.Score), 'g', -1, 32),
+			ID:        last.ID,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to encode next cursor")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	for _, u := range us {
+		var highlights []types.SearchHighlight
+		h, ok := highlight("email", u.Email, req.Query)
+		if ok {
+			highlights = append(highlights, h)
+		}
+		h, ok = highlight("fullname", u.FullName, req.Query)
+		if ok {
+			highlights = append(highlights, h)
+		}
+
+		rsp.Users = append(rsp.Users, types.SearchUser{
+			ListUser: types.ListUser{
+				ID:         u.ID,
+				Email:      u.Email,
+				FullName:   u.FullName,
+				UserGroup:  u.UserGroup,
+				Status:     u.Status,
+				Attributes: u.Attributes,
+				CreatedAt:  u.CreatedAt,
+			},
+			Score:      u.Score,
+			Highlights: highlights,
+		})
+	}
+
+	return
+}
+
+// highlight returns the longest fragment of value that case-insensitively
+// matches a part of query, with its start and end offsets in runes.
+func highlight(field string, value string, query string) (h types.SearchHighlight, ok bool) {
+	v := []rune(value)
+	lv := []rune(strings.ToLower(value))
+	lq := []rune(strings.ToLower(query))
+	if len(lv) != len(v) {
+		lv = v
+		lq = []rune(query)
+	}
+
+	prev := make([]int, len(lq)+1)
+	curr := make([]int, len(lq)+1)
+
+	var length, end int
+	for i := 1; i <= len(lv); i++ {
+		for j := 1; j <= len(lq); j++ {
+			if lv[i-1] == lq[j-1] {
+				curr[j] = prev[j-1] + 1
+				if curr[j] > length {
+					length = curr[j]
+					end = i
+				}
+			} else {
+				curr[j] = 0
+			}
+		}
+		prev, curr = curr, prev
+	}
+
+	if length < minHighlightLength {
+		return
+	}
+
+	h = types.SearchHighlight{
+		Field:    field,
+		Fragment: string(v[end-length : end]),
+		Start:    end - length,
+		End:      end,
+	}
+	ok = true
+
+	return
+}
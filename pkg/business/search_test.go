@@ -0,0 +1,77 @@
+// +build unit
+
+package business
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestHighlight(t *testing.T) {
+	tcs := []struct {
+		name      string
+		value     string
+		query     string
+		expectOk  bool
+		expectedH types.SearchHighlight
+	}{
+		{
+			name:     "exact fragment",
+			value:    "John Doe",
+			query:    "doe",
+			expectOk: true,
+			expectedH: types.SearchHighlight{
+				Field:    "fullname",
+				Fragment: "Doe",
+				Start:    5,
+				End:      8,
+			},
+		},
+		{
+			name:     "mistyped fragment",
+			value:    "John Doe",
+			query:    "jonh",
+			expectOk: true,
+			expectedH: types.SearchHighlight{
+				Field:    "fullname",
+				Fragment: "Jo",
+				Start:    0,
+				End:      2,
+			},
+		},
+		{
+			name:     "multibyte value",
+			value:    "Jürgen Müller",
+			query:    "müll",
+			expectOk: true,
+			expectedH: types.SearchHighlight{
+				Field:    "fullname",
+				Fragment: "Müll",
+				Start:    7,
+				End:      11,
+			},
+		},
+		{
+			name:     "no fragment",
+			value:    "John Doe",
+			query:    "xyz",
+			expectOk: false,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			h, ok := highlight("fullname", tc.value, tc.query)
+
+			assert.Equal(t, tc.expectOk, ok)
+			if tc.expectOk {
+				assert.Equal(t, tc.expectedH, h)
+			}
+		})
+	}
+}
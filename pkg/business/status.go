@@ -0,0 +1,261 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const lockoutReason = "too many failed authentications"
+
+var (
+	errUserNotActive           = errors.New("user is not active")
+	errInvalidStatusTransition = errors.New("invalid status transition")
+	errOwnStatus               = errors.New("users can not change their own status")
+)
+
+// userStatusTransitions lists the statuses a user can change to from each status. Users become deactivated
+// when they are deleted, and active again when they are restored.
+var userStatusTransitions = map[string][]string{
+	types.UserStatusPending:     {types.UserStatusActive, types.UserStatusSuspended, types.UserStatusDeactivated},
+	types.UserStatusActive:      {types.UserStatusSuspended, types.UserStatusLocked, types.UserStatusDeactivated},
+	types.UserStatusSuspended:   {types.UserStatusActive, types.UserStatusDeactivated},
+	types.UserStatusLocked:      {types.UserStatusActive, types.UserStatusSuspended, types.UserStatusDeactivated},
+	types.UserStatusDeactivated: {types.UserStatusActive},
+}
+
+func canTransitionUserStatus(from string, to string) bool {
+	for _, s := range userStatusTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+func checkUserActive(u types.UserModel) (err error) {
+	if u.Status != types.UserStatusActive {
+		err = errors.Wrapf(errUserNotActive, "failed as user is %v", u.Status)
+
+		return
+	}
+
+	return
+}
+
+// SuspendUser suspends an active, locked, or pending user. Suspended users can't sign in, and their tokens are rejected.
+func SuspendUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, sub string, req types.SuspendUserRequest) (rsp types.SuspendUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to suspend user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	if req.ID == sub {
+		err = errors.WithStack(errOwnStatus)
+
+		rsp.Error = types.ErrorCannotChangeOwnStatus
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = changeUserStatus(ctx, m, tx, req.ID, types.UserStatusSuspended, req.Reason, types.AuditActionUserSuspended)
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	case errors.Cause(err) == errInvalidStatusTransition:
+		rsp.Error = types.ErrorInvalidStatusTransition
+		statusCode = http.StatusConflict
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.User = toUser(u)
+
+	return
+}
+
+// ReactivateUser activates a suspended, locked, or pending user.
+func ReactivateUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, sub string, req types.ReactivateUserRequest) (rsp types.ReactivateUserResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to reactivate user")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	if req.ID == sub {
+		err = errors.WithStack(errOwnStatus)
+
+		rsp.Error = types.ErrorCannotChangeOwnStatus
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var u types.UserModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		u, err = changeUserStatus(ctx, m, tx, req.ID, types.UserStatusActive, req.Reason, types.AuditActionUserReactivated)
+
+		return
+	})
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		rsp.Error = types.ErrorUserDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	case errors.Cause(err) == errInvalidStatusTransition:
+		rsp.Error = types.ErrorInvalidStatusTransition
+		statusCode = http.StatusConflict
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.User = toUser(u)
+
+	return
+}
+
+// changeUserStatus moves a user, that isn't deleted, to status if the transition is allowed,
+// records it in the audit log as action, and emits user.status_changed.
+func changeUserStatus(ctx context.Context, m metrics.MetricSink, tx sqlx.ExtContext, id string, status string, reason string, action string) (u types.UserModel, err error) {
+	before, err := persistence.GetUserByIdForUpdate(ctx, m, tx, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get user")
+
+		return
+	}
+
+	if !canTransitionUserStatus(before.Status, status) {
+		err = errors.Wrapf(errInvalidStatusTransition, "failed as user can't change from %v to %v", before.Status, status)
+
+		return
+	}
+
+	u, err = persistence.UpdateUserStatusById(ctx, m, tx, id, status, reason)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user status")
+
+		return
+	}
+
+	diff := diffUsers(&before, &u)
+	diff["status_reason"] = types.AuditChange{Before: before.StatusReason, After: u.StatusReason}
+
+	err = recordAuditEvent(ctx, m, tx, action, u.ID, diff)
+	if err != nil {
+		err = errors.Wrap(err, "failed to record audit event")
+
+		return
+	}
+
+	err = enqueueUserEvent(ctx, m, tx, types.EventTypeUserStatusChanged, u)
+	if err != nil {
+		err = errors.Wrap(err, "failed to enqueue user event")
+
+		return
+	}
+
+	return
+}
+
+// countFailedAuthentication counts a failed authentication of an active user, and locks the user once
+// lockoutThreshold consecutive authentications failed. A lockoutThreshold of 0 disables the lockout.
+func countFailedAuthentication(ctx context.Context, m metrics.MetricSink, tx sqlx.ExtContext, u types.UserModel, lockoutThreshold int) (err error) {
+	if lockoutThreshold <= 0 || u.Status != types.UserStatusActive {
+		return
+	}
+
+	count, err := persistence.IncrementFailedAuthenticationsById(ctx, m, tx, u.ID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to increment failed authentications")
+
+		return
+	}
+
+	if count < lockoutThreshold {
+		return
+	}
+
+	_, err = changeUserStatus(ctx, m, tx, u.ID, types.UserStatusLocked, lockoutReason, types.AuditActionUserLocked)
+	if err != nil {
+		err = errors.Wrap(err, "failed to lock user")
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,43 @@
+// +build unit
+
+package business
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestCanTransitionUserStatus(t *testing.T) {
+	for _, tc := range []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{from: types.UserStatusActive, to: types.UserStatusSuspended, allowed: true},
+		{from: types.UserStatusActive, to: types.UserStatusLocked, allowed: true},
+		{from: types.UserStatusActive, to: types.UserStatusActive, allowed: false},
+		{from: types.UserStatusSuspended, to: types.UserStatusActive, allowed: true},
+		{from: types.UserStatusSuspended, to: types.UserStatusSuspended, allowed: false},
+		{from: types.UserStatusSuspended, to: types.UserStatusLocked, allowed: false},
+		{from: types.UserStatusLocked, to: types.UserStatusActive, allowed: true},
+		{from: types.UserStatusLocked, to: types.UserStatusSuspended, allowed: true},
+		{from: types.UserStatusPending, to: types.UserStatusActive, allowed: true},
+		{from: types.UserStatusPending, to: types.UserStatusLocked, allowed: false},
+		{from: types.UserStatusDeactivated, to: types.UserStatusSuspended, allowed: false},
+		{from: "unknown", to: types.UserStatusActive, allowed: false},
+	} {
+		assert.Equal(t, tc.allowed, canTransitionUserStatus(tc.from, tc.to), tc.from+" -> "+tc.to)
+	}
+}
+
+func TestCheckUserActive(t *testing.T) {
+	assert.NoError(t, checkUserActive(types.UserModel{Status: types.UserStatusActive}))
+
+	for _, s := range []string{types.UserStatusPending, types.UserStatusSuspended, types.UserStatusLocked, types.UserStatusDeactivated} {
+		assert.Equal(t, errUserNotActive, errors.Cause(checkUserActive(types.UserModel{Status: s})), s)
+	}
+}
@@ -0,0 +1,259 @@
+package business
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	eventStreamBufferSize   = 256
+	eventStreamPageSize     = 1000
+	eventListenerPingPeriod = 90 * time.Second
+)
+
+// EventBroadcaster broadcasts outbox events in the order of their stream sequences. Sequences are
+// assigned under a lock after the events are committed, so unlike ids they become visible in order
+// and a subscriber that resumes after a sequence doesn't miss events committed out of id order.
+type EventBroadcaster struct {
+	mu          sync.Mutex
+	subscribers map[chan types.Event]struct{}
+	lastSeq     int64
+	started     bool
+}
+
+func NewEventBroadcaster() *EventBroadcaster {
+	return &EventBroadcaster{
+		subscribers: map[chan types.Event]struct{}{},
+	}
+}
+
+// Subscribe returns a channel that is closed when the subscriber falls behind,
+// the subscriber is expected to resume from the last received event sequence.
+func (b *EventBroadcaster) Subscribe() (events <-chan types.Event, unsubscribe func()) {
+	ch := make(chan types.Event, eventStreamBufferSize)
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		_, ok := b.subscribers[ch]
+		if ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+
+	return ch, unsubscribe
+}
+
+func (b *EventBroadcaster) broadcast(e types.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Listen catches up on every notification, on reconnects of the listener as notifications sent in the
+// meantime are lost, and on every ping period in case a notification was missed otherwise.
+func (b *EventBroadcaster) Listen(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, l *pq.Listener) {
+	b.catchUpAndLog(ctx, m, db)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.Notify:
+			// notifications that arrived in the meantime are covered by the same catch up
+		drain:
+			for {
+				select {
+				case <-l.Notify:
+				default:
+					break drain
+				}
+			}
+
+			b.catchUpAndLog(ctx, m, db)
+		case <-time.After(eventListenerPingPeriod):
+			go func() {
+				err := l.Ping()
+				if err != nil {
+					ctxutil.GetContextLogger(ctx).Warn(errors.Wrap(err, "failed to ping outbox events listener"))
+				}
+			}()
+
+			b.catchUpAndLog(ctx, m, db)
+		}
+	}
+}
+
+func (b *EventBroadcaster) catchUpAndLog(ctx context.Context, m metrics.MetricSink, db *sqlx.DB) {
+	err := b.catchUp(ctx, m, db)
+	if err != nil {
+		ctxutil.GetContextLogger(ctx).Error(errors.Wrap(err, "failed to catch up on outbox events"))
+	}
+}
+
+// catchUp sequences the committed events, and broadcasts the events sequenced since the last broadcast.
+// The first catch up only determines the last sequence, so that a starting instance doesn't broadcast
+// the history.
+func (b *EventBroadcaster) catchUp(ctx context.Context, m metrics.MetricSink, db *sqlx.DB) (err error) {
+	err = SequenceEvents(ctx, m, db)
+	if err != nil {
+		return
+	}
+
+	if !b.started {
+		b.lastSeq, err = persistence.GetLastOutboxEventStreamSeq(ctx, m, db)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get last outbox event stream sequence")
+
+			return
+		}
+
+		b.started = true
+
+		return
+	}
+
+	for {
+		var es []types.OutboxEventModel
+		es, err = persistence.SelectOutboxEventsAfterStreamSeq(ctx, m, db, b.lastSeq, eventStreamPageSize)
+		if err != nil {
+			err = errors.Wrap(err, "failed to select outbox events")
+
+			return
+		}
+
+		for _, e := range es {
+			b.broadcast(toEvent(e))
+
+			b.lastSeq = *e.StreamSeq
+		}
+
+		if len(es) < eventStreamPageSize {
+			return
+		}
+	}
+}
+
+// SequenceEvents assigns stream sequences to the committed events that have none yet. The lock makes
+// concurrent instances wait for each other, so that sequences become visible in the order they were assigned.
+func SequenceEvents(ctx context.Context, m metrics.MetricSink, db *sqlx.DB) (err error) {
+	var n int64
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"sequenced_events_count", n,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to sequence events")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		err = persistence.LockOutboxSequencing(ctx, m, tx)
+		if err != nil {
+			return
+		}
+
+		n, err = persistence.SequenceOutboxEvents(ctx, m, tx)
+		if err != nil {
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// GetLastEventSequence returns the sequence of the last sequenced event, or 0 if there is none.
+func GetLastEventSequence(ctx context.Context, m metrics.MetricSink, db *sqlx.DB) (seq int64, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to get last event sequence")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	seq, err = persistence.GetLastOutboxEventStreamSeq(ctx, m, db)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get last outbox event stream sequence")
+
+		return
+	}
+
+	return
+}
+
+// ReplayEvents returns the page of sequenced events after afterSeq, callers page until fewer than
+// eventStreamPageSize events are returned.
+func ReplayEvents(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, afterSeq int64) (es []types.Event, more bool, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"replayed_events_count", len(es),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to replay events")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	ms, err := persistence.SelectOutboxEventsAfterStreamSeq(ctx, m, db, afterSeq, eventStreamPageSize)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select outbox events")
+
+		return
+	}
+
+	for _, e := range ms {
+		es = append(es, toEvent(e))
+	}
+
+	more = len(ms) == eventStreamPageSize
+
+	return
+}
@@ -0,0 +1,31 @@
+package business
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+const tokenLength = 32
+
+func generateToken() (token string, hash string, err error) {
+	b, err := generateRandomBytes(tokenLength)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate random bytes")
+
+		return
+	}
+
+	token = base64.RawURLEncoding.EncodeToString(b)
+	hash = hashToken(token)
+
+	return
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(h[:])
+}
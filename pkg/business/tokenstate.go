@@ -0,0 +1,168 @@
+package business
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const DefaultTokenStateTtl = 5 * time.Second
+
+var errTokenRevoked = errors.New("token has been revoked")
+
+type tokenState struct {
+	exists    bool
+	state     types.UserTokenStateModel
+	fetchedAt time.Time
+}
+
+// TokenStates caches the status and token epoch of users for a short ttl, so that tokens of users that
+// were deleted, suspended, demoted, or changed their password are rejected without a query per request.
+type TokenStates struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	states    map[string]tokenState
+	lastSweep time.Time
+}
+
+func NewTokenStates(ttl time.Duration) *TokenStates {
+	return &TokenStates{
+		ttl:       ttl,
+		states:    map[string]tokenState{},
+		lastSweep: time.Now(),
+	}
+}
+
+// VerifyToken checks that the subject of the claims exists and is active, and that the token
+// has been issued in the current token epoch of the subject.
+func (s *TokenStates) VerifyToken(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, claims map[string]interface{}) (rsp types.ErrorResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to verify token")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	sub, _ := claims[types.ClaimSub].(string)
+	epoch := tokenEpochFromClaims(claims)
+
+	ts, ok := s.get(sub)
+	if !ok || epoch > ts.state.TokenEpoch {
+		ts, err = s.fetch(ctx, m, db, sub)
+		if err != nil {
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	if !ts.exists {
+		err = errors.Wrap(errUserNotActive, "failed as user does not exist")
+
+		rsp.Error = types.ErrorUserNotActive
+		statusCode = http.StatusUnauthorized
+
+		return
+	}
+
+	if ts.state.Status != types.UserStatusActive {
+		err = errors.Wrapf(errUserNotActive, "failed as user is %v", ts.state.Status)
+
+		rsp.Error = types.ErrorUserNotActive
+		statusCode = http.StatusUnauthorized
+
+		return
+	}
+
+	if epoch != ts.state.TokenEpoch {
+		err = errors.Wrapf(errTokenRevoked, "failed as token epoch %d is not the current epoch %d", epoch, ts.state.TokenEpoch)
+
+		rsp.Error = types.ErrorTokenRevoked
+		statusCode = http.StatusUnauthorized
+
+		return
+	}
+
+	return
+}
+
+func (s *TokenStates) get(sub string) (ts tokenState, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts, ok = s.states[sub]
+	if ok && time.Since(ts.fetchedAt) >= s.ttl {
+		delete(s.states, sub)
+
+		return tokenState{}, false
+	}
+
+	return
+}
+
+func (s *TokenStates) fetch(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, sub string) (ts tokenState, err error) {
+	ts.fetchedAt = time.Now()
+
+	ts.state, err = persistence.GetUserTokenStateById(ctx, m, db, sub)
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		err = nil
+	case err != nil:
+		err = errors.Wrap(err, "failed to get user token state")
+
+		return
+	default:
+		ts.exists = true
+	}
+
+	if s.ttl <= 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[sub] = ts
+
+	if time.Since(s.lastSweep) >= s.ttl {
+		for k, v := range s.states {
+			if time.Since(v.fetchedAt) >= s.ttl {
+				delete(s.states, k)
+			}
+		}
+
+		s.lastSweep = time.Now()
+	}
+
+	return
+}
+
+// tokenEpochFromClaims returns the token epoch of the claims, tokens issued before token epochs were introduced belong to epoch 0.
+func tokenEpochFromClaims(claims map[string]interface{}) int {
+	epoch, _ := claims[types.ClaimTokenEpoch].(float64)
+
+	return int(epoch)
+}
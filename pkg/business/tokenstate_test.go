@@ -0,0 +1,59 @@
+// +build unit
+
+package business
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func TestTokenEpochFromClaims(t *testing.T) {
+	assert.Equal(t, 0, tokenEpochFromClaims(map[string]interface{}{}), "tokens without epoch belong to epoch 0")
+	assert.Equal(t, 3, tokenEpochFromClaims(map[string]interface{}{types.ClaimTokenEpoch: float64(3)}))
+}
+
+func TestTokenStatesVerifyToken(t *testing.T) {
+	ctx := ctxutil.WithContextLogger(context.Background(), zap.NewNop().Sugar())
+
+	s := NewTokenStates(time.Minute)
+	s.states["active"] = tokenState{exists: true, state: types.UserTokenStateModel{Status: types.UserStatusActive, TokenEpoch: 2}, fetchedAt: time.Now()}
+	s.states["suspended"] = tokenState{exists: true, state: types.UserTokenStateModel{Status: types.UserStatusSuspended, TokenEpoch: 1}, fetchedAt: time.Now()}
+	s.states["deleted"] = tokenState{fetchedAt: time.Now()}
+
+	for _, tc := range []struct {
+		sub                string
+		epoch              float64
+		expectedStatusCode int
+		expectedError      string
+	}{
+		{sub: "active", epoch: 2, expectedStatusCode: http.StatusOK},
+		{sub: "active", epoch: 1, expectedStatusCode: http.StatusUnauthorized, expectedError: types.ErrorTokenRevoked},
+		{sub: "suspended", epoch: 1, expectedStatusCode: http.StatusUnauthorized, expectedError: types.ErrorUserNotActive},
+		{sub: "deleted", epoch: 0, expectedStatusCode: http.StatusUnauthorized, expectedError: types.ErrorUserNotActive},
+	} {
+		rsp, statusCode := s.VerifyToken(ctx, nil, nil, map[string]interface{}{types.ClaimSub: tc.sub, types.ClaimTokenEpoch: tc.epoch})
+		assert.Equal(t, tc.expectedStatusCode, statusCode, tc.sub)
+		assert.Equal(t, tc.expectedError, rsp.Error, tc.sub)
+	}
+}
+
+func TestTokenStatesExpire(t *testing.T) {
+	s := NewTokenStates(time.Minute)
+	s.states["fresh"] = tokenState{exists: true, fetchedAt: time.Now()}
+	s.states["stale"] = tokenState{exists: true, fetchedAt: time.Now().Add(-time.Hour)}
+
+	_, ok := s.get("fresh")
+	assert.True(t, ok)
+
+	_, ok = s.get("stale")
+	assert.False(t, ok)
+	assert.NotContains(t, s.states, "stale")
+}
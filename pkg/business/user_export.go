@@ -0,0 +1,290 @@
+package business
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	userExportBatchSize       = 1000
+	userExportParquetRowGroup = 8 * 1024 * 1024
+)
+
+type userEncoder interface {
+	Encode(u types.UserModel) error
+	Close() error
+}
+
+func newUserEncoder(format string, columns []string, w io.Writer) (enc userEncoder, err error) {
+	switch format {
+	case types.ExportFormatCsv:
+		return newCsvUserEncoder(columns, w)
+	case types.ExportFormatJsonl:
+		return &jsonlUserEncoder{columns: columns, enc: json.NewEncoder(w)}, nil
+	case types.ExportFormatParquet:
+		return newParquetUserEncoder(columns, w)
+	}
+
+	err = errors.Errorf("unsupported export format %s", format)
+
+	return
+}
+
+func userColumnValue(u types.UserModel, column string) interface{} {
+	switch column {
+	case types.UserColumnId:
+		return u.ID
+	case types.UserColumnEmail:
+		return u.Email
+	case types.UserColumnFullName:
+		return u.FullName
+	case types.UserColumnUserGroup:
+		return u.UserGroup
+	case types.UserColumnCreatedAt:
+		return u.CreatedAt.UTC()
+	case types.UserColumnUpdatedAt:
+		return u.UpdatedAt.UTC()
+	case types.UserColumnDeletedAt:
+		if u.DeletedAt == nil {
+			return nil
+		}
+
+		return u.DeletedAt.UTC()
+	}
+
+	return nil
+}
+
+type csvUserEncoder struct {
+	columns []string
+	w       *csv.Writer
+}
+
+func newCsvUserEncoder(columns []string, w io.Writer) (enc *csvUserEncoder, err error) {
+	enc = &csvUserEncoder{columns: columns, w: csv.NewWriter(w)}
+
+	err = enc.w.Write(columns)
+	if err != nil {
+		err = errors.Wrap(err, "failed to write csv header")
+
+		return
+	}
+
+	return
+}
+
+func (enc *csvUserEncoder) Encode(u types.UserModel) error {
+	rec := make([]string, len(enc.columns))
+	for i, c := range enc.columns {
+		switch v := userColumnValue(u, c).(type) {
+		case string:
+			rec[i] = v
+		case time.Time:
+			rec[i] = v.Format(time.RFC3339Nano)
+		}
+	}
+
+	return enc.w.Write(rec)
+}
+
+func (enc *csvUserEncoder) Close() error {
+	enc.w.Flush()
+
+	return enc.w.Error()
+}
+
+type jsonlUserEncoder struct {
+	columns []string
+	enc     *json.Encoder
+}
+
+func (enc *jsonlUserEncoder) Encode(u types.UserModel) error {
+	rec := make(map[string]interface{}, len(enc.columns))
+	for _, c := range enc.columns {
+		rec[c] = userColumnValue(u, c)
+	}
+
+	return enc.enc.Encode(rec)
+}
+
+func (enc *jsonlUserEncoder) Close() error {
+	return nil
+}
+
+type parquetUserEncoder struct {
+	columns []string
+	w       *writer.CSVWriter
+}
+
+func newParquetUserEncoder(columns []string, w io.Writer) (enc *parquetUserEncoder, err error) {
+	md := make([]string, len(columns))
+	for i, c := range columns {
+		switch c {
+		case types.UserColumnCreatedAt, types.UserColumnUpdatedAt:
+			md[i] = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MICROS, repetitiontype=REQUIRED", c)
+		case types.UserColumnDeletedAt:
+			md[i] = fmt.Sprintf("name=%s, type=INT64, convertedtype=TIMESTAMP_MICROS, repetitiontype=OPTIONAL", c)
+		default:
+			md[i] = fmt.Sprintf("name=%s, type=BYTE_ARRAY, convertedtype=UTF8, repetitiontype=REQUIRED", c)
+		}
+	}
+
+	pw, err := writer.NewCSVWriterFromWriter(md, w, 1)
+	if err != nil {
+		err = errors.Wrap(err, "failed to create parquet writer")
+
+		return
+	}
+	pw.RowGroupSize = userExportParquetRowGroup
+
+	enc = &parquetUserEncoder{columns: columns, w: pw}
+
+	return
+}
+
+func (enc *parquetUserEncoder) Encode(u types.UserModel) error {
+	rec := make([]interface{}, len(enc.columns))
+	for i, c := range enc.columns {
+		switch v := userColumnValue(u, c).(type) {
+		case string:
+			rec[i] = v
+		case time.Time:
+			rec[i] = v.UnixNano() / int64(time.Microsecond)
+		}
+	}
+
+	return enc.w.Write(rec)
+}
+
+func (enc *parquetUserEncoder) Close() error {
+	return enc.w.WriteStop()
+}
+
+// ExportUsers streams the users matching req to the writer returned by start. start is only called once the export
+// is about to be written, if ExportUsers fails after that, the export is truncated instead of answered with an error.
+func ExportUsers(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ExportUsersRequest, start func() io.Writer) (rsp types.ExportUsersResponse, statusCode int) {
+	var err error
+	var count int
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"format", req.Format,
+			"exported_users_count", count,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to export users")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	columns := req.Columns
+	if len(columns) == 0 {
+		columns = types.UserExportColumns
+	}
+
+	q := types.UsersQuery{
+		SortBy:        req.SortBy,
+		SortOrder:     req.SortOrder,
+		UserGroup:     req.UserGroup,
+		CreatedAfter:  req.CreatedAfter,
+		CreatedBefore: req.CreatedBefore,
+		EmailDomain:   req.EmailDomain,
+		Deleted:       req.Deleted,
+		Status:        req.Status,
+	}
+	if q.SortBy == "" {
+		q.SortBy = types.SortByCreatedAt
+	}
+	if q.SortOrder == "" {
+		q.SortOrder = types.SortOrderDesc
+	}
+
+	var enc userEncoder
+	open := func() (err error) {
+		enc, err = newUserEncoder(req.Format, columns, start())
+		if err != nil {
+			err = errors.Wrap(err, "failed to create encoder")
+
+			return
+		}
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		count, err = persistence.StreamUsers(ctx, m, tx, q, userExportBatchSize, func(us []types.UserModel) (err error) {
+			if enc == nil {
+				err = open()
+				if err != nil {
+					return
+				}
+			}
+
+			for _, u := range us {
+				err = enc.Encode(u)
+				if err != nil {
+					err = errors.Wrapf(err, "failed to encode user %v", u.ID)
+
+					return
+				}
+			}
+
+			return
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to stream users")
+
+			return
+		}
+
+		return
+	})
+	if err == nil && enc == nil {
+		err = open()
+	}
+	if err == nil {
+		err = enc.Close()
+		if err != nil {
+			err = errors.Wrap(err, "failed to close encoder")
+		}
+	}
+	if err != nil {
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,116 @@
+// +build unit
+
+package business
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+var userExportFixtures = func() []types.UserModel {
+	createdAt := time.Date(2020, 8, 1, 10, 0, 0, 123456000, time.UTC)
+	deletedAt := createdAt.Add(time.Hour)
+
+	return []types.UserModel{
+		{ID: "1b2b3c4d-0000-0000-0000-000000000001", Email: "john@example.com", Password: "hash", FullName: "Doe, John", UserGroup: types.UserGroupUser, CreatedAt: createdAt, UpdatedAt: createdAt},
+		{ID: "1b2b3c4d-0000-0000-0000-000000000002", Email: "jane@example.com", Password: "hash", FullName: "Jane Doe", UserGroup: types.UserGroupAdmin, CreatedAt: createdAt, UpdatedAt: createdAt, DeletedAt: &deletedAt},
+	}
+}()
+
+func encodeUsers(format string, columns []string, us []types.UserModel) (b []byte, err error) {
+	var buf bytes.Buffer
+	enc, err := newUserEncoder(format, columns, &buf)
+	if err != nil {
+		return
+	}
+
+	for _, u := range us {
+		err = enc.Encode(u)
+		if err != nil {
+			return
+		}
+	}
+
+	err = enc.Close()
+	if err != nil {
+		return
+	}
+
+	b = buf.Bytes()
+
+	return
+}
+
+func TestCsvUserEncoder(t *testing.T) {
+	b, err := encodeUsers(types.ExportFormatCsv, []string{types.UserColumnEmail, types.UserColumnFullName, types.UserColumnDeletedAt}, userExportFixtures)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "email,fullname,deleted_at\njohn@example.com,\"Doe, John\",\njane@example.com,Jane Doe,2020-08-01T11:00:00.123456Z\n", string(b))
+
+	b, err = encodeUsers(types.ExportFormatCsv, types.UserExportColumns, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "id,email,fullname,user_group,created_at,updated_at,deleted_at\n", string(b))
+}
+
+func TestJsonlUserEncoder(t *testing.T) {
+	b, err := encodeUsers(types.ExportFormatJsonl, []string{types.UserColumnId, types.UserColumnCreatedAt, types.UserColumnDeletedAt}, userExportFixtures)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, `{"created_at":"2020-08-01T10:00:00.123456Z","deleted_at":null,"id":"1b2b3c4d-0000-0000-0000-000000000001"}
+{"created_at":"2020-08-01T10:00:00.123456Z","deleted_at":"2020-08-01T11:00:00.123456Z","id":"1b2b3c4d-0000-0000-0000-000000000002"}
+`, string(b))
+
+	assert.NotContains(t, string(b), "hash")
+}
+
+func TestParquetUserEncoder(t *testing.T) {
+	b, err := encodeUsers(types.ExportFormatParquet, []string{types.UserColumnEmail, types.UserColumnCreatedAt, types.UserColumnDeletedAt}, userExportFixtures)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	f, err := buffer.NewBufferFile(b)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	pr, err := reader.NewParquetColumnReader(f, 1)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer pr.ReadStop()
+
+	assert.Equal(t, int64(2), pr.GetNumRows())
+
+	emails, _, _, err := pr.ReadColumnByIndex(0, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []interface{}{"john@example.com", "jane@example.com"}, emails)
+
+	createdAts, _, _, err := pr.ReadColumnByIndex(1, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []interface{}{userExportFixtures[0].CreatedAt.UnixNano() / 1000, userExportFixtures[1].CreatedAt.UnixNano() / 1000}, createdAts)
+
+	deletedAts, _, _, err := pr.ReadColumnByIndex(2, 2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []interface{}{nil, userExportFixtures[1].DeletedAt.UnixNano() / 1000}, deletedAts)
+}
@@ -0,0 +1,1175 @@
+package business
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/persistence"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	webhookDeliveriesSortBy = "id"
+	webhookSignaturePrefix  = "sha256="
+	webhookBatchSize        = 100
+	webhookMaxErrorLength   = 1024
+	webhookPingTimeout      = 3 * time.Second
+	webhookMaxRedirects     = 3
+)
+
+var errWebhookAddressNotAllowed = errors.New("webhook address is not allowed")
+
+// webhookDeniedNetworks are the networks webhooks aren't delivered to unless private networks are allowed,
+// they cover loopback, private, shared, link-local, multicast and reserved addresses.
+var webhookDeniedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+type WebhookOpts struct {
+	MaxAttempts int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	Timeout     time.Duration
+	Concurrency int
+}
+
+var DefaultWebhookOpts = WebhookOpts{
+	MaxAttempts: 10,
+	MinBackoff:  10 * time.Second,
+	MaxBackoff:  time.Hour,
+	Timeout:     10 * time.Second,
+	Concurrency: 10,
+}
+
+// NewWebhookClient returns the client webhooks are delivered with.
+// Unless allowPrivateNetworks is set, it refuses to connect to webhookDeniedNetworks. The check runs on the resolved
+// address of every connection, so host names resolving to such addresses and redirects to them are refused as well.
+func NewWebhookClient(allowPrivateNetworks bool) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if !allowPrivateNetworks {
+		dialer.Control = func(network string, address string, c syscall.RawConn) (err error) {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				err = errors.Wrap(err, "failed to split webhook address")
+
+				return
+			}
+
+			err = checkWebhookIP(net.ParseIP(host))
+
+			return
+		}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be dialed instead of the webhook, and would connect to the denied networks on its behalf
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= webhookMaxRedirects {
+				return errors.Errorf("failed as the webhook redirected more than %d times", webhookMaxRedirects)
+			}
+
+			return nil
+		},
+	}
+}
+
+func checkWebhookIP(ip net.IP) (err error) {
+	if ip == nil {
+		err = errors.Wrap(errWebhookAddressNotAllowed, "failed to parse webhook ip")
+
+		return
+	}
+
+	for _, n := range webhookDeniedNetworks {
+		if n.Contains(ip) {
+			err = errors.Wrapf(errWebhookAddressNotAllowed, "failed as %s is in the denied network %s", ip, n)
+
+			return
+		}
+	}
+
+	return
+}
+
+func mustParseCIDRs(cidrs ...string) (ns []*net.IPNet) {
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+
+		ns = append(ns, n)
+	}
+
+	return
+}
+
+func RegisterWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.RegisterWebhookRequest) (rsp types.RegisterWebhookResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to register webhook")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	secret, _, err := generateToken()
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate webhook secret")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	var w types.WebhookEndpointModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		w, err = persistence.InsertWebhookEndpoint(ctx, m, tx, types.WebhookEndpointModel{
+			URL:        req.URL,
+			Secret:     secret,
+			EventTypes: append(pq.StringArray{}, req.EventTypes...),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to insert webhook endpoint")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionWebhookRegistered, w.ID, diffWebhooks(nil, &w))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	if err != nil {
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.Webhook = toWebhook(w)
+	rsp.Webhook.Secret = w.Secret
+
+	return
+}
+
+func ListWebhooks(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ListWebhooksRequest) (rsp types.ListWebhooksResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_webhooks_count", len(rsp.Webhooks),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list webhooks")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	ws, err := persistence.SelectWebhookEndpoints(ctx, m, db)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get webhook endpoints from database")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	for _, w := range ws {
+		rsp.Webhooks = append(rsp.Webhooks, *toWebhook(w))
+	}
+
+	return
+}
+
+func UpdateWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.UpdateWebhookRequest) (rsp types.UpdateWebhookResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to update webhook")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	var w types.WebhookEndpointModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		before, err := persistence.GetWebhookEndpointByIdForUpdate(ctx, m, tx, req.ID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get webhook endpoint")
+
+			return
+		}
+
+		w, err = persistence.UpdateWebhookEndpoint(ctx, m, tx, types.WebhookEndpointModel{
+			ID:         req.ID,
+			URL:        req.URL,
+			EventTypes: append(pq.StringArray{}, req.EventTypes...),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to update webhook endpoint")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionWebhookUpdated, w.ID, diffWebhooks(&before, &w))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	rsp.Webhook, rsp.Error, statusCode = webhookResult(w, err)
+
+	return
+}
+
+func PauseWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.PauseWebhookRequest) (rsp types.PauseWebhookResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to pause webhook")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	w, err := setWebhookPaused(ctx, m, db, req.ID, true)
+	rsp.Webhook, rsp.Error, statusCode = webhookResult(w, err)
+
+	return
+}
+
+func ResumeWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ResumeWebhookRequest) (rsp types.ResumeWebhookResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to resume webhook")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	w, err := setWebhookPaused(ctx, m, db, req.ID, false)
+	rsp.Webhook, rsp.Error, statusCode = webhookResult(w, err)
+
+	return
+}
+
+func DeleteWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.DeleteWebhookRequest) (rsp types.DeleteWebhookResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to delete webhook")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		before, err := persistence.GetWebhookEndpointByIdForUpdate(ctx, m, tx, req.ID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get webhook endpoint")
+
+			return
+		}
+
+		err = persistence.DeleteWebhookEndpointById(ctx, m, tx, req.ID)
+		if err != nil {
+			err = errors.Wrap(err, "failed to delete webhook endpoint")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionWebhookDeleted, req.ID, diffWebhooks(&before, nil))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	_, rsp.Error, statusCode = webhookResult(types.WebhookEndpointModel{}, err)
+
+	return
+}
+
+func RotateWebhookSecret(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.RotateWebhookSecretRequest) (rsp types.RotateWebhookSecretResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to rotate webhook secret")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	secret, _, err := generateToken()
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate webhook secret")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	var w types.WebhookEndpointModel
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		w, err = persistence.UpdateWebhookEndpointSecretById(ctx, m, tx, req.ID, secret)
+		if err != nil {
+			err = errors.Wrap(err, "failed to update webhook endpoint secret")
+
+			return
+		}
+
+		// the secret itself is never written to the audit log
+		err = recordAuditEvent(ctx, m, tx, types.AuditActionWebhookSecretRotated, w.ID, nil)
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+	rsp.Webhook, rsp.Error, statusCode = webhookResult(w, err)
+	if rsp.Webhook != nil {
+		rsp.Webhook.Secret = w.Secret
+	}
+
+	return
+}
+
+func PingWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, c *http.Client, req types.PingWebhookRequest) (rsp types.PingWebhookResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to ping webhook")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	w, err := persistence.GetWebhookEndpointById(ctx, m, db, req.ID)
+	_, rsp.Error, statusCode = webhookResult(w, err)
+	if err != nil {
+		return
+	}
+
+	data, err := json.Marshal(map[string]string{"webhook_id": w.ID})
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal ping data")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	a, err := attemptWebhook(ctx, m, db, c, w.URL, w.Secret, types.WebhookDeliveryAttemptModel{
+		WebhookID: w.ID,
+		EventType: types.EventTypeWebhookPing,
+	}, time.Now(), data, webhookPingTimeout)
+	if err != nil {
+		err = errors.Wrap(err, "failed to attempt webhook")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	rsp.Attempt = toWebhookDeliveryAttempt(a)
+	if a.Error != "" {
+		rsp.Error = types.ErrorWebhookPingFailed
+		statusCode = http.StatusBadGateway
+	}
+
+	return
+}
+
+func ListWebhookDeliveryAttempts(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ListWebhookDeliveryAttemptsRequest) (rsp types.ListWebhookDeliveryAttemptsResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_attempts_count", len(rsp.Attempts),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list webhook delivery attempts")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	q := types.WebhookDeliveryAttemptsQuery{
+		Limit:     req.PageSize,
+		WebhookID: req.WebhookID,
+	}
+	if q.Limit == 0 {
+		q.Limit = types.DefaultPageSize
+	}
+
+	if req.Cursor != "" {
+		var c cursor
+		c, err = decodeCursor(req.Cursor)
+		if err == nil && c.SortBy != webhookDeliveriesSortBy {
+			err = errors.New("failed as cursor does not match the requested sort")
+		}
+		if err == nil {
+			q.BeforeID, err = strconv.ParseInt(c.Value, 10, 64)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to decode cursor")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+	}
+
+	pageSize := q.Limit
+	q.Limit = pageSize + 1
+
+	as, err := persistence.SelectWebhookDeliveryAttempts(ctx, m, db, q)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get webhook delivery attempts from database")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	if len(as) > pageSize {
+		as = as[:pageSize]
+
+		rsp.NextCursor, err = encodeCursor(cursor{
+			SortBy:    webhookDeliveriesSortBy,
+			SortOrder: types.SortOrderDesc,
+			Value:     strconv.FormatInt(as[len(as)-1].ID, 10),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to encode next cursor")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	for _, a := range as {
+		rsp.Attempts = append(rsp.Attempts, *toWebhookDeliveryAttempt(a))
+	}
+
+	return
+}
+
+// setWebhookPaused pauses or resumes a webhook, and records it in the audit log.
+func setWebhookPaused(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, id string, paused bool) (w types.WebhookEndpointModel, err error) {
+	action := types.AuditActionWebhookResumed
+	if paused {
+		action = types.AuditActionWebhookPaused
+	}
+
+	err = persistence.WithTransaction(ctx, db, func(tx *sqlx.Tx) (err error) {
+		before, err := persistence.GetWebhookEndpointByIdForUpdate(ctx, m, tx, id)
+		if err != nil {
+			err = errors.Wrap(err, "failed to get webhook endpoint")
+
+			return
+		}
+
+		w, err = persistence.UpdateWebhookEndpointPausedById(ctx, m, tx, id, paused)
+		if err != nil {
+			err = errors.Wrap(err, "failed to update webhook endpoint")
+
+			return
+		}
+
+		err = recordAuditEvent(ctx, m, tx, action, w.ID, diffWebhooks(&before, &w))
+		if err != nil {
+			err = errors.Wrap(err, "failed to record audit event")
+
+			return
+		}
+
+		return
+	})
+
+	return
+}
+
+func webhookResult(w types.WebhookEndpointModel, err error) (webhook *types.Webhook, rspErr string, statusCode int) {
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		return nil, types.ErrorWebhookDoesNotExist, http.StatusNotFound
+	case err != nil:
+		return nil, types.ErrorInternalError, http.StatusInternalServerError
+	}
+
+	return toWebhook(w), "", http.StatusOK
+}
+
+func toWebhook(w types.WebhookEndpointModel) *types.Webhook {
+	return &types.Webhook{
+		ID:         w.ID,
+		URL:        w.URL,
+		EventTypes: w.EventTypes,
+		PausedAt:   w.PausedAt,
+		CreatedAt:  w.CreatedAt,
+		UpdatedAt:  w.UpdatedAt,
+	}
+}
+
+func toWebhookDeliveryAttempt(a types.WebhookDeliveryAttemptModel) *types.WebhookDeliveryAttempt {
+	return &types.WebhookDeliveryAttempt{
+		ID:          a.ID,
+		WebhookID:   a.WebhookID,
+		DeliveryID:  a.DeliveryID,
+		EventID:     a.EventID,
+		EventType:   a.EventType,
+		AttemptedAt: a.AttemptedAt,
+		StatusCode:  a.StatusCode,
+		LatencyMs:   a.LatencyMs,
+		Error:       a.Error,
+	}
+}
+
+func ListDeadWebhookDeliveries(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.ListDeadWebhookDeliveriesRequest) (rsp types.ListDeadWebhookDeliveriesResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"rsp_deliveries_count", len(rsp.Deliveries),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to list dead webhook deliveries")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	q := types.WebhookDeliveriesQuery{
+		Limit:     req.PageSize,
+		WebhookID: req.WebhookID,
+		Status:    types.WebhookDeliveryStatusDead,
+	}
+	if q.Limit == 0 {
+		q.Limit = types.DefaultPageSize
+	}
+
+	if req.Cursor != "" {
+		var c cursor
+		c, err = decodeCursor(req.Cursor)
+		if err == nil && c.SortBy != webhookDeliveriesSortBy {
+			err = errors.New("failed as cursor does not match the requested sort")
+		}
+		if err == nil {
+			q.BeforeID, err = strconv.ParseInt(c.Value, 10, 64)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to decode cursor")
+
+			rsp.Error = types.ErrorInvalidCursor
+			statusCode = http.StatusUnprocessableEntity
+
+			return
+		}
+	}
+
+	pageSize := q.Limit
+	q.Limit = pageSize + 1
+
+	ds, err := persistence.SelectWebhookDeliveries(ctx, m, db, q)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get webhook deliveries from database")
+
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	if len(ds) > pageSize {
+		ds = ds[:pageSize]
+
+		rsp.NextCursor, err = encodeCursor(cursor{
+			SortBy:    webhookDeliveriesSortBy,
+			SortOrder: types.SortOrderDesc,
+			Value:     strconv.FormatInt(ds[len(ds)-1].ID, 10),
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to encode next cursor")
+
+			rsp.Error = types.ErrorInternalError
+			statusCode = http.StatusInternalServerError
+
+			return
+		}
+	}
+
+	for _, d := range ds {
+		rsp.Deliveries = append(rsp.Deliveries, toWebhookDelivery(d))
+	}
+
+	return
+}
+
+func RetryWebhookDelivery(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, v *validator.Validate, req types.RetryWebhookDeliveryRequest) (rsp types.RetryWebhookDeliveryResponse, statusCode int) {
+	var err error
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to retry webhook delivery")
+
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	statusCode = http.StatusOK
+
+	err = v.Struct(&req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to validate the request")
+
+		rsp.Error = err.Error()
+		statusCode = http.StatusUnprocessableEntity
+
+		return
+	}
+
+	d, err := persistence.RetryDeadWebhookDelivery(ctx, m, db, req.ID)
+	switch {
+	case errors.Cause(err) == sql.ErrNoRows:
+		rsp.Error = types.ErrorWebhookDeliveryDoesNotExist
+		statusCode = http.StatusNotFound
+
+		return
+	case err != nil:
+		rsp.Error = types.ErrorInternalError
+		statusCode = http.StatusInternalServerError
+
+		return
+	}
+
+	delivery := toWebhookDelivery(d)
+	rsp.Delivery = &delivery
+
+	return
+}
+
+func toWebhookDelivery(d types.WebhookDeliveryModel) types.WebhookDelivery {
+	return types.WebhookDelivery{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		EventType:      d.EventType,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		NextAttemptAt:  d.NextAttemptAt,
+		LastStatusCode: d.LastStatusCode,
+		LastError:      d.LastError,
+		CreatedAt:      d.CreatedAt,
+		UpdatedAt:      d.UpdatedAt,
+	}
+}
+
+func signWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return webhookSignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func webhookBackoff(attempts int, opts WebhookOpts) time.Duration {
+	d := opts.MinBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= opts.MaxBackoff {
+			return opts.MaxBackoff
+		}
+	}
+
+	return d
+}
+
+func sendWebhook(ctx context.Context, c *http.Client, url string, secret string, e types.Event, timeout time.Duration) (statusCode int, err error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal event")
+
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		err = errors.Wrap(err, "failed to create request")
+
+		return
+	}
+
+	timestamp := time.Now().Unix()
+
+	req.Header.Set(types.HeaderContentType, types.ContentTypeJson)
+	req.Header.Set(types.HeaderWebhookId, strconv.FormatInt(e.ID, 10))
+	req.Header.Set(types.HeaderWebhookTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(types.HeaderWebhookSignature, signWebhookPayload(secret, timestamp, body))
+
+	rsp, err := c.Do(req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to send request")
+
+		return
+	}
+	defer rsp.Body.Close()
+
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(rsp.Body, 64*1024))
+
+	statusCode = rsp.StatusCode
+	if statusCode < 200 || statusCode >= 300 {
+		err = errors.Errorf("failed as endpoint responded with status code %v", statusCode)
+
+		return
+	}
+
+	return
+}
+
+func attemptWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, c *http.Client, url string, secret string, a types.WebhookDeliveryAttemptModel, occurredAt time.Time, data []byte, timeout time.Duration) (inserted types.WebhookDeliveryAttemptModel, err error) {
+	e := types.Event{
+		Type:       a.EventType,
+		OccurredAt: occurredAt.UTC(),
+		Data:       data,
+	}
+	if a.EventID != nil {
+		e.ID = *a.EventID
+	}
+
+	a.AttemptedAt = time.Now()
+
+	var sendErr error
+	a.StatusCode, sendErr = sendWebhook(ctx, c, url, secret, e, timeout)
+	a.LatencyMs = time.Since(a.AttemptedAt).Milliseconds()
+	if sendErr != nil {
+		a.Error = sendErr.Error()
+		if len(a.Error) > webhookMaxErrorLength {
+			a.Error = a.Error[:webhookMaxErrorLength]
+		}
+	}
+
+	inserted, err = persistence.InsertWebhookDeliveryAttempt(ctx, m, db, a)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert webhook delivery attempt")
+
+		return
+	}
+
+	return
+}
+
+func DeliverWebhooks(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, c *http.Client, opts WebhookOpts) (n int, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"attempted_webhook_deliveries_count", n,
+		)
+
+		if err != nil {
+			err = errors.Wrap(err, "failed to deliver webhooks")
+
+			l.Error(err)
+		} else {
+			l.Debug()
+		}
+	}(time.Now())
+
+	_, err = persistence.DispatchOutboxEvents(ctx, m, db, webhookBatchSize)
+	if err != nil {
+		err = errors.Wrap(err, "failed to dispatch outbox events")
+
+		return
+	}
+
+	ds, err := persistence.ClaimDueWebhookDeliveries(ctx, m, db, webhookBatchSize, webhookClaimTimeout(opts))
+	if err != nil {
+		err = errors.Wrap(err, "failed to claim due webhook deliveries")
+
+		return
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	queue := make(chan []types.ClaimedWebhookDeliveryModel)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	groups := groupWebhookDeliveries(ds)
+	for i := 0; i < concurrency && i < len(groups); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for group := range queue {
+				for _, d := range group {
+					attempted, deliverErr := deliverWebhook(ctx, m, db, c, d, opts)
+
+					mu.Lock()
+					if attempted {
+						n++
+					}
+					if deliverErr != nil && err == nil {
+						err = deliverErr
+					}
+					mu.Unlock()
+
+					// the remaining deliveries of the webhook are picked up again once their claims expire
+					if deliverErr != nil {
+						break
+					}
+				}
+			}
+		}()
+	}
+
+	for _, group := range groups {
+		queue <- group
+	}
+	close(queue)
+
+	wg.Wait()
+
+	return
+}
+
+// webhookClaimTimeout is how long a delivery stays claimed, it covers a single attempt as every delivery's claim is
+// extended right before it's attempted.
+func webhookClaimTimeout(opts WebhookOpts) time.Duration {
+	return opts.Timeout + opts.MinBackoff
+}
+
+// groupWebhookDeliveries groups the deliveries by webhook, keeping their order within each webhook.
+func groupWebhookDeliveries(ds []types.ClaimedWebhookDeliveryModel) (groups [][]types.ClaimedWebhookDeliveryModel) {
+	indexes := map[string]int{}
+	for _, d := range ds {
+		i, ok := indexes[d.WebhookID]
+		if !ok {
+			i = len(groups)
+			indexes[d.WebhookID] = i
+			groups = append(groups, nil)
+		}
+
+		groups[i] = append(groups[i], d)
+	}
+
+	return
+}
+
+// deliverWebhook attempts a claimed delivery, unless another instance reclaimed it in the meantime.
+func deliverWebhook(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, c *http.Client, d types.ClaimedWebhookDeliveryModel, opts WebhookOpts) (attempted bool, err error) {
+	extended, err := persistence.ExtendWebhookDeliveryClaim(ctx, m, db, d.ID, d.Attempts, webhookClaimTimeout(opts))
+	if err != nil {
+		err = errors.Wrapf(err, "failed to extend claim on webhook delivery %v", d.ID)
+
+		return
+	}
+
+	if !extended {
+		return
+	}
+
+	attempted = true
+
+	a, err := attemptWebhook(ctx, m, db, c, d.URL, d.Secret, types.WebhookDeliveryAttemptModel{
+		WebhookID:  d.WebhookID,
+		DeliveryID: &d.ID,
+		EventID:    &d.EventID,
+		EventType:  d.EventType,
+	}, d.EventCreatedAt, d.EventPayload, opts.Timeout)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to attempt webhook delivery %v", d.ID)
+
+		return
+	}
+
+	u := d.WebhookDeliveryModel
+	u.LastStatusCode = a.StatusCode
+	u.LastError = a.Error
+
+	switch {
+	case a.Error == "":
+		u.Status = types.WebhookDeliveryStatusDelivered
+	case d.Attempts >= opts.MaxAttempts:
+		u.Status = types.WebhookDeliveryStatusDead
+	default:
+		u.Status = types.WebhookDeliveryStatusPending
+		u.NextAttemptAt = time.Now().Add(webhookBackoff(d.Attempts, opts))
+	}
+
+	if a.Error != "" {
+		ctxutil.GetContextLogger(ctx).With(
+			"webhook_delivery_id", d.ID,
+			"attempts", d.Attempts,
+			"status", u.Status,
+		).Warn("failed to send webhook: " + a.Error)
+	}
+
+	err = persistence.UpdateWebhookDeliveryAttempt(ctx, m, db, u)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to update webhook delivery %v", d.ID)
+
+		return
+	}
+
+	return
+}
+
+func DeliverWebhooksPeriodically(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, c *http.Client, interval time.Duration, opts WebhookOpts) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		for {
+			n, err := DeliverWebhooks(ctx, m, db, c, opts)
+			if err != nil || n < webhookBatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,95 @@
+// +build unit
+
+package business
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+)
+
+func TestSignWebhookPayload(t *testing.T) {
+	s := signWebhookPayload("secret", 1600000000, []byte(`{"id":1}`))
+
+	assert.Equal(t, "sha256=49847f6653f3434dc0d5563850815d91e18471282eeccadbf48380236b3ed25f", s)
+
+	assert.NotEqual(t, s, signWebhookPayload("other", 1600000000, []byte(`{"id":1}`)))
+	assert.NotEqual(t, s, signWebhookPayload("secret", 1600000001, []byte(`{"id":1}`)))
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	opts := WebhookOpts{
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: time.Minute,
+	}
+
+	tcs := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{attempts: 1, expected: 10 * time.Second},
+		{attempts: 2, expected: 20 * time.Second},
+		{attempts: 3, expected: 40 * time.Second},
+		{attempts: 4, expected: time.Minute},
+		{attempts: 100, expected: time.Minute},
+	}
+
+	for _, tc := range tcs {
+		assert.Equal(t, tc.expected, webhookBackoff(tc.attempts, opts))
+	}
+}
+
+func TestCheckWebhookIP(t *testing.T) {
+	for _, ip := range []string{"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "100.64.0.1", "0.0.0.0", "224.0.0.1", "::1", "::", "fd00::1", "fe80::1", "::ffff:127.0.0.1"} {
+		assert.Equal(t, errWebhookAddressNotAllowed, errors.Cause(checkWebhookIP(net.ParseIP(ip))), ip)
+	}
+
+	for _, ip := range []string{"93.184.216.34", "8.8.8.8", "2606:2800:220:1:248:1893:25c8:1946"} {
+		assert.NoError(t, checkWebhookIP(net.ParseIP(ip)), ip)
+	}
+
+	assert.Equal(t, errWebhookAddressNotAllowed, errors.Cause(checkWebhookIP(nil)))
+}
+
+func TestNewWebhookClient(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.String(), http.StatusFound)
+	}))
+	defer s.Close()
+
+	_, err := NewWebhookClient(false).Get(s.URL)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), errWebhookAddressNotAllowed.Error(), "loopback is refused by default")
+	}
+
+	_, err = NewWebhookClient(true).Get(s.URL)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "redirected more than 3 times")
+	}
+}
+
+func TestGroupWebhookDeliveries(t *testing.T) {
+	delivery := func(id int64, webhookID string) types.ClaimedWebhookDeliveryModel {
+		return types.ClaimedWebhookDeliveryModel{WebhookDeliveryModel: types.WebhookDeliveryModel{ID: id, WebhookID: webhookID}}
+	}
+
+	assert.Equal(t, [][]types.ClaimedWebhookDeliveryModel{
+		{delivery(1, "a"), delivery(3, "a"), delivery(4, "a")},
+		{delivery(2, "b"), delivery(5, "b")},
+	}, groupWebhookDeliveries([]types.ClaimedWebhookDeliveryModel{
+		delivery(1, "a"),
+		delivery(2, "b"),
+		delivery(3, "a"),
+		delivery(4, "a"),
+		delivery(5, "b"),
+	}))
+
+	assert.Empty(t, groupWebhookDeliveries(nil))
+}
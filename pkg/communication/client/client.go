@@ -1,11 +1,16 @@
 package client
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/ppwfx/user-svc/pkg/types"
@@ -47,6 +52,174 @@ func DeleteUser(ctx context.Context, c *http.Client, addr string, token string,
 	return
 }
 
+func GetMe(ctx context.Context, c *http.Client, addr string, token string, req types.GetMeRequest) (httpRsp *http.Response, rsp types.GetMeResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteGetMe, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func UpdateProfile(ctx context.Context, c *http.Client, addr string, token string, req types.UpdateProfileRequest) (httpRsp *http.Response, rsp types.UpdateProfileResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteUpdateProfile, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ChangePassword(ctx context.Context, c *http.Client, addr string, token string, req types.ChangePasswordRequest) (httpRsp *http.Response, rsp types.ChangePasswordResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteChangePassword, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func DeleteMyAccount(ctx context.Context, c *http.Client, addr string, token string, req types.DeleteMyAccountRequest) (httpRsp *http.Response, rsp types.DeleteMyAccountResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteDeleteMyAccount, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func UpdateUser(ctx context.Context, c *http.Client, addr string, token string, req types.UpdateUserRequest) (httpRsp *http.Response, rsp types.UpdateUserResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteUpdateUser, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func SearchUsers(ctx context.Context, c *http.Client, addr string, token string, req types.SearchUsersRequest) (httpRsp *http.Response, rsp types.SearchUsersResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteSearchUsers, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RestoreUser(ctx context.Context, c *http.Client, addr string, token string, req types.RestoreUserRequest) (httpRsp *http.Response, rsp types.RestoreUserResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteRestoreUser, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ExportMyData(ctx context.Context, c *http.Client, addr string, token string, req types.ExportMyDataRequest) (httpRsp *http.Response, rsp types.ExportMyDataResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteExportMyData, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ExportUserData(ctx context.Context, c *http.Client, addr string, token string, req types.ExportUserDataRequest) (httpRsp *http.Response, rsp types.ExportUserDataResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteExportUserData, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func DownloadDataExport(ctx context.Context, c *http.Client, addr string, downloadURL string) (httpRsp *http.Response, archive types.DataExportArchive, rsp types.DownloadDataExportResponse, err error) {
+	r, err := http.NewRequest(http.MethodGet, addr+downloadURL, nil)
+	if err != nil {
+		return
+	}
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	b, err := ioutil.ReadAll(httpRsp.Body)
+	if err != nil {
+		return
+	}
+
+	if httpRsp.StatusCode == http.StatusOK {
+		err = json.Unmarshal(b, &archive)
+	} else {
+		err = json.Unmarshal(b, &rsp)
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "failed to unmarshal json: %s", b)
+
+		return
+	}
+
+	return
+}
+
+func StreamUserEvents(ctx context.Context, c *http.Client, addr string, token string, lastEventID string, f func(e types.Event) (next bool)) (httpRsp *http.Response, rsp types.ErrorResponse, err error) {
+	r, err := http.NewRequest(http.MethodGet, addr+types.RouteStreamUserEvents, nil)
+	if err != nil {
+		return
+	}
+	r.Header.Set(types.HeaderAuthorization, types.PrefixBearer+token)
+	if lastEventID != "" {
+		r.Header.Set(types.HeaderLastEventId, lastEventID)
+	}
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	if httpRsp.StatusCode != http.StatusOK {
+		err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+		if err != nil {
+			err = errors.Wrap(err, "failed to unmarshal json")
+
+			return
+		}
+
+		return
+	}
+
+	s := bufio.NewScanner(httpRsp.Body)
+	for s.Scan() {
+		if !strings.HasPrefix(s.Text(), "data: ") {
+			continue
+		}
+
+		var e types.Event
+		err = json.Unmarshal([]byte(strings.TrimPrefix(s.Text(), "data: ")), &e)
+		if err != nil {
+			err = errors.Wrapf(err, "failed to unmarshal event: %s", s.Text())
+
+			return
+		}
+
+		if !f(e) {
+			return
+		}
+	}
+
+	err = s.Err()
+	if err != nil {
+		err = errors.Wrap(err, "failed to read event stream")
+
+		return
+	}
+
+	return
+}
+
 func do(ctx context.Context, c *http.Client, addr string, path string, token string, req interface{}, rsp interface{}) (httpRsp *http.Response, err error) {
 	var buf bytes.Buffer
 	err = json.NewEncoder(&buf).Encode(req)
@@ -84,3 +257,624 @@ func do(ctx context.Context, c *http.Client, addr string, path string, token str
 
 	return
 }
+
+func ListAuditEvents(ctx context.Context, c *http.Client, addr string, token string, req types.ListAuditEventsRequest) (httpRsp *http.Response, rsp types.ListAuditEventsResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteListAuditEvents, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func VerifyAuditEvents(ctx context.Context, c *http.Client, addr string, token string, req types.VerifyAuditEventsRequest) (httpRsp *http.Response, rsp types.VerifyAuditEventsResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteVerifyAuditEvents, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RegisterWebhook(ctx context.Context, c *http.Client, addr string, token string, req types.RegisterWebhookRequest) (httpRsp *http.Response, rsp types.RegisterWebhookResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteRegisterWebhook, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ListDeadWebhookDeliveries(ctx context.Context, c *http.Client, addr string, token string, req types.ListDeadWebhookDeliveriesRequest) (httpRsp *http.Response, rsp types.ListDeadWebhookDeliveriesResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteListDeadWebhookDeliveries, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RetryWebhookDelivery(ctx context.Context, c *http.Client, addr string, token string, req types.RetryWebhookDeliveryRequest) (httpRsp *http.Response, rsp types.RetryWebhookDeliveryResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteRetryWebhookDelivery, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ListWebhooks(ctx context.Context, c *http.Client, addr string, token string, req types.ListWebhooksRequest) (httpRsp *http.Response, rsp types.ListWebhooksResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteListWebhooks, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func UpdateWebhook(ctx context.Context, c *http.Client, addr string, token string, req types.UpdateWebhookRequest) (httpRsp *http.Response, rsp types.UpdateWebhookResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteUpdateWebhook, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func PauseWebhook(ctx context.Context, c *http.Client, addr string, token string, req types.PauseWebhookRequest) (httpRsp *http.Response, rsp types.PauseWebhookResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RoutePauseWebhook, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ResumeWebhook(ctx context.Context, c *http.Client, addr string, token string, req types.ResumeWebhookRequest) (httpRsp *http.Response, rsp types.ResumeWebhookResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteResumeWebhook, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func DeleteWebhook(ctx context.Context, c *http.Client, addr string, token string, req types.DeleteWebhookRequest) (httpRsp *http.Response, rsp types.DeleteWebhookResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteDeleteWebhook, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RotateWebhookSecret(ctx context.Context, c *http.Client, addr string, token string, req types.RotateWebhookSecretRequest) (httpRsp *http.Response, rsp types.RotateWebhookSecretResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteRotateWebhookSecret, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func PingWebhook(ctx context.Context, c *http.Client, addr string, token string, req types.PingWebhookRequest) (httpRsp *http.Response, rsp types.PingWebhookResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RoutePingWebhook, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ListWebhookDeliveryAttempts(ctx context.Context, c *http.Client, addr string, token string, req types.ListWebhookDeliveryAttemptsRequest) (httpRsp *http.Response, rsp types.ListWebhookDeliveryAttemptsResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteListWebhookDeliveryAttempts, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ImportUsers(ctx context.Context, c *http.Client, addr string, token string, req types.ImportUsersRequest) (httpRsp *http.Response, rsp types.ImportUsersResponse, err error) {
+	q := url.Values{}
+	q.Set(types.QueryFormat, req.Format)
+	q.Set(types.QueryDryRun, strconv.FormatBool(req.DryRun))
+	q.Set(types.QueryHashedPasswords, strconv.FormatBool(req.HashedPasswords))
+
+	r, err := http.NewRequest(http.MethodPost, addr+types.RouteImportUsers+"?"+q.Encode(), req.Body)
+	if err != nil {
+		return
+	}
+	switch req.Format {
+	case types.ImportFormatCsv:
+		r.Header.Set(types.HeaderContentType, types.ContentTypeCsv)
+	case types.ImportFormatJsonl:
+		r.Header.Set(types.HeaderContentType, types.ContentTypeJsonl)
+	}
+	r.Header.Set(types.HeaderAuthorization, types.PrefixBearer+token)
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal json")
+
+		return
+	}
+
+	return
+}
+
+func ExportUsers(ctx context.Context, c *http.Client, addr string, token string, req types.ExportUsersRequest, w io.Writer) (httpRsp *http.Response, rsp types.ExportUsersResponse, err error) {
+	var buf bytes.Buffer
+	err = json.NewEncoder(&buf).Encode(req)
+	if err != nil {
+		return
+	}
+
+	r, err := http.NewRequest(http.MethodPost, addr+types.RouteExportUsers, &buf)
+	if err != nil {
+		return
+	}
+	r.Header.Set(types.HeaderContentType, types.ContentTypeJson)
+	r.Header.Set(types.HeaderAuthorization, types.PrefixBearer+token)
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	if httpRsp.StatusCode != http.StatusOK {
+		err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+		if err != nil {
+			err = errors.Wrap(err, "failed to unmarshal json")
+
+			return
+		}
+
+		return
+	}
+
+	_, err = io.Copy(w, httpRsp.Body)
+	if err != nil {
+		err = errors.Wrap(err, "failed to read export")
+
+		return
+	}
+
+	return
+}
+
+func ScimCreateUser(ctx context.Context, c *http.Client, addr string, token string, req types.ScimUser) (httpRsp *http.Response, rsp types.ScimUserResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodPost, addr+types.RouteScimUsers, token, req, &rsp.User)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimGetUser(ctx context.Context, c *http.Client, addr string, token string, id string) (httpRsp *http.Response, rsp types.ScimUserResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodGet, addr+types.RouteScimUsers+"/"+url.PathEscape(id), token, nil, &rsp.User)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimListUsers(ctx context.Context, c *http.Client, addr string, token string, req types.ScimListRequest) (httpRsp *http.Response, rsp types.ScimListUsersResponse, users []types.ScimUser, err error) {
+	rsp.List.Resources = &users
+
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodGet, addr+types.RouteScimUsers+"?"+scimListQuery(req), token, nil, &rsp.List)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimReplaceUser(ctx context.Context, c *http.Client, addr string, token string, id string, req types.ScimUser) (httpRsp *http.Response, rsp types.ScimUserResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodPut, addr+types.RouteScimUsers+"/"+url.PathEscape(id), token, req, &rsp.User)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimPatchUser(ctx context.Context, c *http.Client, addr string, token string, id string, req types.ScimPatchRequest) (httpRsp *http.Response, rsp types.ScimUserResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodPatch, addr+types.RouteScimUsers+"/"+url.PathEscape(id), token, req, &rsp.User)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimDeleteUser(ctx context.Context, c *http.Client, addr string, token string, id string) (httpRsp *http.Response, rsp types.ScimDeleteResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodDelete, addr+types.RouteScimUsers+"/"+url.PathEscape(id), token, nil, nil)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimListGroups(ctx context.Context, c *http.Client, addr string, token string, req types.ScimListRequest) (httpRsp *http.Response, rsp types.ScimListGroupsResponse, groups []types.ScimGroup, err error) {
+	rsp.List.Resources = &groups
+
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodGet, addr+types.RouteScimGroups+"?"+scimListQuery(req), token, nil, &rsp.List)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimGetGroup(ctx context.Context, c *http.Client, addr string, token string, id string) (httpRsp *http.Response, rsp types.ScimGroupResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodGet, addr+types.RouteScimGroups+"/"+url.PathEscape(id), token, nil, &rsp.Group)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimReplaceGroup(ctx context.Context, c *http.Client, addr string, token string, id string, req types.ScimGroup) (httpRsp *http.Response, rsp types.ScimGroupResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodPut, addr+types.RouteScimGroups+"/"+url.PathEscape(id), token, req, &rsp.Group)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ScimPatchGroup(ctx context.Context, c *http.Client, addr string, token string, id string, req types.ScimPatchRequest) (httpRsp *http.Response, rsp types.ScimGroupResponse, err error) {
+	httpRsp, rsp.Error, err = doScim(ctx, c, http.MethodPatch, addr+types.RouteScimGroups+"/"+url.PathEscape(id), token, req, &rsp.Group)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func scimListQuery(req types.ScimListRequest) string {
+	q := url.Values{}
+	if req.Filter != "" {
+		q.Set(types.QueryFilter, req.Filter)
+	}
+	if req.StartIndex != 0 {
+		q.Set(types.QueryStartIndex, strconv.Itoa(req.StartIndex))
+	}
+	if req.Count != 0 {
+		q.Set(types.QueryCount, strconv.Itoa(req.Count))
+	}
+	if req.ExcludedAttributes != "" {
+		q.Set(types.QueryExcludedAttributes, req.ExcludedAttributes)
+	}
+
+	return q.Encode()
+}
+
+func doScim(ctx context.Context, c *http.Client, method string, u string, token string, req interface{}, rsp interface{}) (httpRsp *http.Response, serr *types.ScimError, err error) {
+	var body io.Reader
+	if req != nil {
+		var b []byte
+		b, err = json.Marshal(req)
+		if err != nil {
+			err = errors.Wrap(err, "failed to marshal json")
+
+			return
+		}
+
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return
+	}
+	if req != nil {
+		r.Header.Set(types.HeaderContentType, types.ContentTypeScimJson)
+	}
+	r.Header.Set(types.HeaderAuthorization, types.PrefixBearer+token)
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	if httpRsp.StatusCode == http.StatusNoContent {
+		return
+	}
+
+	if httpRsp.StatusCode >= http.StatusBadRequest {
+		serr = &types.ScimError{}
+		rsp = serr
+	}
+
+	err = json.NewDecoder(httpRsp.Body).Decode(rsp)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal json")
+
+		return
+	}
+
+	return
+}
+
+func StartOidcLogin(ctx context.Context, c *http.Client, addr string, req types.OidcLoginRequest) (httpRsp *http.Response, rsp types.OidcLoginResponse, stateCookie *http.Cookie, err error) {
+	q := url.Values{}
+	if req.Provider != "" {
+		q.Set(types.QueryProvider, req.Provider)
+	}
+	if req.Email != "" {
+		q.Set(types.QueryEmail, req.Email)
+	}
+
+	r, err := http.NewRequest(http.MethodGet, addr+types.RouteOidcLogin+"?"+q.Encode(), nil)
+	if err != nil {
+		return
+	}
+
+	nc := *c
+	nc.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	httpRsp, err = nc.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal json")
+
+		return
+	}
+
+	for _, ck := range httpRsp.Cookies() {
+		if ck.Name == types.CookieOidcState {
+			stateCookie = ck
+		}
+	}
+
+	return
+}
+
+func FinishOidcLogin(ctx context.Context, c *http.Client, callbackURL string, stateCookie *http.Cookie) (httpRsp *http.Response, rsp types.OidcCallbackResponse, err error) {
+	r, err := http.NewRequest(http.MethodGet, callbackURL, nil)
+	if err != nil {
+		return
+	}
+	if stateCookie != nil {
+		r.AddCookie(&http.Cookie{Name: stateCookie.Name, Value: stateCookie.Value})
+	}
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal json")
+
+		return
+	}
+
+	return
+}
+
+func GetSamlMetadata(ctx context.Context, c *http.Client, addr string, req types.SamlMetadataRequest) (httpRsp *http.Response, rsp types.SamlMetadataResponse, err error) {
+	r, err := http.NewRequest(http.MethodGet, addr+types.RouteSamlMetadata+"?"+url.Values{types.QueryProvider: {req.Provider}}.Encode(), nil)
+	if err != nil {
+		return
+	}
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	if httpRsp.StatusCode == http.StatusOK {
+		rsp.Metadata, err = ioutil.ReadAll(httpRsp.Body)
+		if err != nil {
+			err = errors.Wrap(err, "failed to read metadata")
+
+			return
+		}
+
+		return
+	}
+
+	err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal json")
+
+		return
+	}
+
+	return
+}
+
+func FinishSamlLogin(ctx context.Context, c *http.Client, addr string, req types.SamlAcsRequest) (httpRsp *http.Response, rsp types.SamlAcsResponse, err error) {
+	r, err := http.NewRequest(http.MethodPost, addr+types.RouteSamlAcs+"?"+url.Values{types.QueryProvider: {req.Provider}}.Encode(), strings.NewReader(url.Values{types.FormSamlResponse: {req.SamlResponse}}.Encode()))
+	if err != nil {
+		return
+	}
+	r.Header.Set(types.HeaderContentType, "application/x-www-form-urlencoded")
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal json")
+
+		return
+	}
+
+	return
+}
+
+func RequestMagicLink(ctx context.Context, c *http.Client, addr string, req types.RequestMagicLinkRequest) (httpRsp *http.Response, rsp types.RequestMagicLinkResponse, nonceCookie *http.Cookie, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteRequestMagicLink, "", req, &rsp)
+	if err != nil {
+		return
+	}
+
+	for _, ck := range httpRsp.Cookies() {
+		if ck.Name == types.CookieMagicLinkNonce {
+			nonceCookie = ck
+		}
+	}
+
+	return
+}
+
+func ConsumeMagicLink(ctx context.Context, c *http.Client, addr string, req types.ConsumeMagicLinkRequest, nonceCookie *http.Cookie) (httpRsp *http.Response, rsp types.ConsumeMagicLinkResponse, err error) {
+	b, err := json.Marshal(req)
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal json")
+
+		return
+	}
+
+	r, err := http.NewRequest(http.MethodPost, addr+types.RouteConsumeMagicLink, bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	r.Header.Set(types.HeaderContentType, types.ContentTypeJson)
+	if nonceCookie != nil {
+		r.AddCookie(&http.Cookie{Name: nonceCookie.Name, Value: nonceCookie.Value})
+	}
+
+	httpRsp, err = c.Do(r.WithContext(ctx))
+	if err != nil {
+		return
+	}
+	defer httpRsp.Body.Close()
+
+	err = json.NewDecoder(httpRsp.Body).Decode(&rsp)
+	if err != nil {
+		err = errors.Wrap(err, "failed to unmarshal json")
+
+		return
+	}
+
+	return
+}
+
+func InviteUser(ctx context.Context, c *http.Client, addr string, token string, req types.InviteUserRequest) (httpRsp *http.Response, rsp types.InviteUserResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteInviteUser, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ListInvitations(ctx context.Context, c *http.Client, addr string, token string, req types.ListInvitationsRequest) (httpRsp *http.Response, rsp types.ListInvitationsResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteListInvitations, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func RevokeInvitation(ctx context.Context, c *http.Client, addr string, token string, req types.RevokeInvitationRequest) (httpRsp *http.Response, rsp types.RevokeInvitationResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteRevokeInvitation, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func AcceptInvitation(ctx context.Context, c *http.Client, addr string, req types.AcceptInvitationRequest) (httpRsp *http.Response, rsp types.AcceptInvitationResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteAcceptInvitation, "", req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func PutAttributeSchema(ctx context.Context, c *http.Client, addr string, token string, req types.PutAttributeSchemaRequest) (httpRsp *http.Response, rsp types.PutAttributeSchemaResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RoutePutAttributeSchema, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ListAttributeSchemas(ctx context.Context, c *http.Client, addr string, token string, req types.ListAttributeSchemasRequest) (httpRsp *http.Response, rsp types.ListAttributeSchemasResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteListAttributeSchemas, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func DeleteAttributeSchema(ctx context.Context, c *http.Client, addr string, token string, req types.DeleteAttributeSchemaRequest) (httpRsp *http.Response, rsp types.DeleteAttributeSchemaResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteDeleteAttributeSchema, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func SuspendUser(ctx context.Context, c *http.Client, addr string, token string, req types.SuspendUserRequest) (httpRsp *http.Response, rsp types.SuspendUserResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteSuspendUser, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ReactivateUser(ctx context.Context, c *http.Client, addr string, token string, req types.ReactivateUserRequest) (httpRsp *http.Response, rsp types.ReactivateUserResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteReactivateUser, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func Reauthenticate(ctx context.Context, c *http.Client, addr string, token string, req types.ReauthenticateRequest) (httpRsp *http.Response, rsp types.ReauthenticateResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteReauthenticate, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+func ListLoginHistory(ctx context.Context, c *http.Client, addr string, token string, req types.ListLoginHistoryRequest) (httpRsp *http.Response, rsp types.ListLoginHistoryResponse, err error) {
+	httpRsp, err = do(ctx, c, addr, types.RouteListLoginHistory, token, req, &rsp)
+	if err != nil {
+		return
+	}
+
+	return
+}
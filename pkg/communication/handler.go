@@ -2,16 +2,21 @@ package communication
 
 import (
 	"encoding/json"
+	"fmt"
 	"github.com/armon/go-metrics"
 	"github.com/go-playground/validator/v10"
 	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/ppwfx/user-svc/pkg/business"
+	"github.com/ppwfx/user-svc/pkg/mailing"
 	"github.com/ppwfx/user-svc/pkg/types"
 	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
 	"go.uber.org/zap"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func handleDeleteUser(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, allowedSubjectSuffix string) func(w http.ResponseWriter, r *http.Request) {
@@ -54,7 +59,7 @@ func handleDeleteUser(validator *validator.Validate, logger *zap.SugaredLogger,
 	}
 }
 
-func handleAuthenticate(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string) func(w http.ResponseWriter, r *http.Request) {
+func handleAuthenticate(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string, oidcProviders *business.OidcProviders, authenticators business.AuthenticatorChain, lockoutThreshold int, mailer mailing.Mailer) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var rsp types.AuthenticateResponse
 		var statusCode int
@@ -78,7 +83,7 @@ func handleAuthenticate(validator *validator.Validate, logger *zap.SugaredLogger
 			return
 		}
 
-		rsp, statusCode = business.Authenticate(r.Context(), metrics, db, validator, hmacSecret, req)
+		rsp, statusCode = business.Authenticate(r.Context(), metrics, db, validator, hmacSecret, oidcProviders, authenticators, lockoutThreshold, mailer, req)
 
 		return
 	}
@@ -143,3 +148,1554 @@ func handleCreateUser(validator *validator.Validate, logger *zap.SugaredLogger,
 		return
 	}
 }
+
+func handleGetMe(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.GetMeResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.GetMeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.GetMe(r.Context(), metrics, db, validator, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleUpdateProfile(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.UpdateProfileResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.UpdateProfileRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.UpdateProfile(r.Context(), metrics, db, validator, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleChangePassword(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string, argon2IdOpts business.Argon2IdOpts) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ChangePasswordResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ChangePasswordRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ChangePassword(r.Context(), metrics, db, hmacSecret, argon2IdOpts, validator, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleDeleteMyAccount(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.DeleteMyAccountResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.DeleteMyAccountRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.DeleteMyAccount(r.Context(), metrics, db, validator, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleUpdateUser(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.UpdateUserResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			if rsp.User != nil {
+				w.Header().Set(types.HeaderETag, strconv.Quote(rsp.User.Version))
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.UpdateUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		if req.Version == "" {
+			req.Version = extractIfMatch(r)
+		}
+
+		rsp, statusCode = business.UpdateUser(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleSearchUsers(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.SearchUsersResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.SearchUsersRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.SearchUsers(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleRestoreUser(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, deletionGracePeriod time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.RestoreUserResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.RestoreUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.RestoreUser(r.Context(), metrics, db, validator, deletionGracePeriod, req)
+
+		return
+	}
+}
+
+func handleExportMyData(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, dataExportTtl time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ExportMyDataResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ExportMyDataRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ExportMyData(r.Context(), metrics, db, validator, dataExportTtl, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleExportUserData(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, dataExportTtl time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ExportUserDataResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ExportUserDataRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ExportUserData(r.Context(), metrics, db, validator, dataExportTtl, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleDownloadDataExport(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.DownloadDataExportResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			switch statusCode {
+			case http.StatusOK:
+				w.Header().Set(types.HeaderContentType, "application/json; charset=utf-8")
+				w.Header().Set(types.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"user-data-%s.json\"", rsp.ID))
+				w.WriteHeader(statusCode)
+
+				_, err = w.Write(rsp.Archive)
+				if err != nil {
+					logger.Error(err)
+				}
+
+				return
+			case http.StatusAccepted:
+				w.Header().Set(types.HeaderRetryAfter, "5")
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		req := types.DownloadDataExportRequest{
+			Token: r.URL.Query().Get(types.QueryToken),
+		}
+
+		rsp, statusCode = business.DownloadDataExport(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleListAuditEvents(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ListAuditEventsResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ListAuditEventsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ListAuditEvents(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleVerifyAuditEvents(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.VerifyAuditEventsResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.VerifyAuditEventsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.VerifyAuditEvents(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleRegisterWebhook(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.RegisterWebhookResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.RegisterWebhookRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.RegisterWebhook(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleListDeadWebhookDeliveries(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ListDeadWebhookDeliveriesResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ListDeadWebhookDeliveriesRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ListDeadWebhookDeliveries(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleRetryWebhookDelivery(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.RetryWebhookDeliveryResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.RetryWebhookDeliveryRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.RetryWebhookDelivery(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleListWebhooks(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ListWebhooksResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ListWebhooksRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ListWebhooks(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleUpdateWebhook(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.UpdateWebhookResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.UpdateWebhookRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.UpdateWebhook(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handlePauseWebhook(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.PauseWebhookResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.PauseWebhookRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.PauseWebhook(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleResumeWebhook(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ResumeWebhookResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ResumeWebhookRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ResumeWebhook(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleDeleteWebhook(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.DeleteWebhookResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.DeleteWebhookRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.DeleteWebhook(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleRotateWebhookSecret(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.RotateWebhookSecretResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.RotateWebhookSecretRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.RotateWebhookSecret(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handlePingWebhook(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, webhookClient *http.Client) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.PingWebhookResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.PingWebhookRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.PingWebhook(r.Context(), metrics, db, validator, webhookClient, req)
+
+		return
+	}
+}
+
+func handleListWebhookDeliveryAttempts(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ListWebhookDeliveryAttemptsResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ListWebhookDeliveryAttemptsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ListWebhookDeliveryAttempts(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+const (
+	eventStreamRetry           = time.Second
+	eventStreamHeartbeatPeriod = 15 * time.Second
+	eventStreamWriteMargin     = 5 * time.Second
+)
+
+func handleStreamUserEvents(logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, broadcaster *business.EventBroadcaster, streamDuration time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := ctxutil.GetContextLogger(r.Context())
+
+		f, ok := w.(http.Flusher)
+		if !ok {
+			writeJsonResponse(l, w, http.StatusInternalServerError, types.ErrorResponse{
+				Error: types.ErrorInternalError,
+			})
+
+			return
+		}
+
+		events, unsubscribe := broadcaster.Subscribe()
+		defer unsubscribe()
+
+		var lastEventID int64
+		resume := false
+		if v := r.Header.Get(types.HeaderLastEventId); v != "" {
+			var err error
+			lastEventID, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeJsonResponse(l, w, http.StatusUnprocessableEntity, types.ErrorResponse{
+					Error: types.ErrorInvalidLastEventId,
+				})
+
+				return
+			}
+
+			resume = true
+		}
+
+		// a client that doesn't resume starts from the last sequence, which is sent right away, so that
+		// it resumes from there even if no event is streamed before it reconnects
+		if !resume {
+			var err error
+			lastEventID, err = business.GetLastEventSequence(r.Context(), metrics, db)
+			if err != nil {
+				writeJsonResponse(l, w, http.StatusInternalServerError, types.ErrorResponse{
+					Error: types.ErrorInternalError,
+				})
+
+				return
+			}
+		}
+
+		// the stream outlives the server's write timeout
+		err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(streamDuration + eventStreamWriteMargin))
+		if err != nil {
+			err = errors.Wrap(err, "failed to extend write deadline")
+
+			l.Warn(err)
+		}
+
+		w.Header().Set(types.HeaderContentType, types.ContentTypeEventStream)
+		w.Header().Set(types.HeaderCacheControl, "no-cache")
+		w.WriteHeader(http.StatusOK)
+
+		_, err = fmt.Fprintf(w, "retry: %d\nid: %d\n\n", eventStreamRetry.Milliseconds(), lastEventID)
+		if err != nil {
+			return
+		}
+
+		// the replay pages until it caught up, events sequenced in the meantime arrive through the subscription
+		for more := resume; more; {
+			var replayed []types.Event
+			replayed, more, err = business.ReplayEvents(r.Context(), metrics, db, lastEventID)
+			if err != nil {
+				return
+			}
+
+			for _, e := range replayed {
+				err = writeServerSentEvent(w, e)
+				if err != nil {
+					return
+				}
+
+				lastEventID = e.Sequence
+			}
+
+			f.Flush()
+		}
+
+		f.Flush()
+
+		// the stream ends before its write deadline, clients reconnect with Last-Event-ID
+		timer := time.NewTimer(streamDuration)
+		defer timer.Stop()
+
+		// heartbeats keep the connection alive, and repeat the last sequence sent to the client
+		heartbeat := time.NewTicker(eventStreamHeartbeatPeriod)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+				return
+			case <-heartbeat.C:
+				_, err = fmt.Fprintf(w, "id: %d\n\n", lastEventID)
+				if err != nil {
+					l.Warn(errors.Wrap(err, "failed to write heartbeat"))
+
+					return
+				}
+
+				f.Flush()
+			case e, ok := <-events:
+				if !ok {
+					return
+				}
+
+				if e.Sequence <= lastEventID {
+					continue
+				}
+
+				err = writeServerSentEvent(w, e)
+				if err != nil {
+					l.Warn(errors.Wrap(err, "failed to write server-sent event"))
+
+					return
+				}
+
+				lastEventID = e.Sequence
+
+				f.Flush()
+			}
+		}
+	}
+}
+
+func writeServerSentEvent(w io.Writer, e types.Event) (err error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		err = errors.Wrap(err, "failed to marshal event")
+
+		return
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Sequence, e.Type, b)
+	if err != nil {
+		err = errors.Wrap(err, "failed to write event")
+
+		return
+	}
+
+	return
+}
+
+func handleImportUsers(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, allowedSubjectSuffix string, argon2IdOpts business.Argon2IdOpts) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ImportUsersResponse
+		var statusCode int
+
+		l := ctxutil.GetContextLogger(r.Context())
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				l.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		q := r.URL.Query()
+
+		req := types.ImportUsersRequest{
+			Format: q.Get(types.QueryFormat),
+			Body:   r.Body,
+		}
+
+		if req.Format == "" {
+			switch strings.Split(r.Header.Get(types.HeaderContentType), ";")[0] {
+			case types.ContentTypeCsv:
+				req.Format = types.ImportFormatCsv
+			case types.ContentTypeJsonl:
+				req.Format = types.ImportFormatJsonl
+			}
+		}
+
+		var err error
+		for k, b := range map[string]*bool{types.QueryDryRun: &req.DryRun, types.QueryHashedPasswords: &req.HashedPasswords} {
+			if q.Get(k) == "" {
+				continue
+			}
+
+			*b, err = strconv.ParseBool(q.Get(k))
+			if err != nil {
+				err = errors.Wrapf(err, "failed to parse query parameter %s", k)
+				l.Error(err)
+
+				statusCode = http.StatusNotAcceptable
+
+				return
+			}
+		}
+
+		rsp, statusCode = business.ImportUsers(r.Context(), metrics, db, argon2IdOpts, validator, allowedSubjectSuffix, req)
+
+		return
+	}
+}
+
+func handleExportUsers(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, exportTimeout time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ExportUsersResponse
+		var statusCode int
+		var started bool
+
+		l := ctxutil.GetContextLogger(r.Context())
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				l.Error(err)
+			}
+
+			if started {
+				return
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ExportUsersRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ExportUsers(r.Context(), metrics, db, validator, req, func() io.Writer {
+			started = true
+
+			err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportTimeout))
+			if err != nil {
+				err = errors.Wrap(err, "failed to extend write deadline")
+
+				l.Warn(err)
+			}
+
+			contentType := map[string]string{
+				types.ExportFormatCsv:     types.ContentTypeCsv,
+				types.ExportFormatJsonl:   types.ContentTypeJsonl,
+				types.ExportFormatParquet: types.ContentTypeParquet,
+			}[req.Format]
+
+			w.Header().Set(types.HeaderContentType, contentType)
+			w.Header().Set(types.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"users.%s\"", req.Format))
+			w.WriteHeader(http.StatusOK)
+
+			return w
+		})
+
+		return
+	}
+}
+
+func handleOidcLogin(validator *validator.Validate, logger *zap.SugaredLogger, oidcProviders *business.OidcProviders, hmacSecret string) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.OidcLoginResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			if statusCode == http.StatusFound {
+				http.SetCookie(w, &http.Cookie{
+					Name:     types.CookieOidcState,
+					Value:    rsp.LoginState,
+					Path:     types.RouteOidcCallback,
+					MaxAge:   int(business.OidcLoginStateTtl.Seconds()),
+					Secure:   true,
+					HttpOnly: true,
+					SameSite: http.SameSiteLaxMode,
+				})
+				w.Header().Set("Location", rsp.AuthorizationURL)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		req := types.OidcLoginRequest{
+			Provider: r.URL.Query().Get(types.QueryProvider),
+			Email:    r.URL.Query().Get(types.QueryEmail),
+		}
+
+		rsp, statusCode = business.StartOidcLogin(r.Context(), oidcProviders, validator, hmacSecret, req)
+
+		return
+	}
+}
+
+func handleOidcCallback(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, oidcProviders *business.OidcProviders, hmacSecret string, allowedSubjectSuffix string, argon2IdOpts business.Argon2IdOpts, mailer mailing.Mailer) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.OidcCallbackResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			http.SetCookie(w, &http.Cookie{
+				Name:     types.CookieOidcState,
+				Path:     types.RouteOidcCallback,
+				MaxAge:   -1,
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		q := r.URL.Query()
+
+		req := types.OidcCallbackRequest{
+			Code:             q.Get(types.QueryCode),
+			State:            q.Get(types.QueryState),
+			Error:            q.Get(types.QueryError),
+			ErrorDescription: q.Get(types.QueryErrorDescription),
+		}
+
+		c, err := r.Cookie(types.CookieOidcState)
+		if err == nil {
+			req.LoginState = c.Value
+		}
+
+		rsp, statusCode = business.FinishOidcLogin(r.Context(), metrics, db, validator, oidcProviders, hmacSecret, allowedSubjectSuffix, argon2IdOpts, mailer, req)
+
+		return
+	}
+}
+
+func handleSamlMetadata(validator *validator.Validate, logger *zap.SugaredLogger, samlProviders *business.SamlProviders) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.SamlMetadataResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			if statusCode != http.StatusOK {
+				writeJsonResponse(logger, w, statusCode, rsp)
+
+				return
+			}
+
+			w.Header().Set(types.HeaderContentType, types.ContentTypeSamlMetadata)
+			w.WriteHeader(statusCode)
+
+			_, err = w.Write(rsp.Metadata)
+			if err != nil {
+				err = errors.Wrap(err, "failed to write response")
+
+				logger.Error(err)
+			}
+		}()
+
+		req := types.SamlMetadataRequest{
+			Provider: r.URL.Query().Get(types.QueryProvider),
+		}
+
+		rsp, statusCode = business.GetSamlMetadata(r.Context(), validator, samlProviders, req)
+
+		return
+	}
+}
+
+func handleSamlAcs(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, samlProviders *business.SamlProviders, hmacSecret string, allowedSubjectSuffix string, argon2IdOpts business.Argon2IdOpts, mailer mailing.Mailer) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.SamlAcsResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		req := types.SamlAcsRequest{
+			Provider:     r.URL.Query().Get(types.QueryProvider),
+			SamlResponse: r.PostFormValue(types.FormSamlResponse),
+		}
+
+		rsp, statusCode = business.FinishSamlLogin(r.Context(), metrics, db, validator, samlProviders, hmacSecret, allowedSubjectSuffix, argon2IdOpts, mailer, req)
+
+		return
+	}
+}
+
+func handleRequestMagicLink(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, mailer mailing.Mailer, oidcProviders *business.OidcProviders, magicLinkOpts business.MagicLinkOpts) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.RequestMagicLinkResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			if statusCode == http.StatusOK {
+				http.SetCookie(w, &http.Cookie{
+					Name:     types.CookieMagicLinkNonce,
+					Value:    rsp.Nonce,
+					Path:     types.RouteConsumeMagicLink,
+					MaxAge:   int(magicLinkOpts.Ttl.Seconds()),
+					Secure:   true,
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.RequestMagicLinkRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.RequestMagicLink(r.Context(), metrics, db, validator, mailer, oidcProviders, magicLinkOpts, req)
+
+		return
+	}
+}
+
+func handleConsumeMagicLink(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string, mailer mailing.Mailer) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ConsumeMagicLinkResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			if statusCode == http.StatusOK {
+				http.SetCookie(w, &http.Cookie{
+					Name:     types.CookieMagicLinkNonce,
+					Path:     types.RouteConsumeMagicLink,
+					MaxAge:   -1,
+					Secure:   true,
+					HttpOnly: true,
+					SameSite: http.SameSiteStrictMode,
+				})
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ConsumeMagicLinkRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		c, err := r.Cookie(types.CookieMagicLinkNonce)
+		if err == nil {
+			req.Nonce = c.Value
+		}
+
+		rsp, statusCode = business.ConsumeMagicLink(r.Context(), metrics, db, validator, hmacSecret, mailer, req)
+
+		return
+	}
+}
+
+func handleInviteUser(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, mailer mailing.Mailer, oidcProviders *business.OidcProviders, invitationOpts business.InvitationOpts) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.InviteUserResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.InviteUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.InviteUser(r.Context(), metrics, db, validator, mailer, oidcProviders, invitationOpts, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleListInvitations(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ListInvitationsResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ListInvitationsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ListInvitations(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleRevokeInvitation(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.RevokeInvitationResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.RevokeInvitationRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.RevokeInvitation(r.Context(), metrics, db, validator, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleAcceptInvitation(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string, argon2IdOpts business.Argon2IdOpts) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.AcceptInvitationResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.AcceptInvitationRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.AcceptInvitation(r.Context(), metrics, db, validator, hmacSecret, argon2IdOpts, req)
+
+		return
+	}
+}
+
+func handlePutAttributeSchema(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.PutAttributeSchemaResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.PutAttributeSchemaRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.PutAttributeSchema(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleListAttributeSchemas(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ListAttributeSchemasResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ListAttributeSchemasRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ListAttributeSchemas(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleDeleteAttributeSchema(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.DeleteAttributeSchemaResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.DeleteAttributeSchemaRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.DeleteAttributeSchema(r.Context(), metrics, db, validator, req)
+
+		return
+	}
+}
+
+func handleSuspendUser(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.SuspendUserResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.SuspendUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.SuspendUser(r.Context(), metrics, db, validator, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleReactivateUser(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ReactivateUserResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ReactivateUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ReactivateUser(r.Context(), metrics, db, validator, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleReauthenticate(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string, authenticators business.AuthenticatorChain, lockoutThreshold int) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ReauthenticateResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ReauthenticateRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.Reauthenticate(r.Context(), metrics, db, validator, hmacSecret, authenticators, lockoutThreshold, extractClaimSub(r), req)
+
+		return
+	}
+}
+
+func handleListLoginHistory(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp types.ListLoginHistoryResponse
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeJsonResponse(logger, w, statusCode, rsp)
+		}()
+
+		var req types.ListLoginHistoryRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			statusCode = http.StatusNotAcceptable
+
+			return
+		}
+
+		rsp, statusCode = business.ListLoginHistory(r.Context(), metrics, db, validator, extractClaimSub(r), extractClaimUserGroup(r), req)
+
+		return
+	}
+}
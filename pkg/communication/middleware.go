@@ -3,10 +3,14 @@ package communication
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"time"
 
+	"github.com/armon/go-metrics"
 	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/ppwfx/user-svc/pkg/business"
 	"github.com/ppwfx/user-svc/pkg/types"
@@ -15,7 +19,7 @@ import (
 	"go.uber.org/zap"
 )
 
-func composeAuthMiddleware(hmacSecret string, next http.HandlerFunc) http.HandlerFunc {
+func composeAuthMiddleware(hmacSecret string, metrics metrics.MetricSink, db *sqlx.DB, tokenStates *business.TokenStates, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		t := extractAccessToken(r)
 
@@ -43,14 +47,27 @@ func composeAuthMiddleware(hmacSecret string, next http.HandlerFunc) http.Handle
 
 		r = r.WithContext(context.WithValue(r.Context(), types.ContextKeyClaims, claims))
 
+		rsp, statusCode := tokenStates.VerifyToken(r.Context(), metrics, db, claims)
+		if statusCode != http.StatusOK {
+			writeJsonResponse(l, w, statusCode, rsp)
+
+			return
+		}
+
+		sub, _ := claims[types.ClaimSub].(string)
+
+		r = r.WithContext(ctxutil.WithSubject(r.Context(), sub))
+
 		next(w, r)
 	}
 }
 
-func authorizationMiddleware(next http.HandlerFunc) http.HandlerFunc {
+func authorizationMiddleware(networkPolicies *business.NetworkPolicies, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var scopes []string
 
+		role := types.RoleGuest
+
 		c, ok := r.Context().Value(types.ContextKeyClaims).(map[string]interface{})
 		if ok {
 			r, ok := c[types.ClaimUserGroup].(string)
@@ -58,6 +75,8 @@ func authorizationMiddleware(next http.HandlerFunc) http.HandlerFunc {
 				panic("abc")
 			}
 
+			role = r
+
 			switch r {
 			case types.UserGroupAdmin:
 				scopes = types.RoleAdminScopes
@@ -68,8 +87,39 @@ func authorizationMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			scopes = types.RoleGuestScopes
 		}
 
+		ip := ctxutil.GetRemoteIp(r.Context())
+
+		err := networkPolicies.CheckNetwork(role, ip)
+		if err != nil {
+			l := ctxutil.GetContextLogger(r.Context()).With(
+				types.LogSecurityEvent, types.SecurityEventNetworkDenied,
+				types.LogRole, role,
+				types.LogRemoteIp, ip,
+			)
+
+			l.Warn(errors.Wrapf(err, "failed to authorize request to %s", r.URL.Path))
+
+			writeJsonResponse(l, w, http.StatusForbidden, types.ErrorResponse{
+				Error: types.ErrorNetworkNotAllowed,
+			})
+
+			return
+		}
+
 		for _, s := range scopes {
 			if s == r.URL.Path {
+				if ok && business.RequiresStepUp(c, r.URL.Path, time.Now()) {
+					l := ctxutil.GetContextLogger(r.Context())
+
+					l.Warn("failed to authorize user: authentication is too old for route")
+
+					writeJsonResponse(l, w, http.StatusUnauthorized, types.ErrorResponse{
+						Error: types.ErrorStepUpRequired,
+					})
+
+					return
+				}
+
 				next(w, r)
 
 				return
@@ -129,26 +179,52 @@ func (iw *interceptingWriter) Write(p []byte) (int, error) {
 	return iw.ResponseWriter.Write(p)
 }
 
-func composeContextLoggerMiddleware(l *zap.SugaredLogger, next http.HandlerFunc) http.HandlerFunc {
+func (iw *interceptingWriter) Unwrap() http.ResponseWriter {
+	return iw.ResponseWriter
+}
+
+func (iw *interceptingWriter) Flush() {
+	f, ok := iw.ResponseWriter.(http.Flusher)
+	if ok {
+		f.Flush()
+	}
+}
+
+func composeContextLoggerMiddleware(l *zap.SugaredLogger, trustedProxies *business.TrustedProxies, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		iw := &interceptingWriter{0, http.StatusOK, w}
 
+		id := uuid.New().String()
+
 		l := l.With(
-			types.LogId, uuid.New().String(),
+			types.LogId, id,
 		)
 
 		r = r.WithContext(ctxutil.WithContextLogger(r.Context(), l))
 
+		r = r.WithContext(ctxutil.WithRequestId(r.Context(), id))
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+
+		ip = trustedProxies.ClientIP(ip, r.Header.Values(types.HeaderXForwardedFor))
+
+		r = r.WithContext(ctxutil.WithRemoteIp(r.Context(), ip))
+
+		r = r.WithContext(ctxutil.WithUserAgent(r.Context(), r.UserAgent()))
+
 		begin := time.Now()
 
 		next(iw, r)
 
 		l.With(zap.Object(types.LogHttpRequest, &loggingutil.LogHttpRequest{
 			Method:             r.Method,
-			URL:                r.URL.String(),
+			URL:                redactURL(r.URL),
 			UserAgent:          r.UserAgent(),
 			Referrer:           r.Referer(),
-			RemoteIP:           r.RemoteAddr,
+			RemoteIP:           ip,
 			RequestSize:        r.ContentLength,
 			ResponseSize:       iw.count,
 			ResponseStatusCode: iw.code,
@@ -170,3 +246,28 @@ func composeContextLoggerMiddleware(l *zap.SugaredLogger, next http.HandlerFunc)
 		l.Info()
 	}
 }
+
+// redactedQueryParams carry credentials, such as data export download and magic link tokens, or oidc authorization codes.
+var redactedQueryParams = []string{types.QueryToken, types.QueryCode}
+
+// redactURL returns u with the values of redactedQueryParams replaced, so that credentials aren't written to the request log.
+func redactURL(u *url.URL) string {
+	q := u.Query()
+
+	var redacted bool
+	for _, p := range redactedQueryParams {
+		if _, ok := q[p]; ok {
+			q.Set(p, "REDACTED")
+			redacted = true
+		}
+	}
+
+	if !redacted {
+		return u.String()
+	}
+
+	c := *u
+	c.RawQuery = q.Encode()
+
+	return c.String()
+}
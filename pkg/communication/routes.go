@@ -6,22 +6,26 @@ import (
 	"github.com/go-playground/validator/v10"
 	"github.com/jmoiron/sqlx"
 	"github.com/ppwfx/user-svc/pkg/business"
+	"github.com/ppwfx/user-svc/pkg/mailing"
 	"github.com/ppwfx/user-svc/pkg/types"
 	"go.uber.org/zap"
 	"net/http"
 	"net/http/pprof"
+	"strconv"
 	"strings"
+	"time"
 )
 
-func AddSvcRoutes(mux *http.ServeMux, validate *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string, allowedSubjectSuffix string, argon2IdOpts business.Argon2IdOpts) *http.ServeMux {
+func AddSvcRoutes(mux *http.ServeMux, validate *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, hmacSecret string, allowedSubjectSuffix string, argon2IdOpts business.Argon2IdOpts, deletionGracePeriod time.Duration, dataExportTtl time.Duration, webhookClient *http.Client, eventBroadcaster *business.EventBroadcaster, eventStreamDuration time.Duration, userExportTimeout time.Duration, scimBearerToken string, oidcProviders *business.OidcProviders, authenticators business.AuthenticatorChain, samlProviders *business.SamlProviders, mailer mailing.Mailer, magicLinkOpts business.MagicLinkOpts, invitationOpts business.InvitationOpts, lockoutThreshold int, tokenStates *business.TokenStates, networkPolicies *business.NetworkPolicies, trustedProxies *business.TrustedProxies) *http.ServeMux {
 	var maxBodyBytes int64 = 256 * 1024
+	var maxImportBodyBytes int64 = 32 * 1024 * 1024
 
 	authMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
-		return composeContextLoggerMiddleware(logger,
+		return composeContextLoggerMiddleware(logger, trustedProxies,
 			secureMiddleware(
 				composeMaxBodyBytesMiddleware(maxBodyBytes,
-					composeAuthMiddleware(hmacSecret,
-						authorizationMiddleware(next),
+					composeAuthMiddleware(hmacSecret, metrics, db, tokenStates,
+						authorizationMiddleware(networkPolicies, next),
 					),
 				),
 			),
@@ -29,10 +33,22 @@ func AddSvcRoutes(mux *http.ServeMux, validate *validator.Validate, logger *zap.
 	}
 
 	defaultMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
-		return composeContextLoggerMiddleware(logger,
+		return composeContextLoggerMiddleware(logger, trustedProxies,
 			secureMiddleware(
 				composeMaxBodyBytesMiddleware(maxBodyBytes,
-					authorizationMiddleware(next),
+					authorizationMiddleware(networkPolicies, next),
+				),
+			),
+		)
+	}
+
+	importMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
+		return composeContextLoggerMiddleware(logger, trustedProxies,
+			secureMiddleware(
+				composeMaxBodyBytesMiddleware(maxImportBodyBytes,
+					composeAuthMiddleware(hmacSecret, metrics, db, tokenStates,
+						authorizationMiddleware(networkPolicies, next),
+					),
 				),
 			),
 		)
@@ -44,7 +60,131 @@ func AddSvcRoutes(mux *http.ServeMux, validate *validator.Validate, logger *zap.
 
 	mux.HandleFunc(types.RouteDeleteUser, authMiddleware(handleDeleteUser(validate, logger, metrics, db, allowedSubjectSuffix)))
 
-	mux.HandleFunc(types.RouteAuthenticate, sensitiveMiddleware(defaultMiddleware(handleAuthenticate(validate, logger, metrics, db, hmacSecret))))
+	mux.HandleFunc(types.RouteAuthenticate, sensitiveMiddleware(defaultMiddleware(handleAuthenticate(validate, logger, metrics, db, hmacSecret, oidcProviders, authenticators, lockoutThreshold, mailer))))
+
+	mux.HandleFunc(types.RouteGetMe, sensitiveMiddleware(authMiddleware(handleGetMe(validate, logger, metrics, db))))
+
+	mux.HandleFunc(types.RouteUpdateProfile, authMiddleware(handleUpdateProfile(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteChangePassword, sensitiveMiddleware(authMiddleware(handleChangePassword(validate, logger, metrics, db, hmacSecret, argon2IdOpts))))
+
+	mux.HandleFunc(types.RouteDeleteMyAccount, authMiddleware(handleDeleteMyAccount(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteUpdateUser, authMiddleware(handleUpdateUser(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteSearchUsers, authMiddleware(handleSearchUsers(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteRestoreUser, authMiddleware(handleRestoreUser(validate, logger, metrics, db, deletionGracePeriod)))
+
+	mux.HandleFunc(types.RouteExportMyData, authMiddleware(handleExportMyData(validate, logger, metrics, db, dataExportTtl)))
+
+	mux.HandleFunc(types.RouteExportUserData, authMiddleware(handleExportUserData(validate, logger, metrics, db, dataExportTtl)))
+
+	mux.HandleFunc(types.RouteDownloadDataExport, sensitiveMiddleware(defaultMiddleware(handleDownloadDataExport(validate, logger, metrics, db))))
+
+	mux.HandleFunc(types.RouteListAuditEvents, authMiddleware(handleListAuditEvents(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteVerifyAuditEvents, authMiddleware(handleVerifyAuditEvents(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteRegisterWebhook, authMiddleware(handleRegisterWebhook(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteListWebhooks, authMiddleware(handleListWebhooks(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteUpdateWebhook, authMiddleware(handleUpdateWebhook(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RoutePauseWebhook, authMiddleware(handlePauseWebhook(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteResumeWebhook, authMiddleware(handleResumeWebhook(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteDeleteWebhook, authMiddleware(handleDeleteWebhook(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteRotateWebhookSecret, authMiddleware(handleRotateWebhookSecret(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RoutePingWebhook, authMiddleware(handlePingWebhook(validate, logger, metrics, db, webhookClient)))
+
+	mux.HandleFunc(types.RouteListWebhookDeliveryAttempts, authMiddleware(handleListWebhookDeliveryAttempts(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteListDeadWebhookDeliveries, authMiddleware(handleListDeadWebhookDeliveries(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteRetryWebhookDelivery, authMiddleware(handleRetryWebhookDelivery(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteStreamUserEvents, sensitiveMiddleware(authMiddleware(handleStreamUserEvents(logger, metrics, db, eventBroadcaster, eventStreamDuration))))
+
+	mux.HandleFunc(types.RouteExportUsers, sensitiveMiddleware(authMiddleware(handleExportUsers(validate, logger, metrics, db, userExportTimeout))))
+
+	mux.HandleFunc(types.RouteImportUsers, importMiddleware(handleImportUsers(validate, logger, metrics, db, allowedSubjectSuffix, argon2IdOpts)))
+
+	mux.HandleFunc(types.RouteInviteUser, authMiddleware(handleInviteUser(validate, logger, metrics, db, mailer, oidcProviders, invitationOpts)))
+
+	mux.HandleFunc(types.RouteListInvitations, authMiddleware(handleListInvitations(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteRevokeInvitation, authMiddleware(handleRevokeInvitation(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteAcceptInvitation, sensitiveMiddleware(defaultMiddleware(handleAcceptInvitation(validate, logger, metrics, db, hmacSecret, argon2IdOpts))))
+
+	mux.HandleFunc(types.RoutePutAttributeSchema, authMiddleware(handlePutAttributeSchema(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteListAttributeSchemas, authMiddleware(handleListAttributeSchemas(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteDeleteAttributeSchema, authMiddleware(handleDeleteAttributeSchema(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteSuspendUser, authMiddleware(handleSuspendUser(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteReactivateUser, authMiddleware(handleReactivateUser(validate, logger, metrics, db)))
+
+	mux.HandleFunc(types.RouteReauthenticate, sensitiveMiddleware(authMiddleware(handleReauthenticate(validate, logger, metrics, db, hmacSecret, authenticators, lockoutThreshold))))
+
+	mux.HandleFunc(types.RouteListLoginHistory, authMiddleware(handleListLoginHistory(validate, logger, metrics, db)))
+
+	if oidcProviders != nil {
+		mux.HandleFunc(types.RouteOidcLogin, sensitiveMiddleware(defaultMiddleware(handleOidcLogin(validate, logger, oidcProviders, hmacSecret))))
+
+		mux.HandleFunc(types.RouteOidcCallback, sensitiveMiddleware(defaultMiddleware(handleOidcCallback(validate, logger, metrics, db, oidcProviders, hmacSecret, allowedSubjectSuffix, argon2IdOpts, mailer))))
+	}
+
+	if samlProviders != nil {
+		mux.HandleFunc(types.RouteSamlMetadata, defaultMiddleware(handleSamlMetadata(validate, logger, samlProviders)))
+
+		mux.HandleFunc(types.RouteSamlAcs, sensitiveMiddleware(defaultMiddleware(handleSamlAcs(validate, logger, metrics, db, samlProviders, hmacSecret, allowedSubjectSuffix, argon2IdOpts, mailer))))
+	}
+
+	if mailer != nil {
+		mux.HandleFunc(types.RouteRequestMagicLink, defaultMiddleware(handleRequestMagicLink(validate, logger, metrics, db, mailer, oidcProviders, magicLinkOpts)))
+
+		mux.HandleFunc(types.RouteConsumeMagicLink, sensitiveMiddleware(defaultMiddleware(handleConsumeMagicLink(validate, logger, metrics, db, hmacSecret, mailer))))
+	}
+
+	if scimBearerToken != "" {
+		scimMiddleware := func(next http.HandlerFunc) http.HandlerFunc {
+			return composeContextLoggerMiddleware(logger, trustedProxies,
+				secureMiddleware(
+					composeMaxBodyBytesMiddleware(maxBodyBytes,
+						composeScimAuthMiddleware(scimBearerToken,
+							composeScimNetworkMiddleware(networkPolicies, next),
+						),
+					),
+				),
+			)
+		}
+
+		scimUsers := scimMiddleware(handleScimUsers(validate, logger, metrics, db, allowedSubjectSuffix, argon2IdOpts, deletionGracePeriod))
+		mux.HandleFunc(types.RouteScimUsers, scimUsers)
+		mux.HandleFunc(types.RouteScimUsers+"/", scimUsers)
+
+		scimGroups := scimMiddleware(handleScimGroups(validate, logger, metrics, db))
+		mux.HandleFunc(types.RouteScimGroups, scimGroups)
+		mux.HandleFunc(types.RouteScimGroups+"/", scimGroups)
+
+		mux.HandleFunc(types.RouteScimServiceProviderConfig, scimMiddleware(handleScimServiceProviderConfig(logger)))
+
+		scimResourceTypes := scimMiddleware(handleScimDiscovery(logger, types.RouteScimResourceTypes, business.ScimResourceTypes()))
+		mux.HandleFunc(types.RouteScimResourceTypes, scimResourceTypes)
+		mux.HandleFunc(types.RouteScimResourceTypes+"/", scimResourceTypes)
+
+		scimSchemas := scimMiddleware(handleScimDiscovery(logger, types.RouteScimSchemas, business.ScimSchemas()))
+		mux.HandleFunc(types.RouteScimSchemas, scimSchemas)
+		mux.HandleFunc(types.RouteScimSchemas+"/", scimSchemas)
+	}
 
 	return mux
 }
@@ -63,6 +203,39 @@ func extractAccessToken(r *http.Request) (t string) {
 	return strings.TrimPrefix(r.Header.Get(types.HeaderAuthorization), types.PrefixBearer)
 }
 
+func extractIfMatch(r *http.Request) (v string) {
+	v = strings.TrimPrefix(r.Header.Get(types.HeaderIfMatch), "W/")
+
+	u, err := strconv.Unquote(v)
+	if err != nil {
+		return
+	}
+
+	return u
+}
+
+func extractClaimSub(r *http.Request) (sub string) {
+	c, ok := r.Context().Value(types.ContextKeyClaims).(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	sub, _ = c[types.ClaimSub].(string)
+
+	return
+}
+
+func extractClaimUserGroup(r *http.Request) (group string) {
+	c, ok := r.Context().Value(types.ContextKeyClaims).(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	group, _ = c[types.ClaimUserGroup].(string)
+
+	return
+}
+
 func writeJsonResponse(logger *zap.SugaredLogger, w http.ResponseWriter, statusCode int, rsp interface{}) {
 	b, err := json.Marshal(rsp)
 	if err != nil {
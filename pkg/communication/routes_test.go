@@ -3,21 +3,35 @@
 package communication
 
 import (
+	"bufio"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/armon/go-metrics"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
@@ -28,7 +42,11 @@ import (
 	"github.com/ppwfx/user-svc/pkg/types"
 	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
 	"github.com/ppwfx/user-svc/pkg/utils/dockerutil"
+	"github.com/ppwfx/user-svc/pkg/utils/ldaputil"
+	"github.com/ppwfx/user-svc/pkg/utils/mailutil"
 	"github.com/ppwfx/user-svc/pkg/utils/metricsutil"
+	"github.com/ppwfx/user-svc/pkg/utils/oidcutil"
+	"github.com/ppwfx/user-svc/pkg/utils/samlutil"
 )
 
 var args = types.IntegrationTestArgs{}
@@ -38,12 +56,17 @@ var httpClient *http.Client
 var userSvcAddr string
 var pgUrl string
 var metricSink metrics.MetricSink
+var oidcProvider *oidcutil.MockProvider
+var ldapServer *ldaputil.MockServer
+var samlIdentityProvider *samlutil.MockIdentityProvider
+var mailer = &mailutil.MockMailer{}
 var prefix = time.Now().Format("2006-01-02T15-04-05")
 
 func TestMain(m *testing.M) {
 	flag.BoolVar(&args.Remote, "remote", false, "")
 	flag.StringVar(&args.UserSvcAddr, "user-svc-addr", "", "")
 	flag.StringVar(&args.PostgresUrl, "postgres-url", "", "")
+	flag.StringVar(&args.ScimBearerToken, "scim-bearer-token", "scim-token", "")
 	flag.Parse()
 
 	if args.Remote {
@@ -103,11 +126,163 @@ func TestMain(m *testing.M) {
 				return
 			}
 
+			go business.ProcessDataExportsPeriodically(ctx, metricSink, db, 100*time.Millisecond)
+
+			go business.DeliverWebhooksPeriodically(ctx, metricSink, db, business.NewWebhookClient(true), 100*time.Millisecond, business.WebhookOpts{
+				MaxAttempts: 2,
+				MinBackoff:  100 * time.Millisecond,
+				MaxBackoff:  time.Second,
+				Timeout:     time.Second,
+				Concurrency: 4,
+			})
+
+			var listener *pq.Listener
+			listener, err = persistence.NewOutboxEventsListener(logger, pgUrl)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to listen for outbox events")
+
+				return
+			}
+
+			eventBroadcaster := business.NewEventBroadcaster()
+
+			go eventBroadcaster.Listen(ctx, metricSink, db, listener)
+
+			oidcProvider, err = oidcutil.NewMockProvider("user-svc", "client-secret")
+			if err != nil {
+				err = errors.Wrapf(err, "failed to start mock oidc provider")
+
+				return
+			}
+
+			ldapServer, err = ldaputil.NewMockServer(false)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to start mock ldap server")
+
+				return
+			}
+
+			ldapServer.SetEntry(ldaputil.MockEntry{DN: "cn=svc,dc=example,dc=com", Password: "svc-password"})
+
+			var ldapCACert *os.File
+			ldapCACert, err = ioutil.TempFile("", "ldap-ca-*.pem")
+			if err != nil {
+				err = errors.Wrapf(err, "failed to create ldap ca cert file")
+
+				return
+			}
+			defer ldapCACert.Close()
+
+			_, err = ldapCACert.Write(ldapServer.CACert)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to write ldap ca cert file")
+
+				return
+			}
+
+			var ldapAuthenticator *business.LdapAuthenticator
+			ldapAuthenticator, err = business.NewLdapAuthenticator(validate, types.LdapConfig{
+				URL:          ldapServer.URL,
+				StartTLS:     true,
+				CACertFile:   ldapCACert.Name(),
+				BindDN:       "cn=svc,dc=example,dc=com",
+				BindPassword: "svc-password",
+				BaseDN:       "ou=people,dc=example,dc=com",
+				GroupMapping: map[string]string{
+					"cn=staff,ou=groups,dc=example,dc=com":  types.UserGroupUser,
+					"cn=admins,ou=groups,dc=example,dc=com": types.UserGroupAdmin,
+				},
+			}, "@test.com", business.DefaultArgon2IdOpts)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to create ldap authenticator")
+
+				return
+			}
+
+			samlIdentityProvider, err = samlutil.NewMockIdentityProvider("http://idp.test/metadata")
+			if err != nil {
+				err = errors.Wrapf(err, "failed to create mock saml identity provider")
+
+				return
+			}
+
+			var samlIdpMetadata []byte
+			samlIdpMetadata, err = samlIdentityProvider.Metadata()
+			if err != nil {
+				err = errors.Wrapf(err, "failed to get saml idp metadata")
+
+				return
+			}
+
+			var samlIdpMetadataFile *os.File
+			samlIdpMetadataFile, err = ioutil.TempFile("", "saml-idp-*.xml")
+			if err != nil {
+				err = errors.Wrapf(err, "failed to create saml idp metadata file")
+
+				return
+			}
+			defer samlIdpMetadataFile.Close()
+
+			_, err = samlIdpMetadataFile.Write(samlIdpMetadata)
+			if err != nil {
+				err = errors.Wrapf(err, "failed to write saml idp metadata file")
+
+				return
+			}
+
 			go func() {
 				mux := http.NewServeMux()
-				mux = AddSvcRoutes(mux, validate, logger, metricSink, db, "hmac-secret", "@test.com", business.DefaultArgon2IdOpts)
 
 				testServer := httptest.NewServer(mux)
+
+				oidcProviders, err := business.NewOidcProviders(ctx, validate, &http.Client{}, []types.OidcProvider{{
+					Name:         "corp",
+					Issuer:       oidcProvider.URL,
+					ClientID:     oidcProvider.ClientID,
+					ClientSecret: oidcProvider.ClientSecret,
+					RedirectURL:  testServer.URL + types.RouteOidcCallback,
+					Domains:      []string{"oidc.test"},
+				}})
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				samlProviders, err := business.NewSamlProviders(validate, []types.SamlProvider{{
+					Name:            "saml-corp",
+					IdpMetadataFile: samlIdpMetadataFile.Name(),
+					EntityID:        testServer.URL + types.RouteSamlMetadata + "?provider=saml-corp",
+					AcsURL:          testServer.URL + types.RouteSamlAcs + "?provider=saml-corp",
+					Domains:         []string{"saml.test"},
+				}, {
+					Name:            "saml-partner",
+					IdpMetadataFile: samlIdpMetadataFile.Name(),
+					EntityID:        testServer.URL + types.RouteSamlMetadata + "?provider=saml-partner",
+					AcsURL:          testServer.URL + types.RouteSamlAcs + "?provider=saml-partner",
+				}})
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				networkPolicies, err := business.NewNetworkPolicies(validate, types.NetworkPolicies{
+					types.UserGroupAdmin: {Allow: []string{"127.0.0.0/8", "::1/128"}},
+					types.RoleScim:       {Allow: []string{"127.0.0.0/8", "::1/128"}},
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				trustedProxies, err := business.NewTrustedProxies([]string{"127.0.0.0/8", "::1/128"}, 0)
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				mux = AddSvcRoutes(mux, validate, logger, metricSink, db, "hmac-secret", "@test.com", business.DefaultArgon2IdOpts, time.Hour, time.Hour, business.NewWebhookClient(true), eventBroadcaster, 4*time.Second, time.Minute, args.ScimBearerToken, oidcProviders, business.AuthenticatorChain{business.LocalAuthenticator{}, ldapAuthenticator}, samlProviders, mailer, business.MagicLinkOpts{
+					URL:             "https://app.test/login",
+					Ttl:             time.Minute,
+					RateLimit:       3,
+					RateLimitWindow: time.Hour,
+				}, business.InvitationOpts{URL: "https://app.test/invitation"}, 5, business.NewTokenStates(0), networkPolicies, trustedProxies)
+
 				httpClient = testServer.Client()
 
 				userSvcAddr = testServer.URL
@@ -123,6 +298,10 @@ func TestMain(m *testing.M) {
 	c := m.Run()
 
 	if !args.Remote {
+		oidcProvider.Close()
+
+		ldapServer.Close()
+
 		err = dockerutil.RemoveDockerContainers("user-svc-communication")
 		if err != nil {
 			err = errors.Wrapf(err, "failed to remove docker containers")
@@ -556,3 +735,3849 @@ func TestDeleteUser(t *testing.T) {
 		})
 	}
 }
+
+func TestGetMe(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name               string
+		createReq          types.CreateUserRequest
+		authReq            types.AuthenticateRequest
+		expectError        bool
+		expectedStatusCode int
+	}{
+		{
+			name: "valid user",
+			createReq: types.CreateUserRequest{
+				Email:    prefix + "testGetMe0@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testGetMe0@example.com",
+				Password: "password",
+			},
+			expectError:        false,
+			expectedStatusCode: 200,
+		},
+		{
+			name: "invalid user without token",
+			createReq: types.CreateUserRequest{
+				Email:    prefix + "testGetMe1@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testGetMe1@example.com",
+				Password: "password1",
+			},
+			expectError:        true,
+			expectedStatusCode: 401,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.createReq)
+
+				_, authRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, tc.authReq)
+
+				httpRsp, getRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, authRsp.AccessToken, types.GetMeRequest{})
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				if tc.expectError {
+					assert.NotEmpty(t, getRsp.Error)
+					assert.Nil(t, getRsp.User)
+				} else {
+					assert.Empty(t, getRsp.Error)
+					if assert.NotNil(t, getRsp.User) {
+						assert.Equal(t, tc.createReq.Email, getRsp.User.Email)
+						assert.Equal(t, tc.createReq.FullName, getRsp.User.FullName)
+						assert.Equal(t, types.UserGroupUser, getRsp.User.UserGroup)
+					}
+				}
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name               string
+		createReq          types.CreateUserRequest
+		authReq            types.AuthenticateRequest
+		updateReq          types.UpdateProfileRequest
+		expectError        bool
+		expectedStatusCode int
+	}{
+		{
+			name: "valid fullname",
+			createReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateProfile0@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testUpdateProfile0@example.com",
+				Password: "password",
+			},
+			updateReq: types.UpdateProfileRequest{
+				FullName: "janedoe",
+			},
+			expectError:        false,
+			expectedStatusCode: 200,
+		},
+		{
+			name: "invalid fullname",
+			createReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateProfile1@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testUpdateProfile1@example.com",
+				Password: "password",
+			},
+			updateReq: types.UpdateProfileRequest{
+				FullName: "",
+			},
+			expectError:        true,
+			expectedStatusCode: 422,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.createReq)
+
+				_, authRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, tc.authReq)
+
+				httpRsp, updateRsp, err := client.UpdateProfile(ctx, httpClient, userSvcAddr, authRsp.AccessToken, tc.updateReq)
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				if tc.expectError {
+					assert.NotEmpty(t, updateRsp.Error)
+				} else {
+					assert.Empty(t, updateRsp.Error)
+					if assert.NotNil(t, updateRsp.User) {
+						assert.Equal(t, tc.updateReq.FullName, updateRsp.User.FullName)
+					}
+				}
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name               string
+		createReq          types.CreateUserRequest
+		authReq            types.AuthenticateRequest
+		changeReq          types.ChangePasswordRequest
+		expectError        bool
+		expectedStatusCode int
+	}{
+		{
+			name: "valid current password",
+			createReq: types.CreateUserRequest{
+				Email:    prefix + "testChangePassword0@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testChangePassword0@example.com",
+				Password: "password",
+			},
+			changeReq: types.ChangePasswordRequest{
+				CurrentPassword: "password",
+				NewPassword:     "password2",
+			},
+			expectError:        false,
+			expectedStatusCode: 200,
+		},
+		{
+			name: "invalid current password",
+			createReq: types.CreateUserRequest{
+				Email:    prefix + "testChangePassword1@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testChangePassword1@example.com",
+				Password: "password",
+			},
+			changeReq: types.ChangePasswordRequest{
+				CurrentPassword: "password1",
+				NewPassword:     "password2",
+			},
+			expectError:        true,
+			expectedStatusCode: 422,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.createReq)
+
+				_, authRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, tc.authReq)
+
+				httpRsp, changeRsp, err := client.ChangePassword(ctx, httpClient, userSvcAddr, authRsp.AccessToken, tc.changeReq)
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				_, newAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+					Email:    tc.createReq.Email,
+					Password: tc.changeReq.NewPassword,
+				})
+				if err != nil {
+					return
+				}
+
+				if tc.expectError {
+					assert.NotEmpty(t, changeRsp.Error)
+					assert.Empty(t, newAuthRsp.AccessToken)
+				} else {
+					assert.Empty(t, changeRsp.Error)
+					assert.NotEmpty(t, changeRsp.AccessToken)
+					assert.NotEmpty(t, newAuthRsp.AccessToken)
+				}
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDeleteMyAccount(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name               string
+		createReq          types.CreateUserRequest
+		authReq            types.AuthenticateRequest
+		expectError        bool
+		expectedStatusCode int
+	}{
+		{
+			name: "valid user",
+			createReq: types.CreateUserRequest{
+				Email:    prefix + "testDeleteMyAccount0@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testDeleteMyAccount0@example.com",
+				Password: "password",
+			},
+			expectError:        false,
+			expectedStatusCode: 200,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.createReq)
+
+				_, authRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, tc.authReq)
+
+				httpRsp, deleteRsp, err := client.DeleteMyAccount(ctx, httpClient, userSvcAddr, authRsp.AccessToken, types.DeleteMyAccountRequest{})
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				if tc.expectError {
+					assert.NotEmpty(t, deleteRsp.Error)
+				} else {
+					assert.Empty(t, deleteRsp.Error)
+
+					if pgUrl != "" {
+						_, err = persistence.GetUserByEmail(ctx, metricSink, db, tc.createReq.Email)
+						assert.Error(t, err)
+						err = nil
+					}
+				}
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name               string
+		adminCreateReq     types.CreateUserRequest
+		targetCreateReq    types.CreateUserRequest
+		updateReq          types.UpdateUserRequest
+		staleVersion       bool
+		omitVersion        bool
+		expectError        bool
+		expectedStatusCode int
+	}{
+		{
+			name: "valid update",
+			adminCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateUser0@test.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			targetCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateUser0@example.com",
+				Password: "password",
+				FullName: "jonhdoe",
+			},
+			updateReq: types.UpdateUserRequest{
+				Email:     prefix + "testUpdateUser0-updated@example.com",
+				FullName:  "johndoe",
+				UserGroup: types.UserGroupUser,
+			},
+			expectError:        false,
+			expectedStatusCode: 200,
+		},
+		{
+			name: "invalid update with stale version",
+			adminCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateUser1@test.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			targetCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateUser1@example.com",
+				Password: "password",
+				FullName: "jonhdoe",
+			},
+			updateReq: types.UpdateUserRequest{
+				Email:     prefix + "testUpdateUser1@example.com",
+				FullName:  "johndoe",
+				UserGroup: types.UserGroupUser,
+			},
+			staleVersion:       true,
+			expectError:        true,
+			expectedStatusCode: 412,
+		},
+		{
+			name: "invalid update without version",
+			adminCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateUser2@test.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			targetCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testUpdateUser2@example.com",
+				Password: "password",
+				FullName: "jonhdoe",
+			},
+			updateReq: types.UpdateUserRequest{
+				Email:     prefix + "testUpdateUser2@example.com",
+				FullName:  "johndoe",
+				UserGroup: types.UserGroupUser,
+			},
+			omitVersion:        true,
+			expectError:        true,
+			expectedStatusCode: 428,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.adminCreateReq)
+
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.targetCreateReq)
+
+				_, adminAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+					Email:    tc.adminCreateReq.Email,
+					Password: tc.adminCreateReq.Password,
+				})
+
+				_, targetAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+					Email:    tc.targetCreateReq.Email,
+					Password: tc.targetCreateReq.Password,
+				})
+
+				_, getRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, targetAuthRsp.AccessToken, types.GetMeRequest{})
+				if err != nil {
+					return
+				}
+				if !assert.NotNil(t, getRsp.User) {
+					return
+				}
+
+				req := tc.updateReq
+				req.ID = getRsp.User.ID
+				req.Version = getRsp.User.Version
+
+				if tc.staleVersion {
+					_, _, err = client.UpdateProfile(ctx, httpClient, userSvcAddr, targetAuthRsp.AccessToken, types.UpdateProfileRequest{
+						FullName: "janedoe",
+					})
+					if err != nil {
+						return
+					}
+				}
+
+				if tc.omitVersion {
+					req.Version = ""
+				}
+
+				httpRsp, updateRsp, err := client.UpdateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, req)
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				if tc.expectError {
+					assert.NotEmpty(t, updateRsp.Error)
+					assert.Nil(t, updateRsp.User)
+				} else {
+					assert.Empty(t, updateRsp.Error)
+					if assert.NotNil(t, updateRsp.User) {
+						assert.Equal(t, tc.updateReq.Email, updateRsp.User.Email)
+						assert.Equal(t, tc.updateReq.FullName, updateRsp.User.FullName)
+						assert.NotEqual(t, req.Version, updateRsp.User.Version)
+					}
+				}
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestListUsersPagination(t *testing.T) {
+	t.Parallel()
+
+	domain := strings.ToLower(prefix) + ".example.com"
+
+	err := func() (err error) {
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, types.CreateUserRequest{
+			Email:    prefix + "testListUsersPagination@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		})
+
+		_, authRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    prefix + "testListUsersPagination@test.com",
+			Password: "password",
+		})
+
+		var emails []string
+		for _, n := range []string{"a", "b", "c"} {
+			emails = append(emails, "testListUsersPagination-"+n+"@"+domain)
+
+			_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, types.CreateUserRequest{
+				Email:    "testListUsersPagination-" + n + "@" + domain,
+				Password: "password",
+				FullName: "johndoe",
+			})
+		}
+
+		req := types.ListUsersRequest{
+			PageSize:    2,
+			SortBy:      types.SortByEmail,
+			SortOrder:   types.SortOrderAsc,
+			EmailDomain: domain,
+		}
+
+		httpRsp, firstRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, authRsp.AccessToken, req)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Empty(t, firstRsp.Error)
+		if !assert.Len(t, firstRsp.Users, 2) {
+			return
+		}
+		assert.Equal(t, emails[0], firstRsp.Users[0].Email)
+		assert.Equal(t, emails[1], firstRsp.Users[1].Email)
+		assert.NotEmpty(t, firstRsp.NextCursor)
+
+		req.Cursor = firstRsp.NextCursor
+
+		httpRsp, secondRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, authRsp.AccessToken, req)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Empty(t, secondRsp.Error)
+		if !assert.Len(t, secondRsp.Users, 1) {
+			return
+		}
+		assert.Equal(t, emails[2], secondRsp.Users[0].Email)
+		assert.Empty(t, secondRsp.NextCursor)
+
+		req.SortBy = types.SortByCreatedAt
+
+		httpRsp, mismatchRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, authRsp.AccessToken, req)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvalidCursor, mismatchRsp.Error)
+
+		req.Cursor = base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"s":%q,"o":%q,"v":"2020-01-01T00:00:00Z","i":"not-a-uuid"}`, req.SortBy, req.SortOrder)))
+
+		httpRsp, invalidIdRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, authRsp.AccessToken, req)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "cursors with an invalid id are rejected")
+		assert.Equal(t, types.ErrorInvalidCursor, invalidIdRsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSearchUsers(t *testing.T) {
+	t.Parallel()
+
+	fullName := "Searchable " + strings.Replace(prefix, "-", "", -1) + " Doe"
+
+	tcs := []struct {
+		name               string
+		authReq            types.AuthenticateRequest
+		searchReq          types.SearchUsersRequest
+		expectError        bool
+		expectedStatusCode int
+	}{
+		{
+			name: "valid partial fullname",
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testSearchUsers@test.com",
+				Password: "password",
+			},
+			searchReq: types.SearchUsersRequest{
+				Query: strings.Replace(prefix, "-", "", -1),
+			},
+			expectError:        false,
+			expectedStatusCode: 200,
+		},
+		{
+			name: "invalid query",
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testSearchUsers@test.com",
+				Password: "password",
+			},
+			searchReq: types.SearchUsersRequest{
+				Query: "",
+			},
+			expectError:        true,
+			expectedStatusCode: 422,
+		},
+		{
+			name: "invalid user without permissions",
+			authReq: types.AuthenticateRequest{
+				Email:    prefix + "testSearchUsers@example.com",
+				Password: "password",
+			},
+			searchReq: types.SearchUsersRequest{
+				Query: strings.Replace(prefix, "-", "", -1),
+			},
+			expectError:        true,
+			expectedStatusCode: 403,
+		},
+	}
+
+	_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, types.CreateUserRequest{
+		Email:    prefix + "testSearchUsers@test.com",
+		Password: "password",
+		FullName: "johndoe",
+	})
+
+	_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, types.CreateUserRequest{
+		Email:    prefix + "testSearchUsers@example.com",
+		Password: "password",
+		FullName: fullName,
+	})
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, authRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, tc.authReq)
+
+				httpRsp, searchRsp, err := client.SearchUsers(ctx, httpClient, userSvcAddr, authRsp.AccessToken, tc.searchReq)
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				if tc.expectError {
+					assert.NotEmpty(t, searchRsp.Error)
+					assert.Len(t, searchRsp.Users, 0)
+				} else {
+					assert.Empty(t, searchRsp.Error)
+					if assert.NotEmpty(t, searchRsp.Users) {
+						assert.Equal(t, fullName, searchRsp.Users[0].FullName)
+						assert.NotEmpty(t, searchRsp.Users[0].Highlights)
+					}
+				}
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name               string
+		adminCreateReq     types.CreateUserRequest
+		targetCreateReq    types.CreateUserRequest
+		deleteTarget       bool
+		expectError        bool
+		expectedStatusCode int
+	}{
+		{
+			name: "valid deleted user",
+			adminCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testRestoreUser0@test.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			targetCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testRestoreUser0@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			deleteTarget:       true,
+			expectError:        false,
+			expectedStatusCode: 200,
+		},
+		{
+			name: "invalid user that is not deleted",
+			adminCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testRestoreUser1@test.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			targetCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testRestoreUser1@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			deleteTarget:       false,
+			expectError:        true,
+			expectedStatusCode: 404,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.adminCreateReq)
+
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.targetCreateReq)
+
+				_, adminAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+					Email:    tc.adminCreateReq.Email,
+					Password: tc.adminCreateReq.Password,
+				})
+
+				_, targetAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+					Email:    tc.targetCreateReq.Email,
+					Password: tc.targetCreateReq.Password,
+				})
+
+				_, getRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, targetAuthRsp.AccessToken, types.GetMeRequest{})
+				if err != nil {
+					return
+				}
+				if !assert.NotNil(t, getRsp.User) {
+					return
+				}
+
+				if tc.deleteTarget {
+					_, _, err = client.DeleteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteUserRequest{
+						Email: tc.targetCreateReq.Email,
+					})
+					if err != nil {
+						return
+					}
+				}
+
+				httpRsp, restoreRsp, err := client.RestoreUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RestoreUserRequest{
+					ID: getRsp.User.ID,
+				})
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				if tc.expectError {
+					assert.NotEmpty(t, restoreRsp.Error)
+				} else {
+					assert.Empty(t, restoreRsp.Error)
+
+					_, authRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+						Email:    tc.targetCreateReq.Email,
+						Password: tc.targetCreateReq.Password,
+					})
+					if err != nil {
+						return err
+					}
+
+					assert.NotEmpty(t, authRsp.AccessToken)
+				}
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestPurgeDeletedUsers(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testPurgeDeletedUsers0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		var ids []string
+		for i := 1; i <= 2; i++ {
+			createReq := types.CreateUserRequest{
+				Email:    prefix + "testPurgeDeletedUsers" + strconv.Itoa(i) + "@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			}
+
+			_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, createReq)
+			if err != nil {
+				return
+			}
+
+			var u types.UserModel
+			u, err = persistence.GetUserByEmail(ctx, metricSink, db, createReq.Email)
+			if err != nil {
+				return
+			}
+
+			_, _, err = client.DeleteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteUserRequest{Email: createReq.Email})
+			if err != nil {
+				return
+			}
+
+			ids = append(ids, u.ID)
+		}
+
+		_, err = db.ExecContext(ctx, "UPDATE users SET deleted_at = NOW() - interval '2 hours' WHERE id=$1", ids[0])
+		if err != nil {
+			return
+		}
+
+		err = business.PurgeDeletedUsers(ctx, metricSink, db, time.Hour)
+		if err != nil {
+			return
+		}
+
+		_, err = persistence.GetAnyUserById(ctx, metricSink, db, ids[0])
+		assert.Equal(t, sql.ErrNoRows, errors.Cause(err), "users deleted before the grace period are purged")
+
+		_, err = persistence.GetAnyUserById(ctx, metricSink, db, ids[1])
+		if !assert.NoError(t, err, "users deleted within the grace period are kept") {
+			return
+		}
+
+		_, err = db.ExecContext(ctx, "UPDATE users SET deleted_at = NOW() - interval '2 hours' WHERE id=$1", ids[1])
+		if err != nil {
+			return
+		}
+
+		purgeCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		go business.PurgeDeletedUsersPeriodically(purgeCtx, metricSink, db, 50*time.Millisecond, time.Hour)
+
+		assert.Eventually(t, func() bool {
+			_, err := persistence.GetAnyUserById(ctx, metricSink, db, ids[1])
+
+			return errors.Cause(err) == sql.ErrNoRows
+		}, 5*time.Second, 50*time.Millisecond, "users are purged periodically")
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportMyData(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		createReq := types.CreateUserRequest{
+			Email:    prefix + "testExportMyData0@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, createReq)
+
+		_, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    createReq.Email,
+			Password: "wrong-password",
+		})
+		if err != nil {
+			return
+		}
+
+		_, authRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    createReq.Email,
+			Password: createReq.Password,
+		})
+
+		u, err := persistence.GetUserByEmail(ctx, metricSink, db, createReq.Email)
+		if err != nil {
+			return
+		}
+
+		_, err = persistence.InsertUserIdentity(ctx, metricSink, db, types.UserIdentityModel{
+			Provider: "corp",
+			Subject:  prefix + "-testExportMyData0",
+			UserID:   u.ID,
+			Email:    createReq.Email,
+		})
+		if err != nil {
+			return
+		}
+
+		httpRsp, exportRsp, err := client.ExportMyData(ctx, httpClient, userSvcAddr, authRsp.AccessToken, types.ExportMyDataRequest{})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 202, httpRsp.StatusCode)
+		assert.Empty(t, exportRsp.Error)
+		if !assert.NotNil(t, exportRsp.Export) {
+			return
+		}
+		assert.NotEmpty(t, exportRsp.Export.DownloadURL)
+
+		var archive types.DataExportArchive
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			httpRsp, archive, _, err = client.DownloadDataExport(ctx, httpClient, userSvcAddr, exportRsp.Export.DownloadURL)
+			if err != nil {
+				return
+			}
+
+			if httpRsp.StatusCode != http.StatusAccepted {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, createReq.Email, archive.Profile.Email)
+		assert.Equal(t, createReq.FullName, archive.Profile.FullName)
+
+		var outcomes []string
+		for _, a := range archive.LoginAttempts {
+			assert.Equal(t, u.ID, a.UserID)
+			outcomes = append(outcomes, a.Outcome)
+		}
+		assert.ElementsMatch(t, []string{types.LoginOutcomeSuccess, types.LoginOutcomeFailure}, outcomes)
+
+		if assert.Len(t, archive.Identities, 1) {
+			assert.Equal(t, "corp", archive.Identities[0].Provider)
+			assert.Equal(t, prefix+"-testExportMyData0", archive.Identities[0].Subject)
+		}
+
+		httpRsp, _, downloadRsp, err := client.DownloadDataExport(ctx, httpClient, userSvcAddr, types.RouteDownloadDataExport+"?token=invalid")
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 404, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorDataExportDoesNotExist, downloadRsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportUserDataOfDeletedUser(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testExportUserDataOfDeletedUser0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		targetCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testExportUserDataOfDeletedUser1@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, targetCreateReq)
+		if err != nil {
+			return
+		}
+
+		target, err := persistence.GetUserByEmail(ctx, metricSink, db, targetCreateReq.Email)
+		if err != nil {
+			return
+		}
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		httpRsp, _, err := client.DeleteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteUserRequest{Email: targetCreateReq.Email})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, exportRsp, err := client.ExportUserData(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ExportUserDataRequest{ID: target.ID})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 202, httpRsp.StatusCode, "users within the deletion grace period can be exported") || !assert.NotNil(t, exportRsp.Export) {
+			return
+		}
+
+		var archive types.DataExportArchive
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			httpRsp, archive, _, err = client.DownloadDataExport(ctx, httpClient, userSvcAddr, exportRsp.Export.DownloadURL)
+			if err != nil {
+				return
+			}
+
+			if httpRsp.StatusCode != http.StatusAccepted {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, targetCreateReq.Email, archive.Profile.Email)
+		assert.NotNil(t, archive.Profile.DeletedAt)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestListAuditEvents(t *testing.T) {
+	t.Parallel()
+
+	tcs := []struct {
+		name               string
+		adminCreateReq     types.CreateUserRequest
+		targetCreateReq    types.CreateUserRequest
+		authenticateAdmin  bool
+		expectError        bool
+		expectedStatusCode int
+		expectedActions    []string
+	}{
+		{
+			name: "valid admin",
+			adminCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testListAuditEvents0@test.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			targetCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testListAuditEvents0@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authenticateAdmin:  true,
+			expectError:        false,
+			expectedStatusCode: 200,
+			expectedActions:    []string{types.AuditActionUserDeleted, types.AuditActionUserAuthenticated, types.AuditActionUserCreated},
+		},
+		{
+			name: "invalid user",
+			adminCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testListAuditEvents1@example.com",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			targetCreateReq: types.CreateUserRequest{
+				Email:    prefix + "testListAuditEvents1@example.org",
+				Password: "password",
+				FullName: "johndoe",
+			},
+			authenticateAdmin:  false,
+			expectError:        true,
+			expectedStatusCode: 403,
+		},
+	}
+
+	for _, tc := range tcs {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := func() (err error) {
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.adminCreateReq)
+
+				_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, tc.targetCreateReq)
+
+				_, adminAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+					Email:    tc.adminCreateReq.Email,
+					Password: tc.adminCreateReq.Password,
+				})
+
+				_, targetAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+					Email:    tc.targetCreateReq.Email,
+					Password: tc.targetCreateReq.Password,
+				})
+
+				_, getRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, targetAuthRsp.AccessToken, types.GetMeRequest{})
+				if err != nil {
+					return
+				}
+				if !assert.NotNil(t, getRsp.User) {
+					return
+				}
+
+				if tc.authenticateAdmin {
+					_, _, err = client.DeleteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteUserRequest{
+						Email: tc.targetCreateReq.Email,
+					})
+					if err != nil {
+						return
+					}
+				}
+
+				httpRsp, listRsp, err := client.ListAuditEvents(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListAuditEventsRequest{
+					TargetID: getRsp.User.ID,
+				})
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, tc.expectedStatusCode, httpRsp.StatusCode)
+
+				if tc.expectError {
+					assert.NotEmpty(t, listRsp.Error)
+
+					return
+				}
+
+				assert.Empty(t, listRsp.Error)
+
+				var actions []string
+				for _, e := range listRsp.Events {
+					actions = append(actions, e.Action)
+				}
+
+				assert.Equal(t, tc.expectedActions, actions)
+
+				if assert.NotEmpty(t, listRsp.Events) {
+					assert.NotEmpty(t, listRsp.Events[0].ActorID)
+					assert.NotEmpty(t, listRsp.Events[0].RequestID)
+					assert.NotEmpty(t, listRsp.Events[0].Hash)
+				}
+
+				httpRsp, verifyRsp, err := client.VerifyAuditEvents(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.VerifyAuditEventsRequest{})
+				if err != nil {
+					return
+				}
+
+				assert.Equal(t, 200, httpRsp.StatusCode)
+				assert.True(t, verifyRsp.Valid)
+				assert.NotZero(t, verifyRsp.VerifiedCount)
+
+				return
+			}()
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testWebhooks0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		type receivedWebhook struct {
+			header http.Header
+			body   []byte
+		}
+
+		received := make(chan receivedWebhook, 1000)
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+
+			received <- receivedWebhook{header: r.Header, body: b}
+		}))
+		defer receiver.Close()
+
+		var failedMu sync.Mutex
+		failed := map[string][][]byte{}
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+
+			failedMu.Lock()
+			failed[r.Header.Get(types.HeaderWebhookId)] = append(failed[r.Header.Get(types.HeaderWebhookId)], b)
+			failedMu.Unlock()
+
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer failing.Close()
+
+		httpRsp, registerRsp, err := client.RegisterWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RegisterWebhookRequest{
+			URL: receiver.URL,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		if !assert.NotNil(t, registerRsp.Webhook) {
+			return
+		}
+		assert.NotEmpty(t, registerRsp.Webhook.Secret)
+
+		_, failingRegisterRsp, err := client.RegisterWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RegisterWebhookRequest{
+			URL: failing.URL,
+		})
+		if err != nil {
+			return
+		}
+		if !assert.NotNil(t, failingRegisterRsp.Webhook) {
+			return
+		}
+
+		createReq := types.CreateUserRequest{
+			Email:    prefix + "testWebhooks0@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, createReq)
+		if err != nil {
+			return
+		}
+
+		var event types.Event
+		var data types.UserEventData
+		timeout := time.After(10 * time.Second)
+	receive:
+		for {
+			select {
+			case w := <-received:
+				mac := hmac.New(sha256.New, []byte(registerRsp.Webhook.Secret))
+				mac.Write([]byte(w.header.Get(types.HeaderWebhookTimestamp) + "."))
+				mac.Write(w.body)
+
+				assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), w.header.Get(types.HeaderWebhookSignature))
+
+				err = json.Unmarshal(w.body, &event)
+				if err != nil {
+					return
+				}
+
+				err = json.Unmarshal(event.Data, &data)
+				if err != nil {
+					return
+				}
+
+				if event.Type == types.EventTypeUserCreated && data.User.Email == createReq.Email {
+					break receive
+				}
+			case <-timeout:
+				t.Error("timed out waiting for webhook")
+
+				return
+			}
+		}
+
+		assert.Equal(t, createReq.FullName, data.User.FullName)
+
+		var listRsp types.ListDeadWebhookDeliveriesResponse
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			httpRsp, listRsp, err = client.ListDeadWebhookDeliveries(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListDeadWebhookDeliveriesRequest{
+				WebhookID: failingRegisterRsp.Webhook.ID,
+			})
+			if err != nil {
+				return
+			}
+
+			if len(listRsp.Deliveries) > 0 {
+				break
+			}
+
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		if !assert.NotEmpty(t, listRsp.Deliveries) {
+			return
+		}
+		assert.Equal(t, types.WebhookDeliveryStatusDead, listRsp.Deliveries[0].Status)
+		assert.Equal(t, 500, listRsp.Deliveries[0].LastStatusCode)
+
+		failedMu.Lock()
+		bodies := failed[strconv.FormatInt(listRsp.Deliveries[0].EventID, 10)]
+		failedMu.Unlock()
+
+		if assert.Len(t, bodies, 2) {
+			assert.Equal(t, string(bodies[0]), string(bodies[1]), "retries send the same event body")
+		}
+
+		httpRsp, retryRsp, err := client.RetryWebhookDelivery(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RetryWebhookDeliveryRequest{
+			ID: listRsp.Deliveries[0].ID,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		if assert.NotNil(t, retryRsp.Delivery) {
+			assert.Equal(t, types.WebhookDeliveryStatusPending, retryRsp.Delivery.Status)
+		}
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWebhookManagement(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testWebhookManagement0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		defer receiver.Close()
+
+		failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer failing.Close()
+
+		httpRsp, registerRsp, err := client.RegisterWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RegisterWebhookRequest{
+			URL:        failing.URL,
+			EventTypes: []string{types.EventTypeUserDeleted},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		if !assert.NotNil(t, registerRsp.Webhook) {
+			return
+		}
+		assert.Equal(t, []string{types.EventTypeUserDeleted}, registerRsp.Webhook.EventTypes)
+
+		id := registerRsp.Webhook.ID
+
+		httpRsp, registerRsp, err = client.RegisterWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RegisterWebhookRequest{
+			URL:        receiver.URL,
+			EventTypes: []string{"user.unknown"},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+
+		_, listRsp, err := client.ListWebhooks(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListWebhooksRequest{})
+		if err != nil {
+			return
+		}
+
+		var listed bool
+		for _, w := range listRsp.Webhooks {
+			if w.ID == id {
+				listed = true
+
+				assert.Empty(t, w.Secret)
+			}
+		}
+		assert.True(t, listed)
+
+		httpRsp, pingRsp, err := client.PingWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.PingWebhookRequest{
+			ID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 502, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorWebhookPingFailed, pingRsp.Error)
+		if assert.NotNil(t, pingRsp.Attempt) {
+			assert.Equal(t, 500, pingRsp.Attempt.StatusCode)
+		}
+
+		httpRsp, updateRsp, err := client.UpdateWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.UpdateWebhookRequest{
+			ID:         id,
+			URL:        receiver.URL,
+			EventTypes: []string{types.EventTypeUserCreated, types.EventTypeUserDeleted},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		if assert.NotNil(t, updateRsp.Webhook) {
+			assert.Equal(t, receiver.URL, updateRsp.Webhook.URL)
+			assert.Equal(t, []string{types.EventTypeUserCreated, types.EventTypeUserDeleted}, updateRsp.Webhook.EventTypes)
+		}
+
+		httpRsp, pingRsp, err = client.PingWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.PingWebhookRequest{
+			ID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		if assert.NotNil(t, pingRsp.Attempt) {
+			assert.Equal(t, 200, pingRsp.Attempt.StatusCode)
+			assert.Empty(t, pingRsp.Attempt.Error)
+		}
+
+		_, attemptsRsp, err := client.ListWebhookDeliveryAttempts(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListWebhookDeliveryAttemptsRequest{
+			WebhookID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		var pingStatusCodes []int
+		for _, a := range attemptsRsp.Attempts {
+			if a.EventType == types.EventTypeWebhookPing {
+				pingStatusCodes = append(pingStatusCodes, a.StatusCode)
+			}
+		}
+
+		assert.Equal(t, []int{200, 500}, pingStatusCodes)
+
+		_, pauseRsp, err := client.PauseWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.PauseWebhookRequest{
+			ID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, pauseRsp.Webhook) {
+			assert.NotNil(t, pauseRsp.Webhook.PausedAt)
+		}
+
+		_, resumeRsp, err := client.ResumeWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ResumeWebhookRequest{
+			ID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, resumeRsp.Webhook) {
+			assert.Nil(t, resumeRsp.Webhook.PausedAt)
+		}
+
+		_, rotateRsp, err := client.RotateWebhookSecret(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RotateWebhookSecretRequest{
+			ID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, rotateRsp.Webhook) {
+			assert.NotEmpty(t, rotateRsp.Webhook.Secret)
+		}
+
+		httpRsp, _, err = client.DeleteWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteWebhookRequest{
+			ID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, deleteRsp, err := client.DeleteWebhook(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteWebhookRequest{
+			ID: id,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 404, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorWebhookDoesNotExist, deleteRsp.Error)
+
+		_, auditRsp, err := client.ListAuditEvents(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListAuditEventsRequest{TargetID: id})
+		if err != nil {
+			return
+		}
+
+		var actions []string
+		for _, e := range auditRsp.Events {
+			actions = append(actions, e.Action)
+
+			assert.NotContains(t, e.Diff, "secret")
+		}
+
+		assert.Equal(t, []string{
+			types.AuditActionWebhookDeleted,
+			types.AuditActionWebhookSecretRotated,
+			types.AuditActionWebhookResumed,
+			types.AuditActionWebhookPaused,
+			types.AuditActionWebhookUpdated,
+			types.AuditActionWebhookRegistered,
+		}, actions)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestStreamUserEvents(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testStreamUserEvents0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		createReq := types.CreateUserRequest{
+			Email:    prefix + "testStreamUserEvents0@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		streamed := make(chan types.Event, 1000)
+		go func() {
+			_, _, _ = client.StreamUserEvents(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, "", func(e types.Event) bool {
+				streamed <- e
+
+				return true
+			})
+		}()
+
+		time.Sleep(500 * time.Millisecond)
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, createReq)
+		if err != nil {
+			return
+		}
+
+		var created types.Event
+		timeout := time.After(4 * time.Second)
+	stream:
+		for {
+			select {
+			case e := <-streamed:
+				var data types.UserEventData
+				err = json.Unmarshal(e.Data, &data)
+				if err != nil {
+					return
+				}
+
+				if e.Type == types.EventTypeUserCreated && data.User.Email == createReq.Email {
+					created = e
+
+					break stream
+				}
+			case <-timeout:
+				t.Error("timed out waiting for streamed event")
+
+				return
+			}
+		}
+
+		_, _, err = client.DeleteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteUserRequest{
+			Email: createReq.Email,
+		})
+		if err != nil {
+			return
+		}
+
+		var createdData types.UserEventData
+		err = json.Unmarshal(created.Data, &createdData)
+		if err != nil {
+			return
+		}
+
+		_, _, err = client.RestoreUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RestoreUserRequest{ID: createdData.User.ID})
+		if err != nil {
+			return
+		}
+
+		var resumed []types.Event
+		var restored bool
+		rctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		httpRsp, _, err := client.StreamUserEvents(rctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, strconv.FormatInt(created.Sequence-1, 10), func(e types.Event) bool {
+			resumed = append(resumed, e)
+
+			var data types.UserEventData
+			if json.Unmarshal(e.Data, &data) == nil && e.Type == types.EventTypeUserRestored && data.User.ID == createdData.User.ID {
+				restored = true
+			}
+
+			return !restored
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		if assert.True(t, len(resumed) >= 2) {
+			assert.Equal(t, created.ID, resumed[0].ID)
+			assert.Equal(t, created.Sequence, resumed[0].Sequence)
+			assert.True(t, resumed[1].Sequence > created.Sequence)
+		}
+		assert.True(t, restored, "restoring a user emits user.restored")
+
+		// a client that reconnects before receiving an event resumes from the id of the first frame
+		sctx, cancelStream := context.WithCancel(ctx)
+		r, err := http.NewRequestWithContext(sctx, http.MethodGet, userSvcAddr+types.RouteStreamUserEvents, nil)
+		if err != nil {
+			cancelStream()
+
+			return
+		}
+		r.Header.Set(types.HeaderAuthorization, types.PrefixBearer+adminAuthRsp.AccessToken)
+
+		streamRsp, err := httpClient.Do(r)
+		if err != nil {
+			cancelStream()
+
+			return
+		}
+
+		var firstID string
+		s := bufio.NewScanner(streamRsp.Body)
+		for s.Scan() && s.Text() != "" {
+			if strings.HasPrefix(s.Text(), "id: ") {
+				firstID = strings.TrimPrefix(s.Text(), "id: ")
+			}
+		}
+		cancelStream()
+		_ = streamRsp.Body.Close()
+
+		if !assert.NotEmpty(t, firstID, "the first frame carries an id") {
+			return
+		}
+
+		gapCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testStreamUserEvents1@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, gapCreateReq)
+		if err != nil {
+			return
+		}
+
+		var gapCreated bool
+		gctx, cancelGap := context.WithTimeout(ctx, 10*time.Second)
+		defer cancelGap()
+
+		_, _, err = client.StreamUserEvents(gctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, firstID, func(e types.Event) bool {
+			var data types.UserEventData
+			if json.Unmarshal(e.Data, &data) == nil && e.Type == types.EventTypeUserCreated && data.User.Email == gapCreateReq.Email {
+				gapCreated = true
+			}
+
+			return !gapCreated
+		})
+		if err != nil && gctx.Err() == nil {
+			return
+		}
+		err = nil
+
+		assert.True(t, gapCreated, "events created between streams are replayed")
+
+		httpRsp, rsp, err := client.StreamUserEvents(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, "invalid", func(e types.Event) bool {
+			return false
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvalidLastEventId, rsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportUsers(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testImportUsers0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+		existingCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testImportUsers1@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, existingCreateReq)
+
+		_, adminAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+
+		_, userAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    existingCreateReq.Email,
+			Password: existingCreateReq.Password,
+		})
+
+		csv := "email,password,fullname\n" +
+			prefix + "testImportUsers2@example.com,password,johndoe\n" +
+			"not-an-email,password,johndoe\n" +
+			prefix + "testImportUsers2@example.com,password,johndoe\n" +
+			existingCreateReq.Email + ",password,johndoe\n" +
+			prefix + "testImportUsers3@example.com,password,johndoe\n"
+
+		httpRsp, rsp, err := client.ImportUsers(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.ImportUsersRequest{
+			Format: types.ImportFormatCsv,
+			Body:   strings.NewReader(csv),
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 403, httpRsp.StatusCode)
+
+		for _, dryRun := range []bool{true, false} {
+			httpRsp, rsp, err = client.ImportUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ImportUsersRequest{
+				Format: types.ImportFormatCsv,
+				DryRun: dryRun,
+				Body:   strings.NewReader(csv),
+			})
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, 200, httpRsp.StatusCode)
+			assert.Equal(t, dryRun, rsp.DryRun)
+			assert.Equal(t, 5, rsp.Total)
+			assert.Equal(t, 3, rsp.Failed)
+			if assert.Len(t, rsp.Errors, 3) {
+				assert.Equal(t, 2, rsp.Errors[0].Row)
+				assert.Equal(t, types.ImportUserError{Row: 3, Email: prefix + "testImportUsers2@example.com", Error: types.ErrorDuplicateImportRow}, rsp.Errors[1])
+				assert.Equal(t, types.ImportUserError{Row: 4, Email: existingCreateReq.Email, Error: types.ErrorEmailAlreadyExists}, rsp.Errors[2])
+			}
+
+			var authRsp types.AuthenticateResponse
+			httpRsp, authRsp, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+				Email:    prefix + "testImportUsers3@example.com",
+				Password: "password",
+			})
+			if err != nil {
+				return
+			}
+
+			if dryRun {
+				assert.Equal(t, 0, rsp.Imported)
+				assert.Equal(t, 422, httpRsp.StatusCode)
+			} else {
+				assert.Equal(t, 2, rsp.Imported)
+				assert.Equal(t, 200, httpRsp.StatusCode)
+				assert.NotEmpty(t, authRsp.AccessToken)
+			}
+		}
+
+		jsonl := `{"email":"` + prefix + `testImportUsers4@example.com","password":"$argon2id$v=19$m=65536,t=2,p=2$ZlZXaHhVSGIwQTJDQnhIaA$10bK6t+clO4SfEH2YRL4Q/qg2Vg2cPMEl8snLR5y8ng","fullname":"johndoe"}` + "\n" +
+			`{"email":"` + prefix + `testImportUsers5@example.com","password":"secret","fullname":"johndoe"}` + "\n"
+
+		httpRsp, rsp, err = client.ImportUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ImportUsersRequest{
+			Format:          types.ImportFormatJsonl,
+			HashedPasswords: true,
+			Body:            strings.NewReader(jsonl),
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, 1, rsp.Imported)
+		assert.Equal(t, []types.ImportUserError{{Row: 2, Email: prefix + "testImportUsers5@example.com", Error: types.ErrorInvalidPasswordHash}}, rsp.Errors)
+
+		httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    prefix + "testImportUsers4@example.com",
+			Password: "secret",
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, rsp, err = client.ImportUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ImportUsersRequest{
+			Format: types.ImportFormatCsv,
+			Body:   strings.NewReader("email,password\n"),
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.NotEmpty(t, rsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportUsers(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		domain := strings.ToLower(prefix) + ".export.com"
+
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testExportUsers0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+		userCreateReqs := []types.CreateUserRequest{
+			{
+				Email:    "a@" + domain,
+				Password: "password",
+				FullName: "Doe, John",
+			},
+			{
+				Email:    "b@" + domain,
+				Password: "password",
+				FullName: "janedoe",
+			},
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		for _, req := range userCreateReqs {
+			_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, req)
+		}
+
+		_, adminAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+
+		_, userAuthRsp, _ := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    userCreateReqs[0].Email,
+			Password: userCreateReqs[0].Password,
+		})
+
+		var buf strings.Builder
+		httpRsp, _, err := client.ExportUsers(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.ExportUsersRequest{
+			Format: types.ExportFormatCsv,
+		}, &buf)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 403, httpRsp.StatusCode)
+
+		buf.Reset()
+		httpRsp, _, err = client.ExportUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ExportUsersRequest{
+			Format:      types.ExportFormatCsv,
+			Columns:     []string{types.UserColumnEmail, types.UserColumnFullName},
+			SortBy:      types.SortByEmail,
+			SortOrder:   types.SortOrderAsc,
+			EmailDomain: domain,
+		}, &buf)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, types.ContentTypeCsv, httpRsp.Header.Get(types.HeaderContentType))
+		assert.Equal(t, "email,fullname\na@"+domain+",\"Doe, John\"\nb@"+domain+",janedoe\n", buf.String())
+
+		buf.Reset()
+		httpRsp, _, err = client.ExportUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ExportUsersRequest{
+			Format:      types.ExportFormatJsonl,
+			EmailDomain: domain,
+		}, &buf)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if assert.Len(t, lines, 2) {
+			var u map[string]interface{}
+			err = json.Unmarshal([]byte(lines[0]), &u)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, "b@"+domain, u[types.UserColumnEmail])
+			assert.NotContains(t, u, "password")
+		}
+
+		buf.Reset()
+		httpRsp, _, err = client.ExportUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ExportUsersRequest{
+			Format:      types.ExportFormatParquet,
+			EmailDomain: domain,
+		}, &buf)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.True(t, strings.HasPrefix(buf.String(), "PAR1"))
+		assert.True(t, strings.HasSuffix(buf.String(), "PAR1"))
+
+		httpRsp, rsp, err := client.ExportUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ExportUsersRequest{
+			Format:  types.ExportFormatCsv,
+			Columns: []string{"password"},
+		}, &buf)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.NotEmpty(t, rsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScim(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		token := args.ScimBearerToken
+		email := prefix + "testScim0@example.com"
+
+		httpRsp, _, err := client.ScimCreateUser(ctx, httpClient, userSvcAddr, "wrong-token", types.ScimUser{UserName: email})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 401, httpRsp.StatusCode)
+
+		httpRsp, rsp, err := client.ScimCreateUser(ctx, httpClient, userSvcAddr, token, types.ScimUser{
+			Schemas:    []string{types.ScimSchemaUser},
+			UserName:   email,
+			ExternalID: "00u1",
+			Name:       &types.ScimName{GivenName: "John", FamilyName: "Doe"},
+			Password:   "password",
+		})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 201, httpRsp.StatusCode) {
+			return
+		}
+		assert.Equal(t, email, rsp.User.UserName)
+		assert.Equal(t, "John Doe", rsp.User.DisplayName)
+		assert.Equal(t, "00u1", rsp.User.ExternalID)
+		assert.True(t, *rsp.User.Active)
+		assert.Equal(t, types.UserGroupUser, rsp.User.Groups[0].Value)
+
+		id := rsp.User.ID
+
+		httpRsp, rsp, err = client.ScimCreateUser(ctx, httpClient, userSvcAddr, token, types.ScimUser{UserName: email})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 409, httpRsp.StatusCode)
+		if assert.NotNil(t, rsp.Error) {
+			assert.Equal(t, types.ScimTypeUniqueness, rsp.Error.ScimType)
+		}
+
+		httpRsp, listRsp, users, err := client.ScimListUsers(ctx, httpClient, userSvcAddr, token, types.ScimListRequest{Filter: `userName eq "` + strings.ToUpper(email) + `"`})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, 1, listRsp.List.TotalResults)
+		if assert.Len(t, users, 1) {
+			assert.Equal(t, id, users[0].ID)
+		}
+
+		httpRsp, listRsp, users, err = client.ScimListUsers(ctx, httpClient, userSvcAddr, token, types.ScimListRequest{StartIndex: 1, Count: 1})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, 1, listRsp.List.ItemsPerPage)
+		assert.Len(t, users, 1)
+
+		httpRsp, listRsp, _, err = client.ScimListUsers(ctx, httpClient, userSvcAddr, token, types.ScimListRequest{Filter: `displayName co "john"`})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 400, httpRsp.StatusCode)
+		if assert.NotNil(t, listRsp.Error) {
+			assert.Equal(t, types.ScimTypeInvalidFilter, listRsp.Error.ScimType)
+		}
+
+		httpRsp, rsp, err = client.ScimPatchUser(ctx, httpClient, userSvcAddr, token, id, types.ScimPatchRequest{
+			Schemas: []string{types.ScimSchemaPatchOp},
+			Operations: []types.ScimPatchOperation{
+				{Op: "Replace", Path: "name.givenName", Value: json.RawMessage(`"Johnny"`)},
+				{Op: "Replace", Path: "externalId", Value: json.RawMessage(`"00u2"`)},
+			},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, "Johnny Doe", rsp.User.DisplayName)
+		assert.Equal(t, "00u2", rsp.User.ExternalID)
+
+		httpRsp, rsp, err = client.ScimPatchUser(ctx, httpClient, userSvcAddr, token, id, types.ScimPatchRequest{
+			Schemas:    []string{types.ScimSchemaPatchOp},
+			Operations: []types.ScimPatchOperation{{Op: "Replace", Path: "title", Value: json.RawMessage(`"CEO"`)}},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 400, httpRsp.StatusCode)
+		if assert.NotNil(t, rsp.Error) {
+			assert.Equal(t, types.ScimTypeInvalidPath, rsp.Error.ScimType)
+		}
+
+		httpRsp, rsp, err = client.ScimPatchUser(ctx, httpClient, userSvcAddr, token, id, types.ScimPatchRequest{
+			Schemas: []string{types.ScimSchemaPatchOp},
+			Operations: []types.ScimPatchOperation{
+				{Op: "Replace", Path: "displayName", Value: json.RawMessage(`"Jane Doe"`)},
+				{Op: "Replace", Path: "active", Value: json.RawMessage(`false`)},
+			},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, "Jane Doe", rsp.User.DisplayName)
+		assert.False(t, *rsp.User.Active)
+
+		httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: email, Password: "password"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+
+		active := true
+		httpRsp, rsp, err = client.ScimReplaceUser(ctx, httpClient, userSvcAddr, token, id, types.ScimUser{
+			Schemas:     []string{types.ScimSchemaUser},
+			UserName:    email,
+			DisplayName: "Jane Doe",
+			Active:      &active,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.True(t, *rsp.User.Active)
+
+		httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: email, Password: "password"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		inactive := false
+		pendingEmail := prefix + "testScim1@example.com"
+		httpRsp, rsp, err = client.ScimCreateUser(ctx, httpClient, userSvcAddr, token, types.ScimUser{
+			Schemas:  []string{types.ScimSchemaUser},
+			UserName: pendingEmail,
+			Active:   &inactive,
+			Password: "password",
+		})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 201, httpRsp.StatusCode) {
+			return
+		}
+		assert.False(t, *rsp.User.Active, "users created inactive are pending")
+
+		httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: pendingEmail, Password: "password"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+
+		httpRsp, rsp, err = client.ScimPatchUser(ctx, httpClient, userSvcAddr, token, rsp.User.ID, types.ScimPatchRequest{
+			Schemas:    []string{types.ScimSchemaPatchOp},
+			Operations: []types.ScimPatchOperation{{Op: "replace", Path: "active", Value: json.RawMessage(`true`)}},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.True(t, *rsp.User.Active)
+
+		httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: pendingEmail, Password: "password"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "pending users can sign in once activated")
+
+		httpRsp, groupRsp, err := client.ScimPatchGroup(ctx, httpClient, userSvcAddr, token, types.UserGroupAdmin, types.ScimPatchRequest{
+			Schemas:    []string{types.ScimSchemaPatchOp},
+			Operations: []types.ScimPatchOperation{{Op: "add", Path: "members", Value: json.RawMessage(`[{"value":"` + id + `"}]`)}},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Contains(t, groupRsp.Group.Members, types.ScimMember{Value: id, Ref: types.RouteScimUsers + "/" + id, Display: email})
+
+		httpRsp, rsp, err = client.ScimGetUser(ctx, httpClient, userSvcAddr, token, id)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, types.UserGroupAdmin, rsp.User.Groups[0].Value)
+
+		httpRsp, groupRsp, err = client.ScimPatchGroup(ctx, httpClient, userSvcAddr, token, types.UserGroupAdmin, types.ScimPatchRequest{
+			Schemas:    []string{types.ScimSchemaPatchOp},
+			Operations: []types.ScimPatchOperation{{Op: "remove", Path: `members[value eq "` + id + `"]`}},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.NotContains(t, groupRsp.Group.Members, types.ScimMember{Value: id, Ref: types.RouteScimUsers + "/" + id, Display: email})
+
+		httpRsp, groupRsp, err = client.ScimPatchGroup(ctx, httpClient, userSvcAddr, token, types.UserGroupUser, types.ScimPatchRequest{
+			Schemas:    []string{types.ScimSchemaPatchOp},
+			Operations: []types.ScimPatchOperation{{Op: "remove", Path: `members[value eq "` + id + `"]`}},
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 400, httpRsp.StatusCode)
+		if assert.NotNil(t, groupRsp.Error) {
+			assert.Equal(t, types.ScimTypeMutability, groupRsp.Error.ScimType)
+		}
+
+		httpRsp, _, err = client.ScimDeleteUser(ctx, httpClient, userSvcAddr, token, id)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 204, httpRsp.StatusCode)
+
+		httpRsp, rsp, err = client.ScimGetUser(ctx, httpClient, userSvcAddr, token, id)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 404, httpRsp.StatusCode)
+		assert.NotNil(t, rsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func oidcLogin(identity oidcutil.MockIdentity, req types.OidcLoginRequest) (httpRsp *http.Response, rsp types.OidcCallbackResponse, err error) {
+	oidcProvider.SetIdentity(identity)
+
+	httpRsp, loginRsp, stateCookie, err := client.StartOidcLogin(ctx, httpClient, userSvcAddr, req)
+	if err != nil {
+		return
+	}
+
+	if httpRsp.StatusCode != http.StatusFound {
+		err = errors.Errorf("failed to start oidc login: %v %v", httpRsp.StatusCode, loginRsp.Error)
+
+		return
+	}
+
+	c := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	authorizeRsp, err := c.Get(loginRsp.AuthorizationURL)
+	if err != nil {
+		return
+	}
+	_ = authorizeRsp.Body.Close()
+
+	return client.FinishOidcLogin(ctx, httpClient, authorizeRsp.Header.Get("Location"), stateCookie)
+}
+
+func TestOidcLogin(t *testing.T) {
+	if args.Remote {
+		t.Skip("requires the mock oidc provider")
+	}
+
+	t.Parallel()
+
+	err := func() (err error) {
+		existingCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testOidcLogin0@oidc.test",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, existingCreateReq)
+
+		httpRsp, authRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    existingCreateReq.Email,
+			Password: existingCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorFederatedLoginRequired, authRsp.Error)
+
+		httpRsp, rsp, err := oidcLogin(oidcutil.MockIdentity{Subject: prefix + "-0", Email: existingCreateReq.Email, EmailVerified: true, Name: "John Doe"}, types.OidcLoginRequest{Email: existingCreateReq.Email})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.NotEmpty(t, rsp.AccessToken)
+
+		_, meRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, rsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, meRsp.User) {
+			assert.Equal(t, existingCreateReq.Email, meRsp.User.Email)
+			assert.Equal(t, existingCreateReq.FullName, meRsp.User.FullName)
+		}
+
+		jitEmail := prefix + "testOidcLogin1@oidc.test"
+
+		httpRsp, rsp, err = oidcLogin(oidcutil.MockIdentity{Subject: prefix + "-1", Email: jitEmail, EmailVerified: true, Name: "Jane Doe"}, types.OidcLoginRequest{Provider: "corp"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		_, meRsp, err = client.GetMe(ctx, httpClient, userSvcAddr, rsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, meRsp.User) {
+			return
+		}
+		assert.Equal(t, jitEmail, meRsp.User.Email)
+		assert.Equal(t, "Jane Doe", meRsp.User.FullName)
+		assert.Equal(t, types.UserGroupUser, meRsp.User.UserGroup)
+
+		jitID := meRsp.User.ID
+
+		httpRsp, rsp, err = oidcLogin(oidcutil.MockIdentity{Subject: prefix + "-1", Email: prefix + "testOidcLogin2@oidc.test", EmailVerified: true}, types.OidcLoginRequest{Provider: "corp"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		_, meRsp, err = client.GetMe(ctx, httpClient, userSvcAddr, rsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, meRsp.User) {
+			assert.Equal(t, jitID, meRsp.User.ID, "the subject stays linked to the user")
+		}
+
+		httpRsp, rsp, err = oidcLogin(oidcutil.MockIdentity{Subject: prefix + "-3", Email: prefix + "testOidcLogin3@example.com", EmailVerified: true}, types.OidcLoginRequest{Provider: "corp"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorOidcEmailDomainNotAllowed, rsp.Error)
+
+		httpRsp, rsp, err = client.FinishOidcLogin(ctx, httpClient, userSvcAddr+types.RouteOidcCallback+"?code=code&state=state", nil)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvalidOidcState, rsp.Error)
+
+		httpRsp, _, _, err = client.StartOidcLogin(ctx, httpClient, userSvcAddr, types.OidcLoginRequest{Provider: "unknown"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 404, httpRsp.StatusCode)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLdapAuthenticate(t *testing.T) {
+	if args.Remote {
+		t.Skip("requires the mock ldap server")
+	}
+
+	t.Parallel()
+
+	err := func() (err error) {
+		email := prefix + "testLdapAuthenticate0@example.com"
+		dn := "uid=" + prefix + "-0,ou=people,dc=example,dc=com"
+
+		setEntry := func(groups ...string) {
+			ldapServer.SetEntry(ldaputil.MockEntry{DN: dn, Password: "ldap-password", Attributes: map[string][]string{
+				"objectClass": {"person"},
+				"mail":        {email},
+				"cn":          {"John Doe"},
+				"memberOf":    groups,
+			}})
+		}
+
+		setEntry("cn=staff,ou=groups,dc=example,dc=com")
+
+		httpRsp, authRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: email, Password: "ldap-password"})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		_, meRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, authRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, meRsp.User) {
+			return
+		}
+		assert.Equal(t, email, meRsp.User.Email)
+		assert.Equal(t, "John Doe", meRsp.User.FullName)
+		assert.Equal(t, types.UserGroupUser, meRsp.User.UserGroup)
+
+		userID := meRsp.User.ID
+
+		httpRsp, authRsp, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: email, Password: "wrong-password"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvalidCredentials, authRsp.Error)
+
+		setEntry("cn=admins,ou=groups,dc=example,dc=com")
+
+		httpRsp, authRsp, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: email, Password: "ldap-password"})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		_, meRsp, err = client.GetMe(ctx, httpClient, userSvcAddr, authRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, meRsp.User) {
+			assert.Equal(t, userID, meRsp.User.ID)
+			assert.Equal(t, types.UserGroupAdmin, meRsp.User.UserGroup, "the user group follows the group mapping")
+		}
+
+		setEntry()
+
+		httpRsp, authRsp, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: email, Password: "ldap-password"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvalidCredentials, authRsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSamlLogin(t *testing.T) {
+	if args.Remote {
+		t.Skip("requires the mock saml identity provider")
+	}
+
+	t.Parallel()
+
+	err := func() (err error) {
+		entityID := userSvcAddr + types.RouteSamlMetadata + "?provider=saml-corp"
+		acsURL := userSvcAddr + types.RouteSamlAcs + "?provider=saml-corp"
+
+		httpRsp, metadataRsp, err := client.GetSamlMetadata(ctx, httpClient, userSvcAddr, types.SamlMetadataRequest{Provider: "saml-corp"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+		assert.Equal(t, types.ContentTypeSamlMetadata, httpRsp.Header.Get(types.HeaderContentType))
+		assert.Contains(t, string(metadataRsp.Metadata), `Location="`+acsURL+`"`)
+
+		email := prefix + "testSamlLogin0@saml.test"
+
+		samlResponse, err := samlIdentityProvider.MakeResponse(samlutil.MockAssertion{
+			Audience:   entityID,
+			Recipient:  acsURL,
+			NameID:     prefix + "-0",
+			Attributes: map[string]string{"email": email, "displayName": "John Doe"},
+		})
+		if err != nil {
+			return
+		}
+
+		httpRsp, rsp, err := client.FinishSamlLogin(ctx, httpClient, userSvcAddr, types.SamlAcsRequest{Provider: "saml-corp", SamlResponse: samlResponse})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		_, meRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, rsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, meRsp.User) {
+			assert.Equal(t, email, meRsp.User.Email)
+			assert.Equal(t, "John Doe", meRsp.User.FullName)
+			assert.Equal(t, types.UserGroupUser, meRsp.User.UserGroup)
+		}
+
+		httpRsp, rsp, err = client.FinishSamlLogin(ctx, httpClient, userSvcAddr, types.SamlAcsRequest{Provider: "saml-corp", SamlResponse: samlResponse})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "assertions can only be presented once")
+		assert.Equal(t, types.ErrorInvalidSamlResponse, rsp.Error)
+
+		samlResponse, err = samlIdentityProvider.MakeResponse(samlutil.MockAssertion{
+			Audience:   userSvcAddr,
+			Recipient:  acsURL,
+			NameID:     prefix + "-1",
+			Attributes: map[string]string{"email": prefix + "testSamlLogin1@saml.test"},
+		})
+		if err != nil {
+			return
+		}
+
+		httpRsp, rsp, err = client.FinishSamlLogin(ctx, httpClient, userSvcAddr, types.SamlAcsRequest{Provider: "saml-corp", SamlResponse: samlResponse})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvalidSamlResponse, rsp.Error)
+
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testSamlLogin2@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+		if err != nil {
+			return
+		}
+
+		samlResponse, err = samlIdentityProvider.MakeResponse(samlutil.MockAssertion{
+			Audience:   userSvcAddr + types.RouteSamlMetadata + "?provider=saml-partner",
+			Recipient:  userSvcAddr + types.RouteSamlAcs + "?provider=saml-partner",
+			NameID:     prefix + "-2",
+			Attributes: map[string]string{"email": adminCreateReq.Email},
+		})
+		if err != nil {
+			return
+		}
+
+		httpRsp, rsp, err = client.FinishSamlLogin(ctx, httpClient, userSvcAddr, types.SamlAcsRequest{Provider: "saml-partner", SamlResponse: samlResponse})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 409, httpRsp.StatusCode, "a provider without domains can't sign in existing users")
+		assert.Equal(t, types.ErrorIdentityNotLinkable, rsp.Error)
+		assert.Empty(t, rsp.AccessToken)
+
+		httpRsp, _, err = client.FinishSamlLogin(ctx, httpClient, userSvcAddr, types.SamlAcsRequest{Provider: "unknown", SamlResponse: samlResponse})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 404, httpRsp.StatusCode)
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMagicLink(t *testing.T) {
+	if args.Remote {
+		t.Skip("requires the mock mailer")
+	}
+
+	t.Parallel()
+
+	err := func() (err error) {
+		createReq := types.CreateUserRequest{
+			Email:    prefix + "testMagicLink0@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, createReq)
+		if err != nil {
+			return
+		}
+
+		requestMagicLink := func(email string) (httpRsp *http.Response, rsp types.RequestMagicLinkResponse, nonceCookie *http.Cookie, token string, err error) {
+			sent := len(mailer.Messages(email))
+
+			httpRsp, rsp, nonceCookie, err = client.RequestMagicLink(ctx, httpClient, userSvcAddr, types.RequestMagicLinkRequest{Email: email})
+			if err != nil {
+				return
+			}
+
+			if httpRsp.StatusCode != http.StatusOK {
+				return
+			}
+
+			msgs := mailer.WaitForMessages(email, sent+1, 5*time.Second)
+			if len(msgs) == sent {
+				return
+			}
+
+			i := strings.Index(msgs[len(msgs)-1].Body, "https://app.test/login?")
+			if i == -1 {
+				err = errors.New("failed to find magic link in message")
+
+				return
+			}
+
+			u, err := url.Parse(strings.Fields(msgs[len(msgs)-1].Body[i:])[0])
+			if err != nil {
+				err = errors.Wrap(err, "failed to parse magic link")
+
+				return
+			}
+
+			token = u.Query().Get(types.QueryToken)
+
+			return
+		}
+
+		httpRsp, _, nonceCookie, token, err := requestMagicLink(createReq.Email)
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.NotNil(t, nonceCookie) || !assert.NotEmpty(t, token) {
+			return
+		}
+
+		httpRsp, rsp, err := client.ConsumeMagicLink(ctx, httpClient, userSvcAddr, types.ConsumeMagicLinkRequest{Token: token}, nil)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "the nonce cookie is required")
+		assert.Equal(t, types.ErrorInvalidMagicLink, rsp.Error)
+
+		httpRsp, rsp, err = client.ConsumeMagicLink(ctx, httpClient, userSvcAddr, types.ConsumeMagicLinkRequest{Token: token}, nonceCookie)
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		_, meRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, rsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.NotNil(t, meRsp.User) {
+			assert.Equal(t, createReq.Email, meRsp.User.Email)
+		}
+
+		httpRsp, rsp, err = client.ConsumeMagicLink(ctx, httpClient, userSvcAddr, types.ConsumeMagicLinkRequest{Token: token}, nonceCookie)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "magic links can only be consumed once")
+		assert.Equal(t, types.ErrorInvalidMagicLink, rsp.Error)
+
+		unknownEmail := prefix + "testMagicLink1@example.com"
+
+		httpRsp, _, nonceCookie, err = client.RequestMagicLink(ctx, httpClient, userSvcAddr, types.RequestMagicLinkRequest{Email: unknownEmail})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "unknown emails aren't revealed")
+		assert.NotNil(t, nonceCookie)
+		assert.Empty(t, mailer.Messages(unknownEmail))
+
+		for i := 0; i < 2; i++ {
+			httpRsp, _, _, _, err = requestMagicLink(createReq.Email)
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, 200, httpRsp.StatusCode)
+		}
+
+		httpRsp, reqRsp, _, _, err := requestMagicLink(strings.ToUpper(createReq.Email))
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 429, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorTooManyMagicLinkRequests, reqRsp.Error)
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvitations(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testInvitations0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		_, adminMeRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, adminMeRsp.User) {
+			return
+		}
+
+		email := prefix + "testInvitations1@example.com"
+
+		httpRsp, inviteRsp, err := client.InviteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.InviteUserRequest{Email: email, UserGroup: types.UserGroupAdmin})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.NotNil(t, inviteRsp.Invitation) {
+			return
+		}
+		assert.Equal(t, types.InvitationStatusPending, inviteRsp.Invitation.Status)
+		assert.Equal(t, adminMeRsp.User.ID, inviteRsp.Invitation.InvitedBy)
+		assert.NotEmpty(t, inviteRsp.Token)
+
+		firstID, firstToken := inviteRsp.Invitation.ID, inviteRsp.Token
+
+		if !args.Remote {
+			msgs := mailer.Messages(email)
+			if assert.Len(t, msgs, 1) {
+				assert.Contains(t, msgs[0].Body, "https://app.test/invitation?token="+firstToken)
+			}
+		}
+
+		httpRsp, inviteRsp, err = client.InviteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.InviteUserRequest{Email: email, UserGroup: types.UserGroupAdmin, ExpiresInSeconds: 3600})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		httpRsp, listRsp, err := client.ListInvitations(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListInvitationsRequest{Email: email, PageSize: 1})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.Len(t, listRsp.Invitations, 1) {
+			return
+		}
+		assert.Equal(t, inviteRsp.Invitation.ID, listRsp.Invitations[0].ID, "newest first")
+
+		httpRsp, listRsp, err = client.ListInvitations(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListInvitationsRequest{Email: email, PageSize: 1, Cursor: listRsp.NextCursor})
+		if err != nil {
+			return
+		}
+
+		if assert.Len(t, listRsp.Invitations, 1) {
+			assert.Equal(t, firstID, listRsp.Invitations[0].ID)
+			assert.Equal(t, types.InvitationStatusRevoked, listRsp.Invitations[0].Status, "inviting again revokes the open invitation")
+		}
+
+		httpRsp, acceptRsp, err := client.AcceptInvitation(ctx, httpClient, userSvcAddr, types.AcceptInvitationRequest{Token: firstToken, Password: "password", FullName: "Jane Doe"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvalidInvitation, acceptRsp.Error)
+
+		httpRsp, acceptRsp, err = client.AcceptInvitation(ctx, httpClient, userSvcAddr, types.AcceptInvitationRequest{Token: inviteRsp.Token, Password: "password", FullName: "Jane Doe"})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		_, meRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, acceptRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, meRsp.User) {
+			return
+		}
+		assert.Equal(t, email, meRsp.User.Email)
+		assert.Equal(t, "Jane Doe", meRsp.User.FullName)
+		assert.Equal(t, types.UserGroupAdmin, meRsp.User.UserGroup, "the invited user gets the pre-assigned group")
+
+		httpRsp, acceptRsp, err = client.AcceptInvitation(ctx, httpClient, userSvcAddr, types.AcceptInvitationRequest{Token: inviteRsp.Token, Password: "password", FullName: "Jane Doe"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "invitations can only be accepted once")
+
+		_, auditRsp, err := client.ListAuditEvents(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListAuditEventsRequest{TargetID: meRsp.User.ID, Action: types.AuditActionUserCreated})
+		if err != nil {
+			return
+		}
+
+		if assert.Len(t, auditRsp.Events, 1) {
+			assert.Equal(t, adminMeRsp.User.ID, auditRsp.Events[0].Diff["invited_by"].After)
+		}
+
+		_, historyRsp, err := client.ListLoginHistory(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListLoginHistoryRequest{Email: email})
+		if err != nil {
+			return
+		}
+
+		if assert.Len(t, historyRsp.Attempts, 1, "accepting an invitation is a sign in") {
+			assert.Equal(t, types.LoginMethodInvitation, historyRsp.Attempts[0].Method)
+			assert.Equal(t, types.LoginOutcomeSuccess, historyRsp.Attempts[0].Outcome)
+		}
+
+		_, auditRsp, err = client.ListAuditEvents(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListAuditEventsRequest{TargetID: inviteRsp.Invitation.ID, ActorID: adminMeRsp.User.ID, Action: types.AuditActionInvitationCreated})
+		if err != nil {
+			return
+		}
+
+		assert.Len(t, auditRsp.Events, 1)
+
+		httpRsp, revokeRsp, err := client.RevokeInvitation(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RevokeInvitationRequest{ID: inviteRsp.Invitation.ID})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 409, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorInvitationNotPending, revokeRsp.Error)
+
+		httpRsp, inviteRsp, err = client.InviteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.InviteUserRequest{Email: email, UserGroup: types.UserGroupUser})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+		assert.Equal(t, types.ErrorEmailAlreadyExists, inviteRsp.Error)
+
+		otherEmail := prefix + "testInvitations2@example.com"
+
+		_, inviteRsp, err = client.InviteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.InviteUserRequest{Email: otherEmail, UserGroup: types.UserGroupUser})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, inviteRsp.Invitation) {
+			return
+		}
+
+		httpRsp, revokeRsp, err = client.RevokeInvitation(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RevokeInvitationRequest{ID: inviteRsp.Invitation.ID})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.NotNil(t, revokeRsp.Invitation) {
+			assert.Equal(t, types.InvitationStatusRevoked, revokeRsp.Invitation.Status)
+		}
+
+		httpRsp, acceptRsp, err = client.AcceptInvitation(ctx, httpClient, userSvcAddr, types.AcceptInvitationRequest{Token: inviteRsp.Token, Password: "password", FullName: "John Doe"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "revoked invitations can't be accepted")
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserAttributes(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testUserAttributes0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		userCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testUserAttributes1@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, userCreateReq)
+		if err != nil {
+			return
+		}
+
+		_, userAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    userCreateReq.Email,
+			Password: userCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		_, meRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, meRsp.User) {
+			return
+		}
+		assert.JSONEq(t, `{}`, string(meRsp.User.Attributes))
+
+		namespace := "crm_" + strings.NewReplacer("-", "_").Replace(strings.ToLower(prefix))
+
+		httpRsp, putRsp, err := client.PutAttributeSchema(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.PutAttributeSchemaRequest{
+			Namespace: namespace,
+			Schema:    json.RawMessage(`{"type": "object", "properties": {"plan": {"enum": ["free", "pro"]}}, "required": ["plan"]}`),
+		})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.NotNil(t, putRsp.Schema) {
+			return
+		}
+		assert.Equal(t, namespace, putRsp.Schema.Namespace)
+
+		httpRsp, _, err = client.PutAttributeSchema(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.PutAttributeSchemaRequest{Namespace: "Invalid-Namespace", Schema: json.RawMessage(`{}`)})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+
+		httpRsp, _, err = client.PutAttributeSchema(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.PutAttributeSchemaRequest{Namespace: namespace, Schema: json.RawMessage(`{"type": 1}`)})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "invalid schemas are rejected")
+
+		httpRsp, _, err = client.PutAttributeSchema(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.PutAttributeSchemaRequest{Namespace: namespace, Schema: json.RawMessage(`{}`)})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 403, httpRsp.StatusCode)
+
+		httpRsp, listSchemasRsp, err := client.ListAttributeSchemas(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListAttributeSchemasRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) {
+			var found bool
+			for _, s := range listSchemasRsp.Schemas {
+				found = found || s.Namespace == namespace
+			}
+			assert.True(t, found)
+		}
+
+		updateReq := types.UpdateUserRequest{
+			ID:         meRsp.User.ID,
+			Email:      meRsp.User.Email,
+			FullName:   meRsp.User.FullName,
+			UserGroup:  meRsp.User.UserGroup,
+			Attributes: map[string]json.RawMessage{namespace: json.RawMessage(`{"plan": "enterprise"}`)},
+			Version:    meRsp.User.Version,
+		}
+
+		httpRsp, _, err = client.UpdateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, updateReq)
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "attributes that violate the schema are rejected")
+
+		updateReq.Attributes = map[string]json.RawMessage{namespace + "_unknown": json.RawMessage(`{}`)}
+
+		httpRsp, updateRsp, err := client.UpdateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, updateReq)
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 422, httpRsp.StatusCode, "namespaces without schema are rejected") {
+			assert.Equal(t, types.ErrorAttributeSchemaDoesNotExist, updateRsp.Error)
+		}
+
+		updateReq.Attributes = map[string]json.RawMessage{namespace: json.RawMessage(`{"plan": "pro"}`)}
+
+		httpRsp, updateRsp, err = client.UpdateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, updateReq)
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.NotNil(t, updateRsp.User) {
+			return
+		}
+		assert.JSONEq(t, `{"`+namespace+`": {"plan": "pro"}}`, string(updateRsp.User.Attributes))
+
+		httpRsp, listRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{
+			Attributes: json.RawMessage(`{"` + namespace + `": {"plan": "pro"}}`),
+		})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.Len(t, listRsp.Users, 1) {
+			assert.Equal(t, meRsp.User.ID, listRsp.Users[0].ID)
+		}
+
+		httpRsp, listRsp, err = client.ListUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{
+			Attributes: json.RawMessage(`{"` + namespace + `": {"plan": "free"}}`),
+		})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) {
+			assert.Len(t, listRsp.Users, 0)
+		}
+
+		httpRsp, _, err = client.ListUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{Attributes: json.RawMessage(`"pro"`)})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+
+		httpRsp, deleteRsp, err := client.DeleteAttributeSchema(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteAttributeSchemaRequest{Namespace: namespace})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 409, httpRsp.StatusCode, "schemas in use can't be deleted") {
+			assert.Equal(t, types.ErrorAttributeSchemaInUse, deleteRsp.Error)
+		}
+
+		updateReq.Attributes = map[string]json.RawMessage{namespace: json.RawMessage(`null`)}
+		updateReq.Version = updateRsp.User.Version
+
+		httpRsp, updateRsp, err = client.UpdateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, updateReq)
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.NotNil(t, updateRsp.User) {
+			return
+		}
+		assert.JSONEq(t, `{}`, string(updateRsp.User.Attributes))
+
+		httpRsp, _, err = client.DeleteAttributeSchema(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteAttributeSchemaRequest{Namespace: namespace})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, deleteRsp, err = client.DeleteAttributeSchema(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteAttributeSchemaRequest{Namespace: namespace})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 404, httpRsp.StatusCode) {
+			assert.Equal(t, types.ErrorAttributeSchemaDoesNotExist, deleteRsp.Error)
+		}
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserStatus(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testUserStatus0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, adminCreateReq)
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    adminCreateReq.Email,
+			Password: adminCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		_, adminMeRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, adminMeRsp.User) {
+			return
+		}
+
+		userCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testUserStatus1@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		_, _, err = client.CreateUser(ctx, httpClient, userSvcAddr, userCreateReq)
+		if err != nil {
+			return
+		}
+
+		_, userAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{
+			Email:    userCreateReq.Email,
+			Password: userCreateReq.Password,
+		})
+		if err != nil {
+			return
+		}
+
+		_, meRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, meRsp.User) {
+			return
+		}
+		assert.Equal(t, types.UserStatusActive, meRsp.User.Status)
+
+		httpRsp, suspendRsp, err := client.SuspendUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.SuspendUserRequest{ID: adminMeRsp.User.ID, Reason: "testing"})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 422, httpRsp.StatusCode, "admins can't suspend themselves") {
+			assert.Equal(t, types.ErrorCannotChangeOwnStatus, suspendRsp.Error)
+		}
+
+		httpRsp, _, err = client.SuspendUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.SuspendUserRequest{ID: meRsp.User.ID})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode, "a reason is required")
+
+		httpRsp, suspendRsp, err = client.SuspendUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.SuspendUserRequest{ID: meRsp.User.ID, Reason: "chargeback"})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.NotNil(t, suspendRsp.User) {
+			return
+		}
+		assert.Equal(t, types.UserStatusSuspended, suspendRsp.User.Status)
+		assert.Equal(t, "chargeback", suspendRsp.User.StatusReason)
+
+		httpRsp, suspendRsp, err = client.SuspendUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.SuspendUserRequest{ID: meRsp.User.ID, Reason: "chargeback"})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 409, httpRsp.StatusCode) {
+			assert.Equal(t, types.ErrorInvalidStatusTransition, suspendRsp.Error)
+		}
+
+		httpRsp, getMeRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 401, httpRsp.StatusCode, "tokens of suspended users are rejected") {
+			assert.Equal(t, types.ErrorUserNotActive, getMeRsp.Error)
+		}
+
+		httpRsp, authRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: userCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 422, httpRsp.StatusCode, "suspended users can't authenticate, and can't tell whether the password matches") {
+			assert.Equal(t, types.ErrorInvalidCredentials, authRsp.Error)
+			assert.Empty(t, authRsp.AccessToken)
+		}
+
+		httpRsp, listRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{Status: types.UserStatusSuspended, EmailDomain: "example.com", PageSize: 1000})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) {
+			var found bool
+			for _, u := range listRsp.Users {
+				assert.Equal(t, types.UserStatusSuspended, u.Status)
+				found = found || u.ID == meRsp.User.ID
+			}
+			assert.True(t, found)
+		}
+
+		httpRsp, reactivateRsp, err := client.ReactivateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ReactivateUserRequest{ID: meRsp.User.ID, Reason: "resolved"})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.NotNil(t, reactivateRsp.User) {
+			assert.Equal(t, types.UserStatusActive, reactivateRsp.User.Status)
+		}
+
+		httpRsp, getMeRsp, err = client.GetMe(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 401, httpRsp.StatusCode, "tokens issued before the suspension stay revoked") {
+			assert.Equal(t, types.ErrorTokenRevoked, getMeRsp.Error)
+		}
+
+		for i := 0; i < 5; i++ {
+			httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: "wrong-password"})
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, 422, httpRsp.StatusCode)
+		}
+
+		httpRsp, authRsp, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: userCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 422, httpRsp.StatusCode, "locked users get the same response as for a wrong password") {
+			assert.Equal(t, types.ErrorInvalidCredentials, authRsp.Error)
+		}
+
+		httpRsp, listRsp, err = client.ListUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{Status: types.UserStatusLocked, EmailDomain: "example.com", PageSize: 1000})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) {
+			var found bool
+			for _, u := range listRsp.Users {
+				found = found || u.ID == meRsp.User.ID
+			}
+			assert.True(t, found, "users are locked after 5 failed authentications")
+		}
+
+		httpRsp, reactivateRsp, err = client.ReactivateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ReactivateUserRequest{ID: meRsp.User.ID, Reason: "verified by phone"})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.NotNil(t, reactivateRsp.User) {
+			assert.Equal(t, types.UserStatusActive, reactivateRsp.User.Status)
+		}
+
+		httpRsp, _, err = client.UpdateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.UpdateUserRequest{
+			ID:        meRsp.User.ID,
+			Email:     meRsp.User.Email,
+			FullName:  "janedoe",
+			UserGroup: meRsp.User.UserGroup,
+			Version:   meRsp.User.Version,
+		})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "failed authentications, and status changes don't change the version")
+
+		httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: userCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, reactivateRsp, err = client.ReactivateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ReactivateUserRequest{ID: meRsp.User.ID, Reason: "again"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 409, httpRsp.StatusCode, "active users can't be reactivated")
+
+		httpRsp, _, err = client.SuspendUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.SuspendUserRequest{ID: meRsp.User.ID, Reason: "chargeback"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, _, err = client.DeleteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteUserRequest{Email: userCreateReq.Email})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, restoreRsp, err := client.RestoreUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.RestoreUserRequest{ID: meRsp.User.ID})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.NotNil(t, restoreRsp.User) {
+			assert.Equal(t, types.UserStatusSuspended, restoreRsp.User.Status, "restored users keep the status they had before the deletion")
+			assert.Equal(t, "chargeback", restoreRsp.User.StatusReason)
+		}
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTokenRevocation(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testTokenRevocation0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		demotedCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testTokenRevocation1@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		userCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testTokenRevocation2@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		for _, req := range []types.CreateUserRequest{adminCreateReq, demotedCreateReq, userCreateReq} {
+			_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, req)
+		}
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: adminCreateReq.Email, Password: adminCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		_, demotedAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: demotedCreateReq.Email, Password: demotedCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		_, demotedMeRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, demotedAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.NotNil(t, demotedMeRsp.User) {
+			return
+		}
+
+		httpRsp, _, err := client.UpdateUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.UpdateUserRequest{
+			ID:        demotedMeRsp.User.ID,
+			Email:     demotedMeRsp.User.Email,
+			FullName:  demotedMeRsp.User.FullName,
+			UserGroup: types.UserGroupUser,
+			Version:   demotedMeRsp.User.Version,
+		})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		httpRsp, listRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, demotedAuthRsp.AccessToken, types.ListUsersRequest{PageSize: 10})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 401, httpRsp.StatusCode, "tokens issued before a group change are revoked") {
+			assert.Equal(t, types.ErrorTokenRevoked, listRsp.Error)
+		}
+
+		_, demotedAuthRsp, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: demotedCreateReq.Email, Password: demotedCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		httpRsp, _, err = client.ListUsers(ctx, httpClient, userSvcAddr, demotedAuthRsp.AccessToken, types.ListUsersRequest{PageSize: 10})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 403, httpRsp.StatusCode, "new tokens carry the new group")
+
+		_, userAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: userCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		httpRsp, changeRsp, err := client.ChangePassword(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.ChangePasswordRequest{CurrentPassword: userCreateReq.Password, NewPassword: "new-password"})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		httpRsp, getMeRsp, err := client.GetMe(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 401, httpRsp.StatusCode, "tokens issued before a password change are revoked") {
+			assert.Equal(t, types.ErrorTokenRevoked, getMeRsp.Error)
+		}
+
+		httpRsp, _, err = client.GetMe(ctx, httpClient, userSvcAddr, changeRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "the password change returns a token with the new epoch")
+
+		_, userAuthRsp, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: "new-password"})
+		if err != nil {
+			return
+		}
+
+		httpRsp, _, err = client.GetMe(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		httpRsp, _, err = client.DeleteUser(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.DeleteUserRequest{Email: userCreateReq.Email})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		httpRsp, _, err = client.GetMe(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.GetMeRequest{})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 401, httpRsp.StatusCode, "tokens of deleted users are rejected")
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStepUpAuthentication(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testStepUpAuthentication0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		userCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testStepUpAuthentication1@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		for _, req := range []types.CreateUserRequest{adminCreateReq, userCreateReq} {
+			_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, req)
+		}
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: adminCreateReq.Email, Password: adminCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		claims, err := business.GetJwtClaims("hmac-secret", adminAuthRsp.AccessToken)
+		if err != nil {
+			return
+		}
+
+		claims[types.ClaimAuthTime] = time.Now().Add(-time.Hour).Unix()
+
+		staleToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims(claims)).SignedString([]byte("hmac-secret"))
+		if err != nil {
+			return
+		}
+
+		httpRsp, _, err := client.ListUsers(ctx, httpClient, userSvcAddr, staleToken, types.ListUsersRequest{PageSize: 10})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "routes without freshness requirement accept stale tokens")
+
+		httpRsp, deleteRsp, err := client.DeleteUser(ctx, httpClient, userSvcAddr, staleToken, types.DeleteUserRequest{Email: userCreateReq.Email})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 401, httpRsp.StatusCode) {
+			assert.Equal(t, types.ErrorStepUpRequired, deleteRsp.Error)
+		}
+
+		httpRsp, reauthRsp, err := client.Reauthenticate(ctx, httpClient, userSvcAddr, staleToken, types.ReauthenticateRequest{Password: "wrong-password"})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 422, httpRsp.StatusCode) {
+			assert.Equal(t, types.ErrorInvalidCredentials, reauthRsp.Error)
+			assert.Empty(t, reauthRsp.AccessToken)
+		}
+
+		httpRsp, reauthRsp, err = client.Reauthenticate(ctx, httpClient, userSvcAddr, staleToken, types.ReauthenticateRequest{Password: adminCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) || !assert.NotEmpty(t, reauthRsp.AccessToken) {
+			return
+		}
+
+		httpRsp, _, err = client.DeleteUser(ctx, httpClient, userSvcAddr, reauthRsp.AccessToken, types.DeleteUserRequest{Email: userCreateReq.Email})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "reauthenticated tokens satisfy the freshness requirement")
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type userAgentTransport struct {
+	userAgent string
+	next      http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.Header.Set("User-Agent", t.userAgent)
+
+	return t.next.RoundTrip(r)
+}
+
+func TestLoginHistory(t *testing.T) {
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testLoginHistory0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		domain := "lh-" + strings.ToLower(prefix) + ".example.com"
+		userCreateReq := types.CreateUserRequest{
+			Email:    "testloginhistory1@" + domain,
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		for _, req := range []types.CreateUserRequest{adminCreateReq, userCreateReq} {
+			_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, req)
+		}
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: adminCreateReq.Email, Password: adminCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		httpRsp, _, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: "wrong-password"})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 422, httpRsp.StatusCode)
+
+		for i := 0; i < 2; i++ {
+			httpRsp, _, err = client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: userCreateReq.Password})
+			if err != nil {
+				return
+			}
+
+			assert.Equal(t, 200, httpRsp.StatusCode)
+		}
+
+		assert.Empty(t, mailer.Messages(userCreateReq.Email), "the first device isn't notified")
+
+		next := httpClient.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		otherDeviceClient := &http.Client{Transport: userAgentTransport{userAgent: "other-device/1.0", next: next}}
+
+		httpRsp, userAuthRsp, err := client.Authenticate(ctx, otherDeviceClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: userCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		if !assert.Equal(t, 200, httpRsp.StatusCode) {
+			return
+		}
+
+		msgs := mailer.WaitForMessages(userCreateReq.Email, 1, 5*time.Second)
+		if assert.Len(t, msgs, 1, "a new device is notified") {
+			assert.Contains(t, msgs[0].Body, "other-device/1.0")
+		}
+
+		httpRsp, historyRsp, err := client.ListLoginHistory(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.ListLoginHistoryRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.Len(t, historyRsp.Attempts, 4) {
+			assert.Equal(t, types.LoginOutcomeSuccess, historyRsp.Attempts[0].Outcome)
+			assert.Equal(t, "other-device/1.0", historyRsp.Attempts[0].UserAgent)
+			assert.NotEmpty(t, historyRsp.Attempts[0].IP)
+			assert.Equal(t, types.LoginMethodPassword, historyRsp.Attempts[0].Method)
+
+			assert.Equal(t, types.LoginOutcomeFailure, historyRsp.Attempts[3].Outcome)
+			assert.Equal(t, types.ErrorInvalidCredentials, historyRsp.Attempts[3].FailureReason)
+		}
+
+		httpRsp, _, err = client.ListLoginHistory(ctx, httpClient, userSvcAddr, userAuthRsp.AccessToken, types.ListLoginHistoryRequest{Email: adminCreateReq.Email})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 403, httpRsp.StatusCode, "users can only list their own login history")
+
+		httpRsp, historyRsp, err = client.ListLoginHistory(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListLoginHistoryRequest{Email: userCreateReq.Email, Outcome: types.LoginOutcomeFailure})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.Len(t, historyRsp.Attempts, 1) {
+			assert.Equal(t, types.ErrorInvalidCredentials, historyRsp.Attempts[0].FailureReason)
+		}
+
+		httpRsp, historyRsp, err = client.ListLoginHistory(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListLoginHistoryRequest{Email: userCreateReq.Email, PageSize: 3})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.Len(t, historyRsp.Attempts, 3) && assert.NotEmpty(t, historyRsp.NextCursor) {
+			httpRsp, historyRsp, err = client.ListLoginHistory(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListLoginHistoryRequest{Email: userCreateReq.Email, PageSize: 3, Cursor: historyRsp.NextCursor})
+			if err != nil {
+				return
+			}
+
+			if assert.Equal(t, 200, httpRsp.StatusCode) {
+				assert.Len(t, historyRsp.Attempts, 1)
+				assert.Empty(t, historyRsp.NextCursor)
+			}
+		}
+
+		httpRsp, listRsp, err := client.ListUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{EmailDomain: domain})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.Len(t, listRsp.Users, 1) {
+			assert.NotNil(t, listRsp.Users[0].LastLoginAt)
+			assert.NotEmpty(t, listRsp.Users[0].LastLoginIP)
+		}
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+type forwardedForTransport struct {
+	forwardedFor string
+	next         http.RoundTripper
+}
+
+func (t forwardedForTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r.Header.Set(types.HeaderXForwardedFor, t.forwardedFor)
+
+	return t.next.RoundTrip(r)
+}
+
+func TestNetworkPolicies(t *testing.T) {
+	if args.Remote {
+		t.Skip("requires the test server to trust the loopback proxy")
+	}
+
+	t.Parallel()
+
+	err := func() (err error) {
+		adminCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testNetworkPolicies0@test.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		userCreateReq := types.CreateUserRequest{
+			Email:    prefix + "testNetworkPolicies1@example.com",
+			Password: "password",
+			FullName: "johndoe",
+		}
+
+		for _, req := range []types.CreateUserRequest{adminCreateReq, userCreateReq} {
+			_, _, _ = client.CreateUser(ctx, httpClient, userSvcAddr, req)
+		}
+
+		_, adminAuthRsp, err := client.Authenticate(ctx, httpClient, userSvcAddr, types.AuthenticateRequest{Email: adminCreateReq.Email, Password: adminCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		httpRsp, _, err := client.ListUsers(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "admins are allowed from loopback")
+
+		next := httpClient.Transport
+		if next == nil {
+			next = http.DefaultTransport
+		}
+		forwardedClient := &http.Client{Transport: forwardedForTransport{forwardedFor: "198.51.100.1, 203.0.113.7", next: next}}
+
+		httpRsp, listRsp, err := client.ListUsers(ctx, forwardedClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListUsersRequest{})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 403, httpRsp.StatusCode, "the client ip is resolved through the trusted proxy") {
+			assert.Equal(t, types.ErrorNetworkNotAllowed, listRsp.Error)
+		}
+
+		httpRsp, _, err = client.Authenticate(ctx, forwardedClient, userSvcAddr, types.AuthenticateRequest{Email: userCreateReq.Email, Password: userCreateReq.Password})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode)
+
+		httpRsp, historyRsp, err := client.ListLoginHistory(ctx, httpClient, userSvcAddr, adminAuthRsp.AccessToken, types.ListLoginHistoryRequest{Email: userCreateReq.Email, PageSize: 1})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 200, httpRsp.StatusCode) && assert.Len(t, historyRsp.Attempts, 1) {
+			assert.Equal(t, "203.0.113.7", historyRsp.Attempts[0].IP, "the client ip is recorded instead of the proxy's")
+		}
+
+		httpRsp, _, _, err = client.ScimListUsers(ctx, httpClient, userSvcAddr, args.ScimBearerToken, types.ScimListRequest{Count: 1})
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, 200, httpRsp.StatusCode, "the identity provider is allowed from loopback")
+
+		httpRsp, scimRsp, _, err := client.ScimListUsers(ctx, forwardedClient, userSvcAddr, args.ScimBearerToken, types.ScimListRequest{Count: 1})
+		if err != nil {
+			return
+		}
+
+		if assert.Equal(t, 403, httpRsp.StatusCode, "the scim network policy applies to the identity provider") && assert.NotNil(t, scimRsp.Error) {
+			assert.Equal(t, types.ErrorNetworkNotAllowed, scimRsp.Error.Detail)
+		}
+
+		return
+	}()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,327 @@
+package communication
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/go-playground/validator/v10"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/business"
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func composeScimAuthMiddleware(bearerToken string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		l := ctxutil.GetContextLogger(r.Context())
+
+		if subtle.ConstantTimeCompare([]byte(extractAccessToken(r)), []byte(bearerToken)) != 1 {
+			l.Warn("failed to authenticate scim client: invalid bearer token")
+
+			writeScimResponse(l, w, http.StatusUnauthorized, newScimErrorResponse(http.StatusUnauthorized, "", types.ErrorUnauthorized))
+
+			return
+		}
+
+		l = l.With(
+			types.LogUser, types.ScimSubject,
+		)
+
+		r = r.WithContext(ctxutil.WithContextLogger(r.Context(), l))
+
+		r = r.WithContext(ctxutil.WithSubject(r.Context(), types.ScimSubject))
+
+		next(w, r)
+	}
+}
+
+// composeScimNetworkMiddleware applies the network policy of the scim role to the identity provider.
+func composeScimNetworkMiddleware(networkPolicies *business.NetworkPolicies, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := ctxutil.GetRemoteIp(r.Context())
+
+		err := networkPolicies.CheckNetwork(types.RoleScim, ip)
+		if err != nil {
+			l := ctxutil.GetContextLogger(r.Context()).With(
+				types.LogSecurityEvent, types.SecurityEventNetworkDenied,
+				types.LogRole, types.RoleScim,
+				types.LogRemoteIp, ip,
+			)
+
+			l.Warn(errors.Wrapf(err, "failed to authorize request to %s", r.URL.Path))
+
+			writeScimResponse(l, w, http.StatusForbidden, newScimErrorResponse(http.StatusForbidden, "", types.ErrorNetworkNotAllowed))
+
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func newScimErrorResponse(status int, scimType string, detail string) types.ScimError {
+	return types.ScimError{
+		Schemas:  []string{types.ScimSchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}
+}
+
+func writeScimResponse(logger *zap.SugaredLogger, w http.ResponseWriter, statusCode int, rsp interface{}) {
+	if statusCode == http.StatusNoContent {
+		w.WriteHeader(statusCode)
+
+		return
+	}
+
+	b, err := json.Marshal(rsp)
+	if err != nil {
+		logger.Error(err)
+
+		w.WriteHeader(statusCode)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", types.ContentTypeScimJson+"; charset=utf-8")
+	w.WriteHeader(statusCode)
+	_, err = w.Write(b)
+	if err != nil {
+		logger.Error(err)
+	}
+}
+
+func extractScimListRequest(r *http.Request) (req types.ScimListRequest) {
+	q := r.URL.Query()
+
+	req.Filter = q.Get(types.QueryFilter)
+	req.ExcludedAttributes = q.Get(types.QueryExcludedAttributes)
+
+	req.StartIndex = 1
+	i, err := strconv.Atoi(q.Get(types.QueryStartIndex))
+	if err == nil {
+		req.StartIndex = i
+	}
+
+	req.Count = types.ScimDefaultCount
+	i, err = strconv.Atoi(q.Get(types.QueryCount))
+	if err == nil {
+		req.Count = i
+	}
+
+	return
+}
+
+func extractScimId(r *http.Request, route string) string {
+	return strings.Trim(strings.TrimPrefix(r.URL.Path, route), "/")
+}
+
+func handleScimUsers(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB, allowedSubjectSuffix string, argon2IdOpts business.Argon2IdOpts, deletionGracePeriod time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp interface{}
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeScimResponse(logger, w, statusCode, rsp)
+		}()
+
+		decode := func(v interface{}) bool {
+			err := json.NewDecoder(r.Body).Decode(v)
+			if err != nil {
+				statusCode = http.StatusBadRequest
+				rsp = newScimErrorResponse(statusCode, types.ScimTypeInvalidSyntax, errors.Wrap(err, "failed to decode the request").Error())
+
+				return false
+			}
+
+			return true
+		}
+
+		id := extractScimId(r, types.RouteScimUsers)
+
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			var lrsp types.ScimListUsersResponse
+			lrsp, statusCode = business.ScimListUsers(r.Context(), metrics, db, extractScimListRequest(r))
+			rsp = scimResult(lrsp.List, lrsp.Error)
+		case id == "" && r.Method == http.MethodPost:
+			var req types.ScimUser
+			if !decode(&req) {
+				return
+			}
+
+			var ursp types.ScimUserResponse
+			ursp, statusCode = business.ScimCreateUser(r.Context(), metrics, db, argon2IdOpts, validator, allowedSubjectSuffix, req)
+			rsp = scimResult(ursp.User, ursp.Error)
+		case id != "" && r.Method == http.MethodGet:
+			var ursp types.ScimUserResponse
+			ursp, statusCode = business.ScimGetUser(r.Context(), metrics, db, validator, id)
+			rsp = scimResult(ursp.User, ursp.Error)
+		case id != "" && r.Method == http.MethodPut:
+			var req types.ScimUser
+			if !decode(&req) {
+				return
+			}
+
+			var ursp types.ScimUserResponse
+			ursp, statusCode = business.ScimReplaceUser(r.Context(), metrics, db, argon2IdOpts, validator, deletionGracePeriod, id, req)
+			rsp = scimResult(ursp.User, ursp.Error)
+		case id != "" && r.Method == http.MethodPatch:
+			var req types.ScimPatchRequest
+			if !decode(&req) {
+				return
+			}
+
+			var ursp types.ScimUserResponse
+			ursp, statusCode = business.ScimPatchUser(r.Context(), metrics, db, argon2IdOpts, validator, deletionGracePeriod, id, req)
+			rsp = scimResult(ursp.User, ursp.Error)
+		case id != "" && r.Method == http.MethodDelete:
+			var drsp types.ScimDeleteResponse
+			drsp, statusCode = business.ScimDeleteUser(r.Context(), metrics, db, validator, id)
+			rsp = scimResult(nil, drsp.Error)
+		default:
+			statusCode = http.StatusMethodNotAllowed
+			rsp = newScimErrorResponse(statusCode, "", "method not allowed")
+		}
+
+		return
+	}
+}
+
+func handleScimGroups(validator *validator.Validate, logger *zap.SugaredLogger, metrics metrics.MetricSink, db *sqlx.DB) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var rsp interface{}
+		var statusCode int
+
+		defer func() {
+			err := r.Body.Close()
+			if err != nil {
+				err = errors.Wrap(err, "failed to close request body")
+
+				logger.Error(err)
+			}
+
+			writeScimResponse(logger, w, statusCode, rsp)
+		}()
+
+		decode := func(v interface{}) bool {
+			err := json.NewDecoder(r.Body).Decode(v)
+			if err != nil {
+				statusCode = http.StatusBadRequest
+				rsp = newScimErrorResponse(statusCode, types.ScimTypeInvalidSyntax, errors.Wrap(err, "failed to decode the request").Error())
+
+				return false
+			}
+
+			return true
+		}
+
+		id := extractScimId(r, types.RouteScimGroups)
+
+		switch {
+		case id == "" && r.Method == http.MethodGet:
+			var lrsp types.ScimListGroupsResponse
+			lrsp, statusCode = business.ScimListGroups(r.Context(), metrics, db, extractScimListRequest(r))
+			rsp = scimResult(lrsp.List, lrsp.Error)
+		case id != "" && r.Method == http.MethodGet:
+			var grsp types.ScimGroupResponse
+			grsp, statusCode = business.ScimGetGroup(r.Context(), metrics, db, id, extractScimListRequest(r))
+			rsp = scimResult(grsp.Group, grsp.Error)
+		case id != "" && r.Method == http.MethodPut:
+			var req types.ScimGroup
+			if !decode(&req) {
+				return
+			}
+
+			var grsp types.ScimGroupResponse
+			grsp, statusCode = business.ScimReplaceGroup(r.Context(), metrics, db, validator, id, req)
+			rsp = scimResult(grsp.Group, grsp.Error)
+		case id != "" && r.Method == http.MethodPatch:
+			var req types.ScimPatchRequest
+			if !decode(&req) {
+				return
+			}
+
+			var grsp types.ScimGroupResponse
+			grsp, statusCode = business.ScimPatchGroup(r.Context(), metrics, db, validator, id, req)
+			rsp = scimResult(grsp.Group, grsp.Error)
+		case id == "" && r.Method == http.MethodPost, id != "" && r.Method == http.MethodDelete:
+			statusCode = http.StatusNotImplemented
+			rsp = newScimErrorResponse(statusCode, "", "groups are fixed to the user groups and can not be created or deleted")
+		default:
+			statusCode = http.StatusMethodNotAllowed
+			rsp = newScimErrorResponse(statusCode, "", "method not allowed")
+		}
+
+		return
+	}
+}
+
+func scimResult(resource interface{}, serr *types.ScimError) interface{} {
+	if serr != nil {
+		return serr
+	}
+
+	return resource
+}
+
+func handleScimServiceProviderConfig(logger *zap.SugaredLogger) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeScimResponse(logger, w, http.StatusMethodNotAllowed, newScimErrorResponse(http.StatusMethodNotAllowed, "", "method not allowed"))
+
+			return
+		}
+
+		writeScimResponse(logger, w, http.StatusOK, business.ScimServiceProviderConfig())
+	}
+}
+
+func handleScimDiscovery(logger *zap.SugaredLogger, route string, resources []map[string]interface{}) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeScimResponse(logger, w, http.StatusMethodNotAllowed, newScimErrorResponse(http.StatusMethodNotAllowed, "", "method not allowed"))
+
+			return
+		}
+
+		id := extractScimId(r, route)
+		if id == "" {
+			writeScimResponse(logger, w, http.StatusOK, types.ScimListResponse{
+				Schemas:      []string{types.ScimSchemaListResponse},
+				TotalResults: len(resources),
+				StartIndex:   1,
+				ItemsPerPage: len(resources),
+				Resources:    resources,
+			})
+
+			return
+		}
+
+		for _, res := range resources {
+			if res["id"] == id {
+				writeScimResponse(logger, w, http.StatusOK, res)
+
+				return
+			}
+		}
+
+		writeScimResponse(logger, w, http.StatusNotFound, newScimErrorResponse(http.StatusNotFound, "", "resource does not exist"))
+	}
+}
@@ -0,0 +1,24 @@
+package mailing
+
+import (
+	"context"
+
+	"go.uber.org/zap"
+)
+
+// LogMailer logs messages instead of sending them, it's meant for development.
+type LogMailer struct {
+	logger *zap.SugaredLogger
+}
+
+func NewLogMailer(logger *zap.SugaredLogger) *LogMailer {
+	return &LogMailer{
+		logger: logger,
+	}
+}
+
+func (mlr *LogMailer) Send(ctx context.Context, msg Message) (err error) {
+	mlr.logger.With("to", msg.To, "subject", msg.Subject).Info(msg.Body)
+
+	return
+}
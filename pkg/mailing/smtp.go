@@ -0,0 +1,61 @@
+package mailing
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type SmtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSmtpMailer authenticates with PLAIN if a username is given, the connection is upgraded with STARTTLS if the
+// server supports it.
+func NewSmtpMailer(addr string, from string, username string, password string) (mlr *SmtpMailer, err error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to split smtp address %v", addr)
+
+		return
+	}
+
+	mlr = &SmtpMailer{
+		addr: addr,
+		from: from,
+	}
+
+	if username != "" {
+		mlr.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return
+}
+
+func (mlr *SmtpMailer) Send(ctx context.Context, msg Message) (err error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", mlr.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&b, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprintf(&b, "\r\n%s", msg.Body)
+
+	err = smtp.SendMail(mlr.addr, mlr.auth, mlr.from, []string{msg.To}, b.Bytes())
+	if err != nil {
+		err = errors.Wrapf(err, "failed to send mail to %v", msg.To)
+
+		return
+	}
+
+	return
+}
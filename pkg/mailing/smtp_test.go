@@ -0,0 +1,95 @@
+// +build unit
+
+package mailing
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serveSmtp accepts a single session, and returns the data of the first message.
+func serveSmtp(l net.Listener, data chan<- string) {
+	conn, err := l.Accept()
+	if err != nil {
+		close(data)
+
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			close(data)
+
+			return
+		}
+
+		switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "MAIL", "RCPT":
+			reply("250 OK")
+		case "DATA":
+			reply("354 go ahead")
+
+			var b strings.Builder
+			for {
+				line, err = r.ReadString('\n')
+				if err != nil || line == ".\r\n" {
+					break
+				}
+				b.WriteString(line)
+			}
+			data <- b.String()
+
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestSmtpMailerSend(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	data := make(chan string, 1)
+	go serveSmtp(l, data)
+
+	mlr, err := NewSmtpMailer(l.Addr().String(), "noreply@example.com", "", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	err = mlr.Send(context.Background(), Message{To: "john@example.com", Subject: "Your login link", Body: "https://app.test/login?token=abc\r\n"})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	msg := <-data
+	assert.Contains(t, msg, "From: noreply@example.com\r\n")
+	assert.Contains(t, msg, "To: john@example.com\r\n")
+	assert.Contains(t, msg, "Subject: Your login link\r\n")
+	assert.Contains(t, msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(msg, "\r\n\r\nhttps://app.test/login?token=abc\r\n"))
+
+	_, err = NewSmtpMailer("localhost", "noreply@example.com", "", "")
+	assert.Error(t, err)
+}
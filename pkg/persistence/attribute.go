@@ -0,0 +1,182 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func UpsertAttributeSchema(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, s types.AttributeSchemaModel) (upserted types.AttributeSchemaModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"namespace", s.Namespace,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpsertAttributeSchema"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpsertAttributeSchema"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &upserted, "INSERT INTO attribute_schemas (namespace, schema) VALUES ($1, $2) ON CONFLICT (namespace) DO UPDATE SET schema=EXCLUDED.schema RETURNING namespace, schema, created_at, updated_at", s.Namespace, s.Schema)
+	if err != nil {
+		err = errors.Wrap(err, "failed to upsert attribute schema")
+
+		return
+	}
+
+	return
+}
+
+func GetAttributeSchemaForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, namespace string) (s types.AttributeSchemaModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"namespace", namespace,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetAttributeSchemaForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetAttributeSchemaForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &s, "SELECT namespace, schema, created_at, updated_at FROM attribute_schemas WHERE namespace=$1 FOR UPDATE", namespace)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select attribute schema")
+
+		return
+	}
+
+	return
+}
+
+func SelectAttributeSchemas(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (ss []types.AttributeSchemaModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_attribute_schemas_count", len(ss),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectAttributeSchemas"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectAttributeSchemas"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &ss, "SELECT namespace, schema, created_at, updated_at FROM attribute_schemas ORDER BY namespace")
+	if err != nil {
+		err = errors.Wrap(err, "failed to select attribute schemas")
+
+		return
+	}
+
+	return
+}
+
+// SelectAttributeSchemasByNamespacesForShare locks the schemas of the given namespaces,
+// so that they can't be changed or deleted while attributes are validated against them.
+func SelectAttributeSchemasByNamespacesForShare(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, namespaces []string) (ss []types.AttributeSchemaModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_attribute_schemas_count", len(ss),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectAttributeSchemasByNamespacesForShare"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectAttributeSchemasByNamespacesForShare"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &ss, "SELECT namespace, schema, created_at, updated_at FROM attribute_schemas WHERE namespace = ANY($1) ORDER BY namespace FOR SHARE", pq.Array(namespaces))
+	if err != nil {
+		err = errors.Wrap(err, "failed to select attribute schemas by namespaces")
+
+		return
+	}
+
+	return
+}
+
+func DeleteAttributeSchema(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, namespace string) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"namespace", namespace,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "DeleteAttributeSchema"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "DeleteAttributeSchema"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "DELETE FROM attribute_schemas WHERE namespace=$1", namespace)
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete attribute schema")
+
+		return
+	}
+
+	return
+}
+
+// CountUsersWithAttributeNamespace counts the users, including the soft deleted ones, that hold attributes of the namespace.
+func CountUsersWithAttributeNamespace(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, namespace string) (count int, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"namespace", namespace,
+			"count", count,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "CountUsersWithAttributeNamespace"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "CountUsersWithAttributeNamespace"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &count, "SELECT count(*) FROM users WHERE jsonb_exists(attributes, $1)", namespace)
+	if err != nil {
+		err = errors.Wrap(err, "failed to count users with attribute namespace")
+
+		return
+	}
+
+	return
+}
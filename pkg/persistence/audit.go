@@ -0,0 +1,174 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const auditEventsLockKey = 7305723045
+
+func GetLastAuditEventHashForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (hash string, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetLastAuditEventHashForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetLastAuditEventHashForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditEventsLockKey)
+	if err != nil {
+		err = errors.Wrap(err, "failed to lock audit events")
+
+		return
+	}
+
+	err = sqlx.GetContext(ctx, db, &hash, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1")
+	if err == sql.ErrNoRows {
+		err = nil
+
+		return
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to select last audit event hash")
+
+		return
+	}
+
+	return
+}
+
+func InsertAuditEvent(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, e types.AuditEventModel) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"action", e.Action,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertAuditEvent"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertAuditEvent"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "INSERT INTO audit_events (occurred_at, actor_id, action, target_id, request_id, ip, diff, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)", e.OccurredAt, e.ActorID, e.Action, e.TargetID, e.RequestID, e.IP, e.Diff, e.PrevHash, e.Hash)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert audit event")
+
+		return
+	}
+
+	return
+}
+
+func SelectAuditEvents(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.AuditEventsQuery) (es []types.AuditEventModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_audit_events_count", len(es),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectAuditEvents"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectAuditEvents"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.BeforeID != 0 {
+		conditions = append(conditions, "id < "+arg(q.BeforeID))
+	}
+	if q.ActorID != "" {
+		conditions = append(conditions, "actor_id = "+arg(q.ActorID))
+	}
+	if q.TargetID != "" {
+		conditions = append(conditions, "target_id = "+arg(q.TargetID))
+	}
+	if q.SubjectID != "" {
+		p := arg(q.SubjectID)
+		conditions = append(conditions, "(actor_id = "+p+" OR target_id = "+p+")")
+	}
+	if q.Action != "" {
+		conditions = append(conditions, "action = "+arg(q.Action))
+	}
+	if q.OccurredAfter != nil {
+		conditions = append(conditions, "occurred_at >= "+arg(*q.OccurredAfter))
+	}
+	if q.OccurredBefore != nil {
+		conditions = append(conditions, "occurred_at < "+arg(*q.OccurredBefore))
+	}
+
+	query := "SELECT id, occurred_at, actor_id, action, target_id, request_id, ip, diff, prev_hash, hash FROM audit_events WHERE " + strings.Join(conditions, " AND ") + " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	err = sqlx.SelectContext(ctx, db, &es, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select audit events")
+
+		return
+	}
+
+	return
+}
+
+func SelectAuditEventsAfterId(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, afterID int64, limit int) (es []types.AuditEventModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_audit_events_count", len(es),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectAuditEventsAfterId"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectAuditEventsAfterId"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &es, "SELECT id, occurred_at, actor_id, action, target_id, request_id, ip, diff, prev_hash, hash FROM audit_events WHERE id > $1 ORDER BY id ASC LIMIT $2", afterID, limit)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select audit events after id")
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,188 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func InsertDataExport(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, e types.DataExportModel) (inserted types.DataExportModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertDataExport"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertDataExport"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO data_exports (user_id, requested_by, status, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, user_id, requested_by, status, token_hash, created_at, expires_at", e.UserID, e.RequestedBy, types.DataExportStatusPending, e.TokenHash, e.ExpiresAt)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert data export")
+
+		return
+	}
+
+	return
+}
+
+func ClaimPendingDataExport(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, claimTimeout time.Duration) (e types.DataExportModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_data_export_id", e.ID,
+		)
+
+		if err != nil {
+			l.Debug(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "ClaimPendingDataExport"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "ClaimPendingDataExport"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &e, `UPDATE data_exports SET status=$1, claimed_at=NOW() WHERE id = (
+		SELECT id FROM data_exports
+		WHERE expires_at > NOW() AND (status=$2 OR (status=$1 AND claimed_at < NOW() - make_interval(secs => $3)))
+		ORDER BY created_at
+		FOR UPDATE SKIP LOCKED
+		LIMIT 1
+	) RETURNING id, user_id, requested_by, status, token_hash, created_at, claimed_at, expires_at`, types.DataExportStatusProcessing, types.DataExportStatusPending, claimTimeout.Seconds())
+	if err != nil {
+		err = errors.Wrap(err, "failed to claim pending data export")
+
+		return
+	}
+
+	return
+}
+
+func CompleteDataExport(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, archive []byte) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"archive_size", len(archive),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "CompleteDataExport"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "CompleteDataExport"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE data_exports SET status=$1, archive=$2, completed_at=NOW() WHERE id=$3", types.DataExportStatusCompleted, archive, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to complete data export")
+
+		return
+	}
+
+	return
+}
+
+func FailDataExport(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "FailDataExport"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "FailDataExport"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE data_exports SET status=$1, completed_at=NOW() WHERE id=$2", types.DataExportStatusFailed, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to mark data export as failed")
+
+		return
+	}
+
+	return
+}
+
+func GetDataExportByTokenHash(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, tokenHash string) (e types.DataExportModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_data_export_id", e.ID,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetDataExportByTokenHash"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetDataExportByTokenHash"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &e, "SELECT id, user_id, requested_by, status, token_hash, archive, created_at, claimed_at, completed_at, expires_at FROM data_exports WHERE token_hash=$1 AND expires_at > NOW()", tokenHash)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select data export by token hash")
+
+		return
+	}
+
+	return
+}
+
+func DeleteExpiredDataExports(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (n int64, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"deleted_data_exports_count", n,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "DeleteExpiredDataExports"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "DeleteExpiredDataExports"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	res, err := db.ExecContext(ctx, "DELETE FROM data_exports WHERE expires_at <= NOW()")
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete expired data exports")
+
+		return
+	}
+
+	n, err = res.RowsAffected()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get number of deleted data exports")
+
+		return
+	}
+
+	return
+}
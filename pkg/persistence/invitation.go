@@ -0,0 +1,233 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func InsertInvitation(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, i types.InvitationModel) (inserted types.InvitationModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertInvitation"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertInvitation"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO invitations (email, user_group, invited_by, token_hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, email, user_group, invited_by, token_hash, created_at, expires_at, accepted_at, accepted_user_id, revoked_at, revoked_by", i.Email, i.UserGroup, i.InvitedBy, i.TokenHash, i.ExpiresAt)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert invitation")
+
+		return
+	}
+
+	return
+}
+
+func GetInvitationByIdForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (i types.InvitationModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"invitation_id", id,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetInvitationByIdForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetInvitationByIdForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &i, "SELECT id, email, user_group, invited_by, token_hash, created_at, expires_at, accepted_at, accepted_user_id, revoked_at, revoked_by FROM invitations WHERE id=$1 FOR UPDATE", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select invitation by id")
+
+		return
+	}
+
+	return
+}
+
+func GetInvitationByTokenHashForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, tokenHash string) (i types.InvitationModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetInvitationByTokenHashForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetInvitationByTokenHashForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &i, "SELECT id, email, user_group, invited_by, token_hash, created_at, expires_at, accepted_at, accepted_user_id, revoked_at, revoked_by FROM invitations WHERE token_hash=$1 FOR UPDATE", tokenHash)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select invitation by token hash")
+
+		return
+	}
+
+	return
+}
+
+func SelectOpenInvitationsByEmailForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, email string) (is []types.InvitationModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_invitations_count", len(is),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectOpenInvitationsByEmailForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectOpenInvitationsByEmailForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &is, "SELECT id, email, user_group, invited_by, token_hash, created_at, expires_at, accepted_at, accepted_user_id, revoked_at, revoked_by FROM invitations WHERE LOWER(email)=LOWER($1) AND accepted_at IS NULL AND revoked_at IS NULL FOR UPDATE", email)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select open invitations by email")
+
+		return
+	}
+
+	return
+}
+
+func RevokeInvitationById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, revokedBy string) (revoked types.InvitationModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"invitation_id", id,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "RevokeInvitationById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "RevokeInvitationById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &revoked, "UPDATE invitations SET revoked_at=NOW(), revoked_by=$2 WHERE id=$1 RETURNING id, email, user_group, invited_by, token_hash, created_at, expires_at, accepted_at, accepted_user_id, revoked_at, revoked_by", id, revokedBy)
+	if err != nil {
+		err = errors.Wrap(err, "failed to revoke invitation")
+
+		return
+	}
+
+	return
+}
+
+func AcceptInvitationById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, userID string) (accepted types.InvitationModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"invitation_id", id,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "AcceptInvitationById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "AcceptInvitationById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &accepted, "UPDATE invitations SET accepted_at=NOW(), accepted_user_id=$2 WHERE id=$1 RETURNING id, email, user_group, invited_by, token_hash, created_at, expires_at, accepted_at, accepted_user_id, revoked_at, revoked_by", id, userID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to accept invitation")
+
+		return
+	}
+
+	return
+}
+
+func SelectInvitations(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.InvitationsQuery) (is []types.InvitationModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_invitations_count", len(is),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectInvitations"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectInvitations"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.BeforeCreatedAt != nil {
+		conditions = append(conditions, "(created_at, id) < ("+arg(*q.BeforeCreatedAt)+", "+arg(q.BeforeID)+"::uuid)")
+	}
+	if q.Email != "" {
+		conditions = append(conditions, "LOWER(email) = LOWER("+arg(q.Email)+")")
+	}
+	switch q.Status {
+	case types.InvitationStatusPending:
+		conditions = append(conditions, "accepted_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()")
+	case types.InvitationStatusAccepted:
+		conditions = append(conditions, "accepted_at IS NOT NULL")
+	case types.InvitationStatusRevoked:
+		conditions = append(conditions, "revoked_at IS NOT NULL")
+	case types.InvitationStatusExpired:
+		conditions = append(conditions, "accepted_at IS NULL AND revoked_at IS NULL AND expires_at <= NOW()")
+	}
+
+	query := "SELECT id, email, user_group, invited_by, token_hash, created_at, expires_at, accepted_at, accepted_user_id, revoked_at, revoked_by FROM invitations WHERE " + strings.Join(conditions, " AND ") + " ORDER BY created_at DESC, id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	err = sqlx.SelectContext(ctx, db, &is, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select invitations")
+
+		return
+	}
+
+	return
+}
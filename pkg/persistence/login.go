@@ -0,0 +1,152 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func InsertLoginAttempt(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, a types.LoginAttemptModel) (inserted types.LoginAttemptModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"outcome", a.Outcome,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertLoginAttempt"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertLoginAttempt"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO login_attempts (user_id, email, method, ip, user_agent, outcome, failure_reason) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id, occurred_at, user_id, email, method, ip, user_agent, outcome, failure_reason", a.UserID, a.Email, a.Method, a.IP, a.UserAgent, a.Outcome, a.FailureReason)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert login attempt")
+
+		return
+	}
+
+	return
+}
+
+func SelectLoginAttempts(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.LoginAttemptsQuery) (as []types.LoginAttemptModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_login_attempts_count", len(as),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectLoginAttempts"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectLoginAttempts"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.BeforeID != 0 {
+		conditions = append(conditions, "id < "+arg(q.BeforeID))
+	}
+	if q.UserID != "" {
+		conditions = append(conditions, "user_id = "+arg(q.UserID))
+	}
+	if q.Email != "" {
+		conditions = append(conditions, "email = "+arg(q.Email))
+	}
+	if q.Outcome != "" {
+		conditions = append(conditions, "outcome = "+arg(q.Outcome))
+	}
+
+	query := "SELECT id, occurred_at, user_id, email, method, ip, user_agent, outcome, failure_reason FROM login_attempts WHERE " + strings.Join(conditions, " AND ") + " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	err = sqlx.SelectContext(ctx, db, &as, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select login attempts")
+
+		return
+	}
+
+	return
+}
+
+// GetLoginDevice reports whether the user signed in successfully before, and whether from the ip with the user agent.
+func GetLoginDevice(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, userID string, ip string, userAgent string) (d types.LoginDeviceModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetLoginDevice"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetLoginDevice"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &d, "SELECT EXISTS (SELECT 1 FROM login_attempts WHERE user_id=$1 AND outcome='success') AS has_logins, EXISTS (SELECT 1 FROM login_attempts WHERE user_id=$1 AND outcome='success' AND ip=$2 AND user_agent=$3) AS is_known", userID, ip, userAgent)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select login device")
+
+		return
+	}
+
+	return
+}
+
+// SelectLastLoginsByUserIds returns the latest successful login of each of the users that signed in before.
+func SelectLastLoginsByUserIds(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, userIDs []string) (ls []types.LastLoginModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_last_logins_count", len(ls),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectLastLoginsByUserIds"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectLastLoginsByUserIds"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &ls, "SELECT DISTINCT ON (user_id) user_id, occurred_at, ip FROM login_attempts WHERE user_id = ANY($1) AND outcome='success' ORDER BY user_id, id DESC", pq.Array(userIDs))
+	if err != nil {
+		err = errors.Wrap(err, "failed to select last logins by user ids")
+
+		return
+	}
+
+	return
+}
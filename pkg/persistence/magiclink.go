@@ -0,0 +1,181 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+// LockMagicLinkEmail serializes requests for the same email until the transaction ends.
+func LockMagicLinkEmail(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, email string) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "LockMagicLinkEmail"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "LockMagicLinkEmail"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext('magic_links:' || $1))", email)
+	if err != nil {
+		err = errors.Wrap(err, "failed to lock magic link email")
+
+		return
+	}
+
+	return
+}
+
+func CountMagicLinksSince(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, email string, since time.Time) (n int, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "CountMagicLinksSince"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "CountMagicLinksSince"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &n, "SELECT COUNT(*) FROM magic_links WHERE email=$1 AND created_at > $2", email, since)
+	if err != nil {
+		err = errors.Wrap(err, "failed to count magic links")
+
+		return
+	}
+
+	return
+}
+
+func InsertMagicLink(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, ml types.MagicLinkModel) (inserted types.MagicLinkModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertMagicLink"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertMagicLink"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO magic_links (token_hash, email, user_id, nonce_hash, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING token_hash, email, user_id, nonce_hash, created_at, expires_at, consumed_at", ml.TokenHash, ml.Email, ml.UserID, ml.NonceHash, ml.ExpiresAt)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert magic link")
+
+		return
+	}
+
+	return
+}
+
+func GetMagicLinkForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, tokenHash string) (ml types.MagicLinkModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetMagicLinkForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetMagicLinkForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &ml, "SELECT token_hash, email, user_id, nonce_hash, created_at, expires_at, consumed_at FROM magic_links WHERE token_hash=$1 FOR UPDATE", tokenHash)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select magic link by token hash")
+
+		return
+	}
+
+	return
+}
+
+func ConsumeMagicLinksOfUser(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, userID string) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"user_id", userID,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "ConsumeMagicLinksOfUser"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "ConsumeMagicLinksOfUser"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE magic_links SET consumed_at=NOW() WHERE user_id=$1 AND consumed_at IS NULL", userID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to consume magic links")
+
+		return
+	}
+
+	return
+}
+
+func DeleteStaleMagicLinks(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, retention time.Duration) (n int64, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"deleted_magic_links_count", n,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "DeleteStaleMagicLinks"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "DeleteStaleMagicLinks"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	res, err := db.ExecContext(ctx, "DELETE FROM magic_links WHERE expires_at <= NOW() AND created_at <= NOW() - make_interval(secs => $1)", retention.Seconds())
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete stale magic links")
+
+		return
+	}
+
+	n, err = res.RowsAffected()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get number of deleted magic links")
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS users_email_domain_idx;
+
+DROP INDEX IF EXISTS users_user_group_idx;
+
+DROP INDEX IF EXISTS users_fullname_id_idx;
+
+DROP INDEX IF EXISTS users_email_id_idx;
+
+DROP INDEX IF EXISTS users_created_at_id_idx;
\ No newline at end of file
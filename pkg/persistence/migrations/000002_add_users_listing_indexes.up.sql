@@ -0,0 +1,9 @@
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+
+CREATE INDEX IF NOT EXISTS users_email_id_idx ON users (email, id);
+
+CREATE INDEX IF NOT EXISTS users_fullname_id_idx ON users (fullname, id);
+
+CREATE INDEX IF NOT EXISTS users_user_group_idx ON users (user_group);
+
+CREATE INDEX IF NOT EXISTS users_email_domain_idx ON users (lower(split_part(email, '@', 2)));
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_fullname_trgm_idx;
+
+DROP INDEX IF EXISTS users_email_trgm_idx;
\ No newline at end of file
@@ -0,0 +1,5 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS users_email_trgm_idx ON users USING gin (email gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS users_fullname_trgm_idx ON users USING gin (fullname gin_trgm_ops);
\ No newline at end of file
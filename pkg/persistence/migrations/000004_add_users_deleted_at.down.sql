@@ -0,0 +1,9 @@
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+
+DROP INDEX IF EXISTS users_email_not_deleted_idx;
+
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
\ No newline at end of file
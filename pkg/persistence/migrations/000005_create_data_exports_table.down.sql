@@ -0,0 +1 @@
+DROP TABLE IF EXISTS data_exports CASCADE;
\ No newline at end of file
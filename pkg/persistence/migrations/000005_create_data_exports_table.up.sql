@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS data_exports (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    requested_by UUID NOT NULL,
+    status TEXT NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    archive JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    claimed_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS data_exports_status_created_at_idx ON data_exports (status, created_at);
+
+CREATE INDEX IF NOT EXISTS data_exports_expires_at_idx ON data_exports (expires_at);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS audit_events CASCADE;
+
+DROP FUNCTION IF EXISTS prevent_audit_events_modification();
\ No newline at end of file
@@ -0,0 +1,37 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    actor_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    request_id TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    diff JSON,
+    prev_hash TEXT NOT NULL,
+    hash TEXT UNIQUE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_actor_id_id_idx ON audit_events (actor_id, id);
+
+CREATE INDEX IF NOT EXISTS audit_events_target_id_id_idx ON audit_events (target_id, id);
+
+CREATE INDEX IF NOT EXISTS audit_events_action_id_idx ON audit_events (action, id);
+
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at_idx ON audit_events (occurred_at);
+
+CREATE OR REPLACE FUNCTION prevent_audit_events_modification()
+    RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER prevent_audit_events_update_delete
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW
+EXECUTE PROCEDURE prevent_audit_events_modification();
+
+CREATE TRIGGER prevent_audit_events_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT
+EXECUTE PROCEDURE prevent_audit_events_modification();
\ No newline at end of file
@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS outbox_events (
+    id BIGSERIAL PRIMARY KEY,
+    event_type TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    payload JSON NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    dispatched_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS outbox_events_undispatched_id_idx ON outbox_events (id) WHERE dispatched_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_endpoints (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id UUID NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL REFERENCES outbox_events (id) ON DELETE CASCADE,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    UNIQUE (webhook_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt_at_idx ON webhook_deliveries (status, next_attempt_at);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_status_id_idx ON webhook_deliveries (webhook_id, status, id);
\ No newline at end of file
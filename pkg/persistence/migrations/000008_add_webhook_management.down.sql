@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+
+DROP TRIGGER IF EXISTS set_updated_at_webhook_endpoints ON webhook_endpoints;
+
+ALTER TABLE webhook_endpoints DROP COLUMN IF EXISTS updated_at;
+
+ALTER TABLE webhook_endpoints DROP COLUMN IF EXISTS paused_at;
+
+ALTER TABLE webhook_endpoints DROP COLUMN IF EXISTS event_types;
\ No newline at end of file
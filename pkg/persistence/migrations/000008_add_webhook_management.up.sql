@@ -0,0 +1,24 @@
+ALTER TABLE webhook_endpoints ADD COLUMN IF NOT EXISTS event_types TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE webhook_endpoints ADD COLUMN IF NOT EXISTS paused_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE webhook_endpoints ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE TRIGGER set_updated_at_webhook_endpoints
+    BEFORE UPDATE ON webhook_endpoints
+    FOR EACH ROW
+EXECUTE PROCEDURE set_updated_at();
+
+CREATE TABLE IF NOT EXISTS webhook_delivery_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id UUID NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+    delivery_id BIGINT REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    event_id BIGINT,
+    event_type TEXT NOT NULL,
+    attempted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    status_code INTEGER NOT NULL,
+    latency_ms INTEGER NOT NULL,
+    error TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_delivery_attempts_webhook_id_id_idx ON webhook_delivery_attempts (webhook_id, id);
\ No newline at end of file
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS outbox_events_unpublished_id_idx;
+
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS published_at;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS published_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS outbox_events_unpublished_id_idx ON outbox_events (id) WHERE published_at IS NULL;
\ No newline at end of file
@@ -0,0 +1,12 @@
+CREATE OR REPLACE FUNCTION notify_outbox_events()
+    RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('outbox_events', NEW.id::TEXT);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER notify_outbox_events_insert
+    AFTER INSERT ON outbox_events
+    FOR EACH ROW
+EXECUTE PROCEDURE notify_outbox_events();
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS magic_links (
+    token_hash TEXT PRIMARY KEY,
+    email TEXT NOT NULL,
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE,
+    nonce_hash TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    consumed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS magic_links_email_created_at_idx ON magic_links (email, created_at);
+
+CREATE INDEX IF NOT EXISTS magic_links_user_id_idx ON magic_links (user_id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS invitations (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    email TEXT NOT NULL,
+    user_group TEXT NOT NULL,
+    invited_by UUID NOT NULL,
+    token_hash TEXT UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    accepted_at TIMESTAMP WITH TIME ZONE,
+    accepted_user_id UUID REFERENCES users (id) ON DELETE SET NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    revoked_by UUID
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS invitations_open_email_idx ON invitations (LOWER(email)) WHERE accepted_at IS NULL AND revoked_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS invitations_created_at_id_idx ON invitations (created_at, id);
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS attribute_schemas;
+
+DROP INDEX IF EXISTS users_attributes_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS attributes;
@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS users_attributes_idx ON users USING GIN (attributes jsonb_path_ops);
+
+CREATE TABLE IF NOT EXISTS attribute_schemas (
+    namespace TEXT PRIMARY KEY,
+    schema JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TRIGGER set_updated_at_attribute_schemas
+    BEFORE UPDATE ON attribute_schemas
+    FOR EACH ROW
+EXECUTE PROCEDURE set_updated_at();
@@ -0,0 +1,9 @@
+DROP INDEX IF EXISTS users_status_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS failed_authentications;
+
+ALTER TABLE users DROP COLUMN IF EXISTS status_changed_at;
+
+ALTER TABLE users DROP COLUMN IF EXISTS status_reason;
+
+ALTER TABLE users DROP COLUMN IF EXISTS status;
@@ -0,0 +1,11 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('pending', 'active', 'suspended', 'locked', 'deactivated'));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_changed_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_authentications INTEGER NOT NULL DEFAULT 0;
+
+UPDATE users SET status='deactivated', status_changed_at=deleted_at WHERE deleted_at IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS users_status_idx ON users (status) WHERE status <> 'active';
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS bump_token_epoch_users ON users;
+
+DROP FUNCTION IF EXISTS bump_token_epoch();
+
+ALTER TABLE users DROP COLUMN IF EXISTS token_epoch;
@@ -0,0 +1,19 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS token_epoch INTEGER NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION bump_token_epoch()
+    RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.password IS DISTINCT FROM OLD.password
+        OR NEW.user_group IS DISTINCT FROM OLD.user_group
+        OR (NEW.status IS DISTINCT FROM OLD.status AND NEW.status <> 'active')
+        OR (NEW.deleted_at IS NOT NULL AND OLD.deleted_at IS NULL) THEN
+        NEW.token_epoch = OLD.token_epoch + 1;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER bump_token_epoch_users
+    BEFORE UPDATE ON users
+    FOR EACH ROW
+EXECUTE PROCEDURE bump_token_epoch();
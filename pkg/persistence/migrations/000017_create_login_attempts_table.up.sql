@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    occurred_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    user_id UUID REFERENCES users (id) ON DELETE CASCADE,
+    email TEXT NOT NULL,
+    method TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    outcome TEXT NOT NULL CHECK (outcome IN ('success', 'failure')),
+    failure_reason TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_user_id_id_idx ON login_attempts (user_id, id);
+
+CREATE INDEX IF NOT EXISTS login_attempts_email_id_idx ON login_attempts (email, id);
+
+CREATE INDEX IF NOT EXISTS login_attempts_user_id_device_idx ON login_attempts (user_id, ip, user_agent) WHERE outcome = 'success';
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_status_reason;
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_status;
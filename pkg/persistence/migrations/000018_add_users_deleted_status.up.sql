@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_status TEXT CHECK (deleted_status IN ('pending', 'active', 'suspended', 'locked'));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_status_reason TEXT NOT NULL DEFAULT '';
@@ -0,0 +1 @@
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS publish_claimed_until TIMESTAMP WITH TIME ZONE;
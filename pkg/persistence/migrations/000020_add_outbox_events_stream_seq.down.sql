@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS outbox_events_unsequenced_id_idx;
+
+DROP INDEX IF EXISTS outbox_events_stream_seq_idx;
+
+ALTER TABLE outbox_events DROP COLUMN IF EXISTS stream_seq;
+
+DROP SEQUENCE IF EXISTS outbox_events_stream_seq;
@@ -0,0 +1,11 @@
+CREATE SEQUENCE IF NOT EXISTS outbox_events_stream_seq;
+
+ALTER TABLE outbox_events ADD COLUMN IF NOT EXISTS stream_seq BIGINT;
+
+UPDATE outbox_events o SET stream_seq = s.seq FROM (
+    SELECT id, nextval('outbox_events_stream_seq') AS seq FROM (SELECT id FROM outbox_events ORDER BY id) ordered
+) s WHERE o.id = s.id;
+
+CREATE UNIQUE INDEX IF NOT EXISTS outbox_events_stream_seq_idx ON outbox_events (stream_seq);
+
+CREATE INDEX IF NOT EXISTS outbox_events_unsequenced_id_idx ON outbox_events (id) WHERE stream_seq IS NULL;
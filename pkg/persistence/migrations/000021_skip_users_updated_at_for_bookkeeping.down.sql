@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS set_updated_at_users ON users;
+
+CREATE TRIGGER set_updated_at_users
+    BEFORE UPDATE ON users
+    FOR EACH ROW
+EXECUTE PROCEDURE set_updated_at();
@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS set_updated_at_users ON users;
+
+-- updated_at is the version of a user, updates of the failed authentications counter, and of the status
+-- don't change the version, so that they don't fail concurrent updates of the user with a version mismatch
+CREATE TRIGGER set_updated_at_users
+    BEFORE UPDATE ON users
+    FOR EACH ROW
+    WHEN ((to_jsonb(OLD) - ARRAY['updated_at', 'failed_authentications', 'status', 'status_reason', 'status_changed_at', 'token_epoch'])
+        IS DISTINCT FROM (to_jsonb(NEW) - ARRAY['updated_at', 'failed_authentications', 'status', 'status_reason', 'status_changed_at', 'token_epoch']))
+EXECUTE PROCEDURE set_updated_at();
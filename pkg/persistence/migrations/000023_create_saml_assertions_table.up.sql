@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS saml_assertions (
+    provider TEXT NOT NULL,
+    id TEXT NOT NULL,
+    consumed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (provider, id)
+);
+
+CREATE INDEX IF NOT EXISTS saml_assertions_expires_at_idx ON saml_assertions (expires_at);
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_deleted_status_check;
+
+UPDATE users SET deleted_status='active' WHERE deleted_status='deactivated';
+
+ALTER TABLE users ADD CONSTRAINT users_deleted_status_check CHECK (deleted_status IN ('pending', 'active', 'suspended', 'locked'));
@@ -0,0 +1,4 @@
+-- users deactivated through SCIM aren't deleted, and get their status back when they are deleted, and restored
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_deleted_status_check;
+
+ALTER TABLE users ADD CONSTRAINT users_deleted_status_check CHECK (deleted_status IN ('pending', 'active', 'suspended', 'locked', 'deactivated'));
@@ -0,0 +1,347 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+const (
+	outboxPublishingLockKey = 6881503127
+	outboxSequencingLockKey = 6881503128
+	outboxEventsChannel     = "outbox_events"
+)
+
+func InsertOutboxEvent(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, e types.OutboxEventModel) (inserted types.OutboxEventModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"event_type", e.EventType,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertOutboxEvent"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertOutboxEvent"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO outbox_events (event_type, aggregate_id, payload) VALUES ($1, $2, $3) RETURNING id, event_type, aggregate_id, payload, created_at, dispatched_at, published_at", e.EventType, e.AggregateID, e.Payload)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert outbox event")
+
+		return
+	}
+
+	return
+}
+
+func DispatchOutboxEvents(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, limit int) (n int64, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"dispatched_outbox_events_count", n,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "DispatchOutboxEvents"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "DispatchOutboxEvents"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	r, err := db.ExecContext(ctx, `WITH events AS (
+		SELECT id, event_type FROM outbox_events
+		WHERE dispatched_at IS NULL
+		ORDER BY id
+		FOR UPDATE SKIP LOCKED
+		LIMIT $1
+	), deliveries AS (
+		INSERT INTO webhook_deliveries (webhook_id, event_id, status)
+		SELECT w.id, e.id, $2 FROM events e JOIN webhook_endpoints w ON cardinality(w.event_types) = 0 OR e.event_type = ANY(w.event_types)
+		ON CONFLICT DO NOTHING
+	)
+	UPDATE outbox_events SET dispatched_at=NOW() WHERE id IN (SELECT id FROM events)`, limit, types.WebhookDeliveryStatusPending)
+	if err != nil {
+		err = errors.Wrap(err, "failed to dispatch outbox events")
+
+		return
+	}
+
+	n, err = r.RowsAffected()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get number of dispatched outbox events")
+
+		return
+	}
+
+	return
+}
+
+func TryLockOutboxPublishing(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (locked bool, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "TryLockOutboxPublishing"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "TryLockOutboxPublishing"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &locked, "SELECT pg_try_advisory_xact_lock($1)", outboxPublishingLockKey)
+	if err != nil {
+		err = errors.Wrap(err, "failed to lock outbox publishing")
+
+		return
+	}
+
+	return
+}
+
+// ClaimUnpublishedOutboxEvents claims the oldest unpublished events, unless another publisher holds an unexpired claim,
+// so that events are published by one publisher at a time, in the order they were written.
+func ClaimUnpublishedOutboxEvents(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, limit int, claimTimeout time.Duration) (es []types.OutboxEventModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_outbox_events_count", len(es),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "ClaimUnpublishedOutboxEvents"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "ClaimUnpublishedOutboxEvents"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &es, `WITH claimed AS (
+		UPDATE outbox_events SET publish_claimed_until=NOW() + make_interval(secs => $1) WHERE id IN (
+			SELECT id FROM outbox_events WHERE published_at IS NULL ORDER BY id LIMIT $2
+		) AND NOT EXISTS (
+			SELECT 1 FROM outbox_events WHERE published_at IS NULL AND publish_claimed_until > NOW()
+		) RETURNING id, event_type, aggregate_id, payload, created_at, dispatched_at, published_at
+	)
+	SELECT * FROM claimed ORDER BY id`, claimTimeout.Seconds(), limit)
+	if err != nil {
+		err = errors.Wrap(err, "failed to claim unpublished outbox events")
+
+		return
+	}
+
+	return
+}
+
+func ReleaseOutboxEventsPublishClaim(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, ids []int64) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"outbox_events_count", len(ids),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "ReleaseOutboxEventsPublishClaim"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "ReleaseOutboxEventsPublishClaim"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE outbox_events SET publish_claimed_until=NULL WHERE id = ANY($1) AND published_at IS NULL", pq.Array(ids))
+	if err != nil {
+		err = errors.Wrap(err, "failed to release outbox events publish claim")
+
+		return
+	}
+
+	return
+}
+
+func UpdateOutboxEventsPublished(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, ids []int64) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"outbox_events_count", len(ids),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateOutboxEventsPublished"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateOutboxEventsPublished"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE outbox_events SET published_at=NOW(), publish_claimed_until=NULL WHERE id = ANY($1)", pq.Array(ids))
+	if err != nil {
+		err = errors.Wrap(err, "failed to update outbox events published_at")
+
+		return
+	}
+
+	return
+}
+
+// LockOutboxSequencing serializes sequencing until the transaction ends, so that stream sequences become visible in the order they were assigned.
+func LockOutboxSequencing(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "LockOutboxSequencing"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "LockOutboxSequencing"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", outboxSequencingLockKey)
+	if err != nil {
+		err = errors.Wrap(err, "failed to lock outbox sequencing")
+
+		return
+	}
+
+	return
+}
+
+// SequenceOutboxEvents assigns the next stream sequences to committed events that have none yet, in the order of their ids.
+func SequenceOutboxEvents(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (n int64, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"sequenced_outbox_events_count", n,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SequenceOutboxEvents"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SequenceOutboxEvents"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	r, err := db.ExecContext(ctx, `UPDATE outbox_events o SET stream_seq = s.seq FROM (
+		SELECT id, nextval('outbox_events_stream_seq') AS seq FROM (
+			SELECT id FROM outbox_events WHERE stream_seq IS NULL ORDER BY id FOR UPDATE
+		) ordered
+	) s WHERE o.id = s.id`)
+	if err != nil {
+		err = errors.Wrap(err, "failed to sequence outbox events")
+
+		return
+	}
+
+	n, err = r.RowsAffected()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get number of sequenced outbox events")
+
+		return
+	}
+
+	return
+}
+
+func GetLastOutboxEventStreamSeq(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (seq int64, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetLastOutboxEventStreamSeq"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetLastOutboxEventStreamSeq"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &seq, "SELECT COALESCE(MAX(stream_seq), 0) FROM outbox_events")
+	if err != nil {
+		err = errors.Wrap(err, "failed to get last outbox event stream sequence")
+
+		return
+	}
+
+	return
+}
+
+func SelectOutboxEventsAfterStreamSeq(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, afterSeq int64, limit int) (es []types.OutboxEventModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_outbox_events_count", len(es),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectOutboxEventsAfterStreamSeq"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectOutboxEventsAfterStreamSeq"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &es, "SELECT id, event_type, aggregate_id, payload, created_at, dispatched_at, published_at, stream_seq FROM outbox_events WHERE stream_seq > $1 ORDER BY stream_seq LIMIT $2", afterSeq, limit)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select outbox events after stream sequence")
+
+		return
+	}
+
+	return
+}
+
+func NewOutboxEventsListener(logger *zap.SugaredLogger, pgUrl string) (l *pq.Listener, err error) {
+	l = pq.NewListener(pgUrl, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logger.Warn(errors.Wrap(err, "outbox events listener failed"))
+		}
+	})
+
+	err = l.Listen(outboxEventsChannel)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to listen on %v", outboxEventsChannel)
+
+		return
+	}
+
+	return
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/armon/go-metrics"
@@ -11,7 +12,7 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
@@ -52,6 +53,34 @@ func ConnectToPostgresDb(ctx context.Context, db *sqlx.DB, timeout time.Duration
 	return
 }
 
+func WithTransaction(ctx context.Context, db *sqlx.DB, f func(tx *sqlx.Tx) error) (err error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		err = errors.Wrap(err, "failed to begin transaction")
+
+		return
+	}
+
+	err = f(tx)
+	if err != nil {
+		rollbackErr := tx.Rollback()
+		if rollbackErr != nil {
+			err = errors.Wrapf(err, "failed to rollback transaction: %v", rollbackErr)
+		}
+
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = errors.Wrap(err, "failed to commit transaction")
+
+		return
+	}
+
+	return
+}
+
 func Migrate(logger *zap.SugaredLogger, sourceUrl string, pgUrl string) (err error) {
 	defer func(begin time.Time) {
 		l := logger.With(
@@ -81,7 +110,7 @@ func Migrate(logger *zap.SugaredLogger, sourceUrl string, pgUrl string) (err err
 	return
 }
 
-func InsertUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, u types.UserModel) (err error) {
+func InsertUser(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, u types.UserModel) (inserted types.UserModel, err error) {
 	defer func(begin time.Time) {
 		l := ctxutil.GetContextLogger(ctx).With(
 			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
@@ -98,7 +127,62 @@ func InsertUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, u types.
 		m.AddSampleWithLabels([]string{"persistence", "InsertUser"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
 	}(time.Now())
 
-	_, err = db.NamedExecContext(ctx, "INSERT INTO users (email, password, fullname, user_group) VALUES (:email, :password, :fullname, :user_group)", &u)
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO users (email, password, fullname, user_group, external_id) VALUES ($1, $2, $3, $4, $5) RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at", u.Email, u.Password, u.FullName, u.UserGroup, u.ExternalID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert user")
+
+		return
+	}
+
+	return
+}
+
+func SelectExistingUserEmails(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, emails []string) (existing []string, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"emails_count", len(emails),
+			"existing_emails_count", len(existing),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectExistingUserEmails"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectExistingUserEmails"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &existing, "SELECT email FROM users WHERE email = ANY($1) AND deleted_at IS NULL", pq.Array(emails))
+	if err != nil {
+		err = errors.Wrap(err, "failed to select existing user emails")
+
+		return
+	}
+
+	return
+}
+
+func InsertUserIfEmailAvailable(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, u types.UserModel) (inserted types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"user_group", u.UserGroup,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertUserIfEmailAvailable"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertUserIfEmailAvailable"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO users (email, password, fullname, user_group) VALUES ($1, $2, $3, $4) ON CONFLICT (email) WHERE deleted_at IS NULL DO NOTHING RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at", u.Email, u.Password, u.FullName, u.UserGroup)
 	if err != nil {
 		err = errors.Wrap(err, "failed to insert user")
 
@@ -108,7 +192,13 @@ func InsertUser(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, u types.
 	return
 }
 
-func SelectUsersOrderByIdDesc(ctx context.Context, m metrics.MetricSink, db *sqlx.DB) (us []types.UserModel, err error) {
+var usersSortColumns = map[string]string{
+	types.SortByCreatedAt: "created_at",
+	types.SortByEmail:     "email",
+	types.SortByFullName:  "fullname",
+}
+
+func SelectUsers(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.UsersQuery) (us []types.UserModel, err error) {
 	defer func(begin time.Time) {
 		l := ctxutil.GetContextLogger(ctx).With(
 			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
@@ -117,16 +207,42 @@ func SelectUsersOrderByIdDesc(ctx context.Context, m metrics.MetricSink, db *sql
 
 		if err != nil {
 			l.Warn(err)
-			m.IncrCounter([]string{"persistence", "SelectUsersOrderByIdDesc", "error"}, 1)
 		} else {
 			l.Debug()
 		}
 
-		m.IncrCounterWithLabels([]string{"persistence", "SelectUsersOrderByIdDesc"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
-		m.AddSampleWithLabels([]string{"persistence", "SelectUsersOrderByIdDesc"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.IncrCounterWithLabels([]string{"persistence", "SelectUsers"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectUsers"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
 	}(time.Now())
 
-	err = db.SelectContext(ctx, &us, "SELECT id, email, fullname FROM users ORDER BY id DESC")
+	column, ok := usersSortColumns[q.SortBy]
+	if !ok {
+		err = errors.Errorf("failed to sort by unknown column: %v", q.SortBy)
+
+		return
+	}
+
+	comparator, direction := ">", "ASC"
+	if q.SortOrder == types.SortOrderDesc {
+		comparator, direction = "<", "DESC"
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	conditions := usersQueryConditions(q, arg)
+	if q.AfterID != "" {
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s (%s, %s)", column, comparator, arg(q.AfterValue), arg(q.AfterID)))
+	}
+
+	query := "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, created_at, updated_at, deleted_at FROM users WHERE " + strings.Join(conditions, " AND ")
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s LIMIT %s", column, direction, direction, arg(q.Limit))
+
+	err = sqlx.SelectContext(ctx, db, &us, query, args...)
 	if err != nil {
 		err = errors.Wrap(err, "failed to select users")
 
@@ -136,7 +252,117 @@ func SelectUsersOrderByIdDesc(ctx context.Context, m metrics.MetricSink, db *sql
 	return
 }
 
-func GetUserByEmail(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, e string) (u types.UserModel, err error) {
+func usersQueryConditions(q types.UsersQuery, arg func(v interface{}) string) (conditions []string) {
+	conditions = []string{"deleted_at IS NULL"}
+	if q.Deleted {
+		conditions = []string{"deleted_at IS NOT NULL"}
+	}
+
+	if q.UserGroup != "" {
+		conditions = append(conditions, "user_group = "+arg(q.UserGroup))
+	}
+	if q.CreatedAfter != nil {
+		conditions = append(conditions, "created_at >= "+arg(*q.CreatedAfter))
+	}
+	if q.CreatedBefore != nil {
+		conditions = append(conditions, "created_at < "+arg(*q.CreatedBefore))
+	}
+	if q.EmailDomain != "" {
+		conditions = append(conditions, "lower(split_part(email, '@', 2)) = lower("+arg(q.EmailDomain)+")")
+	}
+	if q.Status != "" {
+		conditions = append(conditions, "status = "+arg(q.Status))
+	}
+	if q.Attributes != nil {
+		conditions = append(conditions, "attributes @> "+arg(q.Attributes)+"::jsonb")
+	}
+
+	return
+}
+
+// StreamUsers reads the users matching q through a cursor, and passes them to f in batches of batchSize.
+// It has to be called within a transaction, as the cursor only lives as long as the transaction.
+func StreamUsers(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.UsersQuery, batchSize int, f func(us []types.UserModel) error) (count int, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"streamed_users_count", count,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "StreamUsers"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "StreamUsers"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	column, ok := usersSortColumns[q.SortBy]
+	if !ok {
+		err = errors.Errorf("failed to sort by unknown column: %v", q.SortBy)
+
+		return
+	}
+
+	direction := "ASC"
+	if q.SortOrder == types.SortOrderDesc {
+		direction = "DESC"
+	}
+
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	query := "DECLARE users_stream NO SCROLL CURSOR FOR SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, created_at, updated_at, deleted_at FROM users WHERE " + strings.Join(usersQueryConditions(q, arg), " AND ")
+	query += fmt.Sprintf(" ORDER BY %s %s, id %s", column, direction, direction)
+
+	_, err = db.ExecContext(ctx, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to declare users cursor")
+
+		return
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM users_stream", batchSize)
+	for {
+		var us []types.UserModel
+		err = sqlx.SelectContext(ctx, db, &us, fetch)
+		if err != nil {
+			err = errors.Wrap(err, "failed to fetch users")
+
+			return
+		}
+
+		if len(us) == 0 {
+			break
+		}
+
+		count += len(us)
+
+		err = f(us)
+		if err != nil {
+			err = errors.Wrap(err, "failed to process users")
+
+			return
+		}
+	}
+
+	_, err = db.ExecContext(ctx, "CLOSE users_stream")
+	if err != nil {
+		err = errors.Wrap(err, "failed to close users cursor")
+
+		return
+	}
+
+	return
+}
+
+func GetUserByEmail(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, e string) (u types.UserModel, err error) {
 	defer func(begin time.Time) {
 		l := ctxutil.GetContextLogger(ctx).With(
 			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
@@ -153,7 +379,7 @@ func GetUserByEmail(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, e st
 		m.AddSampleWithLabels([]string{"persistence", "GetUserByEmail"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
 	}(time.Now())
 
-	err = db.GetContext(ctx, &u, "SELECT id, email, fullname, user_group, password, created_at, updated_at FROM users WHERE email=$1", e)
+	err = sqlx.GetContext(ctx, db, &u, "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at FROM users WHERE email=$1 AND deleted_at IS NULL", e)
 	if err != nil {
 		err = errors.Wrap(err, "failed to select user by email")
 
@@ -163,10 +389,522 @@ func GetUserByEmail(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, e st
 	return
 }
 
-func DeleteUserByEmail(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, e string) (err error) {
+func SoftDeleteUserByEmail(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, e string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SoftDeleteUserByEmail"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SoftDeleteUserByEmail"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "UPDATE users SET deleted_at=NOW(), deleted_status=status, deleted_status_reason=status_reason, status='deactivated', status_reason='', status_changed_at=NOW() WHERE email=$1 AND deleted_at IS NULL RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at, deleted_at", e)
+	if err != nil {
+		err = errors.Wrap(err, "failed to soft delete user by email")
+
+		return
+	}
+
+	return
+}
+
+func GetUserById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_user_id", u.ID,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetUserById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetUserById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at FROM users WHERE id=$1 AND deleted_at IS NULL", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select user by id")
+
+		return
+	}
+
+	return
+}
+
+func UpdateUserFullNameById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, fullName string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateUserFullNameById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateUserFullNameById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "UPDATE users SET fullname=$1 WHERE id=$2 AND deleted_at IS NULL RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at", fullName, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user fullname by id")
+
+		return
+	}
+
+	return
+}
+
+func UpdateUserPasswordById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, password string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateUserPasswordById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateUserPasswordById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "UPDATE users SET password=$1 WHERE id=$2 AND deleted_at IS NULL RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at", password, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user password by id")
+
+		return
+	}
+
+	return
+}
+
+func SoftDeleteUserById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SoftDeleteUserById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SoftDeleteUserById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "UPDATE users SET deleted_at=NOW(), deleted_status=status, deleted_status_reason=status_reason, status='deactivated', status_reason='', status_changed_at=NOW() WHERE id=$1 AND deleted_at IS NULL RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at, deleted_at", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to soft delete user by id")
+
+		return
+	}
+
+	return
+}
+
+func UpdateUserByIdAndUpdatedAt(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, u types.UserModel) (updated types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"user_group", u.UserGroup,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateUserByIdAndUpdatedAt"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateUserByIdAndUpdatedAt"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &updated, "UPDATE users SET email=$1, fullname=$2, user_group=$3, attributes=$4 WHERE id=$5 AND updated_at=$6 AND deleted_at IS NULL RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at", u.Email, u.FullName, u.UserGroup, u.Attributes, u.ID, u.UpdatedAt)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user by id and updated_at")
+
+		return
+	}
+
+	return
+}
+
+func IsUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+
+	return ok && pqErr.Code == "23505"
+}
+
+func SearchUsers(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.UsersSearchQuery) (us []types.UserSearchModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_users_count", len(us),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SearchUsers"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SearchUsers"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	score := "GREATEST(word_similarity($1, email), word_similarity($1, fullname))"
+	args := []interface{}{q.Query, "%" + escapeLike(q.Query) + "%"}
+
+	query := "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, created_at, updated_at, " + score + " AS score FROM users" +
+		" WHERE deleted_at IS NULL AND ($1 <% email OR $1 <% fullname OR email ILIKE $2 OR fullname ILIKE $2)"
+	if q.AfterScore != nil {
+		args = append(args, *q.AfterScore, q.AfterID)
+		query += fmt.Sprintf(" AND (%s, id) < ($%d::real, $%d)", score, len(args)-1, len(args))
+	}
+	args = append(args, q.Limit)
+	query += fmt.Sprintf(" ORDER BY score DESC, id DESC LIMIT $%d", len(args))
+
+	err = sqlx.SelectContext(ctx, db, &us, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to search users")
+
+		return
+	}
+
+	return
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// RestoreUserById returns the restored user with DeletedAt set to the time it had been deleted at.
+func RestoreUserById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, gracePeriod time.Duration) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "RestoreUserById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "RestoreUserById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "UPDATE users u SET deleted_at=NULL, status=COALESCE(u.deleted_status, 'active'), status_reason=u.deleted_status_reason, status_changed_at=NOW(), deleted_status=NULL, deleted_status_reason='' FROM users d WHERE u.id=d.id AND u.id=$1 AND u.deleted_at > NOW() - make_interval(secs => $2) RETURNING u.id, u.email, u.fullname, u.user_group, u.external_id, u.status, u.status_reason, u.status_changed_at, u.attributes, u.password, u.created_at, u.updated_at, d.deleted_at", id, gracePeriod.Seconds())
+	if err != nil {
+		err = errors.Wrap(err, "failed to restore user by id")
+
+		return
+	}
+
+	return
+}
+
+func PurgeDeletedUsers(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, gracePeriod time.Duration) (ids []string, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"purged_users_count", len(ids),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "PurgeDeletedUsers"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "PurgeDeletedUsers"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &ids, "DELETE FROM users WHERE deleted_at <= NOW() - make_interval(secs => $1) RETURNING id", gracePeriod.Seconds())
+	if err != nil {
+		err = errors.Wrap(err, "failed to purge deleted users")
+
+		return
+	}
+
+	return
+}
+
+func GetAnyUserById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetAnyUserById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetAnyUserById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at, deleted_at FROM users WHERE id=$1", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select user")
+
+		return
+	}
+
+	return
+}
+
+func GetAnyUserByIdForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetAnyUserByIdForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetAnyUserByIdForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at, deleted_at FROM users WHERE id=$1 FOR UPDATE", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select user for update")
+
+		return
+	}
+
+	return
+}
+
+func UpdateAnyUserById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, u types.UserModel) (updated types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"user_group", u.UserGroup,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateAnyUserById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateAnyUserById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &updated, "UPDATE users SET email=$1, fullname=$2, user_group=$3, password=$4, external_id=$5 WHERE id=$6 RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at, deleted_at", u.Email, u.FullName, u.UserGroup, u.Password, u.ExternalID, u.ID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user")
+
+		return
+	}
+
+	return
+}
+
+func PurgeUserById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "PurgeUserById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "PurgeUserById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "DELETE FROM users WHERE id=$1 RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at, deleted_at", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete user")
+
+		return
+	}
+
+	return
+}
+
+func SelectAnyUsers(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.ScimUsersQuery) (us []types.UserModel, total int, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_users_count", len(us),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectAnyUsers"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectAnyUsers"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.Email != "" {
+		conditions = append(conditions, "lower(email) = lower("+arg(q.Email)+")")
+	}
+	if q.UserGroup != "" {
+		conditions = append(conditions, "user_group = "+arg(q.UserGroup))
+	}
+	if q.Active {
+		conditions = append(conditions, "deleted_at IS NULL")
+	}
+
+	where := " WHERE " + strings.Join(conditions, " AND ")
+
+	err = sqlx.GetContext(ctx, db, &total, "SELECT count(*) FROM users"+where, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to count users")
+
+		return
+	}
+
+	if q.Limit == 0 {
+		return
+	}
+
+	query := "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at, deleted_at FROM users" + where + " ORDER BY created_at, id"
+	query += " OFFSET " + arg(q.Offset)
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	err = sqlx.SelectContext(ctx, db, &us, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select users")
+
+		return
+	}
+
+	return
+}
+
+func GetUserIdentity(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, provider string, subject string) (ui types.UserIdentityModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"provider", provider,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetUserIdentity"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetUserIdentity"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &ui, "SELECT provider, subject, user_id, email, created_at, last_login_at FROM user_identities WHERE provider=$1 AND subject=$2", provider, subject)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select user identity")
+
+		return
+	}
+
+	return
+}
+
+func InsertUserIdentity(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, ui types.UserIdentityModel) (inserted types.UserIdentityModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"provider", ui.Provider,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertUserIdentity"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertUserIdentity"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO user_identities (provider, subject, user_id, email) VALUES ($1, $2, $3, $4) RETURNING provider, subject, user_id, email, created_at, last_login_at", ui.Provider, ui.Subject, ui.UserID, ui.Email)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert user identity")
+
+		return
+	}
+
+	return
+}
+
+func TouchUserIdentity(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, provider string, subject string, email string) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"provider", provider,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "TouchUserIdentity"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "TouchUserIdentity"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE user_identities SET email=$3, last_login_at=NOW() WHERE provider=$1 AND subject=$2", provider, subject, email)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user identity")
+
+		return
+	}
+
+	return
+}
+
+func SelectUserIdentitiesByUserId(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, userID string) (uis []types.UserIdentityModel, err error) {
 	defer func(begin time.Time) {
 		l := ctxutil.GetContextLogger(ctx).With(
 			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_user_identities_count", len(uis),
 		)
 
 		if err != nil {
@@ -175,13 +913,13 @@ func DeleteUserByEmail(ctx context.Context, m metrics.MetricSink, db *sqlx.DB, e
 			l.Debug()
 		}
 
-		m.IncrCounterWithLabels([]string{"persistence", "DeleteUserByEmail"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
-		m.AddSampleWithLabels([]string{"persistence", "DeleteUserByEmail"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.IncrCounterWithLabels([]string{"persistence", "SelectUserIdentitiesByUserId"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectUserIdentitiesByUserId"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
 	}(time.Now())
 
-	_, err = db.ExecContext(ctx, "DELETE FROM users WHERE email=$1", e)
+	err = sqlx.SelectContext(ctx, db, &uis, "SELECT provider, subject, user_id, email, created_at, last_login_at FROM user_identities WHERE user_id=$1 ORDER BY created_at", userID)
 	if err != nil {
-		err = errors.Wrap(err, "failed to delete user by email")
+		err = errors.Wrap(err, "failed to select user identities")
 
 		return
 	}
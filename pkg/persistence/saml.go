@@ -0,0 +1,77 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+// InsertSamlAssertionIfNew fails with sql.ErrNoRows if the assertion of the provider was inserted before.
+func InsertSamlAssertionIfNew(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, a types.SamlAssertionModel) (inserted types.SamlAssertionModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"provider", a.Provider,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertSamlAssertionIfNew"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertSamlAssertionIfNew"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO saml_assertions (provider, id, expires_at) VALUES ($1, $2, $3) ON CONFLICT (provider, id) DO NOTHING RETURNING provider, id, consumed_at, expires_at", a.Provider, a.ID, a.ExpiresAt)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert saml assertion")
+
+		return
+	}
+
+	return
+}
+
+func DeleteExpiredSamlAssertions(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (n int64, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"deleted_saml_assertions_count", n,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "DeleteExpiredSamlAssertions"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "DeleteExpiredSamlAssertions"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	res, err := db.ExecContext(ctx, "DELETE FROM saml_assertions WHERE expires_at <= NOW()")
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete expired saml assertions")
+
+		return
+	}
+
+	n, err = res.RowsAffected()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get number of deleted saml assertions")
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,150 @@
+package persistence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func GetUserByIdForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetUserByIdForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetUserByIdForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "SELECT id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at FROM users WHERE id=$1 AND deleted_at IS NULL FOR UPDATE", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select user by id")
+
+		return
+	}
+
+	return
+}
+
+// GetUserTokenStateById returns the status and token epoch of a user, including soft deleted ones, whose status is deactivated.
+func GetUserTokenStateById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (s types.UserTokenStateModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetUserTokenStateById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetUserTokenStateById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &s, "SELECT status, token_epoch FROM users WHERE id=$1", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select user token state by id")
+
+		return
+	}
+
+	return
+}
+
+func UpdateUserStatusById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, status string, reason string) (u types.UserModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"status", status,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateUserStatusById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateUserStatusById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &u, "UPDATE users SET status=$1, status_reason=$2, status_changed_at=NOW(), failed_authentications=0 WHERE id=$3 AND deleted_at IS NULL RETURNING id, email, fullname, user_group, external_id, status, status_reason, status_changed_at, token_epoch, attributes, password, created_at, updated_at", status, reason, id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update user status")
+
+		return
+	}
+
+	return
+}
+
+// IncrementFailedAuthenticationsById counts a failed authentication of the user, and returns the number of
+// failed authentications since the last successful one.
+func IncrementFailedAuthenticationsById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (count int, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"count", count,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "IncrementFailedAuthenticationsById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "IncrementFailedAuthenticationsById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &count, "UPDATE users SET failed_authentications=failed_authentications+1 WHERE id=$1 AND deleted_at IS NULL RETURNING failed_authentications", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to increment failed authentications")
+
+		return
+	}
+
+	return
+}
+
+func ResetFailedAuthenticationsById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "ResetFailedAuthenticationsById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "ResetFailedAuthenticationsById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE users SET failed_authentications=0 WHERE id=$1 AND failed_authentications > 0", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to reset failed authentications")
+
+		return
+	}
+
+	return
+}
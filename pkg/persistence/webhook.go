@@ -0,0 +1,495 @@
+package persistence
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/armon/go-metrics"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/ppwfx/user-svc/pkg/types"
+	"github.com/ppwfx/user-svc/pkg/utils/ctxutil"
+)
+
+func InsertWebhookEndpoint(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, w types.WebhookEndpointModel) (inserted types.WebhookEndpointModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertWebhookEndpoint"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertWebhookEndpoint"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO webhook_endpoints (url, secret, event_types) VALUES ($1, $2, $3) RETURNING id, url, secret, event_types, paused_at, created_at, updated_at", w.URL, w.Secret, w.EventTypes)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert webhook endpoint")
+
+		return
+	}
+
+	return
+}
+
+func SelectWebhookEndpoints(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext) (ws []types.WebhookEndpointModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_webhook_endpoints_count", len(ws),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectWebhookEndpoints"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectWebhookEndpoints"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &ws, "SELECT id, url, secret, event_types, paused_at, created_at, updated_at FROM webhook_endpoints ORDER BY created_at, id")
+	if err != nil {
+		err = errors.Wrap(err, "failed to select webhook endpoints")
+
+		return
+	}
+
+	return
+}
+
+func GetWebhookEndpointById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (w types.WebhookEndpointModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetWebhookEndpointById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetWebhookEndpointById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &w, "SELECT id, url, secret, event_types, paused_at, created_at, updated_at FROM webhook_endpoints WHERE id=$1", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get webhook endpoint by id")
+
+		return
+	}
+
+	return
+}
+
+func GetWebhookEndpointByIdForUpdate(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (w types.WebhookEndpointModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "GetWebhookEndpointByIdForUpdate"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "GetWebhookEndpointByIdForUpdate"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &w, "SELECT id, url, secret, event_types, paused_at, created_at, updated_at FROM webhook_endpoints WHERE id=$1 FOR UPDATE", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to get webhook endpoint by id for update")
+
+		return
+	}
+
+	return
+}
+
+func UpdateWebhookEndpoint(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, w types.WebhookEndpointModel) (updated types.WebhookEndpointModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateWebhookEndpoint"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateWebhookEndpoint"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &updated, "UPDATE webhook_endpoints SET url=$2, event_types=$3 WHERE id=$1 RETURNING id, url, secret, event_types, paused_at, created_at, updated_at", w.ID, w.URL, w.EventTypes)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update webhook endpoint")
+
+		return
+	}
+
+	return
+}
+
+func UpdateWebhookEndpointPausedById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, paused bool) (w types.WebhookEndpointModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateWebhookEndpointPausedById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateWebhookEndpointPausedById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &w, "UPDATE webhook_endpoints SET paused_at=CASE WHEN $2 THEN COALESCE(paused_at, NOW()) END WHERE id=$1 RETURNING id, url, secret, event_types, paused_at, created_at, updated_at", id, paused)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update webhook endpoint paused_at")
+
+		return
+	}
+
+	return
+}
+
+func UpdateWebhookEndpointSecretById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string, secret string) (w types.WebhookEndpointModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateWebhookEndpointSecretById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateWebhookEndpointSecretById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &w, "UPDATE webhook_endpoints SET secret=$2 WHERE id=$1 RETURNING id, url, secret, event_types, paused_at, created_at, updated_at", id, secret)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update webhook endpoint secret")
+
+		return
+	}
+
+	return
+}
+
+func DeleteWebhookEndpointById(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id string) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "DeleteWebhookEndpointById"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "DeleteWebhookEndpointById"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	r, err := db.ExecContext(ctx, "DELETE FROM webhook_endpoints WHERE id=$1", id)
+	if err != nil {
+		err = errors.Wrap(err, "failed to delete webhook endpoint")
+
+		return
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get number of deleted webhook endpoints")
+
+		return
+	}
+
+	if n == 0 {
+		err = errors.Wrap(sql.ErrNoRows, "failed to delete webhook endpoint")
+
+		return
+	}
+
+	return
+}
+
+func InsertWebhookDeliveryAttempt(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, a types.WebhookDeliveryAttemptModel) (inserted types.WebhookDeliveryAttemptModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "InsertWebhookDeliveryAttempt"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "InsertWebhookDeliveryAttempt"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &inserted, "INSERT INTO webhook_delivery_attempts (webhook_id, delivery_id, event_id, event_type, attempted_at, status_code, latency_ms, error) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id, webhook_id, delivery_id, event_id, event_type, attempted_at, status_code, latency_ms, error", a.WebhookID, a.DeliveryID, a.EventID, a.EventType, a.AttemptedAt, a.StatusCode, a.LatencyMs, a.Error)
+	if err != nil {
+		err = errors.Wrap(err, "failed to insert webhook delivery attempt")
+
+		return
+	}
+
+	return
+}
+
+func SelectWebhookDeliveryAttempts(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.WebhookDeliveryAttemptsQuery) (as []types.WebhookDeliveryAttemptModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_webhook_delivery_attempts_count", len(as),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectWebhookDeliveryAttempts"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectWebhookDeliveryAttempts"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	conditions := []string{"webhook_id = $1"}
+	args := []interface{}{q.WebhookID}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.BeforeID != 0 {
+		conditions = append(conditions, "id < "+arg(q.BeforeID))
+	}
+
+	query := "SELECT id, webhook_id, delivery_id, event_id, event_type, attempted_at, status_code, latency_ms, error FROM webhook_delivery_attempts WHERE " + strings.Join(conditions, " AND ") + " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	err = sqlx.SelectContext(ctx, db, &as, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select webhook delivery attempts")
+
+		return
+	}
+
+	return
+}
+
+func ClaimDueWebhookDeliveries(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, limit int, claimTimeout time.Duration) (ds []types.ClaimedWebhookDeliveryModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_webhook_deliveries_count", len(ds),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "ClaimDueWebhookDeliveries"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "ClaimDueWebhookDeliveries"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.SelectContext(ctx, db, &ds, `WITH claimed AS (
+		UPDATE webhook_deliveries SET attempts=attempts+1, next_attempt_at=NOW() + make_interval(secs => $1), updated_at=NOW() WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status=$2 AND next_attempt_at <= NOW() AND webhook_id NOT IN (SELECT id FROM webhook_endpoints WHERE paused_at IS NOT NULL)
+			ORDER BY next_attempt_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT $3
+		) RETURNING id, webhook_id, event_id, status, attempts, next_attempt_at, last_status_code, last_error, created_at, updated_at
+	)
+	SELECT c.id, c.webhook_id, c.event_id, o.event_type, c.status, c.attempts, c.next_attempt_at, c.last_status_code, c.last_error, c.created_at, c.updated_at, w.url, w.secret, o.created_at AS event_created_at, o.payload AS event_payload
+	FROM claimed c
+	JOIN webhook_endpoints w ON w.id = c.webhook_id
+	JOIN outbox_events o ON o.id = c.event_id
+	ORDER BY c.event_id`, claimTimeout.Seconds(), types.WebhookDeliveryStatusPending, limit)
+	if err != nil {
+		err = errors.Wrap(err, "failed to claim due webhook deliveries")
+
+		return
+	}
+
+	return
+}
+
+// ExtendWebhookDeliveryClaim extends the claim on a delivery as long as it wasn't reclaimed since it was claimed with the given attempts.
+func ExtendWebhookDeliveryClaim(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id int64, attempts int, claimTimeout time.Duration) (extended bool, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"webhook_delivery_id", id,
+			"extended", extended,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "ExtendWebhookDeliveryClaim"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "ExtendWebhookDeliveryClaim"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	r, err := db.ExecContext(ctx, "UPDATE webhook_deliveries SET next_attempt_at=NOW() + make_interval(secs => $3), updated_at=NOW() WHERE id=$1 AND attempts=$2 AND status=$4", id, attempts, claimTimeout.Seconds(), types.WebhookDeliveryStatusPending)
+	if err != nil {
+		err = errors.Wrap(err, "failed to extend webhook delivery claim")
+
+		return
+	}
+
+	n, err := r.RowsAffected()
+	if err != nil {
+		err = errors.Wrap(err, "failed to get number of extended webhook delivery claims")
+
+		return
+	}
+
+	extended = n > 0
+
+	return
+}
+
+func UpdateWebhookDeliveryAttempt(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, d types.WebhookDeliveryModel) (err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"webhook_delivery_id", d.ID,
+			"status", d.Status,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "UpdateWebhookDeliveryAttempt"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "UpdateWebhookDeliveryAttempt"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	_, err = db.ExecContext(ctx, "UPDATE webhook_deliveries SET status=$2, next_attempt_at=$3, last_status_code=$4, last_error=$5, updated_at=NOW() WHERE id=$1", d.ID, d.Status, d.NextAttemptAt, d.LastStatusCode, d.LastError)
+	if err != nil {
+		err = errors.Wrap(err, "failed to update webhook delivery")
+
+		return
+	}
+
+	return
+}
+
+func SelectWebhookDeliveries(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, q types.WebhookDeliveriesQuery) (ds []types.WebhookDeliveryModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"returned_webhook_deliveries_count", len(ds),
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "SelectWebhookDeliveries"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "SelectWebhookDeliveries"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	conditions := []string{"TRUE"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.BeforeID != 0 {
+		conditions = append(conditions, "d.id < "+arg(q.BeforeID))
+	}
+	if q.WebhookID != "" {
+		conditions = append(conditions, "d.webhook_id = "+arg(q.WebhookID))
+	}
+	if q.Status != "" {
+		conditions = append(conditions, "d.status = "+arg(q.Status))
+	}
+
+	query := "SELECT d.id, d.webhook_id, d.event_id, o.event_type, d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.updated_at FROM webhook_deliveries d JOIN outbox_events o ON o.id = d.event_id WHERE " + strings.Join(conditions, " AND ") + " ORDER BY d.id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+
+	err = sqlx.SelectContext(ctx, db, &ds, query, args...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to select webhook deliveries")
+
+		return
+	}
+
+	return
+}
+
+func RetryDeadWebhookDelivery(ctx context.Context, m metrics.MetricSink, db sqlx.ExtContext, id int64) (d types.WebhookDeliveryModel, err error) {
+	defer func(begin time.Time) {
+		l := ctxutil.GetContextLogger(ctx).With(
+			types.LogLatency, fmt.Sprintf("%.6fs", time.Since(begin).Seconds()),
+			"webhook_delivery_id", id,
+		)
+
+		if err != nil {
+			l.Warn(err)
+		} else {
+			l.Debug()
+		}
+
+		m.IncrCounterWithLabels([]string{"persistence", "RetryDeadWebhookDelivery"}, 1, []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+		m.AddSampleWithLabels([]string{"persistence", "RetryDeadWebhookDelivery"}, float32(time.Now().Sub(begin).Milliseconds()), []metrics.Label{{Name: "success", Value: strconv.FormatBool(err == nil)}})
+	}(time.Now())
+
+	err = sqlx.GetContext(ctx, db, &d, `UPDATE webhook_deliveries d SET status=$2, attempts=0, next_attempt_at=NOW(), updated_at=NOW()
+		FROM outbox_events o
+		WHERE d.id=$1 AND d.status=$3 AND o.id = d.event_id
+		RETURNING d.id, d.webhook_id, d.event_id, o.event_type, d.status, d.attempts, d.next_attempt_at, d.last_status_code, d.last_error, d.created_at, d.updated_at`, id, types.WebhookDeliveryStatusPending, types.WebhookDeliveryStatusDead)
+	if err != nil {
+		err = errors.Wrap(err, "failed to retry dead webhook delivery")
+
+		return
+	}
+
+	return
+}
@@ -0,0 +1,102 @@
+package publishing
+
+import (
+	"context"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+)
+
+const natsAckTimeout = 5 * time.Second
+
+type NatsPublisher struct {
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subject string
+}
+
+// NewNatsPublisher publishes to the JetStream stream, and creates the stream if it doesn't exist yet.
+func NewNatsPublisher(url string, stream string, subject string, opts ...nats.Option) (p *NatsPublisher, err error) {
+	c, err := nats.Connect(url, opts...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to connect to nats")
+
+		return
+	}
+
+	js, err := c.JetStream()
+	if err != nil {
+		c.Close()
+
+		err = errors.Wrap(err, "failed to get jetstream context")
+
+		return
+	}
+
+	_, err = js.StreamInfo(stream)
+	if err != nil {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     stream,
+			Subjects: []string{subject, subject + ".>"},
+		})
+		if err != nil {
+			c.Close()
+
+			err = errors.Wrapf(err, "failed to add stream %v", stream)
+
+			return
+		}
+	}
+
+	p = &NatsPublisher{
+		conn:    c,
+		js:      js,
+		subject: subject,
+	}
+
+	return
+}
+
+// Publish publishes to <subject>.<ordering key>, and waits until the stream acknowledged the message, so
+// that messages of the same ordering key arrive in order. The message id is sent as Nats-Msg-Id header,
+// so that the stream discards messages that are published again within its duplicate window.
+func (p *NatsPublisher) Publish(ctx context.Context, msg Message) (err error) {
+	subject := p.subject
+	if msg.OrderingKey != "" {
+		subject += "." + msg.OrderingKey
+	}
+
+	m := nats.NewMsg(subject)
+	m.Data = msg.Data
+	for k, v := range msg.Attributes {
+		m.Header.Set(k, v)
+	}
+
+	opts := []nats.PubOpt{nats.MsgId(msg.ID)}
+	if _, ok := ctx.Deadline(); ok {
+		opts = append(opts, nats.Context(ctx))
+	} else {
+		opts = append(opts, nats.AckWait(natsAckTimeout))
+	}
+
+	_, err = p.js.PublishMsg(m, opts...)
+	if err != nil {
+		err = errors.Wrapf(err, "failed to publish message %v", msg.ID)
+
+		return
+	}
+
+	return
+}
+
+func (p *NatsPublisher) Close() (err error) {
+	err = p.conn.Drain()
+	if err != nil {
+		err = errors.Wrap(err, "failed to drain nats connection")
+
+		return
+	}
+
+	return
+}
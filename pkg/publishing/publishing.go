@@ -0,0 +1,17 @@
+package publishing
+
+import (
+	"context"
+)
+
+type Message struct {
+	ID          string
+	OrderingKey string
+	Data        []byte
+	Attributes  map[string]string
+}
+
+type Publisher interface {
+	Publish(ctx context.Context, msg Message) error
+	Close() error
+}
@@ -0,0 +1,251 @@
+// +build integration
+
+package publishing
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/nats-io/nats.go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ppwfx/user-svc/pkg/utils/dockerutil"
+)
+
+const (
+	pubSubEmulatorHost = "localhost:8085"
+	pubSubProject      = "user-svc"
+	natsUrl            = "nats://localhost:4222"
+)
+
+var ctx = context.Background()
+
+func TestMain(m *testing.M) {
+	err := func() (err error) {
+		err = dockerutil.RemoveDockerContainers("user-svc-publishing")
+		if err != nil {
+			return
+		}
+
+		o, err := exec.Command("docker", strings.Fields("run -d --label user-svc-publishing --rm -p 8085:8085 gcr.io/google.com/cloudsdktool/cloud-sdk:emulators gcloud beta emulators pubsub start --host-port=0.0.0.0:8085")...).Output()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to run pubsub emulator container: %s", o)
+
+			return
+		}
+
+		o, err = exec.Command("docker", strings.Fields("run -d --label user-svc-publishing --rm -p 4222:4222 nats -js")...).Output()
+		if err != nil {
+			err = errors.Wrapf(err, "failed to run nats container: %s", o)
+
+			return
+		}
+
+		err = os.Setenv("PUBSUB_EMULATOR_HOST", pubSubEmulatorHost)
+		if err != nil {
+			err = errors.Wrap(err, "failed to set PUBSUB_EMULATOR_HOST")
+
+			return
+		}
+
+		return
+	}()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	c := m.Run()
+
+	err = dockerutil.RemoveDockerContainers("user-svc-publishing")
+	if err != nil {
+		err = errors.Wrapf(err, "failed to remove docker containers")
+
+		log.Fatal(err)
+	}
+
+	os.Exit(c)
+}
+
+func TestPubSubPublisher(t *testing.T) {
+	err := func() (err error) {
+		var c *pubsub.Client
+		var topic *pubsub.Topic
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			c, err = pubsub.NewClient(ctx, pubSubProject)
+			if err == nil {
+				topic, err = c.CreateTopic(ctx, "user-events")
+			}
+			if err == nil || time.Now().After(deadline) {
+				break
+			}
+
+			time.Sleep(time.Second)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to create topic")
+
+			return
+		}
+		defer c.Close()
+
+		sub, err := c.CreateSubscription(ctx, "user-events", pubsub.SubscriptionConfig{
+			Topic:                 topic,
+			EnableMessageOrdering: true,
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to create subscription")
+
+			return
+		}
+
+		p, err := NewPubSubPublisher(ctx, pubSubProject, "user-events")
+		if err != nil {
+			return
+		}
+		defer p.Close()
+
+		expected := []string{"1", "2", "3"}
+		for _, id := range expected {
+			err = p.Publish(ctx, Message{
+				ID:          id,
+				OrderingKey: "user",
+				Data:        []byte(id),
+				Attributes:  map[string]string{"event_id": id},
+			})
+			if err != nil {
+				return
+			}
+		}
+
+		var mu sync.Mutex
+		var received []string
+		rctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+
+		err = sub.Receive(rctx, func(ctx context.Context, msg *pubsub.Message) {
+			msg.Ack()
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			received = append(received, string(msg.Data))
+			assert.Equal(t, "user", msg.OrderingKey)
+			assert.Equal(t, string(msg.Data), msg.Attributes["event_id"])
+
+			if len(received) == len(expected) {
+				cancel()
+			}
+		})
+		if err != nil {
+			err = errors.Wrap(err, "failed to receive messages")
+
+			return
+		}
+
+		assert.Equal(t, expected, received)
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNatsPublisher(t *testing.T) {
+	err := func() (err error) {
+		var c *nats.Conn
+		deadline := time.Now().Add(30 * time.Second)
+		for {
+			c, err = nats.Connect(natsUrl)
+			if err == nil || time.Now().After(deadline) {
+				break
+			}
+
+			time.Sleep(time.Second)
+		}
+		if err != nil {
+			err = errors.Wrap(err, "failed to connect to nats")
+
+			return
+		}
+		defer c.Close()
+
+		msgs := make(chan *nats.Msg, 10)
+		sub, err := c.ChanSubscribe("user-events.>", msgs)
+		if err != nil {
+			err = errors.Wrap(err, "failed to subscribe")
+
+			return
+		}
+		defer sub.Unsubscribe()
+
+		err = c.Flush()
+		if err != nil {
+			return
+		}
+
+		p, err := NewNatsPublisher(natsUrl, "user-events", "user-events")
+		if err != nil {
+			return
+		}
+		defer p.Close()
+
+		expected := []string{"1", "2", "3"}
+		for _, id := range append(expected, "3") {
+			err = p.Publish(ctx, Message{
+				ID:          id,
+				OrderingKey: "user",
+				Data:        []byte(id),
+				Attributes:  map[string]string{"event_id": id},
+			})
+			if err != nil {
+				return
+			}
+		}
+
+		var received []string
+		timeout := time.After(10 * time.Second)
+		for len(received) < len(expected) {
+			select {
+			case msg := <-msgs:
+				assert.Equal(t, "user-events.user", msg.Subject)
+				assert.Equal(t, string(msg.Data), msg.Header.Get(nats.MsgIdHdr))
+				assert.Equal(t, string(msg.Data), msg.Header.Get("event_id"))
+
+				received = append(received, string(msg.Data))
+			case <-timeout:
+				t.Error("timed out waiting for messages")
+
+				return
+			}
+		}
+
+		assert.Equal(t, expected, received)
+
+		js, err := c.JetStream()
+		if err != nil {
+			return
+		}
+
+		info, err := js.StreamInfo("user-events")
+		if err != nil {
+			return
+		}
+
+		assert.Equal(t, uint64(len(expected)), info.State.Msgs, "messages published again are discarded by the stream")
+
+		return
+	}()
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,64 @@
+package publishing
+
+import (
+	"context"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/pkg/errors"
+	"google.golang.org/api/option"
+)
+
+type PubSubPublisher struct {
+	client *pubsub.Client
+	topic  *pubsub.Topic
+}
+
+func NewPubSubPublisher(ctx context.Context, projectID string, topicID string, opts ...option.ClientOption) (p *PubSubPublisher, err error) {
+	c, err := pubsub.NewClient(ctx, projectID, opts...)
+	if err != nil {
+		err = errors.Wrap(err, "failed to create pubsub client")
+
+		return
+	}
+
+	t := c.Topic(topicID)
+	t.EnableMessageOrdering = true
+
+	p = &PubSubPublisher{
+		client: c,
+		topic:  t,
+	}
+
+	return
+}
+
+func (p *PubSubPublisher) Publish(ctx context.Context, msg Message) (err error) {
+	_, err = p.topic.Publish(ctx, &pubsub.Message{
+		Data:        msg.Data,
+		Attributes:  msg.Attributes,
+		OrderingKey: msg.OrderingKey,
+	}).Get(ctx)
+	if err != nil {
+		// the topic refuses further messages with the same ordering key after a failure
+		p.topic.ResumePublish(msg.OrderingKey)
+
+		err = errors.Wrapf(err, "failed to publish message %v", msg.ID)
+
+		return
+	}
+
+	return
+}
+
+func (p *PubSubPublisher) Close() (err error) {
+	p.topic.Stop()
+
+	err = p.client.Close()
+	if err != nil {
+		err = errors.Wrap(err, "failed to close pubsub client")
+
+		return
+	}
+
+	return
+}
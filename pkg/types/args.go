@@ -1,24 +1,79 @@
 package types
 
 type IntegrationTestArgs struct {
-	UserSvcAddr string
-	PostgresUrl string
-	Remote      bool
+	UserSvcAddr     string
+	PostgresUrl     string
+	Remote          bool
+	ScimBearerToken string
 }
 
 type ServeArgs struct {
-	PostgresUrl            string
-	Port                   string
-	HmacSecret             string
-	AllowedSubjectSuffix   string
-	Metrics                string
-	Logging                string
-	Migrate                string
-	ExposePprof            bool
-	HttpReadTimeoutSeconds int
+	PostgresUrl                string
+	Port                       string
+	HmacSecret                 string
+	AllowedSubjectSuffix       string
+	Metrics                    string
+	Logging                    string
+	Migrate                    string
+	ExposePprof                bool
+	HttpReadTimeoutSeconds     int
+	HttpWriteTimeoutSeconds    int
+	UserExportTimeoutSeconds   int
+	EventStreamSeconds         int
+	DeletionGracePeriodSeconds int
+	PurgeIntervalSeconds       int
+	DataExportTtlSeconds       int
+	DataExportIntervalSeconds  int
+	WebhookIntervalSeconds     int
+	WebhookMaxAttempts         int
+	WebhookAllowPrivate        bool
+	WebhookConcurrency         int
+	Publisher                  string
+	PublishIntervalSeconds     int
+	PubSubProject              string
+	PubSubTopic                string
+	NatsUrl                    string
+	NatsSubject                string
+	NatsStream                 string
+	ScimBearerToken            string
+	OidcProvidersFile          string
+	Authenticators             string
+	LdapConfigFile             string
+	SamlProvidersFile          string
+	Mailer                     string
+	MailFrom                   string
+	SmtpAddr                   string
+	SmtpUsername               string
+	SmtpPassword               string
+	MagicLinkURL               string
+	MagicLinkTtlSeconds        int
+	MagicLinkRateLimit         int
+	MagicLinkRateLimitSeconds  int
+	InvitationURL              string
+	LockoutThreshold           int
+	TokenStateTtlSeconds       int
+	NetworkPoliciesFile        string
+	TrustedProxies             string
+	TrustedProxyHops           int
+}
+
+type ImportArgs struct {
+	UserSvcAddr     string
+	AccessToken     string
+	File            string
+	Format          string
+	DryRun          bool
+	HashedPasswords bool
+	BatchSize       int
 }
 
 const (
 	MetricsStackDriver = "stackdriver"
 	LoggingStackDriver = "stackdriver"
+	PublisherPubSub    = "pubsub"
+	PublisherNats      = "nats"
+	AuthenticatorLocal = "local"
+	AuthenticatorLdap  = "ldap"
+	MailerSmtp         = "smtp"
+	MailerLog          = "log"
 )
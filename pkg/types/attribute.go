@@ -0,0 +1,46 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type PutAttributeSchemaRequest struct {
+	Namespace string          `json:"namespace" validate:"required"`
+	Schema    json.RawMessage `json:"schema" validate:"required"`
+}
+
+type PutAttributeSchemaResponse struct {
+	Error  string           `json:"error"`
+	Schema *AttributeSchema `json:"schema"`
+}
+
+type ListAttributeSchemasRequest struct {
+}
+
+type ListAttributeSchemasResponse struct {
+	Error   string            `json:"error"`
+	Schemas []AttributeSchema `json:"schemas"`
+}
+
+type DeleteAttributeSchemaRequest struct {
+	Namespace string `json:"namespace" validate:"required"`
+}
+
+type DeleteAttributeSchemaResponse struct {
+	Error string `json:"error"`
+}
+
+type AttributeSchema struct {
+	Namespace string          `json:"namespace"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+type AttributeSchemaModel struct {
+	Namespace string    `db:"namespace"`
+	Schema    []byte    `db:"schema"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+}
@@ -0,0 +1,71 @@
+package types
+
+import "time"
+
+type ListAuditEventsRequest struct {
+	PageSize       int        `json:"page_size" validate:"omitempty,min=1,max=1000"`
+	Cursor         string     `json:"cursor"`
+	ActorID        string     `json:"actor_id" validate:"omitempty,uuid"`
+	TargetID       string     `json:"target_id" validate:"omitempty,uuid"`
+	Action         string     `json:"action"`
+	OccurredAfter  *time.Time `json:"occurred_after"`
+	OccurredBefore *time.Time `json:"occurred_before"`
+}
+
+type ListAuditEventsResponse struct {
+	Error      string       `json:"error"`
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+type VerifyAuditEventsRequest struct {
+}
+
+type VerifyAuditEventsResponse struct {
+	Error          string `json:"error"`
+	Valid          bool   `json:"valid"`
+	VerifiedCount  int    `json:"verified_count"`
+	FirstInvalidID int64  `json:"first_invalid_id"`
+}
+
+type AuditEvent struct {
+	ID         int64                  `json:"id"`
+	OccurredAt time.Time              `json:"occurred_at"`
+	ActorID    string                 `json:"actor_id"`
+	Action     string                 `json:"action"`
+	TargetID   string                 `json:"target_id"`
+	RequestID  string                 `json:"request_id"`
+	IP         string                 `json:"ip"`
+	Diff       map[string]AuditChange `json:"diff"`
+	PrevHash   string                 `json:"prev_hash"`
+	Hash       string                 `json:"hash"`
+}
+
+type AuditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditEventsQuery struct {
+	Limit          int
+	BeforeID       int64
+	ActorID        string
+	TargetID       string
+	SubjectID      string
+	Action         string
+	OccurredAfter  *time.Time
+	OccurredBefore *time.Time
+}
+
+type AuditEventModel struct {
+	ID         int64     `db:"id"`
+	OccurredAt time.Time `db:"occurred_at"`
+	ActorID    string    `db:"actor_id"`
+	Action     string    `db:"action"`
+	TargetID   string    `db:"target_id"`
+	RequestID  string    `db:"request_id"`
+	IP         string    `db:"ip"`
+	Diff       []byte    `db:"diff"`
+	PrevHash   string    `db:"prev_hash"`
+	Hash       string    `db:"hash"`
+}
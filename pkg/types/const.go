@@ -1,35 +1,280 @@
 package types
 
 const (
-	RouteCreateUser               = "/api/v0/createUser"
-	RouteDeleteUser               = "/api/v0/deleteUser"
-	RouteListUsers                = "/api/v0/listUsers"
-	RouteAuthenticate             = "/api/v0/authenticate"
-	ContentTypeJson               = "application/json"
-	ErrorInvalidCredentials       = "invalid credentials"
-	ErrorUserDoesNotExist         = "user does not exist"
-	ErrorCanNotDeleteInternalUser = "can not delete internal user"
-	ErrorInternalError            = "internal error"
-	ErrorUnauthorized             = "unauthorized"
-	HeaderAuthorization           = "Authorization"
-	HeaderContentType             = "Content-Type"
-	PrefixBearer                  = "Bearer "
-	ClaimExp                      = "exp"
-	ClaimIat                      = "iat"
-	ClaimUserGroup                = "user_group"
-	ClaimSub                      = "sub"
-	UserGroupUser                 = "user"
-	UserGroupAdmin                = "admin"
-	ContextKeyClaims              = "claims"
-	LogHttpRequest                = "context.httpRequest"
-	LogUser                       = "context.user"
-	LogId                         = "id"
-	LogRole                       = "role"
-	LogLatency                    = "latency"
+	RouteCreateUser                     = "/api/v0/createUser"
+	RouteDeleteUser                     = "/api/v0/deleteUser"
+	RouteListUsers                      = "/api/v0/listUsers"
+	RouteAuthenticate                   = "/api/v0/authenticate"
+	RouteGetMe                          = "/api/v0/getMe"
+	RouteUpdateProfile                  = "/api/v0/updateProfile"
+	RouteChangePassword                 = "/api/v0/changePassword"
+	RouteDeleteMyAccount                = "/api/v0/deleteMyAccount"
+	RouteUpdateUser                     = "/api/v0/updateUser"
+	RouteSearchUsers                    = "/api/v0/searchUsers"
+	RouteRestoreUser                    = "/api/v0/restoreUser"
+	RouteExportMyData                   = "/api/v0/exportMyData"
+	RouteExportUserData                 = "/api/v0/exportUserData"
+	RouteDownloadDataExport             = "/api/v0/downloadDataExport"
+	RouteListAuditEvents                = "/api/v0/listAuditEvents"
+	RouteVerifyAuditEvents              = "/api/v0/verifyAuditEvents"
+	RouteRegisterWebhook                = "/api/v0/registerWebhook"
+	RouteListWebhooks                   = "/api/v0/listWebhooks"
+	RouteUpdateWebhook                  = "/api/v0/updateWebhook"
+	RoutePauseWebhook                   = "/api/v0/pauseWebhook"
+	RouteResumeWebhook                  = "/api/v0/resumeWebhook"
+	RouteDeleteWebhook                  = "/api/v0/deleteWebhook"
+	RouteRotateWebhookSecret            = "/api/v0/rotateWebhookSecret"
+	RoutePingWebhook                    = "/api/v0/pingWebhook"
+	RouteListWebhookDeliveryAttempts    = "/api/v0/listWebhookDeliveryAttempts"
+	RouteStreamUserEvents               = "/api/v0/streamUserEvents"
+	RouteImportUsers                    = "/api/v0/importUsers"
+	RouteExportUsers                    = "/api/v0/exportUsers"
+	RouteOidcLogin                      = "/api/v0/oidcLogin"
+	RouteOidcCallback                   = "/api/v0/oidcCallback"
+	RouteSamlMetadata                   = "/api/v0/samlMetadata"
+	RouteSamlAcs                        = "/api/v0/samlAcs"
+	RouteRequestMagicLink               = "/api/v0/requestMagicLink"
+	RouteConsumeMagicLink               = "/api/v0/consumeMagicLink"
+	RouteInviteUser                     = "/api/v0/inviteUser"
+	RouteListInvitations                = "/api/v0/listInvitations"
+	RouteRevokeInvitation               = "/api/v0/revokeInvitation"
+	RouteAcceptInvitation               = "/api/v0/acceptInvitation"
+	RoutePutAttributeSchema             = "/api/v0/putAttributeSchema"
+	RouteListAttributeSchemas           = "/api/v0/listAttributeSchemas"
+	RouteDeleteAttributeSchema          = "/api/v0/deleteAttributeSchema"
+	RouteSuspendUser                    = "/api/v0/suspendUser"
+	RouteReactivateUser                 = "/api/v0/reactivateUser"
+	RouteReauthenticate                 = "/api/v0/reauthenticate"
+	RouteListLoginHistory               = "/api/v0/listLoginHistory"
+	RouteScimUsers                      = "/scim/v2/Users"
+	RouteScimGroups                     = "/scim/v2/Groups"
+	RouteScimServiceProviderConfig      = "/scim/v2/ServiceProviderConfig"
+	RouteScimResourceTypes              = "/scim/v2/ResourceTypes"
+	RouteScimSchemas                    = "/scim/v2/Schemas"
+	RouteListDeadWebhookDeliveries      = "/api/v0/listDeadWebhookDeliveries"
+	RouteRetryWebhookDelivery           = "/api/v0/retryWebhookDelivery"
+	AuditActionUserCreated              = "user.created"
+	AuditActionUserUpdated              = "user.updated"
+	AuditActionUserDeleted              = "user.deleted"
+	AuditActionUserRestored             = "user.restored"
+	AuditActionUserPurged               = "user.purged"
+	AuditActionUserPasswordChanged      = "user.password_changed"
+	AuditActionUserAuthenticated        = "user.authenticated"
+	AuditActionUserAuthenticationFailed = "user.authentication_failed"
+	AuditActionUserIdentityLinked       = "user.identity_linked"
+	AuditActionUserMagicLinkRequested   = "user.magic_link_requested"
+	AuditActionInvitationCreated        = "invitation.created"
+	AuditActionInvitationRevoked        = "invitation.revoked"
+	AuditActionInvitationAccepted       = "invitation.accepted"
+	AuditActionAttributeSchemaUpdated   = "attribute_schema.updated"
+	AuditActionAttributeSchemaDeleted   = "attribute_schema.deleted"
+	AuditActionUserSuspended            = "user.suspended"
+	AuditActionUserReactivated          = "user.reactivated"
+	AuditActionUserDeactivated          = "user.deactivated"
+	AuditActionUserLocked               = "user.locked"
+	AuditActionUserReauthenticated      = "user.reauthenticated"
+	AuditActionDataExportRequested      = "data_export.requested"
+	AuditActionWebhookRegistered        = "webhook.registered"
+	AuditActionWebhookUpdated           = "webhook.updated"
+	AuditActionWebhookPaused            = "webhook.paused"
+	AuditActionWebhookResumed           = "webhook.resumed"
+	AuditActionWebhookDeleted           = "webhook.deleted"
+	AuditActionWebhookSecretRotated     = "webhook.secret_rotated"
+	EventTypeUserCreated                = "user.created"
+	EventTypeUserDeleted                = "user.deleted"
+	EventTypeUserRestored               = "user.restored"
+	EventTypeUserGroupChanged           = "user.group_changed"
+	EventTypeUserStatusChanged          = "user.status_changed"
+	EventTypeWebhookPing                = "webhook.ping"
+	ContentTypeJson                     = "application/json"
+	ContentTypeEventStream              = "text/event-stream"
+	ContentTypeCsv                      = "text/csv"
+	ContentTypeJsonl                    = "application/x-ndjson"
+	ContentTypeParquet                  = "application/vnd.apache.parquet"
+	ContentTypeScimJson                 = "application/scim+json"
+	ContentTypeSamlMetadata             = "application/samlmetadata+xml"
+	ErrorInvalidCredentials             = "invalid credentials"
+	ErrorUserDoesNotExist               = "user does not exist"
+	ErrorCanNotDeleteInternalUser       = "can not delete internal user"
+	ErrorUserCanNotBeRestored           = "user does not exist, or can not be restored anymore"
+	ErrorInternalError                  = "internal error"
+	ErrorUnauthorized                   = "unauthorized"
+	ErrorEmailAlreadyExists             = "email already exists"
+	ErrorVersionRequired                = "version is required"
+	ErrorDataExportDoesNotExist         = "data export does not exist, or has expired"
+	ErrorDataExportNotReady             = "data export is not ready yet"
+	ErrorDataExportFailed               = "data export failed"
+	ErrorInvalidCursor                  = "invalid cursor"
+	ErrorInvalidLastEventId             = "invalid Last-Event-ID"
+	ErrorTooManyImportRows              = "too many rows"
+	ErrorDuplicateImportRow             = "email occurs more than once in the import"
+	ErrorInvalidPasswordHash            = "password is not an argon2id hash"
+	ErrorWebhookDoesNotExist            = "webhook does not exist"
+	ErrorWebhookPingFailed              = "webhook ping failed"
+	ErrorGroupDoesNotExist              = "group does not exist"
+	ErrorOidcProviderDoesNotExist       = "identity provider does not exist"
+	ErrorInvalidOidcState               = "login state is invalid, or expired"
+	ErrorOidcLoginFailed                = "login with the identity provider failed"
+	ErrorOidcEmailNotVerified           = "email is not verified by the identity provider"
+	ErrorOidcEmailDomainNotAllowed      = "email domain is not allowed for the identity provider"
+	ErrorSamlProviderDoesNotExist       = "identity provider does not exist"
+	ErrorInvalidSamlResponse            = "saml response is invalid"
+	ErrorSamlEmailMissing               = "saml assertion contains no valid email"
+	ErrorSamlEmailDomainNotAllowed      = "email domain is not allowed for the identity provider"
+	ErrorIdentityNotLinkable            = "email belongs to an existing user that the identity provider may not sign in"
+	ErrorTooManyMagicLinkRequests       = "too many magic link requests"
+	ErrorInvalidMagicLink               = "magic link is invalid or expired"
+	ErrorInvitationDoesNotExist         = "invitation does not exist"
+	ErrorInvitationNotPending           = "invitation is not pending"
+	ErrorInvalidInvitation              = "invitation is invalid or expired"
+	ErrorInvitationAlreadyExists        = "an invitation for the email was created concurrently"
+	ErrorInvalidAttributeNamespace      = "attribute namespace has to match ^[a-z][a-z0-9_]{0,63}$"
+	ErrorAttributeSchemaDoesNotExist    = "attribute schema does not exist"
+	ErrorAttributeSchemaInUse           = "attribute schema is in use by users"
+	ErrorUserNotActive                  = "user is not active"
+	ErrorInvalidStatusTransition        = "user status does not allow the transition"
+	ErrorCannotChangeOwnStatus          = "users can not change their own status"
+	ErrorTokenRevoked                   = "token has been revoked"
+	ErrorStepUpRequired                 = "step-up authentication required"
+	ErrorNetworkNotAllowed              = "client network is not allowed"
+	ErrorFederatedLoginRequired         = "email domain requires login with the identity provider"
+	ErrorWebhookDeliveryDoesNotExist    = "webhook delivery does not exist, or isn't dead"
+	ErrorVersionMismatch                = "version does not match, the user has been modified concurrently"
+	HeaderAuthorization                 = "Authorization"
+	HeaderContentType                   = "Content-Type"
+	HeaderIfMatch                       = "If-Match"
+	HeaderETag                          = "ETag"
+	HeaderContentDisposition            = "Content-Disposition"
+	HeaderRetryAfter                    = "Retry-After"
+	HeaderLastEventId                   = "Last-Event-ID"
+	HeaderCacheControl                  = "Cache-Control"
+	HeaderXForwardedFor                 = "X-Forwarded-For"
+	HeaderWebhookId                     = "Webhook-Id"
+	HeaderWebhookTimestamp              = "Webhook-Timestamp"
+	HeaderWebhookSignature              = "Webhook-Signature"
+	QueryToken                          = "token"
+	QueryFormat                         = "format"
+	QueryDryRun                         = "dry_run"
+	QueryHashedPasswords                = "hashed_passwords"
+	ImportFormatCsv                     = "csv"
+	ImportFormatJsonl                   = "jsonl"
+	MaxImportRows                       = 10000
+	ExportFormatCsv                     = "csv"
+	ExportFormatJsonl                   = "jsonl"
+	ExportFormatParquet                 = "parquet"
+	UserColumnId                        = "id"
+	UserColumnEmail                     = "email"
+	UserColumnFullName                  = "fullname"
+	UserColumnUserGroup                 = "user_group"
+	UserColumnCreatedAt                 = "created_at"
+	UserColumnUpdatedAt                 = "updated_at"
+	UserColumnDeletedAt                 = "deleted_at"
+	ScimSchemaUser                      = "urn:ietf:params:scim:schemas:core:2.0:User"
+	ScimSchemaGroup                     = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	ScimSchemaListResponse              = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	ScimSchemaPatchOp                   = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ScimSchemaError                     = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ScimSchemaServiceProviderConfig     = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	ScimSchemaResourceType              = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	ScimSchemaSchema                    = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	ScimResourceTypeUser                = "User"
+	ScimResourceTypeGroup               = "Group"
+	ScimTypeInvalidFilter               = "invalidFilter"
+	ScimTypeInvalidSyntax               = "invalidSyntax"
+	ScimTypeInvalidPath                 = "invalidPath"
+	ScimTypeInvalidValue                = "invalidValue"
+	ScimTypeMutability                  = "mutability"
+	ScimTypeUniqueness                  = "uniqueness"
+	ScimDefaultCount                    = 100
+	ScimMaxCount                        = 1000
+	ScimSubject                         = "scim"
+	QueryFilter                         = "filter"
+	QueryStartIndex                     = "startIndex"
+	QueryCount                          = "count"
+	QueryExcludedAttributes             = "excludedAttributes"
+	QueryProvider                       = "provider"
+	QueryEmail                          = "email"
+	QueryCode                           = "code"
+	QueryState                          = "state"
+	QueryError                          = "error"
+	QueryErrorDescription               = "error_description"
+	CookieOidcState                     = "user_svc_oidc_state"
+	CookieMagicLinkNonce                = "user_svc_magic_link_nonce"
+	FormSamlResponse                    = "SAMLResponse"
+	DataExportStatusPending             = "pending"
+	DataExportStatusProcessing          = "processing"
+	DataExportStatusCompleted           = "completed"
+	DataExportStatusFailed              = "failed"
+	WebhookDeliveryStatusPending        = "pending"
+	WebhookDeliveryStatusDelivered      = "delivered"
+	WebhookDeliveryStatusDead           = "dead"
+	InvitationStatusPending             = "pending"
+	InvitationStatusAccepted            = "accepted"
+	InvitationStatusRevoked             = "revoked"
+	InvitationStatusExpired             = "expired"
+	PrefixBearer                        = "Bearer "
+	ClaimExp                            = "exp"
+	ClaimIat                            = "iat"
+	ClaimUserGroup                      = "user_group"
+	ClaimSub                            = "sub"
+	ClaimTokenEpoch                     = "token_epoch"
+	ClaimAuthTime                       = "auth_time"
+	SortByCreatedAt                     = "created_at"
+	SortByEmail                         = "email"
+	SortByFullName                      = "fullname"
+	SortByScore                         = "score"
+	SortOrderAsc                        = "asc"
+	SortOrderDesc                       = "desc"
+	DefaultPageSize                     = 50
+	UserGroupUser                       = "user"
+	UserGroupAdmin                      = "admin"
+	RoleGuest                           = "guest"
+	RoleScim                            = "scim"
+	UserStatusPending                   = "pending"
+	UserStatusActive                    = "active"
+	UserStatusSuspended                 = "suspended"
+	UserStatusLocked                    = "locked"
+	UserStatusDeactivated               = "deactivated"
+	LoginOutcomeSuccess                 = "success"
+	LoginOutcomeFailure                 = "failure"
+	LoginMethodPassword                 = "password"
+	LoginMethodReauthenticate           = "reauthenticate"
+	LoginMethodOidc                     = "oidc"
+	LoginMethodSaml                     = "saml"
+	LoginMethodMagicLink                = "magic_link"
+	LoginMethodInvitation               = "invitation"
+	ContextKeyClaims                    = "claims"
+	LogHttpRequest                      = "context.httpRequest"
+	LogUser                             = "context.user"
+	LogId                               = "id"
+	LogRole                             = "role"
+	LogLatency                          = "latency"
+	LogRemoteIp                         = "remote_ip"
+	LogSecurityEvent                    = "security_event"
+	SecurityEventNetworkDenied          = "network_policy.denied"
 )
 
 var (
-	RoleGuestScopes = []string{RouteCreateUser, RouteAuthenticate}
-	RoleUserScopes  = []string{}
-	RoleAdminScopes = []string{RouteListUsers, RouteDeleteUser}
+	RoleGuestScopes = []string{RouteCreateUser, RouteAuthenticate, RouteDownloadDataExport, RouteOidcLogin, RouteOidcCallback, RouteSamlMetadata, RouteSamlAcs, RouteRequestMagicLink, RouteConsumeMagicLink, RouteAcceptInvitation}
+	RoleUserScopes  = []string{RouteGetMe, RouteUpdateProfile, RouteChangePassword, RouteDeleteMyAccount, RouteExportMyData, RouteReauthenticate, RouteListLoginHistory}
+	RoleAdminScopes = []string{RouteListUsers, RouteDeleteUser, RouteUpdateUser, RouteSearchUsers, RouteRestoreUser, RouteExportUserData, RouteListAuditEvents, RouteVerifyAuditEvents, RouteRegisterWebhook, RouteListWebhooks, RouteUpdateWebhook, RoutePauseWebhook, RouteResumeWebhook, RouteDeleteWebhook, RouteRotateWebhookSecret, RoutePingWebhook, RouteListWebhookDeliveryAttempts, RouteListDeadWebhookDeliveries, RouteStreamUserEvents, RouteImportUsers, RouteExportUsers, RouteRetryWebhookDelivery, RouteInviteUser, RouteListInvitations, RouteRevokeInvitation, RoutePutAttributeSchema, RouteListAttributeSchemas, RouteDeleteAttributeSchema, RouteSuspendUser, RouteReactivateUser, RouteGetMe, RouteUpdateProfile, RouteChangePassword, RouteDeleteMyAccount, RouteExportMyData, RouteReauthenticate, RouteListLoginHistory}
 )
+
+// RouteMaxAuthAgeSeconds lists the routes that require a recent authentication, and how many seconds ago
+// the authentication may have been at most. Older tokens have to be refreshed with RouteReauthenticate.
+var RouteMaxAuthAgeSeconds = map[string]int64{
+	RouteDeleteUser:            5 * 60,
+	RouteUpdateUser:            15 * 60,
+	RouteRestoreUser:           15 * 60,
+	RouteSuspendUser:           15 * 60,
+	RouteReactivateUser:        15 * 60,
+	RouteExportUserData:        15 * 60,
+	RouteExportUsers:           15 * 60,
+	RouteImportUsers:           15 * 60,
+	RouteDeleteWebhook:         15 * 60,
+	RouteRotateWebhookSecret:   15 * 60,
+	RouteDeleteAttributeSchema: 15 * 60,
+	RouteDeleteMyAccount:       5 * 60,
+}
+
+var UserExportColumns = []string{UserColumnId, UserColumnEmail, UserColumnFullName, UserColumnUserGroup, UserColumnCreatedAt, UserColumnUpdatedAt, UserColumnDeletedAt}
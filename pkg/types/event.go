@@ -0,0 +1,29 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Event struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Data       json.RawMessage `json:"data"`
+	Sequence   int64           `json:"sequence,omitempty"`
+}
+
+type UserEventData struct {
+	User ListUser `json:"user"`
+}
+
+type OutboxEventModel struct {
+	ID           int64      `db:"id"`
+	EventType    string     `db:"event_type"`
+	AggregateID  string     `db:"aggregate_id"`
+	Payload      []byte     `db:"payload"`
+	CreatedAt    time.Time  `db:"created_at"`
+	DispatchedAt *time.Time `db:"dispatched_at"`
+	PublishedAt  *time.Time `db:"published_at"`
+	StreamSeq    *int64     `db:"stream_seq"`
+}
@@ -0,0 +1,80 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ExportMyDataRequest struct {
+}
+
+type ExportMyDataResponse struct {
+	Error  string      `json:"error"`
+	Export *DataExport `json:"export"`
+}
+
+type ExportUserDataRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type ExportUserDataResponse struct {
+	Error  string      `json:"error"`
+	Export *DataExport `json:"export"`
+}
+
+type DownloadDataExportRequest struct {
+	Token string `validate:"required"`
+}
+
+type DownloadDataExportResponse struct {
+	Error   string `json:"error"`
+	ID      string `json:"-"`
+	Archive []byte `json:"-"`
+}
+
+type DataExport struct {
+	ID          string    `json:"id"`
+	Status      string    `json:"status"`
+	DownloadURL string    `json:"download_url"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type DataExportArchive struct {
+	GeneratedAt   time.Time            `json:"generated_at"`
+	Profile       DataExportProfile    `json:"profile"`
+	AuditEvents   []AuditEvent         `json:"audit_events"`
+	LoginAttempts []LoginAttempt       `json:"login_attempts"`
+	Identities    []DataExportIdentity `json:"identities"`
+}
+
+type DataExportProfile struct {
+	ID         string          `json:"id"`
+	Email      string          `json:"email"`
+	FullName   string          `json:"fullname"`
+	UserGroup  string          `json:"user_group"`
+	Attributes json.RawMessage `json:"attributes"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	DeletedAt  *time.Time      `json:"deleted_at"`
+}
+
+type DataExportIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+type DataExportModel struct {
+	ID          string     `db:"id"`
+	UserID      string     `db:"user_id"`
+	RequestedBy string     `db:"requested_by"`
+	Status      string     `db:"status"`
+	TokenHash   string     `db:"token_hash"`
+	Archive     []byte     `db:"archive"`
+	CreatedAt   time.Time  `db:"created_at"`
+	ClaimedAt   *time.Time `db:"claimed_at"`
+	CompletedAt *time.Time `db:"completed_at"`
+	ExpiresAt   time.Time  `db:"expires_at"`
+}
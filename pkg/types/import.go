@@ -0,0 +1,25 @@
+package types
+
+import "io"
+
+type ImportUsersRequest struct {
+	Format          string    `validate:"required,oneof=csv jsonl"`
+	DryRun          bool      `validate:"-"`
+	HashedPasswords bool      `validate:"-"`
+	Body            io.Reader `validate:"-"`
+}
+
+type ImportUsersResponse struct {
+	Error    string            `json:"error"`
+	DryRun   bool              `json:"dry_run"`
+	Total    int               `json:"total"`
+	Imported int               `json:"imported"`
+	Failed   int               `json:"failed"`
+	Errors   []ImportUserError `json:"errors"`
+}
+
+type ImportUserError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email"`
+	Error string `json:"error"`
+}
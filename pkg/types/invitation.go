@@ -0,0 +1,84 @@
+package types
+
+import "time"
+
+type InviteUserRequest struct {
+	Email            string `json:"email" validate:"required,email"`
+	UserGroup        string `json:"user_group" validate:"required,oneof=user admin"`
+	ExpiresInSeconds int    `json:"expires_in_seconds" validate:"omitempty,min=60,max=2592000"`
+}
+
+type InviteUserResponse struct {
+	Error      string      `json:"error"`
+	Invitation *Invitation `json:"invitation"`
+	Token      string      `json:"token"`
+}
+
+type ListInvitationsRequest struct {
+	PageSize int    `json:"page_size" validate:"omitempty,min=1,max=1000"`
+	Cursor   string `json:"cursor"`
+	Status   string `json:"status" validate:"omitempty,oneof=pending accepted revoked expired"`
+	Email    string `json:"email"`
+}
+
+type ListInvitationsResponse struct {
+	Error       string       `json:"error"`
+	Invitations []Invitation `json:"invitations"`
+	NextCursor  string       `json:"next_cursor"`
+}
+
+type RevokeInvitationRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type RevokeInvitationResponse struct {
+	Error      string      `json:"error"`
+	Invitation *Invitation `json:"invitation"`
+}
+
+type AcceptInvitationRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+	FullName string `json:"fullname" validate:"required"`
+}
+
+type AcceptInvitationResponse struct {
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+type Invitation struct {
+	ID             string     `json:"id"`
+	Email          string     `json:"email"`
+	UserGroup      string     `json:"user_group"`
+	Status         string     `json:"status"`
+	InvitedBy      string     `json:"invited_by"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ExpiresAt      time.Time  `json:"expires_at"`
+	AcceptedAt     *time.Time `json:"accepted_at"`
+	AcceptedUserID *string    `json:"accepted_user_id"`
+	RevokedAt      *time.Time `json:"revoked_at"`
+	RevokedBy      *string    `json:"revoked_by"`
+}
+
+type InvitationModel struct {
+	ID             string     `db:"id"`
+	Email          string     `db:"email"`
+	UserGroup      string     `db:"user_group"`
+	InvitedBy      string     `db:"invited_by"`
+	TokenHash      string     `db:"token_hash"`
+	CreatedAt      time.Time  `db:"created_at"`
+	ExpiresAt      time.Time  `db:"expires_at"`
+	AcceptedAt     *time.Time `db:"accepted_at"`
+	AcceptedUserID *string    `db:"accepted_user_id"`
+	RevokedAt      *time.Time `db:"revoked_at"`
+	RevokedBy      *string    `db:"revoked_by"`
+}
+
+type InvitationsQuery struct {
+	Limit           int
+	Status          string
+	Email           string
+	BeforeCreatedAt *time.Time
+	BeforeID        string
+}
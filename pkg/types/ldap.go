@@ -0,0 +1,18 @@
+package types
+
+type LdapConfig struct {
+	URL              string            `json:"url" validate:"required,url"`
+	StartTLS         bool              `json:"start_tls"`
+	CACertFile       string            `json:"ca_cert_file"`
+	BindDN           string            `json:"bind_dn" validate:"required"`
+	BindPassword     string            `json:"bind_password" validate:"required"`
+	BaseDN           string            `json:"base_dn" validate:"required"`
+	UserFilter       string            `json:"user_filter"`
+	SubjectAttribute string            `json:"subject_attribute"`
+	NameAttribute    string            `json:"name_attribute"`
+	GroupAttribute   string            `json:"group_attribute"`
+	GroupMapping     map[string]string `json:"group_mapping" validate:"dive,oneof=user admin"`
+	DefaultUserGroup string            `json:"default_user_group" validate:"omitempty,oneof=user admin"`
+	PoolSize         int               `json:"pool_size" validate:"gte=0"`
+	TimeoutSeconds   int               `json:"timeout_seconds" validate:"gte=0"`
+}
@@ -0,0 +1,60 @@
+package types
+
+import "time"
+
+type ListLoginHistoryRequest struct {
+	PageSize int    `json:"page_size" validate:"omitempty,min=1,max=1000"`
+	Cursor   string `json:"cursor"`
+	UserID   string `json:"user_id" validate:"omitempty,uuid"`
+	Email    string `json:"email" validate:"omitempty,email"`
+	Outcome  string `json:"outcome" validate:"omitempty,oneof=success failure"`
+}
+
+type ListLoginHistoryResponse struct {
+	Error      string         `json:"error"`
+	Attempts   []LoginAttempt `json:"attempts"`
+	NextCursor string         `json:"next_cursor"`
+}
+
+type LoginAttempt struct {
+	ID            int64     `json:"id"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	UserID        string    `json:"user_id"`
+	Email         string    `json:"email"`
+	Method        string    `json:"method"`
+	IP            string    `json:"ip"`
+	UserAgent     string    `json:"user_agent"`
+	Outcome       string    `json:"outcome"`
+	FailureReason string    `json:"failure_reason"`
+}
+
+type LoginAttemptsQuery struct {
+	Limit    int
+	BeforeID int64
+	UserID   string
+	Email    string
+	Outcome  string
+}
+
+type LoginAttemptModel struct {
+	ID            int64     `db:"id"`
+	OccurredAt    time.Time `db:"occurred_at"`
+	UserID        *string   `db:"user_id"`
+	Email         string    `db:"email"`
+	Method        string    `db:"method"`
+	IP            string    `db:"ip"`
+	UserAgent     string    `db:"user_agent"`
+	Outcome       string    `db:"outcome"`
+	FailureReason string    `db:"failure_reason"`
+}
+
+type LoginDeviceModel struct {
+	HasLogins bool `db:"has_logins"`
+	IsKnown   bool `db:"is_known"`
+}
+
+type LastLoginModel struct {
+	UserID     string    `db:"user_id"`
+	OccurredAt time.Time `db:"occurred_at"`
+	IP         string    `db:"ip"`
+}
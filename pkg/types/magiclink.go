@@ -0,0 +1,32 @@
+package types
+
+import "time"
+
+type RequestMagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type RequestMagicLinkResponse struct {
+	Error string `json:"error"`
+	Nonce string `json:"-"`
+}
+
+type ConsumeMagicLinkRequest struct {
+	Token string `json:"token" validate:"required"`
+	Nonce string `json:"-"`
+}
+
+type ConsumeMagicLinkResponse struct {
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+type MagicLinkModel struct {
+	TokenHash  string     `db:"token_hash"`
+	Email      string     `db:"email"`
+	UserID     *string    `db:"user_id"`
+	NonceHash  string     `db:"nonce_hash"`
+	CreatedAt  time.Time  `db:"created_at"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	ConsumedAt *time.Time `db:"consumed_at"`
+}
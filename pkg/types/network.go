@@ -0,0 +1,8 @@
+package types
+
+type NetworkPolicy struct {
+	Allow []string `json:"allow" validate:"dive,cidr"`
+	Deny  []string `json:"deny" validate:"dive,cidr"`
+}
+
+type NetworkPolicies map[string]NetworkPolicy
@@ -0,0 +1,56 @@
+package types
+
+import "time"
+
+type OidcProvider struct {
+	Name         string   `json:"name" validate:"required"`
+	Issuer       string   `json:"issuer" validate:"required,url"`
+	ClientID     string   `json:"client_id" validate:"required"`
+	ClientSecret string   `json:"client_secret"`
+	RedirectURL  string   `json:"redirect_url" validate:"required,url"`
+	Scopes       []string `json:"scopes"`
+	Domains      []string `json:"domains" validate:"dive,fqdn"`
+	// LinkExistingUsers trusts the provider to sign in existing users with emails outside of its domains
+	LinkExistingUsers bool `json:"link_existing_users"`
+}
+
+type OidcLoginRequest struct {
+	Provider string `json:"provider" validate:"required_without=Email"`
+	Email    string `json:"email" validate:"omitempty,email"`
+}
+
+type OidcLoginResponse struct {
+	Error            string `json:"error"`
+	AuthorizationURL string `json:"authorization_url"`
+	LoginState       string `json:"-"`
+}
+
+type OidcCallbackRequest struct {
+	Code             string `json:"code"`
+	State            string `json:"state" validate:"required"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+	LoginState       string `json:"-" validate:"required"`
+}
+
+type OidcCallbackResponse struct {
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+type OidcLoginState struct {
+	Provider     string    `json:"provider"`
+	State        string    `json:"state"`
+	Nonce        string    `json:"nonce"`
+	CodeVerifier string    `json:"code_verifier"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+type UserIdentityModel struct {
+	Provider    string    `db:"provider"`
+	Subject     string    `db:"subject"`
+	UserID      string    `db:"user_id"`
+	Email       string    `db:"email"`
+	CreatedAt   time.Time `db:"created_at"`
+	LastLoginAt time.Time `db:"last_login_at"`
+}
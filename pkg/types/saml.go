@@ -0,0 +1,43 @@
+package types
+
+import (
+	"time"
+)
+
+type SamlProvider struct {
+	Name            string   `json:"name" validate:"required"`
+	IdpMetadataFile string   `json:"idp_metadata_file" validate:"required"`
+	EntityID        string   `json:"entity_id" validate:"required"`
+	AcsURL          string   `json:"acs_url" validate:"required,url"`
+	EmailAttribute  string   `json:"email_attribute"`
+	NameAttribute   string   `json:"name_attribute"`
+	Domains         []string `json:"domains" validate:"dive,fqdn"`
+	// LinkExistingUsers trusts the provider to sign in existing users, even if it has no domains
+	LinkExistingUsers bool `json:"link_existing_users"`
+}
+
+type SamlMetadataRequest struct {
+	Provider string `json:"provider" validate:"required"`
+}
+
+type SamlMetadataResponse struct {
+	Error    string `json:"error"`
+	Metadata []byte `json:"-"`
+}
+
+type SamlAcsRequest struct {
+	Provider     string `json:"provider" validate:"required"`
+	SamlResponse string `json:"saml_response" validate:"required,base64"`
+}
+
+type SamlAcsResponse struct {
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+type SamlAssertionModel struct {
+	Provider   string    `db:"provider"`
+	ID         string    `db:"id"`
+	ConsumedAt time.Time `db:"consumed_at"`
+	ExpiresAt  time.Time `db:"expires_at"`
+}
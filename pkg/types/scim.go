@@ -0,0 +1,125 @@
+package types
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type ScimMeta struct {
+	ResourceType string     `json:"resourceType"`
+	Created      *time.Time `json:"created,omitempty"`
+	LastModified *time.Time `json:"lastModified,omitempty"`
+	Location     string     `json:"location"`
+	Version      string     `json:"version,omitempty"`
+}
+
+type ScimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type ScimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimGroupRef struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type ScimUser struct {
+	Schemas     []string       `json:"schemas"`
+	ID          string         `json:"id,omitempty"`
+	ExternalID  string         `json:"externalId,omitempty"`
+	UserName    string         `json:"userName" validate:"required,email"`
+	Name        *ScimName      `json:"name,omitempty"`
+	DisplayName string         `json:"displayName,omitempty"`
+	Emails      []ScimEmail    `json:"emails,omitempty"`
+	Active      *bool          `json:"active,omitempty"`
+	Password    string         `json:"password,omitempty"`
+	Groups      []ScimGroupRef `json:"groups,omitempty"`
+	Meta        *ScimMeta      `json:"meta,omitempty"`
+}
+
+type ScimMember struct {
+	Value   string `json:"value"`
+	Ref     string `json:"$ref,omitempty"`
+	Display string `json:"display,omitempty"`
+}
+
+type ScimGroup struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []ScimMember `json:"members,omitempty"`
+	Meta        *ScimMeta    `json:"meta,omitempty"`
+}
+
+type ScimListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int         `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+type ScimPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []ScimPatchOperation `json:"Operations" validate:"required,min=1,dive"`
+}
+
+type ScimPatchOperation struct {
+	Op    string          `json:"op" validate:"required"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type ScimError struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+type ScimListRequest struct {
+	Filter             string
+	StartIndex         int
+	Count              int
+	ExcludedAttributes string
+}
+
+type ScimUserResponse struct {
+	User  ScimUser
+	Error *ScimError
+}
+
+type ScimGroupResponse struct {
+	Group ScimGroup
+	Error *ScimError
+}
+
+type ScimListUsersResponse struct {
+	List  ScimListResponse
+	Error *ScimError
+}
+
+type ScimListGroupsResponse struct {
+	List  ScimListResponse
+	Error *ScimError
+}
+
+type ScimDeleteResponse struct {
+	Error *ScimError
+}
+
+type ScimUsersQuery struct {
+	Email     string
+	UserGroup string
+	Active    bool
+	Offset    int
+	Limit     int
+}
@@ -1,6 +1,9 @@
 package types
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type CreateUserRequest struct {
 	Email    string `json:"email" validate:"required,email"`
@@ -21,6 +24,34 @@ type DeleteUserResponse struct {
 }
 
 type ListUsersRequest struct {
+	PageSize      int             `json:"page_size" validate:"omitempty,min=1,max=1000"`
+	Cursor        string          `json:"cursor"`
+	SortBy        string          `json:"sort_by" validate:"omitempty,oneof=created_at email fullname"`
+	SortOrder     string          `json:"sort_order" validate:"omitempty,oneof=asc desc"`
+	UserGroup     string          `json:"user_group" validate:"omitempty,oneof=user admin"`
+	CreatedAfter  *time.Time      `json:"created_after"`
+	CreatedBefore *time.Time      `json:"created_before"`
+	EmailDomain   string          `json:"email_domain" validate:"omitempty,fqdn"`
+	Deleted       bool            `json:"deleted"`
+	Status        string          `json:"status" validate:"omitempty,oneof=pending active suspended locked deactivated"`
+	Attributes    json.RawMessage `json:"attributes"`
+}
+
+type ExportUsersRequest struct {
+	Format        string     `json:"format" validate:"required,oneof=csv jsonl parquet"`
+	Columns       []string   `json:"columns" validate:"omitempty,unique,dive,oneof=id email fullname user_group created_at updated_at deleted_at"`
+	SortBy        string     `json:"sort_by" validate:"omitempty,oneof=created_at email fullname"`
+	SortOrder     string     `json:"sort_order" validate:"omitempty,oneof=asc desc"`
+	UserGroup     string     `json:"user_group" validate:"omitempty,oneof=user admin"`
+	CreatedAfter  *time.Time `json:"created_after"`
+	CreatedBefore *time.Time `json:"created_before"`
+	EmailDomain   string     `json:"email_domain" validate:"omitempty,fqdn"`
+	Deleted       bool       `json:"deleted"`
+	Status        string     `json:"status" validate:"omitempty,oneof=pending active suspended locked deactivated"`
+}
+
+type ExportUsersResponse struct {
+	Error string `json:"error"`
 }
 
 type ErrorResponse struct {
@@ -28,14 +59,22 @@ type ErrorResponse struct {
 }
 
 type ListUsersResponse struct {
-	Error string     `json:"error"`
-	Users []ListUser `json:"users"`
+	Error      string     `json:"error"`
+	Users      []ListUser `json:"users"`
+	NextCursor string     `json:"next_cursor"`
 }
 
 type ListUser struct {
-	ID       string `json:"id"`
-	Email    string `json:"email"`
-	FullName string `json:"fullname"`
+	ID          string          `json:"id"`
+	Email       string          `json:"email"`
+	FullName    string          `json:"fullname"`
+	UserGroup   string          `json:"user_group"`
+	Status      string          `json:"status"`
+	Attributes  json.RawMessage `json:"attributes"`
+	LastLoginAt *time.Time      `json:"last_login_at"`
+	LastLoginIP string          `json:"last_login_ip"`
+	CreatedAt   time.Time       `json:"created_at"`
+	DeletedAt   *time.Time      `json:"deleted_at,omitempty"`
 }
 
 type AuthenticateRequest struct {
@@ -48,12 +87,176 @@ type AuthenticateResponse struct {
 	AccessToken string `json:"access_token"`
 }
 
+type ReauthenticateRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type ReauthenticateResponse struct {
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+type User struct {
+	ID              string          `json:"id"`
+	Email           string          `json:"email"`
+	FullName        string          `json:"fullname"`
+	UserGroup       string          `json:"user_group"`
+	Status          string          `json:"status"`
+	StatusReason    string          `json:"status_reason"`
+	StatusChangedAt *time.Time      `json:"status_changed_at"`
+	Attributes      json.RawMessage `json:"attributes"`
+	CreatedAt       time.Time       `json:"created_at"`
+	UpdatedAt       time.Time       `json:"updated_at"`
+	Version         string          `json:"version"`
+}
+
+type GetMeRequest struct {
+}
+
+type GetMeResponse struct {
+	Error string `json:"error"`
+	User  *User  `json:"user"`
+}
+
+type UpdateProfileRequest struct {
+	FullName string `json:"fullname" validate:"required"`
+}
+
+type UpdateProfileResponse struct {
+	Error string `json:"error"`
+	User  *User  `json:"user"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type ChangePasswordResponse struct {
+	Error       string `json:"error"`
+	AccessToken string `json:"access_token"`
+}
+
+type DeleteMyAccountRequest struct {
+}
+
+type DeleteMyAccountResponse struct {
+	Error string `json:"error"`
+}
+
+type UpdateUserRequest struct {
+	ID         string                     `json:"id" validate:"required,uuid"`
+	Email      string                     `json:"email" validate:"required,email"`
+	FullName   string                     `json:"fullname" validate:"required"`
+	UserGroup  string                     `json:"user_group" validate:"required,oneof=user admin"`
+	Attributes map[string]json.RawMessage `json:"attributes"`
+	Version    string                     `json:"version"`
+}
+
+type UpdateUserResponse struct {
+	Error string `json:"error"`
+	User  *User  `json:"user"`
+}
+
+type SearchUsersRequest struct {
+	Query    string `json:"query" validate:"required,max=256"`
+	PageSize int    `json:"page_size" validate:"omitempty,min=1,max=1000"`
+	Cursor   string `json:"cursor"`
+}
+
+type SearchUsersResponse struct {
+	Error      string       `json:"error"`
+	Users      []SearchUser `json:"users"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+type SearchUser struct {
+	ListUser
+	Score      float32           `json:"score"`
+	Highlights []SearchHighlight `json:"highlights"`
+}
+
+type SearchHighlight struct {
+	Field    string `json:"field"`
+	Fragment string `json:"fragment"`
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+type UsersSearchQuery struct {
+	Query      string
+	Limit      int
+	AfterScore *float32
+	AfterID    string
+}
+
+type UserSearchModel struct {
+	UserModel
+	Score float32 `db:"score"`
+}
+
+type UsersQuery struct {
+	Limit         int
+	SortBy        string
+	SortOrder     string
+	AfterValue    interface{}
+	AfterID       string
+	UserGroup     string
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	EmailDomain   string
+	Deleted       bool
+	Status        string
+	Attributes    []byte
+}
+
+type SuspendUserRequest struct {
+	ID     string `json:"id" validate:"required,uuid"`
+	Reason string `json:"reason" validate:"required,max=512"`
+}
+
+type SuspendUserResponse struct {
+	Error string `json:"error"`
+	User  *User  `json:"user"`
+}
+
+type ReactivateUserRequest struct {
+	ID     string `json:"id" validate:"required,uuid"`
+	Reason string `json:"reason" validate:"required,max=512"`
+}
+
+type ReactivateUserResponse struct {
+	Error string `json:"error"`
+	User  *User  `json:"user"`
+}
+
+type RestoreUserRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type RestoreUserResponse struct {
+	Error string `json:"error"`
+	User  *User  `json:"user"`
+}
+
 type UserModel struct {
-	ID        string    `db:"id"`
-	Email     string    `db:"email"`
-	Password  string    `db:"password"`
-	FullName  string    `db:"fullname"`
-	UserGroup string    `db:"user_group"`
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	ID              string     `db:"id"`
+	Email           string     `db:"email"`
+	Password        string     `db:"password"`
+	FullName        string     `db:"fullname"`
+	UserGroup       string     `db:"user_group"`
+	ExternalID      string     `db:"external_id"`
+	Status          string     `db:"status"`
+	StatusReason    string     `db:"status_reason"`
+	StatusChangedAt *time.Time `db:"status_changed_at"`
+	TokenEpoch      int        `db:"token_epoch"`
+	Attributes      []byte     `db:"attributes"`
+	CreatedAt       time.Time  `db:"created_at"`
+	UpdatedAt       time.Time  `db:"updated_at"`
+	DeletedAt       *time.Time `db:"deleted_at"`
+}
+
+type UserTokenStateModel struct {
+	Status     string `db:"status"`
+	TokenEpoch int    `db:"token_epoch"`
 }
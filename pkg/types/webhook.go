@@ -0,0 +1,206 @@
+package types
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type RegisterWebhookRequest struct {
+	URL        string   `json:"url" validate:"required,url,max=2048"`
+	EventTypes []string `json:"event_types" validate:"dive,oneof=user.created user.deleted user.restored user.group_changed user.status_changed"`
+}
+
+type RegisterWebhookResponse struct {
+	Error   string   `json:"error"`
+	Webhook *Webhook `json:"webhook"`
+}
+
+type ListWebhooksRequest struct {
+}
+
+type ListWebhooksResponse struct {
+	Error    string    `json:"error"`
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type UpdateWebhookRequest struct {
+	ID         string   `json:"id" validate:"required,uuid"`
+	URL        string   `json:"url" validate:"required,url,max=2048"`
+	EventTypes []string `json:"event_types" validate:"dive,oneof=user.created user.deleted user.restored user.group_changed user.status_changed"`
+}
+
+type UpdateWebhookResponse struct {
+	Error   string   `json:"error"`
+	Webhook *Webhook `json:"webhook"`
+}
+
+type PauseWebhookRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type PauseWebhookResponse struct {
+	Error   string   `json:"error"`
+	Webhook *Webhook `json:"webhook"`
+}
+
+type ResumeWebhookRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type ResumeWebhookResponse struct {
+	Error   string   `json:"error"`
+	Webhook *Webhook `json:"webhook"`
+}
+
+type DeleteWebhookRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type DeleteWebhookResponse struct {
+	Error string `json:"error"`
+}
+
+type RotateWebhookSecretRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type RotateWebhookSecretResponse struct {
+	Error   string   `json:"error"`
+	Webhook *Webhook `json:"webhook"`
+}
+
+type PingWebhookRequest struct {
+	ID string `json:"id" validate:"required,uuid"`
+}
+
+type PingWebhookResponse struct {
+	Error   string                  `json:"error"`
+	Attempt *WebhookDeliveryAttempt `json:"attempt"`
+}
+
+type ListWebhookDeliveryAttemptsRequest struct {
+	WebhookID string `json:"webhook_id" validate:"required,uuid"`
+	PageSize  int    `json:"page_size" validate:"omitempty,min=1,max=1000"`
+	Cursor    string `json:"cursor"`
+}
+
+type ListWebhookDeliveryAttemptsResponse struct {
+	Error      string                   `json:"error"`
+	Attempts   []WebhookDeliveryAttempt `json:"attempts"`
+	NextCursor string                   `json:"next_cursor"`
+}
+
+type ListDeadWebhookDeliveriesRequest struct {
+	PageSize  int    `json:"page_size" validate:"omitempty,min=1,max=1000"`
+	Cursor    string `json:"cursor"`
+	WebhookID string `json:"webhook_id" validate:"omitempty,uuid"`
+}
+
+type ListDeadWebhookDeliveriesResponse struct {
+	Error      string            `json:"error"`
+	Deliveries []WebhookDelivery `json:"deliveries"`
+	NextCursor string            `json:"next_cursor"`
+}
+
+type RetryWebhookDeliveryRequest struct {
+	ID int64 `json:"id" validate:"required,min=1"`
+}
+
+type RetryWebhookDeliveryResponse struct {
+	Error    string           `json:"error"`
+	Delivery *WebhookDelivery `json:"delivery"`
+}
+
+type Webhook struct {
+	ID         string     `json:"id"`
+	URL        string     `json:"url"`
+	Secret     string     `json:"secret,omitempty"`
+	EventTypes []string   `json:"event_types"`
+	PausedAt   *time.Time `json:"paused_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+type WebhookDeliveryAttempt struct {
+	ID          int64     `json:"id"`
+	WebhookID   string    `json:"webhook_id"`
+	DeliveryID  *int64    `json:"delivery_id"`
+	EventID     *int64    `json:"event_id"`
+	EventType   string    `json:"event_type"`
+	AttemptedAt time.Time `json:"attempted_at"`
+	StatusCode  int       `json:"status_code"`
+	LatencyMs   int64     `json:"latency_ms"`
+	Error       string    `json:"error"`
+}
+
+type WebhookDelivery struct {
+	ID             int64     `json:"id"`
+	WebhookID      string    `json:"webhook_id"`
+	EventID        int64     `json:"event_id"`
+	EventType      string    `json:"event_type"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	NextAttemptAt  time.Time `json:"next_attempt_at"`
+	LastStatusCode int       `json:"last_status_code"`
+	LastError      string    `json:"last_error"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+type WebhookEndpointModel struct {
+	ID         string         `db:"id"`
+	URL        string         `db:"url"`
+	Secret     string         `db:"secret"`
+	EventTypes pq.StringArray `db:"event_types"`
+	PausedAt   *time.Time     `db:"paused_at"`
+	CreatedAt  time.Time      `db:"created_at"`
+	UpdatedAt  time.Time      `db:"updated_at"`
+}
+
+type WebhookDeliveryAttemptModel struct {
+	ID          int64     `db:"id"`
+	WebhookID   string    `db:"webhook_id"`
+	DeliveryID  *int64    `db:"delivery_id"`
+	EventID     *int64    `db:"event_id"`
+	EventType   string    `db:"event_type"`
+	AttemptedAt time.Time `db:"attempted_at"`
+	StatusCode  int       `db:"status_code"`
+	LatencyMs   int64     `db:"latency_ms"`
+	Error       string    `db:"error"`
+}
+
+type WebhookDeliveryAttemptsQuery struct {
+	Limit     int
+	BeforeID  int64
+	WebhookID string
+}
+
+type WebhookDeliveryModel struct {
+	ID             int64     `db:"id"`
+	WebhookID      string    `db:"webhook_id"`
+	EventID        int64     `db:"event_id"`
+	EventType      string    `db:"event_type"`
+	Status         string    `db:"status"`
+	Attempts       int       `db:"attempts"`
+	NextAttemptAt  time.Time `db:"next_attempt_at"`
+	LastStatusCode int       `db:"last_status_code"`
+	LastError      string    `db:"last_error"`
+	CreatedAt      time.Time `db:"created_at"`
+	UpdatedAt      time.Time `db:"updated_at"`
+}
+
+type ClaimedWebhookDeliveryModel struct {
+	WebhookDeliveryModel
+	URL            string    `db:"url"`
+	Secret         string    `db:"secret"`
+	EventCreatedAt time.Time `db:"event_created_at"`
+	EventPayload   []byte    `db:"event_payload"`
+}
+
+type WebhookDeliveriesQuery struct {
+	Limit     int
+	BeforeID  int64
+	WebhookID string
+	Status    string
+}
@@ -19,3 +19,51 @@ func GetContextLogger(ctx context.Context) (logger *zap.SugaredLogger) {
 func WithContextLogger(ctx context.Context, logger *zap.SugaredLogger) context.Context {
 	return context.WithValue(ctx, contextLoggerKey{}, logger)
 }
+
+type requestIdKey struct{}
+
+func GetRequestId(ctx context.Context) (id string) {
+	id, _ = ctx.Value(requestIdKey{}).(string)
+
+	return
+}
+
+func WithRequestId(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, id)
+}
+
+type remoteIpKey struct{}
+
+func GetRemoteIp(ctx context.Context) (ip string) {
+	ip, _ = ctx.Value(remoteIpKey{}).(string)
+
+	return
+}
+
+func WithRemoteIp(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, remoteIpKey{}, ip)
+}
+
+type userAgentKey struct{}
+
+func GetUserAgent(ctx context.Context) (userAgent string) {
+	userAgent, _ = ctx.Value(userAgentKey{}).(string)
+
+	return
+}
+
+func WithUserAgent(ctx context.Context, userAgent string) context.Context {
+	return context.WithValue(ctx, userAgentKey{}, userAgent)
+}
+
+type subjectKey struct{}
+
+func GetSubject(ctx context.Context) (sub string) {
+	sub, _ = ctx.Value(subjectKey{}).(string)
+
+	return
+}
+
+func WithSubject(ctx context.Context, sub string) context.Context {
+	return context.WithValue(ctx, subjectKey{}, sub)
+}
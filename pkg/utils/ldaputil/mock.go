@@ -0,0 +1,434 @@
+package ldaputil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/pkg/errors"
+)
+
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+type MockEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+// MockServer is an LDAP directory for tests. It supports simple binds, searches with and, or, not, equality, and
+// presence filters, and StartTLS. Searches require a non-anonymous bind.
+type MockServer struct {
+	URL    string
+	CACert []byte
+
+	listener  net.Listener
+	tlsConfig *tls.Config
+	wg        sync.WaitGroup
+
+	mu          sync.Mutex
+	entries     []MockEntry
+	conns       map[net.Conn]bool
+	connections int
+	closed      bool
+}
+
+// NewMockServer listens on a random local port, with ldaps if implicitTLS is set, and with ldap and StartTLS
+// otherwise. CACert is the PEM encoded self-signed certificate of the server.
+func NewMockServer(implicitTLS bool) (s *MockServer, err error) {
+	cert, certPEM, err := newSelfSignedCert()
+	if err != nil {
+		err = errors.Wrap(err, "failed to create certificate")
+
+		return
+	}
+
+	s = &MockServer{
+		CACert:    certPEM,
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		conns:     map[net.Conn]bool{},
+	}
+
+	scheme := "ldap"
+	if implicitTLS {
+		scheme = "ldaps"
+		s.listener, err = tls.Listen("tcp", "127.0.0.1:0", s.tlsConfig)
+	} else {
+		s.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		err = errors.Wrap(err, "failed to listen")
+
+		return
+	}
+
+	s.URL = scheme + "://" + s.listener.Addr().String()
+
+	s.wg.Add(1)
+	go s.serve()
+
+	return
+}
+
+// SetEntry adds the entry, or replaces the entry with the same DN.
+func (s *MockServer) SetEntry(e MockEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.entries {
+		if strings.EqualFold(s.entries[i].DN, e.DN) {
+			s.entries[i] = e
+
+			return
+		}
+	}
+
+	s.entries = append(s.entries, e)
+}
+
+// Connections returns the number of connections accepted so far.
+func (s *MockServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.connections
+}
+
+func (s *MockServer) Close() {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		_ = c.Close()
+	}
+	s.mu.Unlock()
+
+	_ = s.listener.Close()
+
+	s.wg.Wait()
+}
+
+func (s *MockServer) serve() {
+	defer s.wg.Done()
+
+	for {
+		c, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = c.Close()
+
+			return
+		}
+		s.conns[c] = true
+		s.connections++
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+func (s *MockServer) handle(c net.Conn) {
+	defer s.wg.Done()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+
+		_ = c.Close()
+	}()
+
+	var boundDN string
+	for {
+		p, err := ber.ReadPacket(c)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+
+		id, ok := p.Children[0].Value.(int64)
+		if !ok {
+			return
+		}
+
+		op := p.Children[1]
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			name, password := "", ""
+			if len(op.Children) == 3 {
+				name = packetString(op.Children[1])
+				password = packetString(op.Children[2])
+			}
+
+			code, diagnostic := uint16(ldap.LDAPResultSuccess), ""
+			switch {
+			case name == "" && password == "":
+				boundDN = ""
+			case s.bind(name, password):
+				boundDN = name
+			default:
+				boundDN = ""
+				code, diagnostic = ldap.LDAPResultInvalidCredentials, "invalid credentials"
+			}
+
+			err = writeResult(c, id, ldap.ApplicationBindResponse, code, diagnostic)
+		case ldap.ApplicationSearchRequest:
+			err = s.search(c, id, op, boundDN)
+		case ldap.ApplicationExtendedRequest:
+			if len(op.Children) == 0 || packetString(op.Children[0]) != startTLSOID {
+				err = writeResult(c, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultProtocolError, "unsupported extended operation")
+
+				break
+			}
+
+			if _, ok := c.(*tls.Conn); ok {
+				err = writeResult(c, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultOperationsError, "tls is already established")
+
+				break
+			}
+
+			err = writeResult(c, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultSuccess, "")
+			if err != nil {
+				break
+			}
+
+			tc := tls.Server(c, s.tlsConfig)
+			err = tc.Handshake()
+			if err != nil {
+				break
+			}
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.conns[tc] = true
+			s.mu.Unlock()
+
+			c = tc
+		case ldap.ApplicationUnbindRequest:
+			return
+		case ldap.ApplicationAbandonRequest:
+		default:
+			err = writeResult(c, id, ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform, "unsupported operation")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (s *MockServer) bind(dn string, password string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) {
+			return e.Password != "" && e.Password == password
+		}
+	}
+
+	return false
+}
+
+func (s *MockServer) search(c net.Conn, id int64, op *ber.Packet, boundDN string) (err error) {
+	if boundDN == "" {
+		return writeResult(c, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights, "anonymous search is not allowed")
+	}
+
+	if len(op.Children) < 7 {
+		return writeResult(c, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, "malformed search request")
+	}
+
+	base := strings.ToLower(packetString(op.Children[0]))
+	scope, _ := op.Children[1].Value.(int64)
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+
+	s.mu.Lock()
+	var matches []MockEntry
+	for _, e := range s.entries {
+		dn := strings.ToLower(e.DN)
+
+		var inScope bool
+		switch scope {
+		case ldap.ScopeBaseObject:
+			inScope = dn == base
+		case ldap.ScopeSingleLevel:
+			i := strings.Index(dn, ",")
+			inScope = i >= 0 && dn[i+1:] == base
+		default:
+			inScope = dn == base || strings.HasSuffix(dn, ","+base)
+		}
+
+		if inScope && matchFilter(filter, e) {
+			matches = append(matches, e)
+		}
+	}
+	s.mu.Unlock()
+
+	for i, e := range matches {
+		if sizeLimit > 0 && int64(i) >= sizeLimit {
+			return writeResult(c, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded, "size limit exceeded")
+		}
+
+		err = writeEntry(c, id, e)
+		if err != nil {
+			return
+		}
+	}
+
+	return writeResult(c, id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "")
+}
+
+func matchFilter(f *ber.Packet, e MockEntry) bool {
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !matchFilter(c, e) {
+				return false
+			}
+		}
+
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if matchFilter(c, e) {
+				return true
+			}
+		}
+
+		return false
+	case ldap.FilterNot:
+		return len(f.Children) == 1 && !matchFilter(f.Children[0], e)
+	case ldap.FilterEqualityMatch:
+		if len(f.Children) != 2 {
+			return false
+		}
+
+		for _, v := range entryValues(e, packetString(f.Children[0])) {
+			if strings.EqualFold(v, packetString(f.Children[1])) {
+				return true
+			}
+		}
+
+		return false
+	case ldap.FilterPresent:
+		return len(entryValues(e, f.Data.String())) > 0
+	default:
+		return false
+	}
+}
+
+func entryValues(e MockEntry, attribute string) []string {
+	for k, vs := range e.Attributes {
+		if strings.EqualFold(k, attribute) {
+			return vs
+		}
+	}
+
+	return nil
+}
+
+func packetString(p *ber.Packet) string {
+	s, ok := p.Value.(string)
+	if ok {
+		return s
+	}
+
+	return p.Data.String()
+}
+
+func newMessage(id int64) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+
+	return p
+}
+
+func writeResult(c net.Conn, id int64, tag ber.Tag, code uint16, diagnostic string) (err error) {
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	r.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, diagnostic, "Diagnostic Message"))
+
+	p := newMessage(id)
+	p.AppendChild(r)
+
+	_, err = c.Write(p.Bytes())
+
+	return
+}
+
+func writeEntry(c net.Conn, id int64, e MockEntry) (err error) {
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for k, vs := range e.Attributes {
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, "Type"))
+
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range vs {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		a.AppendChild(values)
+
+		attributes.AppendChild(a)
+	}
+
+	r := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	r.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	r.AppendChild(attributes)
+
+	p := newMessage(id)
+	p.AppendChild(r)
+
+	_, err = c.Write(p.Bytes())
+
+	return
+}
+
+func newSelfSignedCert() (cert tls.Certificate, certPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate key")
+
+		return
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap mock"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		err = errors.Wrap(err, "failed to create certificate")
+
+		return
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+
+	return
+}
@@ -0,0 +1,52 @@
+package mailutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ppwfx/user-svc/pkg/mailing"
+)
+
+// MockMailer keeps sent messages in memory for tests.
+type MockMailer struct {
+	mu       sync.Mutex
+	messages []mailing.Message
+}
+
+func (mlr *MockMailer) Send(ctx context.Context, msg mailing.Message) (err error) {
+	mlr.mu.Lock()
+	defer mlr.mu.Unlock()
+
+	mlr.messages = append(mlr.messages, msg)
+
+	return
+}
+
+// Messages returns the messages sent to an address, oldest first.
+func (mlr *MockMailer) Messages(to string) (msgs []mailing.Message) {
+	mlr.mu.Lock()
+	defer mlr.mu.Unlock()
+
+	for _, msg := range mlr.messages {
+		if msg.To == to {
+			msgs = append(msgs, msg)
+		}
+	}
+
+	return
+}
+
+// WaitForMessages returns the messages sent to an address once there are at least n of them, or after the timeout,
+// as mails can be sent after the response.
+func (mlr *MockMailer) WaitForMessages(to string, n int, timeout time.Duration) (msgs []mailing.Message) {
+	deadline := time.Now().Add(timeout)
+	for {
+		msgs = mlr.Messages(to)
+		if len(msgs) >= n || time.Now().After(deadline) {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
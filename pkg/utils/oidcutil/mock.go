@@ -0,0 +1,256 @@
+package oidcutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const mockKeyId = "mock"
+
+type MockIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+type mockGrant struct {
+	identity      MockIdentity
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+// MockProvider is an OpenID Connect provider for tests. It signs in whoever was last set with SetIdentity,
+// without asking for credentials.
+type MockProvider struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu           sync.Mutex
+	identity     MockIdentity
+	tamperClaims func(claims map[string]interface{})
+	grants       map[string]mockGrant
+}
+
+func NewMockProvider(clientID string, clientSecret string) (p *MockProvider, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate rsa key")
+
+		return
+	}
+
+	p = &MockProvider{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		grants:       map[string]mockGrant{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", p.handleDiscovery)
+	mux.HandleFunc("/keys", p.handleKeys)
+	mux.HandleFunc("/authorize", p.handleAuthorize)
+	mux.HandleFunc("/token", p.handleToken)
+
+	p.server = httptest.NewServer(mux)
+	p.URL = p.server.URL
+
+	return
+}
+
+func (p *MockProvider) Close() {
+	p.server.Close()
+}
+
+func (p *MockProvider) SetIdentity(i MockIdentity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.identity = i
+}
+
+// TamperClaims lets f modify the claims of the id tokens issued from now on, f may be nil.
+func (p *MockProvider) TamperClaims(f func(claims map[string]interface{})) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.tamperClaims = f
+}
+
+func (p *MockProvider) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{string(jose.RS256)},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *MockProvider) handleKeys(w http.ResponseWriter, r *http.Request) {
+	writeJson(w, http.StatusOK, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &p.key.PublicKey,
+		KeyID:     mockKeyId,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+func (p *MockProvider) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != p.ClientID || q.Get("response_type") != "code" {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+
+		return
+	}
+
+	code, err := randomString()
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+
+		return
+	}
+
+	p.mu.Lock()
+	p.grants[code] = mockGrant{
+		identity:      p.identity,
+		nonce:         q.Get("nonce"),
+		codeChallenge: q.Get("code_challenge"),
+		redirectURI:   redirectURI.String(),
+	}
+	p.mu.Unlock()
+
+	rq := redirectURI.Query()
+	rq.Set("code", code)
+	rq.Set("state", q.Get("state"))
+	redirectURI.RawQuery = rq.Encode()
+
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *MockProvider) handleToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != p.ClientID || clientSecret != p.ClientSecret {
+		writeJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+
+		return
+	}
+
+	code := r.PostForm.Get("code")
+
+	p.mu.Lock()
+	g, ok := p.grants[code]
+	delete(p.grants, code)
+	tamperClaims := p.tamperClaims
+	p.mu.Unlock()
+
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != g.redirectURI {
+		writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+
+		return
+	}
+
+	if g.codeChallenge != "" {
+		h := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if base64.RawURLEncoding.EncodeToString(h[:]) != g.codeChallenge {
+			writeJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+
+			return
+		}
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            p.URL,
+		"sub":            g.identity.Subject,
+		"aud":            p.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          g.nonce,
+		"email":          g.identity.Email,
+		"email_verified": g.identity.EmailVerified,
+		"name":           g.identity.Name,
+	}
+	if tamperClaims != nil {
+		tamperClaims(claims)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key}, (&jose.SignerOptions{}).WithHeader("kid", mockKeyId))
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+
+		return
+	}
+
+	idToken, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+
+		return
+	}
+
+	accessToken, err := randomString()
+	if err != nil {
+		writeJson(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func randomString() (s string, err error) {
+	b := make([]byte, 16)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+
+	s = base64.RawURLEncoding.EncodeToString(b)
+
+	return
+}
+
+func writeJson(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,197 @@
+package samlutil
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/crewjam/saml"
+	"github.com/pkg/errors"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+type MockAssertion struct {
+	ID         string
+	Audience   string
+	Recipient  string
+	NameID     string
+	Attributes map[string]string
+	// Tamper changes the name id after the response was signed.
+	Tamper bool
+}
+
+// MockIdentityProvider is a SAML identity provider for tests. It signs responses for whatever assertion it is asked
+// to make, without asking for credentials.
+type MockIdentityProvider struct {
+	idp *saml.IdentityProvider
+}
+
+func NewMockIdentityProvider(entityID string) (p *MockIdentityProvider, err error) {
+	metadataURL, err := url.Parse(entityID)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse entity id")
+
+		return
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		err = errors.Wrap(err, "failed to generate rsa key")
+
+		return
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: metadataURL.Host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		err = errors.Wrap(err, "failed to create certificate")
+
+		return
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		err = errors.Wrap(err, "failed to parse certificate")
+
+		return
+	}
+
+	ssoURL := *metadataURL
+	ssoURL.Path += "/sso"
+
+	p = &MockIdentityProvider{
+		idp: &saml.IdentityProvider{
+			Key:             key,
+			Certificate:     cert,
+			MetadataURL:     *metadataURL,
+			SSOURL:          ssoURL,
+			SignatureMethod: dsig.RSASHA256SignatureMethod,
+		},
+	}
+
+	return
+}
+
+func (p *MockIdentityProvider) Metadata() (b []byte, err error) {
+	b, err = xml.Marshal(p.idp.Metadata())
+	if err != nil {
+		err = errors.Wrap(err, "failed to encode metadata")
+
+		return
+	}
+
+	return
+}
+
+// MakeResponse returns a signed response as the base64 encoded SAMLResponse form value of the HTTP-POST binding.
+func (p *MockIdentityProvider) MakeResponse(a MockAssertion) (samlResponse string, err error) {
+	now := time.Now()
+
+	id := a.ID
+	if id == "" {
+		id = fmt.Sprintf("id-%v", now.UnixNano())
+	}
+
+	var attributes []saml.Attribute
+	for name, value := range a.Attributes {
+		attributes = append(attributes, saml.Attribute{
+			Name:       name,
+			NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+			Values:     []saml.AttributeValue{{Type: "xs:string", Value: value}},
+		})
+	}
+
+	var audiences []saml.AudienceRestriction
+	if a.Audience != "" {
+		audiences = append(audiences, saml.AudienceRestriction{Audience: saml.Audience{Value: a.Audience}})
+	}
+
+	req := &saml.IdpAuthnRequest{
+		IDP:             p.idp,
+		Now:             now,
+		SPSSODescriptor: &saml.SPSSODescriptor{},
+		ACSEndpoint:     &saml.IndexedEndpoint{Binding: saml.HTTPPostBinding, Location: a.Recipient},
+		Assertion: &saml.Assertion{
+			ID:           id,
+			IssueInstant: now,
+			Version:      "2.0",
+			Issuer: saml.Issuer{
+				Format: "urn:oasis:names:tc:SAML:2.0:nameid-format:entity",
+				Value:  p.idp.MetadataURL.String(),
+			},
+			Subject: &saml.Subject{
+				NameID: &saml.NameID{
+					Format: string(saml.PersistentNameIDFormat),
+					Value:  a.NameID,
+				},
+				SubjectConfirmations: []saml.SubjectConfirmation{{
+					Method: "urn:oasis:names:tc:SAML:2.0:cm:bearer",
+					SubjectConfirmationData: &saml.SubjectConfirmationData{
+						NotOnOrAfter: now.Add(saml.MaxIssueDelay),
+						Recipient:    a.Recipient,
+					},
+				}},
+			},
+			Conditions: &saml.Conditions{
+				NotBefore:            now.Add(-saml.MaxClockSkew),
+				NotOnOrAfter:         now.Add(saml.MaxIssueDelay),
+				AudienceRestrictions: audiences,
+			},
+			AuthnStatements: []saml.AuthnStatement{{
+				AuthnInstant: now,
+				AuthnContext: saml.AuthnContext{
+					AuthnContextClassRef: &saml.AuthnContextClassRef{
+						Value: "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport",
+					},
+				},
+			}},
+			AttributeStatements: []saml.AttributeStatement{{Attributes: attributes}},
+		},
+	}
+
+	err = req.MakeResponse()
+	if err != nil {
+		err = errors.Wrap(err, "failed to make response")
+
+		return
+	}
+
+	if a.Tamper {
+		nameID := req.ResponseEl.FindElement("//NameID")
+		if nameID == nil {
+			err = errors.New("failed to find name id")
+
+			return
+		}
+		nameID.SetText(a.NameID + "-tampered")
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(req.ResponseEl)
+
+	b, err := doc.WriteToBytes()
+	if err != nil {
+		err = errors.Wrap(err, "failed to encode response")
+
+		return
+	}
+
+	samlResponse = base64.StdEncoding.EncodeToString(b)
+
+	return
+}